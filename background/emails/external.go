@@ -4,7 +4,13 @@ import (
 	"beam/config"
 	"beam/data/models"
 	"beam/data/services/discount"
+	"bytes"
+	"errors"
 	"fmt"
+	"html/template"
+	"os"
+
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 func VerificationEmail(store, email, param, ipStr string, tools *config.Tools) error {
@@ -61,3 +67,63 @@ func WelcomeDiscountEmail(store, email string, cust *models.Customer, isWelcome,
 
 	return nil
 }
+
+var giftCardTemplate = template.Must(template.New("giftcard").Parse(`<div style="max-width:480px;margin:0 auto;font-family:Helvetica,Arial,sans-serif;color:#111">
+<div style="border-radius:16px;padding:32px;background:#111;color:#fff">
+<div style="font-size:14px;letter-spacing:2px;text-transform:uppercase">{{.Store}}</div>
+<div style="font-size:40px;font-weight:bold;margin:24px 0">{{.Amount}}</div>
+<div style="font-size:12px;opacity:.7">Gift card code</div>
+<div style="font-size:22px;font-family:monospace;letter-spacing:2px">{{.Code}}</div>
+<div style="font-size:12px;opacity:.7;margin-top:12px">PIN</div>
+<div style="font-size:22px;font-family:monospace">{{.Pin}}</div>
+</div>
+{{if .Message}}<p style="font-size:16px;margin:24px 0;white-space:pre-wrap">{{.Message}}</p>{{end}}
+<p style="font-size:14px">Use it at checkout on <a href="https://{{.Domain}}">{{.Domain}}</a>. Expires {{.Expires}}.</p>
+<p style="font-size:14px"><a href="{{.BalanceURL}}">Check your balance</a></p>
+</div>`))
+
+func GiftCardDelivery(store, domain string, gc *models.GiftCard, tools *config.Tools) error {
+	fromEmail := os.Getenv("STORE_EMAIL")
+	if fromEmail == "" {
+		return errors.New("STORE_EMAIL is not set")
+	}
+
+	vals := map[string]string{
+		"Store":      store,
+		"Domain":     domain,
+		"Amount":     fmt.Sprintf("$%.2f", float64(gc.LeftoverCents)/100),
+		"Code":       discount.SpaceDisplayGC(gc.IDCode),
+		"Pin":        gc.Pin,
+		"Message":    gc.ShortMessage,
+		"Expires":    gc.Expired.Format("January 2, 2006"),
+		"BalanceURL": discount.BalanceCheckURL(domain, gc.IDCode),
+	}
+
+	var html bytes.Buffer
+	if err := giftCardTemplate.Execute(&html, vals); err != nil {
+		return err
+	}
+
+	greeting := "Hi"
+	if gc.Recipient.Name != "" {
+		greeting += " " + gc.Recipient.Name
+	}
+	text := fmt.Sprintf("%s,\n\nYou've been sent a %s %s gift card.\n\nCode: %s\nPIN: %s\n", greeting, vals["Amount"], store, vals["Code"], vals["Pin"])
+	if gc.ShortMessage != "" {
+		text += "\n" + gc.ShortMessage + "\n"
+	}
+	text += fmt.Sprintf("\nUse it at checkout on %s. Expires %s.\nCheck your balance: %s", domain, vals["Expires"], vals["BalanceURL"])
+
+	from := mail.NewEmail(store, fromEmail)
+	to := mail.NewEmail(gc.Recipient.Name, gc.Recipient.Email)
+	subject := fmt.Sprintf("You've been sent a %s gift card", store)
+	mailMessage := mail.NewV3MailInit(from, subject, to, mail.NewContent("text/plain", text), mail.NewContent("text/html", html.String()))
+
+	resp, err := tools.SendGrid.Send(mailMessage)
+	if err != nil {
+		return err
+	} else if resp.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid status %d sending gift card %d", resp.StatusCode, gc.ID)
+	}
+	return nil
+}
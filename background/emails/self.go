@@ -91,6 +91,29 @@ func AlertGiftCardID(id string, iter int, store string, tools *config.Tools) {
 	}
 }
 
+func AlertGiftCardLocked(code string, id int, store, ip string, tools *config.Tools) {
+	fromEmail := os.Getenv("ADMIN_EMAIL")
+	if fromEmail == "" {
+		log.Println("ADMIN_EMAIL is not set")
+		return
+	}
+
+	toEmail := fromEmail
+	subject := "Alert: Gift Card Locked After Failed PIN Attempts"
+
+	message := fmt.Sprintf("Gift card locked after too many failed PIN attempts\n\nID: %d\nCode: %s\nLast IP: %s\n\nStore: %s.\n\nIt stays locked until unlocked in admin.", id, code, ip, store)
+
+	from := mail.NewEmail("Admin", fromEmail)
+	to := mail.NewEmail("Admin", toEmail)
+	content := mail.NewContent("text/plain", message)
+	mailMessage := mail.NewV3MailInit(from, subject, to, content)
+
+	_, err := tools.SendGrid.Send(mailMessage)
+	if err != nil {
+		log.Printf("Error sending email: %v", err)
+	}
+}
+
 func HandleWebhook(tools *config.Tools, payload map[string]any) {
 
 	statusToTitle := map[string]string{
@@ -416,3 +439,107 @@ func BackupLogEmail(subject, body string, logErr error, tools *config.Tools) {
 		return
 	}
 }
+
+func AlertHeldOrderChange(store, orderID, kind string, tools *config.Tools, refund, giftCardSum int, providedErr error) {
+	fromEmail := os.Getenv("ADMIN_EMAIL")
+	if fromEmail == "" {
+		log.Println("ADMIN_EMAIL is not set")
+		return
+	}
+
+	toEmail := fromEmail
+	subject := store + ": Held Order Changed By Customer"
+	if providedErr != nil {
+		subject = "Alert: Held Order Change Failed Partway"
+	}
+
+	message := fmt.Sprintf("A held order was changed before going to Printful.\n\nStore: %s\nOrder ID: %s\nChange: %s\nRefunded in cents: %d\nGift cards used in cents (not restored automatically): %d\nError: %v", store, orderID, kind, refund, giftCardSum, providedErr)
+
+	from := mail.NewEmail("Admin", fromEmail)
+	to := mail.NewEmail("Admin", toEmail)
+	content := mail.NewContent("text/plain", message)
+	mailMessage := mail.NewV3MailInit(from, subject, to, content)
+
+	_, err := tools.SendGrid.Send(mailMessage)
+	if err != nil {
+		log.Printf("Error sending email: %v", err)
+	}
+}
+
+func AlertNegativeVariantMargin(store string, variantID int, tools *config.Tools, report models.MarginReport) {
+	fromEmail := os.Getenv("ADMIN_EMAIL")
+	if fromEmail == "" {
+		log.Println("ADMIN_EMAIL is not set")
+		return
+	}
+
+	toEmail := fromEmail
+	subject := "SERIOUS Alert: Negative Rolling Margin on Variant"
+
+	message := fmt.Sprintf("A variant is losing money over the rolling margin window.\n\nStore: %s\nVariant ID: %d\nOrders: %d\nQuantity: %d\nRevenue in cents: %d\nCost in cents: %d\nMargin in cents: %d\nMargin: %.1f%%\n\nCHECK PRICING NOW.", store, variantID, report.Orders, report.Quantity, report.Revenue, report.Cost, report.Margin, report.MarginPct*100)
+
+	from := mail.NewEmail("Admin", fromEmail)
+	to := mail.NewEmail("Admin", toEmail)
+	content := mail.NewContent("text/plain", message)
+	mailMessage := mail.NewV3MailInit(from, subject, to, content)
+
+	_, err := tools.SendGrid.Send(mailMessage)
+	if err != nil {
+		log.Printf("Error sending email: %v", err)
+	}
+}
+
+func AlertMarginPolicy(store, id, action string, tools *config.Tools, check models.MarginCheck, checkErr error) {
+	fromEmail := os.Getenv("ADMIN_EMAIL")
+	if fromEmail == "" {
+		log.Println("ADMIN_EMAIL is not set")
+		return
+	}
+
+	toEmail := fromEmail
+	subject := "Alert: Order Below Minimum Margin"
+	if action == "approve" {
+		subject = "Alert: Order Needs Margin Approval"
+	}
+
+	message := fmt.Sprintf("An order did not meet the store margin policy.\n\nStore: %s\nOrder or Draft Order ID: %s\nPolicy action: %s\nRevenue in cents: %d\nCost in cents: %d\nProfit in cents: %d\nRequired in cents: %d\nShortfall in cents: %d", store, id, action, check.Revenue, check.Cost, check.Profit, check.Required, check.Shortfall)
+	if checkErr != nil {
+		message += fmt.Sprintf("\nUnable to check margin: %v", checkErr)
+	}
+	if action == "approve" {
+		message += "\n\nThe order is held until it is approved or rejected."
+	}
+
+	from := mail.NewEmail("Admin", fromEmail)
+	to := mail.NewEmail("Admin", toEmail)
+	content := mail.NewContent("text/plain", message)
+	mailMessage := mail.NewV3MailInit(from, subject, to, content)
+
+	_, err := tools.SendGrid.Send(mailMessage)
+	if err != nil {
+		log.Printf("Error sending email: %v", err)
+	}
+}
+
+func AlertGiftCardDelivery(store string, ids []int, tools *config.Tools, sendErr error) {
+	fromEmail := os.Getenv("ADMIN_EMAIL")
+	if fromEmail == "" {
+		log.Println("ADMIN_EMAIL is not set")
+		return
+	}
+
+	toEmail := fromEmail
+	subject := "Alert: Unable to Send Scheduled Gift Cards"
+
+	message := fmt.Sprintf("Scheduled gift cards could not be emailed to their recipients and will be retried on the next run.\n\nStore: %s\nGift card IDs: %v\nLast error: %v", store, ids, sendErr)
+
+	from := mail.NewEmail("Admin", fromEmail)
+	to := mail.NewEmail("Admin", toEmail)
+	content := mail.NewContent("text/plain", message)
+	mailMessage := mail.NewV3MailInit(from, subject, to, content)
+
+	_, err := tools.SendGrid.Send(mailMessage)
+	if err != nil {
+		log.Printf("Error sending email: %v", err)
+	}
+}
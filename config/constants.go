@@ -3,6 +3,8 @@ package config
 import "time"
 
 const MIN_ORDER_PRICE = 100
+const MAX_DISCOUNT_CODES = 4
+const MAX_BATCH_CODES = 50000
 
 const PAGELEN int = 20
 const ORDERLEN int = 6
@@ -18,6 +20,14 @@ const BATCH int = 45
 
 const SHIPINTERVAL time.Duration = 60 * time.Second
 
+const SHIP_CACHE_FRESH time.Duration = 1 * time.Hour
+const SHIP_CACHE_STALE time.Duration = 6 * time.Hour
+const SHIP_CACHE_REFRESH time.Duration = 30 * time.Second
+
+const STRIPE_FEE_PCT float64 = 0.029
+const STRIPE_FEE_FIXED int = 30
+const MARGIN_WINDOW time.Duration = 30 * 24 * time.Hour
+
 const FAVES_LIMIT = 50
 const SAVES_LIMIT = 15
 const LAST_ORDERED_LIMIT = 50
@@ -27,6 +37,14 @@ const MAX_CUSTOM_LISTS = 25
 const GC_HANDLE string = "/giftcard"
 const GC_IMG string = "https://cdn.com/gc_"
 const GC_NAME string = "Gift Card"
+const GC_MAX_SEND_DAYS = 365
+
+const STORE_CREDIT_DAYS = 365
+
+const HELD_EDIT_LOCK_MINS = 2 // A held order edit lock older than this was left by a crash
+const POINTS_EXPIRY_DAYS = 365
+const POINTS_CODE = "POINTS" // Stands in for a discount code on the line discounts points pay for
+const GC_DELIVERY_BATCH = 200
 
 const LOWER_INV = 150
 const HIGHER_INV = 500
@@ -44,6 +62,15 @@ const MAX_SICODE_ATTEMPTS = 3
 const MAX_TWOFA_NEW = 3
 const MAX_SICODE_NEW = 3
 
+const TOTP_PERIOD = 30 // seconds
+const TOTP_DIGITS = 6
+const TOTP_SKEW = 1 // Steps either side accepted
+const RECOVERY_CODE_COUNT = 10
+
+const PASSKEY_EXPIR_MINS = 5
+const MAX_PASSKEYS = 10
+const MAX_PASSKEY_ATTEMPTS = 10 // Per hour
+
 const RESET_EXPIR_MINS = 60
 const RESET_PASS_COOLDOWN = 3 // Days
 
@@ -75,6 +102,16 @@ const LOCKOUT_MINUTES_ACCOUNT = 30
 const LOCKOUT_MINUTES_MINUTE = 120
 const LOCKOUT_MINUTES_HOUR = 480
 
+const GC_FAILS_CODE = 5 // Per GC_FAIL_WINDOW_HOURS, then the code is locked until an admin unlocks it
+const GC_FAIL_WINDOW_HOURS = 24
+const GC_FAILS_IP = 10    // Per hour
+const GC_FAILS_DEVICE = 6 // Per hour, also per guest
+const GC_LOCKOUT_MINUTES = 120
+
+const AFFILIATE_LOGIN_ATTEMPTS = 10 // Per hour, per email and per IP
+const AFFILIATE_PORTAL_HOURS = 12
+const AFFILIATE_WINDOW_DAYS = 30 // Days an affiliate click is credited for stores without their own
+
 const CONFIRM_EMAIL_WAIT = 30     // seconds
 const CONFIRM_EMAIL_MAX = 10      // attempts
 const CONFIRM_EMAIL_COOLDOWN = 12 // hours
@@ -89,6 +126,10 @@ const REVIEW_BUCKET_NAME = "reviews-storage-beam-a312"
 
 const BASE_WELCOME_CODE = "WELCOME-"
 const BASE_ALWAYS_CODE = "ALWAYS-WORKS-"
+const REFERRAL_PREFIX = "FRIEND" // Referral codes are FRIEND-..., and double as the friend's discount code
+const REFERRAL_REWARD_PREFIX = "REFER"
+const REFERRAL_CODE_DAYS = 90
+const AFFILIATE_PREFIX = "AFF"
 
 const DEFAULT_WELCOME_PCT = 15
 const DEFAULT_ALWAYS_PCT = 10
@@ -4,62 +4,109 @@ import (
 	"beam/data/models"
 	"fmt"
 	"log"
+	"math"
+	"strings"
 )
 
-func CollectionCurrency(c *models.ClientCookie, t *Tools, render *models.CollectionRender) {
+func CollectionCurrency(c *models.ClientCookie, t *Tools, s *SettingsMutex, render *models.CollectionRender) {
 	rate, currency, otherCurrency := getCurrency(c, t)
+	taxRate, inclusive := cookieInclusiveRate(c, s)
 
 	for _, p := range render.Products {
+		price := InclusivePrice(p.Price, taxRate)
 		render.PricedProducts = append(render.PricedProducts, models.ProductWithPriceRender{
 			Product: p,
 			PriceRender: models.PriceRender{
-				DollarPrice:    fmt.Sprintf("$%.2f", float64(p.Price)/100),
+				DollarPrice:    fmt.Sprintf("$%.2f", float64(price)/100),
 				IsOtherPrice:   otherCurrency,
-				OtherPrice:     fmt.Sprintf("%.2f", (float64(p.Price)*rate)/100),
+				OtherPrice:     fmt.Sprintf("%.2f", (float64(price)*rate)/100),
 				OtherPriceCode: currency,
+				TaxInclusive:   inclusive,
 			},
 		})
 	}
 	render.Products = []models.ProductInfo{}
 }
 
-func ProductCurrency(c *models.ClientCookie, t *Tools, render *models.ProductRender) {
+func ProductCurrency(c *models.ClientCookie, t *Tools, s *SettingsMutex, render *models.ProductRender) {
 	rate, currency, otherCurrency := getCurrency(c, t)
+	taxRate, inclusive := cookieInclusiveRate(c, s)
 
+	price := InclusivePrice(render.Price, taxRate)
 	render.PriceRender = models.PriceRender{
-		DollarPrice:    fmt.Sprintf("$%.2f", float64(render.Price)/100),
+		DollarPrice:    fmt.Sprintf("$%.2f", float64(price)/100),
 		IsOtherPrice:   otherCurrency,
-		OtherPrice:     fmt.Sprintf("%.2f", (float64(render.Price)*rate)/100),
+		OtherPrice:     fmt.Sprintf("%.2f", (float64(price)*rate)/100),
 		OtherPriceCode: currency,
+		TaxInclusive:   inclusive,
 	}
 
+	compareAt := InclusivePrice(render.CompareAt, taxRate)
 	render.CompareAtRender = models.PriceRender{
-		DollarPrice:    fmt.Sprintf("$%.2f", float64(render.CompareAt)/100),
+		DollarPrice:    fmt.Sprintf("$%.2f", float64(compareAt)/100),
 		IsOtherPrice:   otherCurrency,
-		OtherPrice:     fmt.Sprintf("%.2f", (float64(render.CompareAt)*rate)/100),
+		OtherPrice:     fmt.Sprintf("%.2f", (float64(compareAt)*rate)/100),
 		OtherPriceCode: currency,
+		TaxInclusive:   inclusive,
+	}
+
+	for i, t := range render.VolumeTiers {
+		tierPrice := InclusivePrice(t.Price, taxRate)
+		render.VolumeTiers[i].PriceRender = models.PriceRender{
+			DollarPrice:    fmt.Sprintf("$%.2f", float64(tierPrice)/100),
+			IsOtherPrice:   otherCurrency,
+			OtherPrice:     fmt.Sprintf("%.2f", (float64(tierPrice)*rate)/100),
+			OtherPriceCode: currency,
+			TaxInclusive:   inclusive,
+		}
 	}
 }
 
-func CartCurrency(c *models.ClientCookie, t *Tools, render *models.CartRender) {
+func CartCurrency(c *models.ClientCookie, t *Tools, s *SettingsMutex, render *models.CartRender) {
 
 	rate, currency, otherCurrency := getCurrency(c, t)
+	taxRate, inclusive := cookieInclusiveRate(c, s)
 
+	// Gross up per unit so the cart matches the line math used at checkout; gift cards are never taxed
+	subtotal := 0
 	for i, cl := range render.CartLines {
+		lineTotal := cl.Subtotal
+		if inclusive && !cl.ActualLine.IsGiftCard {
+			lineTotal = InclusivePrice(cl.ActualLine.Price, taxRate) * cl.ActualLine.Quantity
+		}
+		subtotal += lineTotal
+
 		cl.PriceRender = models.PriceRender{
-			DollarPrice:    fmt.Sprintf("$%.2f", float64(cl.Subtotal)/100),
+			DollarPrice:    fmt.Sprintf("$%.2f", float64(lineTotal)/100),
 			IsOtherPrice:   otherCurrency,
-			OtherPrice:     fmt.Sprintf("%.2f", (float64(cl.Subtotal)*rate)/100),
+			OtherPrice:     fmt.Sprintf("%.2f", (float64(lineTotal)*rate)/100),
 			OtherPriceCode: currency,
+			TaxInclusive:   inclusive,
 		}
 		render.CartLines[i] = cl
 	}
 
+	if !inclusive {
+		subtotal = render.Subtotal
+	}
+
 	render.PriceRender = models.PriceRender{
-		DollarPrice:    fmt.Sprintf("$%.2f", float64(render.Subtotal)/100),
+		DollarPrice:    fmt.Sprintf("$%.2f", float64(subtotal)/100),
 		IsOtherPrice:   otherCurrency,
-		OtherPrice:     fmt.Sprintf("%.2f", (float64(render.Subtotal)*rate)/100),
+		OtherPrice:     fmt.Sprintf("%.2f", (float64(subtotal)*rate)/100),
 		OtherPriceCode: currency,
+		TaxInclusive:   inclusive,
+	}
+
+	if render.FreeShipAway > 0 {
+		away := InclusivePrice(render.FreeShipAway, taxRate)
+		render.FreeShipAwayRender = models.PriceRender{
+			DollarPrice:    fmt.Sprintf("$%.2f", float64(away)/100),
+			IsOtherPrice:   otherCurrency,
+			OtherPrice:     fmt.Sprintf("%.2f", (float64(away)*rate)/100),
+			OtherPriceCode: currency,
+			TaxInclusive:   inclusive,
+		}
 	}
 
 }
@@ -68,16 +115,75 @@ func DraftOrderCurrency(c *models.ClientCookie, t *Tools, draft *models.DraftOrd
 
 	rate, currency, otherCurrency := getCurrency(c, t)
 
-	return models.DraftOrderRender{
+	ret := models.DraftOrderRender{
 		DraftOrder: draft,
 		TotalPriceRender: models.PriceRender{
 			DollarPrice:    fmt.Sprintf("$%.2f", float64(draft.Total)/100),
 			IsOtherPrice:   otherCurrency,
 			OtherPrice:     fmt.Sprintf("%.2f", (float64(draft.Total)*rate)/100),
 			OtherPriceCode: currency,
+			TaxInclusive:   draft.TaxInclusive,
 		},
+		TaxInclusive: draft.TaxInclusive,
 	}
 
+	// The draft already carries the backed out tax, so the gross subtotal is the net plus what was included
+	if draft.TaxInclusive {
+		grossSubtotal := draft.Subtotal - draft.OrderLevelDiscount + draft.Tax
+		ret.SubtotalPriceRender = models.PriceRender{
+			DollarPrice:    fmt.Sprintf("$%.2f", float64(grossSubtotal)/100),
+			IsOtherPrice:   otherCurrency,
+			OtherPrice:     fmt.Sprintf("%.2f", (float64(grossSubtotal)*rate)/100),
+			OtherPriceCode: currency,
+			TaxInclusive:   true,
+		}
+		ret.IncludedTaxRender = models.PriceRender{
+			DollarPrice:    fmt.Sprintf("$%.2f", float64(draft.Tax)/100),
+			IsOtherPrice:   otherCurrency,
+			OtherPrice:     fmt.Sprintf("%.2f", (float64(draft.Tax)*rate)/100),
+			OtherPriceCode: currency,
+			TaxInclusive:   true,
+		}
+	}
+
+	return ret
+}
+
+// Returns the VAT/GST rate included in displayed prices for the store and country, and whether one applies
+func InclusiveTaxRate(s *SettingsMutex, store, country string) (float64, bool) {
+	if s == nil || store == "" || country == "" {
+		return 0, false
+	}
+
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	rates, ok := s.Settings.InclusiveTax[store]
+	if !ok {
+		return 0, false
+	}
+
+	rate, ok := rates[strings.ToUpper(strings.TrimSpace(country))]
+	if !ok || rate <= 0 {
+		return 0, false
+	}
+
+	return rate, true
+}
+
+// Net cents -> the tax inclusive cents shown to the customer
+func InclusivePrice(cents int, rate float64) int {
+	if rate <= 0 {
+		return cents
+	}
+	return int(math.Round(float64(cents) * (1 + rate)))
+}
+
+func cookieInclusiveRate(c *models.ClientCookie, s *SettingsMutex) (float64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	return InclusiveTaxRate(s, c.Store, c.Country)
 }
 
 // Returns the flot64 USD -> rate, name of the other rate, and whether or not to continue
@@ -5,8 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type StoreNamesWithMutex struct {
@@ -140,3 +145,138 @@ func LoadAllData() *AllMutexes {
 		Settings: SettingsMutex{Settings: settings},
 	}
 }
+
+// Store rules if set, otherwise the old FREESHIP customer tag and FREESHIP_SUBTOTAL behavior
+func FreeShipRules(s *SettingsMutex, store string) []models.FreeShipRule {
+	s.Mu.RLock()
+	rules, ok := s.Settings.FreeShipRules[store]
+	s.Mu.RUnlock()
+
+	if ok {
+		return slices.Clone(rules)
+	}
+
+	freeShipSubtotal, err := strconv.Atoi(os.Getenv("FREESHIP_SUBTOTAL"))
+	if err != nil {
+		return nil
+	}
+
+	return []models.FreeShipRule{
+		{Name: "TAG", CustomerTags: []string{"FREESHIP"}},
+		{Name: "SUBTOTAL", MinSubtotal: freeShipSubtotal},
+	}
+}
+
+// Zero means orders go to Printful as soon as they are paid
+func OrderEditWindow(s *SettingsMutex, store string) time.Duration {
+	s.Mu.RLock()
+	minutes := s.Settings.EditWindowMinutes[store]
+	s.Mu.RUnlock()
+
+	if minutes <= 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func MarginPolicy(s *SettingsMutex, store string) (models.MarginPolicy, bool) {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	policy, ok := s.Settings.MarginPolicies[store]
+	if !ok || (policy.MinCents <= 0 && policy.MinPct <= 0) {
+		return models.MarginPolicy{}, false
+	}
+	return policy, true
+}
+
+func TagVolumeTiers(s *SettingsMutex, store string) map[string][]models.VolumeTier {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	return maps.Clone(s.Settings.VolumeTiers[store])
+}
+
+// Days until credit from the source expires, STORE_CREDIT_DAYS when the store doesn't set it; zero means it never expires
+func StoreCreditDays(s *SettingsMutex, store, source string) int {
+	s.Mu.RLock()
+	days, ok := s.Settings.StoreCreditDays[store][source]
+	s.Mu.RUnlock()
+
+	if !ok {
+		return STORE_CREDIT_DAYS
+	} else if days < 0 {
+		return 0
+	}
+	return days
+}
+
+// Tiers come back lowest first; false when the store has no program or it can't redeem
+func LoyaltyProgram(s *SettingsMutex, store string) (models.LoyaltyProgram, bool) {
+	s.Mu.RLock()
+	program, ok := s.Settings.LoyaltyPrograms[store]
+	s.Mu.RUnlock()
+
+	if !ok || program.RedeemStep <= 0 || program.RedeemCents <= 0 {
+		return models.LoyaltyProgram{}, false
+	}
+
+	program.Tiers = slices.Clone(program.Tiers)
+	sort.SliceStable(program.Tiers, func(i, j int) bool {
+		return program.Tiers[i].MinLifetime < program.Tiers[j].MinLifetime
+	})
+	return program, true
+}
+
+func ReferralProgram(s *SettingsMutex, store string) (models.ReferralProgram, bool) {
+	s.Mu.RLock()
+	program, ok := s.Settings.ReferralPrograms[store]
+	s.Mu.RUnlock()
+
+	if !ok || program.FriendPct <= 0 || program.FriendPct > 100 || program.RewardCents <= 0 {
+		return models.ReferralProgram{}, false
+	} else if program.RewardKind != "credit" && program.RewardKind != "code" {
+		return models.ReferralProgram{}, false
+	}
+	if program.CodeDays <= 0 {
+		program.CodeDays = REFERRAL_CODE_DAYS
+	}
+	return program, true
+}
+
+// Touch is "first" or "last"
+func AffiliateAttribution(s *SettingsMutex, store string) models.AffiliateAttribution {
+	s.Mu.RLock()
+	attribution := s.Settings.AffiliateAttribution[store]
+	s.Mu.RUnlock()
+
+	if attribution.WindowDays <= 0 {
+		attribution.WindowDays = AFFILIATE_WINDOW_DAYS
+	}
+	if attribution.Touch != "first" {
+		attribution.Touch = "last"
+	}
+	return attribution
+}
+
+// The store's promotions with the given IDs, active or not, by priority; for redoing an order that already had them
+func PromotionsByID(s *SettingsMutex, store string, ids []string) []models.Promotion {
+	s.Mu.RLock()
+	promos := slices.Clone(s.Settings.Promotions[store])
+	s.Mu.RUnlock()
+
+	promos = slices.DeleteFunc(promos, func(p models.Promotion) bool { return !slices.Contains(ids, p.ID) })
+	sort.SliceStable(promos, func(i, j int) bool { return promos[i].Priority > promos[j].Priority })
+	return promos
+}
+
+// Active promotions for the store, highest priority first
+func Promotions(s *SettingsMutex, store string, now time.Time) []models.Promotion {
+	s.Mu.RLock()
+	promos := slices.Clone(s.Settings.Promotions[store])
+	s.Mu.RUnlock()
+
+	promos = slices.DeleteFunc(promos, func(p models.Promotion) bool { return !p.Active(now) })
+	sort.SliceStable(promos, func(i, j int) bool { return promos[i].Priority > promos[j].Priority })
+	return promos
+}
@@ -26,7 +26,7 @@ func PostgresConnect(mutex *AllMutexes) map[string]*gorm.DB {
 			log.Fatalf("failed to connect to database: %v", err)
 		}
 
-		err = db.AutoMigrate(&models.Cart{}, &models.CartLine{}, &models.Comparable{}, &models.Contact{}, &models.Customer{}, &models.Discount{}, &models.DiscountUser{}, &models.FavesLine{}, &models.SavesList{}, &models.LastOrdersList{}, &models.Product{}, &models.Variant{})
+		err = db.AutoMigrate(&models.Cart{}, &models.CartLine{}, &models.Comparable{}, &models.Contact{}, &models.Customer{}, &models.RecoveryCode{}, &models.Passkey{}, &models.Discount{}, &models.DiscountUser{}, &models.DiscountBatch{}, &models.GiftCard{}, &models.GiftCardUseLine{}, &models.StoreCredit{}, &models.StoreCreditLine{}, &models.PointsLot{}, &models.PointsLine{}, &models.LoyaltyAccount{}, &models.Affiliate{}, &models.Referral{}, &models.AffiliateRate{}, &models.AffiliateCommission{}, &models.AffiliateLogin{}, &models.FavesLine{}, &models.SavesList{}, &models.LastOrdersList{}, &models.Product{}, &models.Variant{}, &models.OrderProfit{}, &models.OrderProfitLine{})
 		if err != nil {
 			log.Fatalf("failed to migrate database: %v", err)
 		}
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+// Overrides the affiliate's Commission on lines of the product
+type AffiliateRate struct {
+	AffiliateID int `gorm:"primaryKey;autoIncrement:false"`
+	ProductID   int `gorm:"primaryKey;autoIncrement:false"`
+	Commission  float64
+}
+
+// The commission ledger; an order's Earn line is written once, Reversals take back up to what it earned
+type AffiliateCommission struct {
+	ID          int       `gorm:"primaryKey"`
+	AffiliateID int       `gorm:"index"`
+	OrderID     string    `gorm:"index"`
+	Date        time.Time `gorm:"index"`
+	Kind        string    // Earn, Reversal
+	NetCents    int       // Signed order net the line is for
+	Cents       int       // Signed commission
+	Reason      string
+}
+
+// Only the hash of the portal token is kept
+type AffiliateLogin struct {
+	TokenHash   string `gorm:"primaryKey"`
+	AffiliateID int    `gorm:"index"`
+	Created     time.Time
+	Expires     time.Time
+}
+
+// Touch "first" keeps the affiliate that first brought the customer until its window ends, "last" gives it to the latest click;
+// an affiliate's own WindowDays overrides the store's
+type AffiliateAttribution struct {
+	WindowDays int
+	Touch      string
+}
+
+type AffiliateMonth struct {
+	Month      string // 2006-01
+	Clicks     int
+	Orders     int
+	Revenue    int // Net of reversals
+	Commission int // Net of reversals
+}
+
+type AffiliateDashboard struct {
+	Code       string
+	Name       string
+	Rate       float64          // The affiliate's Commission
+	Rates      []*AffiliateRate // Product overrides
+	From       time.Time
+	To         time.Time
+	Clicks     int
+	Orders     int
+	Revenue    int
+	Commission int
+	Months     []AffiliateMonth // Oldest first
+}
+
+// Per affiliate and month totals from the database
+type AffiliateMonthCount struct {
+	AffiliateID int
+	Month       string
+	Count       int
+	Net         int
+	Cents       int
+}
@@ -18,6 +18,19 @@ type ConversionStorage struct {
 	Rates   map[string]float64 `json:"r"`
 }
 
+// Shared Printful results, fresh until Expires and served stale until the redis TTL
+type ShipRateStorage struct {
+	Set     time.Time      `json:"s"`
+	Expires time.Time      `json:"e"`
+	Rates   []ShippingRate `json:"r"`
+}
+
+type OrderEstimateStorage struct {
+	Set      time.Time         `json:"s"`
+	Expires  time.Time         `json:"e"`
+	Estimate OrderEstimateCost `json:"r"`
+}
+
 type ConversionResponse struct {
 	Success   bool               `json:"success"`
 	Terms     string             `json:"terms"`
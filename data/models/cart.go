@@ -26,4 +26,5 @@ type CartLine struct {
 	IsGiftCard      bool
 	GiftCardCode    string
 	GiftCardMessage string
+	GiftCardTo      GiftCardRecipient `gorm:"embedded;embeddedPrefix:gc_to_"`
 }
@@ -187,13 +187,17 @@ type SessionLine struct {
 }
 
 type Affiliate struct {
-	ID        int    `gorm:"primaryKey"`
-	Code      string `gorm:"uniqueIndex"`
-	Name      string
-	Email     string
-	CreatedAt time.Time
-	LastUsed  time.Time
-	Valid     bool
+	ID         int    `gorm:"primaryKey"`
+	Code       string `gorm:"uniqueIndex"`
+	CustomerID int    `gorm:"index"` // The referring customer for referral codes, zero for outside affiliates
+	Name       string
+	Email      string
+	CreatedAt  time.Time
+	LastUsed   time.Time
+	Valid      bool
+	Commission float64 // Share of each order's net, 0.05 for 5%; product rates override it
+	Password   string  `json:"-"` // Portal login, empty can't log in
+	WindowDays int     // Days a click is credited, zero uses the store's attribution window
 }
 
 type AffiliateLine struct {
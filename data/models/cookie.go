@@ -20,6 +20,7 @@ type ClientCookie struct {
 	GuestCart     int       `json:"r"`
 	OtherCurrency bool      `json:"o"`
 	Currency      string    `json:"u"`
+	Country       string    `json:"y"`
 }
 
 // Session
@@ -33,8 +34,10 @@ type SessionCookie struct {
 
 // Affiliate
 type AffiliateSession struct {
-	ID         int    `json:"i"`
-	ActualCode string `json:"a"`
+	ID         int       `json:"i"`
+	ActualCode string    `json:"a"`
+	Touched    time.Time `json:"t"` // The click the affiliate is credited for
+	Expires    time.Time `json:"e"` // End of the attribution window, cookies without one are ignored
 }
 
 // For 2FA between steps
@@ -42,6 +45,7 @@ type TwoFactorCookie struct {
 	TwoFactorCode string    `json:"t"`
 	CustomerID    int       `json:"c"`
 	Set           time.Time `json:"s"`
+	Method        string    `json:"m"` // Which code to ask for
 }
 
 // For 6 digit sign in code
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// A grant of store credit to a customer; credit is spent soonest expiring first
+type StoreCredit struct {
+	ID            int `gorm:"primaryKey"`
+	CustomerID    int `gorm:"index"`
+	Created       time.Time
+	Expires       time.Time `gorm:"index"` // Zero never expires
+	Source        string    // Refund, Return, Goodwill, Referral
+	OrderID       string    `gorm:"index"` // Order the credit came from, if any
+	Note          string
+	OriginalCents int
+	LeftoverCents int
+	Status        string // Active, Spent, Expired
+}
+
+// The store credit ledger; a customer's lines always add up to the LeftoverCents of their credits
+type StoreCreditLine struct {
+	ID         int    `gorm:"primaryKey"`
+	CustomerID int    `gorm:"index"`
+	CreditID   int    `gorm:"index"`
+	OrderID    string `gorm:"index"`
+	Date       time.Time
+	Kind       string // Issue, Use, Reversal, Expire
+	Change     int    // Signed change to the credit's LeftoverCents
+	EndAmount  int
+	Reason     string
+}
+
+type StoreCreditWallet struct {
+	Balance    int
+	NextExpiry time.Time // Zero when nothing is set to expire
+	Expiring   int       // Cents that go at NextExpiry
+	Credits    []*StoreCredit
+	Lines      []*StoreCreditLine
+}
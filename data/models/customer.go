@@ -27,6 +27,10 @@ type Customer struct {
 	LastResetWithLogout      time.Time
 	EmailChanged             time.Time
 	Uses2FA                  bool
+	TwoFAMethod              string // email, totp; empty is email
+	TOTPSecret               string `json:"-"` // Sealed with TOTP_ENCR_KEY
+	TOTPPending              string `json:"-"` // Sealed secret awaiting its first code to finish enrolling
+	TOTPLastStep             int64  `json:"-"` // Codes at or before this step were used already
 	UsesOtherCurrency        bool
 	OtherCurrency            string
 	ConfirmsSent             int
@@ -40,6 +44,56 @@ type Customer struct {
 	BirthDay                 int
 }
 
+// One time code for when the authenticator app is lost; only the hash is kept
+type RecoveryCode struct {
+	ID         int    `gorm:"primaryKey"`
+	CustomerID int    `gorm:"index"`
+	CodeHash   string `gorm:"index"`
+	Created    time.Time
+	Used       time.Time // Zero until used
+}
+
+// WebAuthn credential, a customer can have several
+type Passkey struct {
+	ID           int       `gorm:"primaryKey" json:"id"`
+	CustomerID   int       `gorm:"index" json:"-"`
+	CredentialID string    `gorm:"uniqueIndex" json:"credential_id"` // base64url
+	PublicKey    []byte    `json:"-"`                                // SPKI DER
+	Algorithm    int       `json:"-"`                                // COSE, -7 ES256, -8 EdDSA, -257 RS256
+	SignCount    uint32    `json:"-"`
+	Name         string    `json:"name"`
+	Created      time.Time `json:"created"`
+	LastUsed     time.Time `json:"last_used"`
+}
+
+// Fields from the browser's PublicKeyCredential, binary ones base64url
+type PasskeyRegistration struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+type PasskeyAssertion struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// What the browser needs for navigator.credentials.create or get, plus the param to send back with the result
+type PasskeyOptions struct {
+	Param       string   `json:"param"`
+	Challenge   string   `json:"challenge"`
+	RPID        string   `json:"rp_id"`
+	RPName      string   `json:"rp_name"`
+	UserID      string   `json:"user_id,omitempty"`
+	UserName    string   `json:"user_name,omitempty"`
+	Algorithms  []int    `json:"algorithms,omitempty"`
+	Credentials []string `json:"credentials,omitempty"` // Exclude when registering, allow when signing in for two factor
+	TimeoutMS   int      `json:"timeout"`
+}
+
 type CustomerPost struct {
 	FirstName       string  `json:"first"`
 	LastName        string  `json:"last"`
@@ -77,3 +131,11 @@ type Contact struct {
 	Country        string  `json:"country" bson:"country"`
 	CountryCode    string  `json:"country_code" bson:"country_code"`
 }
+
+// Result of the local address check, Suggested is nil when nothing would change
+type AddressCheck struct {
+	Valid     bool     `json:"valid"`
+	Errors    []string `json:"errors"`
+	Warnings  []string `json:"warnings"`
+	Suggested *Contact `json:"suggested,omitempty"`
+}
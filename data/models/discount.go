@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
 
 type Discount struct {
 	ID               int    `gorm:"primaryKey"`
@@ -11,18 +16,105 @@ type Discount struct {
 	Expired          time.Time
 	IsPercentageOff  bool
 	PercentageOff    float64
-	IsDollarsOff     bool // DNE Now
-	DollarsOff       int  // DNE Now
+	IsDollarsOff     bool
+	DollarsOff       int
 	HasMaxUses       bool
 	MaxUses          int
 	Uses             int
 	HasMinSubtotal   bool
 	MinSubtotal      int
-	Stacks           bool // DNE Now
+	Stacks           bool   // Same as Combination "all" when Combination is empty
+	Combination      string // "all", "shipping" (only with shipping discounts) or "exclusive"; empty is exclusive
+	IsShippingOff    bool
+	ShippingOff      int // Cents off shipping, 0 takes off all of it
 	AppliesToAllAny  bool
 	SingleCustomerID int
 	ShortMessage     string
 	HasUserList      bool
+	Target           DiscountTarget `gorm:"embedded;embeddedPrefix:target_"`
+	BatchID          int            `gorm:"index"` // 0 when the code was made on its own
+}
+
+// Single use codes generated together from one template
+type DiscountBatch struct {
+	ID          int    `gorm:"primaryKey"`
+	Name        string `gorm:"unique"`
+	Prefix      string
+	Count       int
+	Created     time.Time
+	Deactivated time.Time
+}
+
+type DiscountBatchStats struct {
+	BatchID     int
+	Codes       int
+	Active      int
+	UsedCodes   int
+	Redemptions int
+	Reversals   int
+	Customers   int
+	FirstUse    time.Time
+	LastUse     time.Time
+}
+
+func (d *Discount) CombinationRule() string {
+	switch d.Combination {
+	case "all", "shipping", "exclusive":
+		return d.Combination
+	}
+	if d.Stacks {
+		return "all"
+	}
+	return "exclusive"
+}
+
+// Empty include lists let every line in, any exclude match keeps a line out
+type DiscountTarget struct {
+	IncludeProductIDs   pq.Int64Array  `gorm:"type:integer[]"`
+	ExcludeProductIDs   pq.Int64Array  `gorm:"type:integer[]"`
+	IncludeVariantIDs   pq.Int64Array  `gorm:"type:integer[]"`
+	ExcludeVariantIDs   pq.Int64Array  `gorm:"type:integer[]"`
+	IncludeTags         pq.StringArray `gorm:"type:text[]"` // Full tags, e.g. "Product Type__Hoodie"
+	ExcludeTags         pq.StringArray `gorm:"type:text[]"`
+	IncludeCollections  pq.StringArray `gorm:"type:text[]"` // Collection names, matched against "Collection__" tags
+	ExcludeCollections  pq.StringArray `gorm:"type:text[]"`
+	MinEligibleQuantity int            // Eligible items needed before the code takes anything off
+}
+
+func (t DiscountTarget) IsEmpty() bool {
+	return !t.hasIncludes() && len(t.ExcludeProductIDs) == 0 && len(t.ExcludeVariantIDs) == 0 &&
+		len(t.ExcludeTags) == 0 && len(t.ExcludeCollections) == 0 && t.MinEligibleQuantity <= 0
+}
+
+func (t DiscountTarget) hasIncludes() bool {
+	return len(t.IncludeProductIDs) > 0 || len(t.IncludeVariantIDs) > 0 || len(t.IncludeTags) > 0 || len(t.IncludeCollections) > 0
+}
+
+func (t DiscountTarget) Matches(line OrderLine) bool {
+	if t.matchesAny(line, t.ExcludeProductIDs, t.ExcludeVariantIDs, t.ExcludeTags, t.ExcludeCollections) {
+		return false
+	}
+	if !t.hasIncludes() {
+		return true
+	}
+	return t.matchesAny(line, t.IncludeProductIDs, t.IncludeVariantIDs, t.IncludeTags, t.IncludeCollections)
+}
+
+func (t DiscountTarget) matchesAny(line OrderLine, pids, vids pq.Int64Array, tags, colls pq.StringArray) bool {
+	if slices.Contains(pids, int64(line.ProductID)) || slices.Contains(vids, int64(line.VariantID)) {
+		return true
+	}
+	for _, tag := range line.Tags {
+		if slices.Contains(tags, tag) {
+			return true
+		}
+	}
+	for _, c := range colls {
+		if slices.Contains(line.Tags, "Collection__"+c) {
+			return true
+		}
+	}
+	return false
 }
 
 type DiscountUser struct {
@@ -38,11 +130,24 @@ type GiftCard struct {
 	Activated     time.Time
 	Spent         time.Time
 	Expired       time.Time
-	Status        string // Draft, Active, Spent (Expired)
+	Status        string // Draft, Active, Spent, Void (Expired)
 	OriginalCents int
 	LeftoverCents int
 	ShortMessage  string
 	Pin           string
+	OrderID       string            `gorm:"index"` // Order the card was bought on
+	Recipient     GiftCardRecipient `gorm:"embedded;embeddedPrefix:recipient_"`
+	SendPending   bool              `gorm:"index"` // Paid for and waiting on Recipient.SendAt
+	Delivered     time.Time
+	Locked        bool `gorm:"index"` // Too many wrong pins; no lookups until an admin unlocks it
+	LockedAt      time.Time
+}
+
+// Who a bought card is emailed to; a zero SendAt sends as soon as the order is paid
+type GiftCardRecipient struct {
+	Name   string    `json:"name" bson:"name"`
+	Email  string    `json:"email" bson:"email"`
+	SendAt time.Time `json:"send_at" bson:"send_at"`
 }
 
 type DiscountUseLine struct {
@@ -57,6 +162,7 @@ type DiscountUseLine struct {
 	IsReversal   bool
 }
 
+// The gift card's ledger; every change to LeftoverCents has a line and the lines always add up to it
 type GiftCardUseLine struct {
 	ID             int    `gorm:"primaryKey"`
 	GiftCardID     int    `gorm:"index"`
@@ -70,4 +176,42 @@ type GiftCardUseLine struct {
 	AmountApplied  int
 	EndAmount      int
 	IsReversal     bool
+	Kind           string // Issue, Use, Reversal, Adjust, Void, Extend; empty on lines from before the ledger, which are uses or reversals
+	Change         int    // Signed change to LeftoverCents
+	Reason         string
+}
+
+func (l GiftCardUseLine) BalanceChange() int {
+	if l.Kind != "" {
+		return l.Change
+	} else if l.IsReversal {
+		return l.AmountApplied
+	}
+	return -l.AmountApplied
+}
+
+type GiftCardLedger struct {
+	GiftCard  GiftCard
+	Lines     []GiftCardUseLine
+	LedgerSum int
+	Balanced  bool
+}
+
+// Uses are net of reversals; Revenue is PostDiscountTotal of the orders the code was used on, cancelled orders left out
+type DiscountAnalytics struct {
+	DiscountID    int
+	DiscountCode  string
+	Uses          int
+	Reversals     int
+	Orders        int
+	Revenue       int
+	DiscountGiven int
+	AverageOrder  int
+	Periods       []DiscountPeriod
+}
+
+type DiscountPeriod struct {
+	Start   time.Time
+	Uses    int
+	Revenue int
 }
@@ -35,6 +35,16 @@ type TwoFactorEmailParam struct {
 	SixDigitCode []uint    `json:"x"`
 	Tries        int       `json:"r"`
 	NewCodeReqs  int       `json:"n"`
+	Method       string    `json:"m"` // email, totp; totp has no SixDigitCode
+}
+
+type PasskeyChallengeParam struct {
+	Param      string    `json:"p"`
+	Challenge  string    `json:"x"`
+	CustomerID int       `json:"c"` // Zero for a sign in, the passkey says who it is
+	Purpose    string    `json:"u"` // Register, Login, TwoFactor
+	RPID       string    `json:"r"`
+	Set        time.Time `json:"s"`
 }
 
 type LoginSpecificParams struct {
@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// Points earned at once, e.g. for one order; points are redeemed soonest expiring first
+type PointsLot struct {
+	ID             int `gorm:"primaryKey"`
+	CustomerID     int `gorm:"index"`
+	Created        time.Time
+	Expires        time.Time `gorm:"index"` // Zero never expires
+	Source         string    // Order, Review, Birthday, Signup, Adjust
+	SourceRef      string    `gorm:"index"` // One lot per customer, source and ref, e.g. the order ID or birthday year
+	OriginalPoints int
+	LeftoverPoints int
+	Status         string // Active, Spent, Expired
+}
+
+// The points ledger; a customer's lines with a lot always add up to the LeftoverPoints of their lots,
+// a Reversal without a lot is what an order earned that had already been redeemed
+type PointsLine struct {
+	ID         int    `gorm:"primaryKey"`
+	CustomerID int    `gorm:"index"`
+	LotID      int    `gorm:"index"`
+	OrderID    string `gorm:"index"`
+	Date       time.Time
+	Kind       string // Earn, Redeem, Reversal, Restore, Expire
+	Change     int    // Signed change to the lot's LeftoverPoints
+	EndAmount  int
+	Reason     string
+}
+
+// Lifetime is points earned less points reversed, redeeming and expiry don't lower it
+type LoyaltyAccount struct {
+	CustomerID  int `gorm:"primaryKey;autoIncrement:false"`
+	Lifetime    int
+	Tier        string
+	TierSince   time.Time
+	FreeShipTag bool // The FREESHIP customer tag was added for the tier, so it goes when the tier does
+}
+
+// PointsPerDollar are earned on the order after discounts, before shipping and tax, times the tier Multiplier
+// Points redeem in whole RedeemStep blocks worth RedeemCents each; ExpiryDays zero is POINTS_EXPIRY_DAYS, negative never expires
+type LoyaltyProgram struct {
+	PointsPerDollar int
+	ReviewPoints    int
+	BirthdayPoints  int
+	SignupPoints    int
+	RedeemStep      int
+	RedeemCents     int
+	ExpiryDays      int
+	Tiers           []LoyaltyTier // Lowest MinLifetime first
+}
+
+type LoyaltyTier struct {
+	Name        string
+	MinLifetime int
+	Multiplier  float64 // Zero earns at 1x
+	FreeShip    bool    // Gives the customer the FREESHIP tag
+}
+
+// Points picked at checkout; Redeemed is what fits the order in whole steps
+type PointsRedemption struct {
+	Available   int `bson:"available" json:"available"`
+	Requested   int `bson:"requested" json:"requested"`
+	Redeemed    int `bson:"redeemed" json:"redeemed"`
+	Cents       int `bson:"cents" json:"cents"` // Taken off the subtotal after discount codes
+	RedeemStep  int `bson:"step" json:"step"`
+	RedeemCents int `bson:"step_cents" json:"step_cents"`
+}
+
+type PointsWallet struct {
+	Balance     int
+	Lifetime    int
+	Tier        string
+	NextTier    string
+	ToNextTier  int
+	NextExpiry  time.Time // Zero when nothing is set to expire
+	Expiring    int       // Points that go at NextExpiry
+	RedeemStep  int
+	RedeemCents int
+	Lots        []*PointsLot
+	Lines       []*PointsLine
+}
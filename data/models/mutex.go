@@ -1,6 +1,11 @@
 package models
 
-import "sort"
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
 
 type TagMap struct {
 	ToURL   map[string]string `json:"k"`
@@ -52,6 +57,161 @@ type StateCodes struct {
 type SpecialStoreSettings struct {
 	WelcomePct     map[string]int
 	AlwaysWorksPct map[string]int
+	// Store -> country code -> VAT/GST rate already included in displayed prices
+	InclusiveTax map[string]map[string]float64
+	// Store -> local shipping table
+	ShipTables map[string]ShipTable
+	// Store -> free shipping rules, first match wins
+	FreeShipRules map[string][]FreeShipRule
+	// Store -> country codes accepting PO boxes, stores not listed accept them everywhere
+	POBoxCountries map[string][]string
+	// Store -> minutes a paid order is held for customer edits before going to Printful
+	EditWindowMinutes map[string]int
+	// Store -> minimum profit an order must clear after Printful cost and Stripe fees
+	MarginPolicies map[string]MarginPolicy
+	// Store -> automatic promotions, no code needed
+	Promotions map[string][]Promotion
+	// Store -> product tag ("Key__Value") -> volume tiers for products without their own
+	VolumeTiers map[string]map[string][]VolumeTier
+	// Store -> credit source (Refund, Return, Goodwill, Referral) -> days until store credit expires, negative never expires
+	StoreCreditDays map[string]map[string]int
+	// Store -> points program, stores not listed don't have one
+	LoyaltyPrograms map[string]LoyaltyProgram
+	// Store -> customer referral program, stores not listed don't have one
+	ReferralPrograms map[string]ReferralProgram
+	// Store -> affiliate attribution window and touch, stores not listed get AFFILIATE_WINDOW_DAYS and last touch
+	AffiliateAttribution map[string]AffiliateAttribution
+}
+
+// Action: "block" refuses checkout, "approve" holds the paid order for an admin, "adjust_ship" raises shipping to cover the gap
+// The required profit is the larger of MinCents and MinPct of revenue; a zero MaxShipAdjust leaves the shipping raise uncapped
+type MarginPolicy struct {
+	Action        string
+	MinCents      int
+	MinPct        float64
+	MaxShipAdjust int
+}
+
+// Empty Countries/CustomerTags match anyone, zero Starts/Ends are open ended
+// Services are the rate IDs made free; when empty the cheapest rate is free and the rest are reduced by it
+type FreeShipRule struct {
+	Name         string
+	MinSubtotal  int
+	Countries    []string
+	Services     []string
+	CustomerTags []string
+	Starts       time.Time
+	Ends         time.Time
+}
+
+func (r FreeShipRule) Active(now time.Time) bool {
+	if !r.Starts.IsZero() && now.Before(r.Starts) {
+		return false
+	}
+	if !r.Ends.IsZero() && now.After(r.Ends) {
+		return false
+	}
+	return true
+}
+
+// Empty country only matches rules without a country restriction
+func (r FreeShipRule) MatchesCountry(country string) bool {
+	if len(r.Countries) == 0 {
+		return true
+	}
+	for _, c := range r.Countries {
+		if country != "" && strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r FreeShipRule) MatchesCustomer(tags []string) bool {
+	if len(r.CustomerTags) == 0 {
+		return true
+	}
+	for _, t := range r.CustomerTags {
+		if slices.Contains(tags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Type: "bxgy" (every BuyQuantity eligible items get the cheapest GetQuantity GetPctOff off, 0 makes them free),
+// "spend" (PctOff off eligible items once they reach MinSpend), "tiered" (the highest Tiers break reached),
+// "gift" (GiftVariantID in the cart is free once the other eligible items reach MinSpend)
+// Higher Priority goes first; an Exclusive promotion only applies alone. Zero Starts/Ends are open ended
+type Promotion struct {
+	ID            string
+	Name          string
+	Type          string
+	Priority      int
+	Exclusive     bool
+	Starts        time.Time
+	Ends          time.Time
+	Target        DiscountTarget
+	BuyQuantity   int
+	GetQuantity   int
+	GetPctOff     float64
+	MinSpend      int
+	PctOff        float64
+	Tiers         []PromoTier
+	GiftVariantID int
+}
+
+type PromoTier struct {
+	MinSpend int
+	PctOff   float64
+}
+
+func (p Promotion) Active(now time.Time) bool {
+	if !p.Starts.IsZero() && now.Before(p.Starts) {
+		return false
+	}
+	if !p.Ends.IsZero() && now.After(p.Ends) {
+		return false
+	}
+	return true
+}
+
+// Local table-rate shipping
+// Mode: "primary" (local first, Printful if no zone matches), "fallback" (local only when Printful fails),
+// "cap" (Printful rates capped at the local rate of the same ID); anything else leaves Printful alone
+type ShipTable struct {
+	Mode       string
+	Zones      []ShipZone
+	Weights    map[int]float64 // Product ID -> weight-equivalent units per item, default 1
+	Surcharges map[int]int     // Product ID -> cents per item
+}
+
+// Empty States covers the whole country; the first matching zone is used
+type ShipZone struct {
+	Name      string
+	Countries []string
+	States    []string
+	Services  []ShipService
+}
+
+// Basis: "weight" or "items"
+// If Tiers is set the first tier with UpTo >= units is used, else BaseCents + PerUnitCents for each unit after the first
+// PrintfulMethod is what Printful ships it with (STANDARD, EXPRESS...), empty is STANDARD
+type ShipService struct {
+	ID              string
+	Name            string
+	PrintfulMethod  string
+	Basis           string
+	BaseCents       int
+	PerUnitCents    int
+	Tiers           []ShipTier
+	MinDeliveryDays int
+	MaxDeliveryDays int
+}
+
+type ShipTier struct {
+	UpTo  float64
+	Cents int
 }
 
 var sizeOrder = map[string]int{
@@ -42,10 +42,15 @@ type Order struct {
 	Tip                     int                   `bson:"tip" json:"tip"`
 	PreGiftCardTotal        int                   `bson:"pgc_total" json:"pgc_total"`
 	GiftCardSum             int                   `bson:"gc_sum" json:"gc_sum"`         // To apply towards order
-	PostGiftCardTotal       int                   `bson:"post_total" json:"post_total"` // After GC applied, before added purchasing GC
+	StoreCreditSum          int                   `bson:"credit_sum" json:"credit_sum"` // Store credit applied after gift cards
+	Points                  PointsRedemption      `bson:"points" json:"points"`         // Already in OrderLevelDiscount
+	PointsEarned            int                   `bson:"points_earned" json:"points_earned"`
+	PostGiftCardTotal       int                   `bson:"post_total" json:"post_total"` // After GC and store credit applied, before added purchasing GC
 	GiftCardBuyTotal        int                   `bson:"gc_total" json:"gc_total"`     // For purchasing
 	Total                   int                   `bson:"total" json:"total"`
-	OrderDiscount           OrderDiscount         `bson:"non_stacking_discount_code" json:"non_stacking_discount_code"`
+	OrderDiscount           OrderDiscount         `bson:"non_stacking_discount_code" json:"non_stacking_discount_code"` // First of Discounts
+	Discounts               []OrderDiscount       `bson:"discounts" json:"discounts"`                                   // In the order applied
+	ShippingDiscount        int                   `bson:"ship_disc" json:"ship_disc"`                                   // Already taken out of Shipping
 	ShippingContact         *Contact              `bson:"shipping_contact" json:"shipping_contact"`
 	Lines                   []OrderLine           `bson:"lines" json:"lines"`
 	GiftCardBuyLines        []GiftCardBuyLine     `bson:"gc_lines" json:"gc_lines"`     // For purchasing
@@ -59,6 +64,8 @@ type Order struct {
 	SessionID               string                `bson:"session" json:"session"`
 	AffiliateID             int                   `bson:"affiliate_id" json:"affiliate_id"`
 	AffiliateCode           string                `bson:"affiliate_code" json:"affiliate_code"`
+	AffiliateTouch          time.Time             `bson:"affiliate_touch" json:"affiliate_touch"`     // Click the affiliate is credited for
+	AffiliateExpires        time.Time             `bson:"affiliate_expires" json:"affiliate_expires"` // End of its attribution window
 	External                bool                  `bson:"external" json:"external"`
 	ExternalPlatform        string                `bson:"external_platform" json:"external_platform"`
 	ExternalID              string                `bson:"external_id" json:"external_id"`
@@ -68,6 +75,8 @@ type Order struct {
 	GiftMessage             string                `bson:"gift_mess" json:"gift_mess"`
 	CATax                   bool                  `bson:"ca_tax" json:"ca_tax"`
 	CATaxRate               float64               `bson:"ca_tax_rate" json:"ca_tax_rate"`
+	TaxInclusive            bool                  `bson:"tax_incl" json:"tax_incl"`
+	InclusiveTaxRate        float64               `bson:"tax_incl_rate" json:"tax_incl_rate"`
 	CheckDeliveryDate       time.Time             `bson:"check_date" json:"check_date"`
 	CheckEmailSent          bool                  `bson:"check_sent" json:"check_sent"`
 	PaymentMethodID         string                `bson:"pm_id" json:"pm_id"`
@@ -76,6 +85,25 @@ type Order struct {
 	MovedToAccountDate      time.Time             `bson:"moved_to_date" json:"moved_to_date"`
 	CancellationMessage     string                `bson:"cancel_mess" json:"cancel_mess"`
 	PaymentMethodsForFailed []PaymentMethodStripe `bson:"all_pm" json:"all_pm"`
+	Held                    bool                  `bson:"held" json:"held"` // Paid, but not yet posted to printful
+	HeldUntil               time.Time             `bson:"held_until" json:"held_until"`
+	FreeShipRules           []FreeShipRule        `bson:"fs_rules" json:"fs_rules"`
+	RefundedCents           int                   `bson:"refunded" json:"refunded"`
+	PartialRefundIDs        []string              `bson:"prf_ids" json:"prf_ids"`
+	Edits                   []OrderEdit           `bson:"edits" json:"edits"`
+	MarginShipAdjust        int                   `bson:"margin_ship" json:"margin_ship"` // Included in Shipping
+	AwaitingApproval        bool                  `bson:"approval" json:"approval"`       // Held until an admin approves the margin
+	EditLock                time.Time             `bson:"edit_lock" json:"-"`             // Set while an edit or cancel of the held order runs
+	Promotions              []AppliedPromotion    `bson:"promos" json:"promos"`
+}
+
+// Customer change made while the order was held
+type OrderEdit struct {
+	Timestamp   time.Time `bson:"ts" json:"ts"`
+	Kind        string    `bson:"kind" json:"kind"` // Contact, Remove Line, Cancel, Store Credit
+	Note        string    `bson:"note" json:"note"`
+	RefundCents int       `bson:"refund" json:"refund"`
+	RefundID    string    `bson:"rf_id" json:"rf_id"`
 }
 
 type DraftOrder struct {
@@ -104,10 +132,16 @@ type DraftOrder struct {
 	Tip                   int                          `bson:"tip" json:"tip"`
 	PreGiftCardTotal      int                          `bson:"pgc_total" json:"pgc_total"`
 	GiftCardSum           int                          `bson:"gc_sum" json:"gc_sum"`         // To apply towards order
-	PostGiftCardTotal     int                          `bson:"post_total" json:"post_total"` // After GC applied, before added purchasing GC
+	StoreCreditSum        int                          `bson:"credit_sum" json:"credit_sum"` // Store credit applied after gift cards
+	StoreCreditAvailable  int                          `bson:"credit_avail" json:"credit_avail"`
+	UseStoreCredit        bool                         `bson:"use_credit" json:"use_credit"` // On by default when the customer has credit
+	Points                PointsRedemption             `bson:"points" json:"points"`         // Already in OrderLevelDiscount
+	PostGiftCardTotal     int                          `bson:"post_total" json:"post_total"` // After GC and store credit applied, before added purchasing GC
 	GiftCardBuyTotal      int                          `bson:"gc_total" json:"gc_total"`     // For purchasing
 	Total                 int                          `bson:"total" json:"total"`
-	OrderDiscount         OrderDiscount                `bson:"non_stacking_discount_code" json:"non_stacking_discount_code"`
+	OrderDiscount         OrderDiscount                `bson:"non_stacking_discount_code" json:"non_stacking_discount_code"` // First of Discounts
+	Discounts             []OrderDiscount              `bson:"discounts" json:"discounts"`                                   // In the order applied
+	ShippingDiscount      int                          `bson:"ship_disc" json:"ship_disc"`                                   // Already taken out of Shipping
 	ShippingContact       *Contact                     `bson:"shipping_contact" json:"shipping_contact"`
 	Lines                 []OrderLine                  `bson:"lines" json:"lines"`
 	GiftCardBuyLines      []GiftCardBuyLine            `bson:"gc_lines" json:"gc_lines"`     // For purchasing
@@ -126,6 +160,11 @@ type DraftOrder struct {
 	GiftMessage           string                       `bson:"gift_mess" json:"gift_mess"`
 	CATax                 bool                         `bson:"ca_tax" json:"ca_tax"`
 	CATaxRate             float64                      `bson:"ca_tax_rate" json:"ca_tax_rate"`
+	TaxInclusive          bool                         `bson:"tax_incl" json:"tax_incl"`
+	InclusiveTaxRate      float64                      `bson:"tax_incl_rate" json:"tax_incl_rate"`
+	FreeShipRules         []FreeShipRule               `bson:"fs_rules" json:"fs_rules"`
+	MarginShipAdjust      int                          `bson:"margin_ship" json:"margin_ship"` // Included in Shipping
+	Promotions            []AppliedPromotion           `bson:"promos" json:"promos"`
 	NewPaymentMethodID    string                       `bson:"new_pm_id" json:"new_pm_id"`
 	ExistingPaymentMethod PaymentMethodStripe          `bson:"ex_pm" json:"ex_pm"`
 	CheckDeliveryDate     time.Time                    `bson:"check_date" json:"check_date"`
@@ -148,12 +187,88 @@ type OrderDiscount struct {
 	ShortMessage     string
 	IsPercentageOff  bool
 	PercentageOff    float64
+	IsDollarsOff     bool
+	DollarsOff       int
 	HasMinSubtotal   bool
 	MinSubtotal      int
 	AppliesToAllAny  bool
 	SingleCustomerID int
 	HasUserList      bool
 	CustomerList     []int
+	IsShippingOff    bool
+	ShippingOff      int
+	Combination      string
+	Target           DiscountTarget
+	Amount           int    // Cents this code took off the subtotal
+	ShippingAmount   int    // Cents this code took off shipping
+	EligibleQuantity int    // Items the code could take from
+	Note             string // Why the code only took from part of the order, shown in cart and checkout
+}
+
+// Both codes have to allow the other
+func DiscountsCombine(a, b OrderDiscount) bool {
+	return a.allows(b) && b.allows(a)
+}
+
+func (d OrderDiscount) allows(other OrderDiscount) bool {
+	switch d.Combination {
+	case "all":
+		return true
+	case "shipping":
+		return other.IsShippingOff && !other.IsPercentageOff && !other.IsDollarsOff
+	}
+	return false
+}
+
+// Drafts and orders saved before codes could stack only have OrderDiscount
+func appliedDiscounts(primary OrderDiscount, discs []OrderDiscount) []OrderDiscount {
+	if len(discs) == 0 && primary.DiscountCode != "" {
+		return []OrderDiscount{primary}
+	}
+	return discs
+}
+
+func DiscountCodes(discs []OrderDiscount) []string {
+	codes := []string{}
+	for _, d := range discs {
+		codes = append(codes, d.DiscountCode)
+	}
+	return codes
+}
+
+// Placed inside the affiliate's attribution window; orders from before windows have no expiry and are credited
+func (o *Order) AffiliateCredited() bool {
+	return o.AffiliateID > 0 && (o.AffiliateExpires.IsZero() || o.DateCreated.Before(o.AffiliateExpires))
+}
+
+func (o *Order) AppliedDiscounts() []OrderDiscount {
+	return appliedDiscounts(o.OrderDiscount, o.Discounts)
+}
+
+func (d *DraftOrder) AppliedDiscounts() []OrderDiscount {
+	return appliedDiscounts(d.OrderDiscount, d.Discounts)
+}
+
+// One promotion's part of a line's PromoDiscount
+type LineAdjustment struct {
+	PromotionID string `bson:"promo_id" json:"promo_id"`
+	Name        string `bson:"name" json:"name"`
+	Amount      int    `bson:"amount" json:"amount"`
+}
+
+// What a promotion took off the whole order, Note explains a promotion the customer is close to
+type AppliedPromotion struct {
+	PromotionID string `bson:"promo_id" json:"promo_id"`
+	Name        string `bson:"name" json:"name"`
+	Type        string `bson:"type" json:"type"`
+	Amount      int    `bson:"amount" json:"amount"`
+	Note        string `bson:"note" json:"note"`
+}
+
+// One code's part of a line's OrderDiscShare
+type LineDiscount struct {
+	DiscountCode string `bson:"code" json:"code"`
+	Amount       int    `bson:"amount" json:"amount"`
 }
 
 type OrderLine struct {
@@ -172,19 +287,25 @@ type OrderLine struct {
 	Quantity          int                    `bson:"quantity" json:"quantity"`
 	UndiscountedPrice int                    `bson:"undiscounted_price" json:"undiscounted_price"`
 	Price             int                    `bson:"price" json:"price"`
-	LineLevelDiscount int                    `bson:"line_level_discount" json:"line_level_discount"`
+	LineLevelDiscount int                    `bson:"line_level_discount" json:"line_level_discount"` // Volume discount for the whole line, already out of Price
+	OrderDiscShare    int                    `bson:"order_disc_share" json:"order_disc_share"`       // Share of the order level discount for the whole line
+	Discounts         []LineDiscount         `bson:"line_discs" json:"line_discs"`
+	Tags              []string               `bson:"tags" json:"tags"` // Product tags when the line was added, for discount targeting
+	Promotions        []LineAdjustment       `bson:"promos" json:"promos"`
+	PromoDiscount     int                    `bson:"promo_disc" json:"promo_disc"` // Taken off the whole line by promotions, already out of LineTotal
 	EndPrice          int                    `bson:"end_price" json:"end_price"`
 	LineTotal         int                    `bson:"line_total" json:"line_total"`
 }
 
 type GiftCardBuyLine struct {
-	ImageURL     string `bson:"image_url" json:"image_url"`
-	ProductTitle string `bson:"product_title" json:"product_title"`
-	Handle       string `bson:"handle" json:"handle"`
-	Message      string `bson:"message" json:"message"`
-	CardID       int    `bson:"card_id" json:"card_id"`
-	CardCode     string `bson:"card_code" json:"card_code"`
-	Price        int    `bson:"price" json:"price"`
+	ImageURL     string            `bson:"image_url" json:"image_url"`
+	ProductTitle string            `bson:"product_title" json:"product_title"`
+	Handle       string            `bson:"handle" json:"handle"`
+	Message      string            `bson:"message" json:"message"`
+	CardID       int               `bson:"card_id" json:"card_id"`
+	CardCode     string            `bson:"card_code" json:"card_code"`
+	Price        int               `bson:"price" json:"price"`
+	Recipient    GiftCardRecipient `bson:"recipient" json:"recipient"` // As bought; the card has any later change
 }
 
 type ShippingRate struct {
@@ -198,6 +319,15 @@ type ShippingRate struct {
 	MinDeliveryDate string    `json:"minDeliveryDate" bson:"min_delivery_date"`
 	MaxDeliveryDate string    `json:"maxDeliveryDate" bson:"max_delivery_date"`
 	Timestamp       time.Time `json:"timestamp" bson:"timestamp"`
+	PrintfulMethod  string    `json:"printful_method" bson:"printful_method"` // Set on local rates, Printful's own rates use ID
+}
+
+// What the order is sent to Printful with
+func (r ShippingRate) FulfilmentMethod() string {
+	if r.PrintfulMethod != "" {
+		return r.PrintfulMethod
+	}
+	return r.ID
 }
 
 type OrderEstimateCost struct {
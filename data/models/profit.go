@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+// Cost breakdown for an order once it's sent to printful, all in cents
+// IsEstimate is set when the printful response was unusable and the draft estimate was used instead
+type OrderProfit struct {
+	ID                  int    `gorm:"primaryKey"`
+	OrderID             string `gorm:"uniqueIndex"`
+	PrintfulID          string
+	Created             time.Time `gorm:"index"`
+	IsEstimate          bool
+	DiscountCode        string `gorm:"index"`
+	Subtotal            int
+	Discount            int
+	ShippingCharged     int
+	TaxCollected        int
+	Tip                 int
+	Revenue             int
+	PFItems             int
+	PFDiscount          int
+	PFShipping          int
+	PFDigitization      int
+	PFAdditionalFee     int
+	PFFulfillmentFee    int
+	PFRetailDeliveryFee int
+	PFTax               int
+	PFVat               int
+	PFTotal             int
+	StripeFee           int
+	TotalCost           int
+	Margin              int
+}
+
+// Order revenue and cost split by line; SharedCost is the line's part of shipping, fees, tax and stripe
+type OrderProfitLine struct {
+	ID           int       `gorm:"primaryKey"`
+	OrderID      string    `gorm:"index"`
+	Created      time.Time `gorm:"index"`
+	ProductID    int       `gorm:"index"`
+	VariantID    int       `gorm:"index"`
+	Handle       string
+	DiscountCode string
+	Quantity     int
+	Revenue      int
+	ItemCost     int
+	SharedCost   int
+	Margin       int
+}
+
+type MarginReport struct {
+	Key       string
+	Orders    int
+	Quantity  int
+	Revenue   int
+	Cost      int
+	Margin    int
+	MarginPct float64
+}
+
+// Checkout margin against a store's margin policy, in cents
+type MarginCheck struct {
+	Revenue   int
+	Cost      int
+	Profit    int
+	Required  int
+	Shortfall int
+}
@@ -23,7 +23,14 @@ type ProductRedis struct {
 	SEODescription string         `json:"sd"`
 	Variants       []VariantRedis `json:"v"`
 	StandardPrice  int            `json:"sp"`
-	VolumeDisc     bool           `json:"vd"`
+	VolumeDisc     bool           `json:"vd"`           // Default tiers when the product and its tags have none
+	VolumeTiers    []VolumeTier   `json:"vt,omitempty"` // Own tiers, these win over tag tiers
+}
+
+// MinQuantity units and up get PctOff off each unit
+type VolumeTier struct {
+	MinQuantity int     `json:"q"`
+	PctOff      float64 `json:"p"`
 }
 
 type VariantRedis struct {
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// A friend's first order placed with a customer's referral code; the referrer is rewarded once it's delivered
+type Referral struct {
+	ID             int    `gorm:"primaryKey"`
+	AffiliateID    int    `gorm:"index"`
+	ReferrerID     int    `gorm:"index"`
+	Code           string `gorm:"index"`
+	OrderID        string `gorm:"uniqueIndex"`
+	FriendID       int    `gorm:"index"` // Zero for guest orders
+	FriendEmail    string `gorm:"index"` // Normalized so aliases of one inbox match
+	GuestID        string `gorm:"index"`
+	IPAddress      string `gorm:"index"`
+	Created        time.Time
+	Status         string // Pending, Rewarding, Rewarded, Rejected
+	RejectReason   string // self, email, device, ip, duplicate, limit
+	RewardKind     string // credit, code
+	RewardCents    int
+	RewardCreditID int
+	RewardCode     string
+	Rewarded       time.Time
+}
+
+// FriendPct off the friend's first order; once it's delivered the referrer gets RewardCents as store credit ("credit")
+// or a single use code good for CodeDays ("code"). MaxPerMonth caps rewards per referrer, zero is uncapped
+type ReferralProgram struct {
+	FriendPct   int
+	RewardKind  string
+	RewardCents int
+	CodeDays    int
+	MaxPerMonth int
+}
+
+type ReferralRender struct {
+	Code      string
+	Link      string
+	FriendPct int
+	Referrals []*Referral
+	Pending   int
+	Rewarded  int
+	Earned    int // Cents rewarded
+}
@@ -13,6 +13,7 @@ type PriceRender struct {
 	IsOtherPrice   bool
 	OtherPrice     string
 	OtherPriceCode string
+	TaxInclusive   bool
 }
 
 // Full collection equivalent info
@@ -90,6 +91,15 @@ type ProductRender struct {
 	Blocks          AllVariants
 	PriceRender     PriceRender
 	CompareAtRender PriceRender
+	VolumeTiers     []VolumeTierRender
+}
+
+// One row of the price break table
+type VolumeTierRender struct {
+	MinQuantity int
+	PctOff      float64
+	Price       int
+	PriceRender PriceRender
 }
 
 // Cart
@@ -102,6 +112,18 @@ type CartRender struct {
 	PriceRender PriceRender
 	Cart        Cart
 	CartLines   []CartLineRender
+
+	FreeShipQualified  bool
+	FreeShipAway       int
+	FreeShipRule       string
+	FreeShipAwayRender PriceRender
+
+	DiscountCode   string
+	DiscountAmount int
+	DiscountNote   string // Why the code only takes from some of the cart
+
+	Promotions    []AppliedPromotion
+	PromoDiscount int // Taken off Subtotal by promotions
 }
 
 type CartLineRender struct {
@@ -110,6 +132,8 @@ type CartLineRender struct {
 	QuantityMaxed bool
 	Subtotal      int
 	PriceRender   PriceRender
+	Promotions    []LineAdjustment
+	PromoDiscount int
 }
 
 // Payment
@@ -265,6 +289,22 @@ type OrderRender struct {
 	Descending bool
 }
 
+type GiftCardListRender struct {
+	GiftCards []*GiftCard
+	Total     int
+	Previous  bool
+	Next      bool
+	Page      int
+}
+
+type DiscountListRender struct {
+	Discounts []*Discount
+	Total     int
+	Previous  bool
+	Next      bool
+	Page      int
+}
+
 type ReviewPageRender struct {
 	AllReviews []*Review
 	CustReview *Review
@@ -287,6 +327,9 @@ type ComparablesRender struct {
 }
 
 type DraftOrderRender struct {
-	DraftOrder       *DraftOrder
-	TotalPriceRender PriceRender
+	DraftOrder          *DraftOrder
+	TotalPriceRender    PriceRender
+	TaxInclusive        bool
+	SubtotalPriceRender PriceRender
+	IncludedTaxRender   PriceRender
 }
@@ -29,6 +29,7 @@ type Product struct {
 	SEODescription string         `gorm:"type:text"`
 	StandardPrice  int            `gorm:"type:int"`
 	VolumeDisc     bool
+	VolumeTiers    []VolumeTier `gorm:"type:jsonb;serializer:json"`
 }
 
 // Comparable represents the structure for the COMPARABLE table.
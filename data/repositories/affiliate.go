@@ -0,0 +1,210 @@
+package repositories
+
+import (
+	"beam/data/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AffiliateRepository interface {
+	GetAffiliate(id int) (*models.Affiliate, error)
+	GetPortalAffiliate(email string) (*models.Affiliate, error)
+	ListAffiliates() ([]*models.Affiliate, error)
+	CreateAffiliate(aff *models.Affiliate) error
+	SaveAffiliate(aff *models.Affiliate) error
+	GetRates(affiliateID int) ([]*models.AffiliateRate, error)
+	SetRates(affiliateID int, rates []*models.AffiliateRate) error
+	EarnCommission(line *models.AffiliateCommission) (bool, error)
+	ReverseCommission(orderID string, cents, whole int, reason string) (int, error)
+	GetCommissions(affiliateID int, from, to time.Time) ([]*models.AffiliateCommission, error)
+	ClickMonths(affiliateID int, from, to time.Time) ([]models.AffiliateMonthCount, error)
+	SaleMonths(affiliateID int, from, to time.Time) ([]models.AffiliateMonthCount, error)
+	CommissionMonths(affiliateID int, from, to time.Time) ([]models.AffiliateMonthCount, error)
+	SaveLogin(login *models.AffiliateLogin) error
+	GetLogin(tokenHash string, now time.Time) (*models.AffiliateLogin, error)
+	DeleteLogin(tokenHash string) error
+}
+
+type affiliateRepo struct {
+	db *gorm.DB
+}
+
+func NewAffiliateRepository(db *gorm.DB) AffiliateRepository {
+	return &affiliateRepo{db: db}
+}
+
+func (r *affiliateRepo) GetAffiliate(id int) (*models.Affiliate, error) {
+	var aff models.Affiliate
+	err := r.db.First(&aff, id).Error
+	return &aff, err
+}
+
+// nil when no outside affiliate has the email; referral codes can't log in
+func (r *affiliateRepo) GetPortalAffiliate(email string) (*models.Affiliate, error) {
+	var aff models.Affiliate
+	err := r.db.Where("email = ? AND customer_id = 0", email).First(&aff).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &aff, err
+}
+
+// Outside affiliates only, customer referral codes are under referrals
+func (r *affiliateRepo) ListAffiliates() ([]*models.Affiliate, error) {
+	var affs []*models.Affiliate
+	err := r.db.Where("customer_id = 0").Order("id").Find(&affs).Error
+	return affs, err
+}
+
+func (r *affiliateRepo) CreateAffiliate(aff *models.Affiliate) error {
+	return r.db.Create(aff).Error
+}
+
+func (r *affiliateRepo) SaveAffiliate(aff *models.Affiliate) error {
+	return r.db.Save(aff).Error
+}
+
+func (r *affiliateRepo) GetRates(affiliateID int) ([]*models.AffiliateRate, error) {
+	var rates []*models.AffiliateRate
+	err := r.db.Where("affiliate_id = ?", affiliateID).Order("product_id").Find(&rates).Error
+	return rates, err
+}
+
+// Replaces all of the affiliate's product rates
+func (r *affiliateRepo) SetRates(affiliateID int, rates []*models.AffiliateRate) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("affiliate_id = ?", affiliateID).Delete(&models.AffiliateRate{}).Error; err != nil {
+			return err
+		}
+		if len(rates) == 0 {
+			return nil
+		}
+		return tx.Create(&rates).Error
+	})
+}
+
+// False when the order already has its Earn line
+func (r *affiliateRepo) EarnCommission(line *models.AffiliateCommission) (bool, error) {
+	earned := false
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.AffiliateCommission{}).Where("order_id = ? AND kind = ?", line.OrderID, "Earn").Count(&count).Error; err != nil {
+			return err
+		} else if count > 0 {
+			return nil
+		}
+
+		earned = true
+		return tx.Create(line).Error
+	})
+
+	return earned, err
+}
+
+// Takes back the share cents is of whole of what the order earned, never more than is left; returns the commission reversed
+func (r *affiliateRepo) ReverseCommission(orderID string, cents, whole int, reason string) (int, error) {
+	reversed := 0
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var earn models.AffiliateCommission
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("order_id = ? AND kind = ?", orderID, "Earn").First(&earn).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var lines []*models.AffiliateCommission
+		if err := tx.Where("order_id = ? AND kind = ?", orderID, "Reversal").Find(&lines).Error; err != nil {
+			return err
+		}
+
+		netLeft, centsLeft := earn.NetCents, earn.Cents
+		for _, l := range lines {
+			netLeft += l.NetCents
+			centsLeft += l.Cents
+		}
+
+		net, commission := netLeft, centsLeft
+		if cents < whole {
+			net = min(earn.NetCents*cents/whole, netLeft)
+			commission = min(earn.Cents*cents/whole, centsLeft)
+		}
+		if net <= 0 && commission <= 0 {
+			return nil
+		}
+
+		reversed = commission
+		return tx.Create(&models.AffiliateCommission{
+			AffiliateID: earn.AffiliateID,
+			OrderID:     orderID,
+			Date:        time.Now(),
+			Kind:        "Reversal",
+			NetCents:    -net,
+			Cents:       -commission,
+			Reason:      reason,
+		}).Error
+	})
+
+	return reversed, err
+}
+
+func (r *affiliateRepo) GetCommissions(affiliateID int, from, to time.Time) ([]*models.AffiliateCommission, error) {
+	var lines []*models.AffiliateCommission
+	err := r.db.Where("affiliate_id = ? AND date >= ? AND date < ?", affiliateID, from, to).Order("date, id").Find(&lines).Error
+	return lines, err
+}
+
+func (r *affiliateRepo) ClickMonths(affiliateID int, from, to time.Time) ([]models.AffiliateMonthCount, error) {
+	var counts []models.AffiliateMonthCount
+	err := r.db.Model(&models.AffiliateLine{}).
+		Select(`affiliate_id, to_char("timestamp", 'YYYY-MM') AS month, COUNT(*) AS count`).
+		Where(`affiliate_id = ? AND "timestamp" >= ? AND "timestamp" < ?`, affiliateID, from, to).
+		Group("affiliate_id, month").Order("month").Scan(&counts).Error
+	return counts, err
+}
+
+func (r *affiliateRepo) SaleMonths(affiliateID int, from, to time.Time) ([]models.AffiliateMonthCount, error) {
+	var counts []models.AffiliateMonthCount
+	err := r.db.Model(&models.AffiliateSale{}).
+		Select(`affiliate_id, to_char("timestamp", 'YYYY-MM') AS month, COUNT(DISTINCT order_id) AS count`).
+		Where(`affiliate_id = ? AND "timestamp" >= ? AND "timestamp" < ?`, affiliateID, from, to).
+		Group("affiliate_id, month").Order("month").Scan(&counts).Error
+	return counts, err
+}
+
+// Zero affiliateID totals every affiliate, e.g. for payouts
+func (r *affiliateRepo) CommissionMonths(affiliateID int, from, to time.Time) ([]models.AffiliateMonthCount, error) {
+	q := r.db.Model(&models.AffiliateCommission{}).
+		Select(`affiliate_id, to_char(date, 'YYYY-MM') AS month, SUM(net_cents) AS net, SUM(cents) AS cents`).
+		Where("date >= ? AND date < ?", from, to)
+	if affiliateID > 0 {
+		q = q.Where("affiliate_id = ?", affiliateID)
+	}
+
+	var counts []models.AffiliateMonthCount
+	err := q.Group("affiliate_id, month").Order("affiliate_id, month").Scan(&counts).Error
+	return counts, err
+}
+
+func (r *affiliateRepo) SaveLogin(login *models.AffiliateLogin) error {
+	return r.db.Create(login).Error
+}
+
+// nil when the token is unknown or expired
+func (r *affiliateRepo) GetLogin(tokenHash string, now time.Time) (*models.AffiliateLogin, error) {
+	var login models.AffiliateLogin
+	err := r.db.Where("token_hash = ? AND expires > ?", tokenHash, now).First(&login).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &login, err
+}
+
+func (r *affiliateRepo) DeleteLogin(tokenHash string) error {
+	return r.db.Where("token_hash = ?", tokenHash).Delete(&models.AffiliateLogin{}).Error
+}
@@ -321,6 +321,7 @@ func (r *cartRepo) CopyCartWithLines(cartID, newCustomer int, guestID string) (i
 			IsGiftCard:      line.IsGiftCard,
 			GiftCardCode:    line.GiftCardCode,
 			GiftCardMessage: line.GiftCardMessage,
+			GiftCardTo:      line.GiftCardTo,
 		}
 	}
 
@@ -0,0 +1,233 @@
+package repositories
+
+import (
+	"beam/data/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type StoreCreditRepository interface {
+	IssueCredit(credit *models.StoreCredit, reason string) error
+	GetActiveCredits(customerID int, now time.Time) ([]*models.StoreCredit, error)
+	GetCredits(customerID int) ([]*models.StoreCredit, error)
+	GetCreditLines(customerID, limit int) ([]*models.StoreCreditLine, error)
+	UseCredit(customerID, amount int, orderID string, now time.Time) error
+	RestoreOrderCredit(orderID, reason string, expiry func(source string) time.Time) (int, error)
+	ExpireCredits(now time.Time, limit int) (int, error)
+}
+
+type storeCreditRepo struct {
+	db *gorm.DB
+}
+
+func NewStoreCreditRepository(db *gorm.DB) StoreCreditRepository {
+	return &storeCreditRepo{db: db}
+}
+
+// Credit and its Issue line go in together
+func (r *storeCreditRepo) IssueCredit(credit *models.StoreCredit, reason string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(credit).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.StoreCreditLine{
+			CustomerID: credit.CustomerID,
+			CreditID:   credit.ID,
+			OrderID:    credit.OrderID,
+			Date:       credit.Created,
+			Kind:       "Issue",
+			Change:     credit.LeftoverCents,
+			EndAmount:  credit.LeftoverCents,
+			Reason:     reason,
+		}).Error
+	})
+}
+
+// Spendable credits, soonest expiring first and credits that never expire last
+func (r *storeCreditRepo) GetActiveCredits(customerID int, now time.Time) ([]*models.StoreCredit, error) {
+	var credits []*models.StoreCredit
+	err := activeCredits(r.db, customerID, now).Find(&credits).Error
+	return credits, err
+}
+
+func (r *storeCreditRepo) GetCredits(customerID int) ([]*models.StoreCredit, error) {
+	var credits []*models.StoreCredit
+	err := r.db.Where("customer_id = ?", customerID).Order("created DESC").Find(&credits).Error
+	return credits, err
+}
+
+func (r *storeCreditRepo) GetCreditLines(customerID, limit int) ([]*models.StoreCreditLine, error) {
+	var lines []*models.StoreCreditLine
+	err := r.db.Where("customer_id = ?", customerID).Order("id DESC").Limit(limit).Find(&lines).Error
+	return lines, err
+}
+
+// Takes the amount from the customer's credits in spending order, all or nothing
+func (r *storeCreditRepo) UseCredit(customerID, amount int, orderID string, now time.Time) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var credits []*models.StoreCredit
+		if err := activeCredits(tx.Clauses(clause.Locking{Strength: "UPDATE"}), customerID, now).Find(&credits).Error; err != nil {
+			return err
+		}
+
+		left := amount
+		lines := []*models.StoreCreditLine{}
+		for _, c := range credits {
+			if left == 0 {
+				break
+			}
+
+			take := min(c.LeftoverCents, left)
+			c.LeftoverCents -= take
+			if c.LeftoverCents == 0 {
+				c.Status = "Spent"
+			}
+			left -= take
+
+			if err := tx.Save(c).Error; err != nil {
+				return err
+			}
+			lines = append(lines, &models.StoreCreditLine{
+				CustomerID: customerID,
+				CreditID:   c.ID,
+				OrderID:    orderID,
+				Date:       now,
+				Kind:       "Use",
+				Change:     -take,
+				EndAmount:  c.LeftoverCents,
+			})
+		}
+
+		if left > 0 {
+			return errors.New("not enough store credit")
+		}
+		return tx.Create(&lines).Error
+	})
+}
+
+// Gives back what an order took from each credit, returning the cents restored; an expired credit gets a fresh expiry
+// from expiry (zero for never) so the restored cents can be spent, anything left on it from before is written off first
+func (r *storeCreditRepo) RestoreOrderCredit(orderID, reason string, expiry func(source string) time.Time) (int, error) {
+	restored := 0
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var lines []*models.StoreCreditLine
+		if err := tx.Where("order_id = ? AND kind IN ?", orderID, []string{"Use", "Reversal"}).Find(&lines).Error; err != nil {
+			return err
+		}
+
+		owed := map[int]int{}
+		for _, l := range lines {
+			owed[l.CreditID] -= l.Change
+		}
+
+		now := time.Now()
+		for creditID, cents := range owed {
+			if cents <= 0 {
+				continue
+			}
+
+			var c models.StoreCredit
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&c, creditID).Error; err != nil {
+				return err
+			}
+
+			lineReason := reason
+			if c.Status == "Expired" || (!c.Expires.IsZero() && !c.Expires.After(now)) {
+				if c.LeftoverCents > 0 {
+					if err := tx.Create(&models.StoreCreditLine{
+						CustomerID: c.CustomerID,
+						CreditID:   c.ID,
+						Date:       now,
+						Kind:       "Expire",
+						Change:     -c.LeftoverCents,
+						Reason:     "Expired " + c.Expires.Format("2006-01-02"),
+					}).Error; err != nil {
+						return err
+					}
+					c.LeftoverCents = 0
+				}
+
+				c.Expires = expiry(c.Source)
+				if c.Expires.IsZero() {
+					lineReason += "; expired credit no longer expires"
+				} else {
+					lineReason += "; expired credit now expires " + c.Expires.Format("2006-01-02")
+				}
+			}
+
+			c.LeftoverCents += cents
+			c.Status = "Active"
+			if err := tx.Save(&c).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Create(&models.StoreCreditLine{
+				CustomerID: c.CustomerID,
+				CreditID:   c.ID,
+				OrderID:    orderID,
+				Date:       now,
+				Kind:       "Reversal",
+				Change:     cents,
+				EndAmount:  c.LeftoverCents,
+				Reason:     lineReason,
+			}).Error; err != nil {
+				return err
+			}
+			restored += cents
+		}
+		return nil
+	})
+
+	return restored, err
+}
+
+// Zeroes credits past their expiry with an Expire line each
+func (r *storeCreditRepo) ExpireCredits(now time.Time, limit int) (int, error) {
+	expired := 0
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var credits []*models.StoreCredit
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ? AND expires > ? AND expires <= ?", "Active", time.Time{}, now).
+			Limit(limit).Find(&credits).Error; err != nil {
+			return err
+		}
+
+		for _, c := range credits {
+			change := -c.LeftoverCents
+			c.LeftoverCents = 0
+			c.Status = "Expired"
+			if err := tx.Save(c).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Create(&models.StoreCreditLine{
+				CustomerID: c.CustomerID,
+				CreditID:   c.ID,
+				Date:       now,
+				Kind:       "Expire",
+				Change:     change,
+				Reason:     "Expired " + c.Expires.Format("2006-01-02"),
+			}).Error; err != nil {
+				return err
+			}
+			expired++
+		}
+		return nil
+	})
+
+	return expired, err
+}
+
+func activeCredits(db *gorm.DB, customerID int, now time.Time) *gorm.DB {
+	return db.Where("customer_id = ? AND status = ? AND leftover_cents > 0 AND (expires = ? OR expires > ?)", customerID, "Active", time.Time{}, now).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "expires = ?, expires, id", Vars: []interface{}{time.Time{}}}})
+}
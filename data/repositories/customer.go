@@ -62,6 +62,21 @@ type CustomerRepository interface {
 	SetTwoFactorNX(param, store string) error
 	UnsetTwoFactorNX(param, store string) error
 
+	ReplaceRecoveryCodes(customerID int, hashes []string) error
+	UseRecoveryCode(customerID int, hash string) (bool, error)
+	SetTOTPStep(customerID int, step int64) (bool, error)
+	CountRecoveryCodes(customerID int) (int64, error)
+
+	StorePasskeyChallenge(param models.PasskeyChallengeParam, store string) error
+	TakePasskeyChallenge(param, store string) (models.PasskeyChallengeParam, error)
+	GetPasskeys(customerID int) ([]*models.Passkey, error)
+	GetPasskeyByCredential(credentialID string) (*models.Passkey, error)
+	CountPasskeys(customerID int) (int64, error)
+	CreatePasskey(pk *models.Passkey) error
+	UsePasskey(pk *models.Passkey, signCount uint32) (bool, error)
+	RenamePasskey(customerID, id int, name string) error
+	DeletePasskey(customerID, id int) error
+
 	UpdateCustomerCurrency(id int, usesOtherCurrency bool, otherCurrency string) error
 
 	SetDeviceMapping(customerID int, guestID, store string) error
@@ -78,6 +93,8 @@ type CustomerRepository interface {
 	CheckPasswordFailedAttempts(store, guestID string, customerID int) (bool, error)
 	SetPasswordFailedAttempts(store, guestID string, customerID int) (bool, error)
 	SuccessfulPasswordAttempt(store, guestID string, customerID int) error
+	CheckTOTPFailedAttempts(store string, customerID int) (bool, error)
+	SetTOTPFailedAttempts(store string, customerID int) error
 
 	SaveAuthParams(param models.LoginSpecificParams, store string) error
 	GetAuthParams(param, store string) (models.LoginSpecificParams, error)
@@ -639,6 +656,122 @@ func (r *customerRepo) DeleteTwoFactor(param, store string) error {
 	return r.rdb.Del(context.Background(), key).Err()
 }
 
+// Drops every code the customer had, used or not; no hashes just removes them
+func (r *customerRepo) ReplaceRecoveryCodes(customerID int, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("customer_id = ?", customerID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		codes := make([]*models.RecoveryCode, len(hashes))
+		for i, h := range hashes {
+			codes[i] = &models.RecoveryCode{CustomerID: customerID, CodeHash: h, Created: now}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// False when the code is wrong or already used
+func (r *customerRepo) UseRecoveryCode(customerID int, hash string) (bool, error) {
+	res := r.db.Model(&models.RecoveryCode{}).
+		Where("customer_id = ? AND code_hash = ? AND used = ?", customerID, hash, time.Time{}).
+		Update("used", time.Now())
+	return res.RowsAffected == 1, res.Error
+}
+
+// Only moves the step forward, false means the code's step was already used
+func (r *customerRepo) SetTOTPStep(customerID int, step int64) (bool, error) {
+	res := r.db.Model(&models.Customer{}).
+		Where("id = ? AND totp_last_step < ?", customerID, step).
+		Update("totp_last_step", step)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *customerRepo) CountRecoveryCodes(customerID int) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.RecoveryCode{}).Where("customer_id = ? AND used = ?", customerID, time.Time{}).Count(&count).Error
+	return count, err
+}
+
+func (r *customerRepo) StorePasskeyChallenge(param models.PasskeyChallengeParam, store string) error {
+	if param.Param == "" {
+		return errors.New("param cannot be empty")
+	}
+
+	data, err := json.Marshal(param)
+	if err != nil {
+		return err
+	}
+
+	return r.rdb.Set(context.Background(), store+"::PKCH::"+param.Param, data, time.Duration(config.PASSKEY_EXPIR_MINS)*time.Minute).Err()
+}
+
+// Removes it as it's read so a challenge can only be answered once
+func (r *customerRepo) TakePasskeyChallenge(param, store string) (models.PasskeyChallengeParam, error) {
+	if param == "" {
+		return models.PasskeyChallengeParam{}, errors.New("param cannot be empty")
+	}
+	key := store + "::PKCH::" + param
+
+	data, err := r.rdb.GetDel(context.Background(), key).Bytes()
+	if err != nil {
+		return models.PasskeyChallengeParam{}, err
+	}
+
+	var result models.PasskeyChallengeParam
+	if err := json.Unmarshal(data, &result); err != nil {
+		return models.PasskeyChallengeParam{}, err
+	}
+
+	return result, nil
+}
+
+func (r *customerRepo) GetPasskeys(customerID int) ([]*models.Passkey, error) {
+	var pks []*models.Passkey
+	err := r.db.Where("customer_id = ?", customerID).Order("id").Find(&pks).Error
+	return pks, err
+}
+
+// nil when no customer registered the credential
+func (r *customerRepo) GetPasskeyByCredential(credentialID string) (*models.Passkey, error) {
+	var pk models.Passkey
+	err := r.db.Where("credential_id = ?", credentialID).First(&pk).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &pk, err
+}
+
+func (r *customerRepo) CountPasskeys(customerID int) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Passkey{}).Where("customer_id = ?", customerID).Count(&count).Error
+	return count, err
+}
+
+func (r *customerRepo) CreatePasskey(pk *models.Passkey) error {
+	return r.db.Create(pk).Error
+}
+
+// False when another sign in moved the counter first
+func (r *customerRepo) UsePasskey(pk *models.Passkey, signCount uint32) (bool, error) {
+	res := r.db.Model(&models.Passkey{}).
+		Where("id = ? AND sign_count = ?", pk.ID, pk.SignCount).
+		Updates(map[string]any{"sign_count": signCount, "last_used": time.Now()})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (r *customerRepo) RenamePasskey(customerID, id int, name string) error {
+	return r.db.Model(&models.Passkey{}).Where("id = ? AND customer_id = ?", id, customerID).Update("name", name).Error
+}
+
+func (r *customerRepo) DeletePasskey(customerID, id int) error {
+	return r.db.Where("id = ? AND customer_id = ?", id, customerID).Delete(&models.Passkey{}).Error
+}
+
 func (r *customerRepo) DeleteIncompleteUnverifiedCustomers() error {
 	cutoff := time.Now().Add(-24 * time.Hour)
 
@@ -719,6 +852,9 @@ func (r *customerRepo) CheckPasswordFailedAttempts(store, guestID string, custom
 	key := store + "::PWFA::" + guestID + "::" + strconv.Itoa(customerID)
 
 	val, err := r.rdb.Get(context.Background(), key).Int()
+	if err == redis.Nil {
+		return false, nil
+	}
 	return val >= config.PASSWORD_MAX_ATTEMPTS, err
 }
 
@@ -739,6 +875,25 @@ func (r *customerRepo) SuccessfulPasswordAttempt(store, guestID string, customer
 	return r.rdb.Del(context.Background(), key).Err()
 }
 
+func (r *customerRepo) CheckTOTPFailedAttempts(store string, customerID int) (bool, error) {
+	key := store + "::TOTPFA::" + strconv.Itoa(customerID)
+
+	val, err := r.rdb.Get(context.Background(), key).Int()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return val >= config.MAX_TWOFA_ATTEMPTS, err
+}
+
+func (r *customerRepo) SetTOTPFailedAttempts(store string, customerID int) error {
+	key := store + "::TOTPFA::" + strconv.Itoa(customerID)
+
+	if err := r.rdb.Incr(context.Background(), key).Err(); err != nil {
+		return err
+	}
+	return r.rdb.Expire(context.Background(), key, config.TWOFA_EXPIR_MINS*time.Minute).Err()
+}
+
 func (r *customerRepo) SaveAuthParams(param models.LoginSpecificParams, store string) error {
 	if param.Param == "" {
 		return errors.New("param cannot be empty")
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"beam/data/models"
+	"beam/data/services/discount"
 
 	"math/rand"
 
@@ -19,20 +20,44 @@ type DiscountRepository interface {
 	Read(id int) (*models.Discount, error)
 	Update(discount models.Discount) error
 	Delete(id int) error
-	CreateGiftCard(idCode string, cents int, message string) (int, string, error)
+	CreateGiftCard(idCode string, cents int, message string, recipient models.GiftCardRecipient) (int, string, error)
 	IDCodeExists(idCode string) (bool, error)
 	GetGiftCard(idCode string) (*models.GiftCard, error)
 	GetGiftCardsByIDCodes(idCodes []string) ([]*models.GiftCard, error)
 	GetDiscountsByCodes(codes []string) ([]*models.Discount, error)
 	GetDiscountByCode(code string) (*models.Discount, error)
 	GetDiscountWithUsers(discountCode string) (*models.Discount, []*models.DiscountUser, error)
+	GetReferrer(code string) (*models.Affiliate, error)
 	SaveDiscount(discount *models.Discount) error
 	SaveDiscountWithUser(discount *models.Discount, discountUser *models.DiscountUser) error
 	SaveGiftCards(giftCards []*models.GiftCard) error
+	SearchDiscounts(query, status string, limit, offset int) ([]*models.Discount, int64, error)
+	GetDiscountUsers(discountID int) ([]*models.DiscountUser, error)
+	SaveDiscountWithUserList(discount *models.Discount, customerIDs []int) error
+	GetDiscountUseLines(discountID int) ([]*models.DiscountUseLine, error)
+
+	CreateDiscountBatch(batch *models.DiscountBatch, discounts []*models.Discount) error
+	GetDiscountBatch(id int) (*models.DiscountBatch, error)
+	GetBatchDiscounts(batchID int) ([]*models.Discount, error)
+	DeactivateBatch(batchID int) (int64, error)
+	BatchStats(batchID int) (*models.DiscountBatchStats, error)
 
 	DiscountUseLine(use *models.DiscountUseLine)
+	UseDiscounts(discounts []*models.Discount, customerID int, uses []*models.DiscountUseLine) error
 	GiftCardUseLines(uses []*models.GiftCardUseLine)
 
+	GetGiftCardByID(id int) (*models.GiftCard, error)
+	SearchGiftCards(query, status string, limit, offset int) ([]*models.GiftCard, int64, error)
+	GetGiftCardLines(giftCardID int) ([]*models.GiftCardUseLine, error)
+	IssueGiftCard(giftCard *models.GiftCard, reason string) error
+	ChangeGiftCard(id int, kind, reason string, apply func(gc *models.GiftCard) (int, error)) (*models.GiftCard, error)
+	ActivateGiftCards(ids []int, orderID string) error
+	GetDueGiftCards(now time.Time, limit int) ([]*models.GiftCard, error)
+	ClaimGiftCardSend(id int) (bool, error)
+	ReleaseGiftCardSend(id int) error
+	UpdateGiftCard(id int, apply func(gc *models.GiftCard) error) (*models.GiftCard, error)
+	LockGiftCard(id int) error
+
 	UseGiftCard(idCode, pin string, amount int) (int, int, int, error)
 	UseGiftCards(data map[[2]string]int, orderID, guestID, sessionID string, customerID int) ([]*models.GiftCardUseLine, error)
 }
@@ -63,7 +88,7 @@ func (r *discountRepo) Delete(id int) error {
 	return r.db.Delete(&models.Discount{}, id).Error
 }
 
-func (r *discountRepo) CreateGiftCard(idCode string, cents int, message string) (int, string, error) {
+func (r *discountRepo) CreateGiftCard(idCode string, cents int, message string, recipient models.GiftCardRecipient) (int, string, error) {
 
 	pin := fmt.Sprintf("%03d", rand.Intn(1000))
 	giftCard := models.GiftCard{
@@ -75,16 +100,200 @@ func (r *discountRepo) CreateGiftCard(idCode string, cents int, message string)
 		LeftoverCents: cents,
 		ShortMessage:  message,
 		Pin:           pin,
+		Recipient:     recipient,
 	}
-	if err := r.db.Create(&giftCard).Error; err != nil {
+	if err := r.IssueGiftCard(&giftCard, "Purchase"); err != nil {
 		return 0, "", err
 	}
 	return giftCard.ID, pin, nil
 }
 
+// Card and its Issue line go in together
+func (r *discountRepo) IssueGiftCard(giftCard *models.GiftCard, reason string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(giftCard).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.GiftCardUseLine{
+			GiftCardID:    giftCard.ID,
+			GiftCardCode:  giftCard.IDCode,
+			Date:          giftCard.Created,
+			AmountApplied: giftCard.LeftoverCents,
+			EndAmount:     giftCard.LeftoverCents,
+			Kind:          "Issue",
+			Change:        giftCard.LeftoverCents,
+			Reason:        reason,
+		}).Error
+	})
+}
+
+func (r *discountRepo) GetGiftCardByID(id int) (*models.GiftCard, error) {
+	var giftCard models.GiftCard
+	err := r.db.First(&giftCard, id).Error
+	return &giftCard, err
+}
+
+// Query matches any part of the code, spaces and dashes ignored
+func (r *discountRepo) SearchGiftCards(query, status string, limit, offset int) ([]*models.GiftCard, int64, error) {
+	q := r.db.Model(&models.GiftCard{})
+	if query != "" {
+		q = q.Where("id_code LIKE ?", "%"+query+"%")
+	}
+	if status == "Locked" {
+		q = q.Where("locked = ?", true)
+	} else if status != "" {
+		q = q.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var giftCards []*models.GiftCard
+	err := q.Order("created DESC").Limit(limit).Offset(offset).Find(&giftCards).Error
+	return giftCards, total, err
+}
+
+func (r *discountRepo) GetGiftCardLines(giftCardID int) ([]*models.GiftCardUseLine, error) {
+	var lines []*models.GiftCardUseLine
+	err := r.db.Where("gift_card_id = ?", giftCardID).Order("id").Find(&lines).Error
+	return lines, err
+}
+
+// Locks the card, refuses to touch it if its ledger is already off, then saves the change with its ledger line
+// apply makes the change to the card and returns how much it moved LeftoverCents
+func (r *discountRepo) ChangeGiftCard(id int, kind, reason string, apply func(gc *models.GiftCard) (int, error)) (*models.GiftCard, error) {
+	var gc models.GiftCard
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&gc, id).Error; err != nil {
+			return err
+		}
+		if err := checkLedger(tx, &gc); err != nil {
+			return err
+		}
+
+		prev := gc.LeftoverCents
+		change, err := apply(&gc)
+		if err != nil {
+			return err
+		} else if gc.LeftoverCents != prev+change {
+			return errors.New("gift card change does not match its balance")
+		} else if gc.LeftoverCents < 0 {
+			return errors.New("gift card balance can't go below zero")
+		}
+
+		if err := tx.Save(&gc).Error; err != nil {
+			return err
+		}
+
+		amount := change
+		if amount < 0 {
+			amount = -amount
+		}
+		return tx.Create(&models.GiftCardUseLine{
+			GiftCardID:     gc.ID,
+			GiftCardCode:   gc.IDCode,
+			Date:           time.Now(),
+			PreviousAmount: prev,
+			AmountApplied:  amount,
+			EndAmount:      gc.LeftoverCents,
+			Kind:           kind,
+			Change:         change,
+			Reason:         reason,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &gc, nil
+}
+
+// Bought cards go live once their order is paid; ones with a recipient wait on the scheduler
+func (r *discountRepo) ActivateGiftCards(ids []int, orderID string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&models.GiftCard{}).
+		Where("id IN ? AND status = ?", ids, "Draft").
+		Updates(map[string]interface{}{
+			"status":       "Active",
+			"activated":    time.Now(),
+			"order_id":     orderID,
+			"send_pending": gorm.Expr("recipient_email <> ''"),
+		}).Error
+}
+
+func (r *discountRepo) GetDueGiftCards(now time.Time, limit int) ([]*models.GiftCard, error) {
+	var giftCards []*models.GiftCard
+	err := r.db.Where("send_pending = ? AND status = ? AND recipient_send_at <= ?", true, "Active", now).
+		Order("recipient_send_at").Limit(limit).Find(&giftCards).Error
+	return giftCards, err
+}
+
+// Only one run gets to send a card; false means another run already has it
+func (r *discountRepo) ClaimGiftCardSend(id int) (bool, error) {
+	res := r.db.Model(&models.GiftCard{}).
+		Where("id = ? AND send_pending = ?", id, true).
+		Updates(map[string]interface{}{"send_pending": false, "delivered": time.Now()})
+	return res.RowsAffected == 1, res.Error
+}
+
+// Puts a claimed card back for the next run after a failed send
+func (r *discountRepo) ReleaseGiftCardSend(id int) error {
+	return r.db.Model(&models.GiftCard{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"send_pending": true, "delivered": time.Time{}}).Error
+}
+
+// For changes that don't touch the balance; those go through ChangeGiftCard
+func (r *discountRepo) UpdateGiftCard(id int, apply func(gc *models.GiftCard) error) (*models.GiftCard, error) {
+	var gc models.GiftCard
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&gc, id).Error; err != nil {
+			return err
+		}
+
+		prev := gc.LeftoverCents
+		if err := apply(&gc); err != nil {
+			return err
+		} else if gc.LeftoverCents != prev {
+			return errors.New("gift card balance can only change with a ledger line")
+		}
+
+		return tx.Save(&gc).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &gc, nil
+}
+
+func (r *discountRepo) LockGiftCard(id int) error {
+	return r.db.Model(&models.GiftCard{}).
+		Where("id = ? AND locked = ?", id, false).
+		Updates(map[string]interface{}{"locked": true, "locked_at": time.Now()}).Error
+}
+
+func checkLedger(tx *gorm.DB, gc *models.GiftCard) error {
+	var lines []*models.GiftCardUseLine
+	if err := tx.Where("gift_card_id = ?", gc.ID).Find(&lines).Error; err != nil {
+		return err
+	}
+
+	if ledger := discount.GiftCardLedger(gc, lines); !ledger.Balanced {
+		return fmt.Errorf("gift card %d ledger is off: ledger %d, balance %d", gc.ID, ledger.LedgerSum, gc.LeftoverCents)
+	}
+	return nil
+}
+
 func (r *discountRepo) IDCodeExists(idCode string) (bool, error) {
 	var exists bool
-	err := r.db.Raw("SELECT EXISTS(SELECT 1 FROM gift_cards WHERE uuid_code = ?)", idCode).Scan(&exists).Error
+	err := r.db.Raw("SELECT EXISTS(SELECT 1 FROM gift_cards WHERE id_code = ?)", idCode).Scan(&exists).Error
 	return exists, err
 }
 
@@ -117,6 +326,13 @@ func (r *discountRepo) GetDiscountByCode(code string) (*models.Discount, error)
 	return &discount, err
 }
 
+// The customer referral code behind a FRIEND- discount code
+func (r *discountRepo) GetReferrer(code string) (*models.Affiliate, error) {
+	var aff models.Affiliate
+	err := r.db.Where("code = ? AND valid = true AND customer_id > 0", code).First(&aff).Error
+	return &aff, err
+}
+
 func (r *discountRepo) GetDiscountWithUsers(discountCode string) (*models.Discount, []*models.DiscountUser, error) {
 	var discount models.Discount
 	if err := r.db.Where("discount_code = ?", discountCode).First(&discount).Error; err != nil {
@@ -152,6 +368,149 @@ func (r *discountRepo) SaveGiftCards(giftCards []*models.GiftCard) error {
 	return r.db.Save(giftCards).Error
 }
 
+// Codes made one at a time; batch codes are listed through their batch
+func (r *discountRepo) SearchDiscounts(query, status string, limit, offset int) ([]*models.Discount, int64, error) {
+	q := r.db.Model(&models.Discount{}).Where("batch_id = 0")
+	if query != "" {
+		q = q.Where("discount_code ILIKE ? OR short_message ILIKE ?", "%"+query+"%", "%"+query+"%")
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var discounts []*models.Discount
+	err := q.Order("created DESC").Limit(limit).Offset(offset).Find(&discounts).Error
+	return discounts, total, err
+}
+
+func (r *discountRepo) GetDiscountUsers(discountID int) ([]*models.DiscountUser, error) {
+	var users []*models.DiscountUser
+	err := r.db.Where("discount_id = ?", discountID).Order("customer_id").Find(&users).Error
+	return users, err
+}
+
+// Customers dropped from the list lose their row, ones kept keep their use counts
+func (r *discountRepo) SaveDiscountWithUserList(discount *models.Discount, customerIDs []int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(discount).Error; err != nil {
+			return err
+		}
+
+		del := tx.Where("discount_id = ?", discount.ID)
+		if len(customerIDs) > 0 {
+			del = del.Where("customer_id NOT IN ?", customerIDs)
+		}
+		if err := del.Delete(&models.DiscountUser{}).Error; err != nil {
+			return err
+		}
+
+		if len(customerIDs) == 0 {
+			return nil
+		}
+
+		users := make([]models.DiscountUser, len(customerIDs))
+		for i, id := range customerIDs {
+			users[i] = models.DiscountUser{DiscountID: discount.ID, CustomerID: id}
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&users).Error
+	})
+}
+
+func (r *discountRepo) GetDiscountUseLines(discountID int) ([]*models.DiscountUseLine, error) {
+	var uses []*models.DiscountUseLine
+	err := r.db.Where("discount_id = ?", discountID).Order("date").Find(&uses).Error
+	return uses, err
+}
+
+// Batch and codes go in together or not at all; a code already taken fails the whole insert
+func (r *discountRepo) CreateDiscountBatch(batch *models.DiscountBatch, discounts []*models.Discount) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		for _, d := range discounts {
+			d.BatchID = batch.ID
+		}
+		return tx.CreateInBatches(discounts, 500).Error
+	})
+}
+
+func (r *discountRepo) GetDiscountBatch(id int) (*models.DiscountBatch, error) {
+	var batch models.DiscountBatch
+	err := r.db.First(&batch, id).Error
+	return &batch, err
+}
+
+func (r *discountRepo) GetBatchDiscounts(batchID int) ([]*models.Discount, error) {
+	var discounts []*models.Discount
+	err := r.db.Where("batch_id = ?", batchID).Order("id").Find(&discounts).Error
+	return discounts, err
+}
+
+func (r *discountRepo) DeactivateBatch(batchID int) (int64, error) {
+	now := time.Now()
+	var count int64
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Discount{}).
+			Where("batch_id = ? AND status = ?", batchID, "Active").
+			Updates(map[string]any{"status": "Deactivated", "deactivated": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		count = res.RowsAffected
+
+		return tx.Model(&models.DiscountBatch{}).Where("id = ?", batchID).Update("deactivated", now).Error
+	})
+
+	return count, err
+}
+
+func (r *discountRepo) BatchStats(batchID int) (*models.DiscountBatchStats, error) {
+	stats := models.DiscountBatchStats{BatchID: batchID}
+
+	err := r.db.Model(&models.Discount{}).
+		Select("COUNT(*) AS codes, COUNT(*) FILTER (WHERE status = 'Active') AS active, COUNT(*) FILTER (WHERE uses > 0) AS used_codes").
+		Where("batch_id = ?", batchID).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var uses struct {
+		Redemptions int
+		Reversals   int
+		Customers   int
+		FirstUse    *time.Time
+		LastUse     *time.Time
+	}
+	err = r.db.Model(&models.DiscountUseLine{}).
+		Select("COUNT(*) FILTER (WHERE NOT is_reversal) AS redemptions, COUNT(*) FILTER (WHERE is_reversal) AS reversals, "+
+			"COUNT(DISTINCT NULLIF(customer_id, 0)) + COUNT(DISTINCT NULLIF(guest_id, '')) AS customers, MIN(date) AS first_use, MAX(date) AS last_use").
+		Where("discount_id IN (?)", r.db.Model(&models.Discount{}).Select("id").Where("batch_id = ?", batchID)).
+		Scan(&uses).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Redemptions = uses.Redemptions
+	stats.Reversals = uses.Reversals
+	stats.Customers = uses.Customers
+	if uses.FirstUse != nil {
+		stats.FirstUse = *uses.FirstUse
+	}
+	if uses.LastUse != nil {
+		stats.LastUse = *uses.LastUse
+	}
+
+	return &stats, nil
+}
+
 func (r *discountRepo) DiscountUseLine(use *models.DiscountUseLine) {
 
 	if use == nil {
@@ -164,6 +523,60 @@ func (r *discountRepo) DiscountUseLine(use *models.DiscountUseLine) {
 
 }
 
+// All the codes on an order are used together or not at all; each count only goes up while it's under the max, so two
+// orders at once can't both take a code's last use
+func (r *discountRepo) UseDiscounts(discounts []*models.Discount, customerID int, uses []*models.DiscountUseLine) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, d := range discounts {
+			// Welcome and referral codes aren't stored
+			if d.ID < 0 {
+				continue
+			}
+
+			if d.HasUserList {
+				q := tx.Model(&models.DiscountUser{}).Where("discount_id = ? AND customer_id = ?", d.ID, customerID)
+				if d.HasMaxUses {
+					q = q.Where("uses < ?", d.MaxUses)
+				}
+				if res := q.Update("uses", gorm.Expr("uses + 1")); res.Error != nil {
+					return res.Error
+				} else if res.RowsAffected != 1 {
+					return fmt.Errorf("discount code %s has no uses left", d.DiscountCode)
+				}
+			}
+
+			q := tx.Model(&models.Discount{}).Where("id = ? AND status = ?", d.ID, "Active")
+			if d.HasMaxUses && !d.HasUserList {
+				q = q.Where("uses < max_uses")
+			}
+			if res := q.Update("uses", gorm.Expr("uses + 1")); res.Error != nil {
+				return res.Error
+			} else if res.RowsAffected != 1 {
+				return fmt.Errorf("discount code %s has no uses left", d.DiscountCode)
+			}
+
+			if !d.HasMaxUses {
+				continue
+			}
+
+			// A user list code is done once every listed customer has used up their share
+			done := tx.Model(&models.Discount{}).Where("id = ? AND uses >= max_uses", d.ID)
+			if d.HasUserList {
+				done = tx.Model(&models.Discount{}).Where("id = ? AND NOT EXISTS (?)", d.ID,
+					tx.Model(&models.DiscountUser{}).Select("1").Where("discount_id = ? AND uses < ?", d.ID, d.MaxUses))
+			}
+			if err := done.Update("status", "Deactivated").Error; err != nil {
+				return err
+			}
+		}
+
+		if len(uses) == 0 {
+			return nil
+		}
+		return tx.Create(&uses).Error
+	})
+}
+
 func (r *discountRepo) GiftCardUseLines(uses []*models.GiftCardUseLine) {
 	if len(uses) == 0 {
 		return
@@ -185,6 +598,7 @@ func (r *discountRepo) UseGiftCard(idCode, pin string, amount int) (int, int, in
 	}
 
 	if gc.Pin != pin {
+		tx.Rollback()
 		return 0, 0, 0, fmt.Errorf("incorrect pin: %s", idCode)
 	}
 
@@ -192,21 +606,30 @@ func (r *discountRepo) UseGiftCard(idCode, pin string, amount int) (int, int, in
 	id := gc.ID
 
 	if gc.Status == "Draft" {
+		tx.Rollback()
 		return 0, 0, 0, fmt.Errorf("not yet paid for: %s", idCode)
 	}
 
-	if gc.Status == "Spent" || gc.LeftoverCents == 0 {
+	if gc.Status == "Spent" || gc.Status == "Void" || gc.LeftoverCents == 0 {
+		tx.Rollback()
 		return 0, 0, 0, fmt.Errorf("giftcard spent: %s", idCode)
 	}
 
 	if gc.Expired.Before(time.Now()) {
+		tx.Rollback()
 		return 0, 0, 0, fmt.Errorf("expired: %s", idCode)
 	}
 
 	if gc.LeftoverCents < amount {
+		tx.Rollback()
 		return 0, 0, 0, fmt.Errorf("cents left over: %d, cents needed: %d", gc.LeftoverCents, amount)
 	}
 
+	if err := checkLedger(tx, &gc); err != nil {
+		tx.Rollback()
+		return 0, 0, 0, err
+	}
+
 	gc.LeftoverCents -= amount
 	new := gc.LeftoverCents
 
@@ -220,6 +643,21 @@ func (r *discountRepo) UseGiftCard(idCode, pin string, amount int) (int, int, in
 		return 0, 0, 0, err
 	}
 
+	use := &models.GiftCardUseLine{
+		GiftCardID:     gc.ID,
+		GiftCardCode:   idCode,
+		Date:           time.Now(),
+		PreviousAmount: prev,
+		AmountApplied:  amount,
+		EndAmount:      new,
+		Kind:           "Use",
+		Change:         -amount,
+	}
+	if err := tx.Create(use).Error; err != nil {
+		tx.Rollback()
+		return 0, 0, 0, err
+	}
+
 	return id, prev, new, tx.Commit().Error
 }
 
@@ -269,7 +707,7 @@ func (r *discountRepo) UseGiftCards(data map[[2]string]int, orderID, guestID, se
 			return nil, fmt.Errorf("not yet paid for: %s", idCode)
 		}
 
-		if gc.Status == "Spent" || gc.LeftoverCents == 0 {
+		if gc.Status == "Spent" || gc.Status == "Void" || gc.LeftoverCents == 0 {
 			tx.Rollback()
 			return nil, fmt.Errorf("giftcard spent: %s", idCode)
 		}
@@ -284,6 +722,11 @@ func (r *discountRepo) UseGiftCards(data map[[2]string]int, orderID, guestID, se
 			return nil, fmt.Errorf("cents left over: %d, cents needed: %d", gc.LeftoverCents, amount)
 		}
 
+		if err := checkLedger(tx, gc); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
 		prev := gc.LeftoverCents
 		gc.LeftoverCents -= amount
 
@@ -305,6 +748,8 @@ func (r *discountRepo) UseGiftCards(data map[[2]string]int, orderID, guestID, se
 			PreviousAmount: prev,
 			AmountApplied:  amount,
 			EndAmount:      gc.LeftoverCents,
+			Kind:           "Use",
+			Change:         -amount,
 		}
 
 		uses = append(uses, use)
@@ -315,6 +760,11 @@ func (r *discountRepo) UseGiftCards(data map[[2]string]int, orderID, guestID, se
 		return nil, err
 	}
 
+	if err := tx.Create(&uses).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		return nil, err
 	}
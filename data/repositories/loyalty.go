@@ -0,0 +1,328 @@
+package repositories
+
+import (
+	"beam/data/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LoyaltyRepository interface {
+	GetAccount(customerID int) (*models.LoyaltyAccount, error)
+	SaveAccount(account *models.LoyaltyAccount) error
+	EarnPoints(lot *models.PointsLot, orderID, reason string) (bool, error)
+	GetActiveLots(customerID int, now time.Time) ([]*models.PointsLot, error)
+	GetLots(customerID int) ([]*models.PointsLot, error)
+	GetPointsLines(customerID, limit int) ([]*models.PointsLine, error)
+	RedeemPoints(customerID, points int, orderID string, now time.Time) error
+	RestoreOrderPoints(orderID, reason string) (int, error)
+	ReverseOrderPoints(customerID int, orderID string, points int, reason string) (int, error)
+	ExpirePoints(now time.Time, limit int) (int, error)
+}
+
+type loyaltyRepo struct {
+	db *gorm.DB
+}
+
+func NewLoyaltyRepository(db *gorm.DB) LoyaltyRepository {
+	return &loyaltyRepo{db: db}
+}
+
+// An empty account for customers who haven't earned yet
+func (r *loyaltyRepo) GetAccount(customerID int) (*models.LoyaltyAccount, error) {
+	var account models.LoyaltyAccount
+	err := r.db.Where("customer_id = ?", customerID).First(&account).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.LoyaltyAccount{CustomerID: customerID}, nil
+	}
+	return &account, err
+}
+
+func (r *loyaltyRepo) SaveAccount(account *models.LoyaltyAccount) error {
+	return r.db.Save(account).Error
+}
+
+// Lot, Earn line and lifetime go in together; false when the customer already has a lot for the source and ref
+func (r *loyaltyRepo) EarnPoints(lot *models.PointsLot, orderID, reason string) (bool, error) {
+	earned := false
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		account := models.LoyaltyAccount{CustomerID: lot.CustomerID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, lot.CustomerID).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&models.PointsLot{}).Where("customer_id = ? AND source = ? AND source_ref = ?", lot.CustomerID, lot.Source, lot.SourceRef).Count(&count).Error; err != nil {
+			return err
+		} else if count > 0 {
+			return nil
+		}
+
+		if err := tx.Create(lot).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.PointsLine{
+			CustomerID: lot.CustomerID,
+			LotID:      lot.ID,
+			OrderID:    orderID,
+			Date:       lot.Created,
+			Kind:       "Earn",
+			Change:     lot.LeftoverPoints,
+			EndAmount:  lot.LeftoverPoints,
+			Reason:     reason,
+		}).Error; err != nil {
+			return err
+		}
+
+		account.Lifetime += lot.OriginalPoints
+		earned = true
+		return tx.Save(&account).Error
+	})
+
+	return earned, err
+}
+
+// Redeemable lots, soonest expiring first and lots that never expire last
+func (r *loyaltyRepo) GetActiveLots(customerID int, now time.Time) ([]*models.PointsLot, error) {
+	var lots []*models.PointsLot
+	err := activeLots(r.db, customerID, now).Find(&lots).Error
+	return lots, err
+}
+
+func (r *loyaltyRepo) GetLots(customerID int) ([]*models.PointsLot, error) {
+	var lots []*models.PointsLot
+	err := r.db.Where("customer_id = ?", customerID).Order("created DESC").Find(&lots).Error
+	return lots, err
+}
+
+func (r *loyaltyRepo) GetPointsLines(customerID, limit int) ([]*models.PointsLine, error) {
+	var lines []*models.PointsLine
+	err := r.db.Where("customer_id = ?", customerID).Order("id DESC").Limit(limit).Find(&lines).Error
+	return lines, err
+}
+
+// Takes the points from the customer's lots in redeeming order, all or nothing
+func (r *loyaltyRepo) RedeemPoints(customerID, points int, orderID string, now time.Time) error {
+	if points <= 0 {
+		return nil
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var lots []*models.PointsLot
+		if err := activeLots(tx.Clauses(clause.Locking{Strength: "UPDATE"}), customerID, now).Find(&lots).Error; err != nil {
+			return err
+		}
+
+		left, lines, err := takePoints(tx, lots, points, customerID, orderID, "Redeem", "", now)
+		if err != nil {
+			return err
+		} else if left > 0 {
+			return errors.New("not enough points")
+		}
+		return tx.Create(&lines).Error
+	})
+}
+
+// Gives back what an order redeemed from each lot, expired or not, returning the points restored
+func (r *loyaltyRepo) RestoreOrderPoints(orderID, reason string) (int, error) {
+	restored := 0
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var lines []*models.PointsLine
+		if err := tx.Where("order_id = ? AND kind IN ?", orderID, []string{"Redeem", "Restore"}).Find(&lines).Error; err != nil {
+			return err
+		}
+
+		owed := map[int]int{}
+		for _, l := range lines {
+			owed[l.LotID] -= l.Change
+		}
+
+		now := time.Now()
+		for lotID, points := range owed {
+			if points <= 0 {
+				continue
+			}
+
+			var lot models.PointsLot
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lot, lotID).Error; err != nil {
+				return err
+			}
+
+			lot.LeftoverPoints += points
+			if lot.Status == "Spent" {
+				lot.Status = "Active"
+			}
+			if err := tx.Save(&lot).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Create(&models.PointsLine{
+				CustomerID: lot.CustomerID,
+				LotID:      lot.ID,
+				OrderID:    orderID,
+				Date:       now,
+				Kind:       "Restore",
+				Change:     points,
+				EndAmount:  lot.LeftoverPoints,
+				Reason:     reason,
+			}).Error; err != nil {
+				return err
+			}
+			restored += points
+		}
+		return nil
+	})
+
+	return restored, err
+}
+
+// Takes back up to points of what the order earned that isn't reversed yet, from the order's own lot first and then
+// the customer's other lots; what they can't cover is recorded without a lot. Returns the points reversed
+func (r *loyaltyRepo) ReverseOrderPoints(customerID int, orderID string, points int, reason string) (int, error) {
+	reversed := 0
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var account models.LoyaltyAccount
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, customerID).Error; err != nil {
+			return err
+		}
+
+		var lines []*models.PointsLine
+		if err := tx.Where("customer_id = ? AND order_id = ? AND kind IN ?", customerID, orderID, []string{"Earn", "Reversal"}).Find(&lines).Error; err != nil {
+			return err
+		}
+
+		net, orderLot := 0, 0
+		for _, l := range lines {
+			if l.Kind == "Earn" {
+				orderLot = l.LotID
+			}
+			net += l.Change
+		}
+		if points > net {
+			points = net
+		}
+		if points <= 0 {
+			return nil
+		}
+
+		now := time.Now()
+		var lots []*models.PointsLot
+		if err := activeLots(tx.Clauses(clause.Locking{Strength: "UPDATE"}), customerID, now).Find(&lots).Error; err != nil {
+			return err
+		}
+		for i, lot := range lots {
+			if lot.ID == orderLot {
+				lots[0], lots[i] = lots[i], lots[0]
+				break
+			}
+		}
+
+		short, newLines, err := takePoints(tx, lots, points, customerID, orderID, "Reversal", reason, now)
+		if err != nil {
+			return err
+		}
+		if short > 0 {
+			newLines = append(newLines, &models.PointsLine{
+				CustomerID: customerID,
+				OrderID:    orderID,
+				Date:       now,
+				Kind:       "Reversal",
+				Change:     -short,
+				Reason:     reason + " (already redeemed)",
+			})
+		}
+		if err := tx.Create(&newLines).Error; err != nil {
+			return err
+		}
+
+		account.Lifetime = max(account.Lifetime-points, 0)
+		reversed = points
+		return tx.Save(&account).Error
+	})
+
+	return reversed, err
+}
+
+// Zeroes lots past their expiry with an Expire line each
+func (r *loyaltyRepo) ExpirePoints(now time.Time, limit int) (int, error) {
+	expired := 0
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var lots []*models.PointsLot
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ? AND expires > ? AND expires <= ?", "Active", time.Time{}, now).
+			Limit(limit).Find(&lots).Error; err != nil {
+			return err
+		}
+
+		for _, lot := range lots {
+			change := -lot.LeftoverPoints
+			lot.LeftoverPoints = 0
+			lot.Status = "Expired"
+			if err := tx.Save(lot).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Create(&models.PointsLine{
+				CustomerID: lot.CustomerID,
+				LotID:      lot.ID,
+				Date:       now,
+				Kind:       "Expire",
+				Change:     change,
+				Reason:     "Expired " + lot.Expires.Format("2006-01-02"),
+			}).Error; err != nil {
+				return err
+			}
+			expired++
+		}
+		return nil
+	})
+
+	return expired, err
+}
+
+// Lowers the lots in order until points are taken, saving each; returns what couldn't be taken and the lines to record
+func takePoints(tx *gorm.DB, lots []*models.PointsLot, points, customerID int, orderID, kind, reason string, now time.Time) (int, []*models.PointsLine, error) {
+	left := points
+	lines := []*models.PointsLine{}
+	for _, lot := range lots {
+		if left == 0 {
+			break
+		}
+
+		take := min(lot.LeftoverPoints, left)
+		lot.LeftoverPoints -= take
+		if lot.LeftoverPoints == 0 {
+			lot.Status = "Spent"
+		}
+		left -= take
+
+		if err := tx.Save(lot).Error; err != nil {
+			return left, nil, err
+		}
+		lines = append(lines, &models.PointsLine{
+			CustomerID: customerID,
+			LotID:      lot.ID,
+			OrderID:    orderID,
+			Date:       now,
+			Kind:       kind,
+			Change:     -take,
+			EndAmount:  lot.LeftoverPoints,
+			Reason:     reason,
+		})
+	}
+	return left, lines, nil
+}
+
+func activeLots(db *gorm.DB, customerID int, now time.Time) *gorm.DB {
+	return db.Where("customer_id = ? AND status = ? AND leftover_points > 0 AND (expires = ? OR expires > ?)", customerID, "Active", time.Time{}, now).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "expires = ?, expires, id", Vars: []interface{}{time.Time{}}}})
+}
@@ -1,6 +1,7 @@
 package repositories
 
 import (
+	"beam/config"
 	"beam/data/models"
 	"context"
 	"time"
@@ -25,6 +26,11 @@ type OrderRepository interface {
 	MarkOrderStatusUpdate(order *models.Order, status string) (bool, error)
 
 	GetCheckOrders() ([]models.Order, error)
+	GetReleasableOrders() ([]models.Order, error)
+	ClaimHeldOrder(id string) (bool, error)
+	LockHeldOrder(id string) (bool, error)
+	UnlockHeldOrder(id string) error
+	LockReleasedOrder(id string) (bool, error)
 	UpdateCheckDeliveryDate(ids []string) error
 	UpdateCheckEmailSent(ids []string) error
 	GetOrdersByIDs(ids []string) ([]models.Order, error)
@@ -218,6 +224,98 @@ func (r *orderRepo) GetCheckOrders() ([]models.Order, error) {
 	return orders, nil
 }
 
+func (r *orderRepo) GetReleasableOrders() ([]models.Order, error) {
+	filter := bson.M{
+		"held":       true,
+		"approval":   bson.M{"$ne": true},
+		"status":     bson.M{"$ne": "Cancelled"},
+		"held_until": bson.M{"$lt": time.Now()},
+	}
+
+	cursor, err := r.coll.Find(context.Background(), filter, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var orders []models.Order
+	if err := cursor.All(context.Background(), &orders); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// Takes the hold off only if the order is still held, not cancelled and not mid edit; false when another run or change got it first
+func (r *orderRepo) ClaimHeldOrder(id string) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.coll.UpdateOne(context.Background(), bson.M{
+		"_id":       objID,
+		"held":      true,
+		"status":    bson.M{"$ne": "Cancelled"},
+		"edit_lock": bson.M{"$not": bson.M{"$gt": time.Now().Add(-config.HELD_EDIT_LOCK_MINS * time.Minute)}},
+	}, bson.M{"$set": bson.M{"held": false}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// Only one edit or cancel of a held order at a time, and none once it's released
+func (r *orderRepo) LockHeldOrder(id string) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	res, err := r.coll.UpdateOne(context.Background(), bson.M{
+		"_id":       objID,
+		"held":      true,
+		"status":    bson.M{"$ne": "Cancelled"},
+		"edit_lock": bson.M{"$not": bson.M{"$gt": now.Add(-config.HELD_EDIT_LOCK_MINS * time.Minute)}},
+	}, bson.M{"$set": bson.M{"edit_lock": now}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// Same lock as LockHeldOrder for an order that's already been posted, e.g. so two refunds can't both pass the amount check;
+// UnlockHeldOrder clears it
+func (r *orderRepo) LockReleasedOrder(id string) (bool, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	res, err := r.coll.UpdateOne(context.Background(), bson.M{
+		"_id":       objID,
+		"held":      false,
+		"status":    bson.M{"$ne": "Cancelled"},
+		"edit_lock": bson.M{"$not": bson.M{"$gt": now.Add(-config.HELD_EDIT_LOCK_MINS * time.Minute)}},
+	}, bson.M{"$set": bson.M{"edit_lock": now}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *orderRepo) UnlockHeldOrder(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.coll.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{"$set": bson.M{"edit_lock": time.Time{}}})
+	return err
+}
+
 func (r *orderRepo) UpdateCheckDeliveryDate(ids []string) error {
 	var objectIDs []primitive.ObjectID
 	for _, id := range ids {
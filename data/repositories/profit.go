@@ -0,0 +1,144 @@
+package repositories
+
+import (
+	"beam/data/models"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ProfitRepository interface {
+	SaveOrderProfit(profit *models.OrderProfit, lines []*models.OrderProfitLine) error
+	GetOrderProfit(orderID string) (*models.OrderProfit, []*models.OrderProfitLine, error)
+	MarginReport(groupBy string, start, end time.Time) ([]models.MarginReport, error)
+	VariantMargins(variantIDs []int, since time.Time) (map[int]models.MarginReport, error)
+}
+
+type profitRepo struct {
+	db *gorm.DB
+}
+
+func NewProfitRepository(db *gorm.DB) ProfitRepository {
+	return &profitRepo{db: db}
+}
+
+// Replaces any earlier profit rows for the order, e.g. an estimate later confirmed by printful
+func (r *profitRepo) SaveOrderProfit(profit *models.OrderProfit, lines []*models.OrderProfitLine) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "order_id"}},
+			UpdateAll: true,
+		}).Create(profit).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("order_id = ?", profit.OrderID).Delete(&models.OrderProfitLine{}).Error; err != nil {
+			return err
+		}
+
+		if len(lines) == 0 {
+			return nil
+		}
+		return tx.Create(&lines).Error
+	})
+}
+
+func (r *profitRepo) GetOrderProfit(orderID string) (*models.OrderProfit, []*models.OrderProfitLine, error) {
+	var profit models.OrderProfit
+	if err := r.db.Where("order_id = ?", orderID).First(&profit).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var lines []*models.OrderProfitLine
+	if err := r.db.Where("order_id = ?", orderID).Find(&lines).Error; err != nil {
+		return &profit, nil, err
+	}
+
+	return &profit, lines, nil
+}
+
+// groupBy in "product", "variant", "discount", "store", "day", "week", "month"
+func (r *profitRepo) MarginReport(groupBy string, start, end time.Time) ([]models.MarginReport, error) {
+	var rows []models.MarginReport
+
+	var q *gorm.DB
+	switch groupBy {
+	case "product", "variant":
+		col := "product_id"
+		if groupBy == "variant" {
+			col = "variant_id"
+		}
+		q = r.db.Model(&models.OrderProfitLine{}).
+			Select(fmt.Sprintf("CAST(%s AS TEXT) AS key, COUNT(DISTINCT order_id) AS orders, SUM(quantity) AS quantity, SUM(revenue) AS revenue, SUM(item_cost + shared_cost) AS cost, SUM(margin) AS margin", col)).
+			Group(col)
+	case "discount", "store", "day", "week", "month":
+		key := "'store'"
+		switch groupBy {
+		case "discount":
+			key = "discount_code"
+		case "day", "week", "month":
+			key = fmt.Sprintf("TO_CHAR(DATE_TRUNC('%s', created), 'YYYY-MM-DD')", groupBy)
+		}
+		q = r.db.Model(&models.OrderProfit{}).
+			Select(fmt.Sprintf("%s AS key, COUNT(*) AS orders, 0 AS quantity, SUM(revenue) AS revenue, SUM(total_cost) AS cost, SUM(margin) AS margin", key)).
+			Group("1")
+	default:
+		return nil, errors.New("unknown margin report grouping: " + groupBy)
+	}
+
+	err := q.Where("created >= ? AND created < ?", start, end).
+		Order("margin ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		if rows[i].Revenue != 0 {
+			rows[i].MarginPct = float64(rows[i].Margin) / float64(rows[i].Revenue)
+		}
+	}
+
+	return rows, nil
+}
+
+func (r *profitRepo) VariantMargins(variantIDs []int, since time.Time) (map[int]models.MarginReport, error) {
+	var rows []struct {
+		VariantID int
+		Orders    int
+		Quantity  int
+		Revenue   int
+		Cost      int
+		Margin    int
+	}
+
+	err := r.db.Model(&models.OrderProfitLine{}).
+		Select("variant_id, COUNT(DISTINCT order_id) AS orders, SUM(quantity) AS quantity, SUM(revenue) AS revenue, SUM(item_cost + shared_cost) AS cost, SUM(margin) AS margin").
+		Where("variant_id IN ? AND created >= ?", variantIDs, since).
+		Group("variant_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[int]models.MarginReport, len(rows))
+	for _, row := range rows {
+		report := models.MarginReport{
+			Key:      fmt.Sprintf("%d", row.VariantID),
+			Orders:   row.Orders,
+			Quantity: row.Quantity,
+			Revenue:  row.Revenue,
+			Cost:     row.Cost,
+			Margin:   row.Margin,
+		}
+		if row.Revenue != 0 {
+			report.MarginPct = float64(row.Margin) / float64(row.Revenue)
+		}
+		ret[row.VariantID] = report
+	}
+
+	return ret, nil
+}
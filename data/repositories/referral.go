@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"beam/data/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReferralRepository interface {
+	GetReferralAffiliate(customerID int) (*models.Affiliate, error)
+	CreateReferralAffiliate(aff *models.Affiliate) error
+	GetAffiliateByCode(code string) (*models.Affiliate, error)
+	GetSessionIP(sessionID string) (string, error)
+	FraudCheck(ref *models.Referral) (string, error)
+	CountRewarded(referrerID int, since time.Time) (int64, error)
+	CreateReferral(ref *models.Referral) (bool, error)
+	GetReferralByOrder(orderID string) (*models.Referral, error)
+	GetReferrals(referrerID int) ([]*models.Referral, error)
+	ClaimReferral(id int) (bool, error)
+	SaveReferral(ref *models.Referral) error
+}
+
+type referralRepo struct {
+	db *gorm.DB
+}
+
+func NewReferralRepository(db *gorm.DB) ReferralRepository {
+	return &referralRepo{db: db}
+}
+
+// nil when the customer hasn't had a referral code made yet
+func (r *referralRepo) GetReferralAffiliate(customerID int) (*models.Affiliate, error) {
+	var aff models.Affiliate
+	err := r.db.Where("customer_id = ?", customerID).First(&aff).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &aff, err
+}
+
+func (r *referralRepo) CreateReferralAffiliate(aff *models.Affiliate) error {
+	return r.db.Create(aff).Error
+}
+
+func (r *referralRepo) GetAffiliateByCode(code string) (*models.Affiliate, error) {
+	var aff models.Affiliate
+	err := r.db.Where("code = ? AND valid = true", code).First(&aff).Error
+	return &aff, err
+}
+
+func (r *referralRepo) GetSessionIP(sessionID string) (string, error) {
+	if sessionID == "" {
+		return "", nil
+	}
+
+	var session models.Session
+	err := r.db.Select("ip_address").Where("id = ?", sessionID).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return session.IPAddress, err
+}
+
+// The first reason the referral looks like the referrer ordering for themselves or farming friends, empty when it looks fine
+func (r *referralRepo) FraudCheck(ref *models.Referral) (string, error) {
+	var count int64
+	if ref.GuestID != "" {
+		if err := r.db.Model(&models.Session{}).Where("customer_id = ? AND guest_id = ?", ref.ReferrerID, ref.GuestID).Count(&count).Error; err != nil {
+			return "", err
+		} else if count > 0 {
+			return "device", nil
+		}
+	}
+	if ref.IPAddress != "" {
+		if err := r.db.Model(&models.Session{}).Where("customer_id = ? AND ip_address = ?", ref.ReferrerID, ref.IPAddress).Count(&count).Error; err != nil {
+			return "", err
+		} else if count > 0 {
+			return "ip", nil
+		}
+	}
+
+	// One reward per friend, however many referrers they were sent by
+	if err := r.db.Model(&models.Referral{}).
+		Where("order_id <> ? AND status <> ? AND (friend_email = ? OR (friend_id > 0 AND friend_id = ?))", ref.OrderID, "Rejected", ref.FriendEmail, ref.FriendID).
+		Count(&count).Error; err != nil {
+		return "", err
+	} else if count > 0 {
+		return "duplicate", nil
+	}
+
+	if ref.GuestID != "" || ref.IPAddress != "" {
+		if err := r.db.Model(&models.Referral{}).
+			Where("referrer_id = ? AND order_id <> ? AND ((guest_id <> '' AND guest_id = ?) OR (ip_address <> '' AND ip_address = ?))", ref.ReferrerID, ref.OrderID, ref.GuestID, ref.IPAddress).
+			Count(&count).Error; err != nil {
+			return "", err
+		} else if count > 0 {
+			return "duplicate", nil
+		}
+	}
+
+	return "", nil
+}
+
+func (r *referralRepo) CountRewarded(referrerID int, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Referral{}).Where("referrer_id = ? AND status = ? AND rewarded >= ?", referrerID, "Rewarded", since).Count(&count).Error
+	return count, err
+}
+
+// False when the order already has a referral
+func (r *referralRepo) CreateReferral(ref *models.Referral) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(ref)
+	return res.RowsAffected > 0, res.Error
+}
+
+// nil when the order wasn't referred
+func (r *referralRepo) GetReferralByOrder(orderID string) (*models.Referral, error) {
+	var ref models.Referral
+	err := r.db.Where("order_id = ?", orderID).First(&ref).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &ref, err
+}
+
+func (r *referralRepo) GetReferrals(referrerID int) ([]*models.Referral, error) {
+	var refs []*models.Referral
+	err := r.db.Where("referrer_id = ?", referrerID).Order("created DESC").Find(&refs).Error
+	return refs, err
+}
+
+// Moves a Pending referral to Rewarding so only one delivery check pays it out
+func (r *referralRepo) ClaimReferral(id int) (bool, error) {
+	res := r.db.Model(&models.Referral{}).Where("id = ? AND status = ?", id, "Pending").Update("status", "Rewarding")
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *referralRepo) SaveReferral(ref *models.Referral) error {
+	return r.db.Save(ref).Error
+}
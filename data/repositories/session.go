@@ -19,7 +19,7 @@ type SessionRepository interface {
 	Delete(id string) error
 	AddToBatch(session *models.Session, line *models.SessionLine)
 	FlushBatch()
-	GetAffiliate(code string) (models.AffiliateSession, error)
+	GetAffiliate(code string) (*models.Affiliate, error)
 	AddAffiliateLine(line *models.AffiliateLine, store string)
 	AddAffiliateSale(line *models.AffiliateSale, store string)
 }
@@ -227,19 +227,17 @@ func (r *sessionRepo) FlushBatch() {
 	}
 }
 
-func (r *sessionRepo) GetAffiliate(code string) (models.AffiliateSession, error) {
+func (r *sessionRepo) GetAffiliate(code string) (*models.Affiliate, error) {
 	var aff models.Affiliate
 	if err := r.db.Where("code = ? AND valid = true", code).First(&aff).Error; err != nil {
-		return models.AffiliateSession{}, err
+		return nil, err
 	}
 
-	session := models.AffiliateSession{ID: aff.ID, ActualCode: aff.Code}
-
 	go func() {
 		r.db.Model(&models.Affiliate{}).Where("id = ?", aff.ID).Update("last_used", time.Now())
 	}()
 
-	return session, nil
+	return &aff, nil
 }
 
 func (r *sessionRepo) AddAffiliateLine(line *models.AffiliateLine, store string) {
@@ -22,6 +22,11 @@ type MainService struct {
 	Event        services.EventService
 	Notification services.NotificationService
 	Session      services.SessionService
+	Profit       services.ProfitService
+	StoreCredit  services.StoreCreditService
+	Loyalty      services.LoyaltyService
+	Referral     services.ReferralService
+	Affiliate    services.AffiliateService
 	Mutex        *config.AllMutexes
 }
 
@@ -51,6 +56,11 @@ func NewMainService(pgDBs map[string]*gorm.DB, redis *redis.Client, mongoDBs map
 			Event:        services.NewEventService(repositories.NewEventRepository(mongoDBs[name], redis, name, ct, storeLen)),
 			Notification: services.NewNotificationService(repositories.NewNotificationRepository(mongoDBs[name])),
 			Session:      services.NewSessionService(repositories.NewSessionRepository(pgDBs[name], redis, name, ct, storeLen)),
+			Profit:       services.NewProfitService(repositories.NewProfitRepository(pgDBs[name])),
+			StoreCredit:  services.NewStoreCreditService(repositories.NewStoreCreditRepository(pgDBs[name])),
+			Loyalty:      services.NewLoyaltyService(repositories.NewLoyaltyRepository(pgDBs[name])),
+			Referral:     services.NewReferralService(repositories.NewReferralRepository(pgDBs[name])),
+			Affiliate:    services.NewAffiliateService(repositories.NewAffiliateRepository(pgDBs[name])),
 		}
 
 		ct++
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"beam/data/repositories"
+	"beam/data/services/custhelp"
+	"beam/data/services/discount"
+	"beam/data/services/orderhelp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+type AffiliateService interface {
+	Login(dpi *DataPassIn, email, password string, tools *config.Tools) (string, error)
+	Logout(dpi *DataPassIn, token string) error
+	CheckLogin(dpi *DataPassIn, token string) (int, error)
+	GetDashboard(dpi *DataPassIn, affiliateID int, fromMonth, toMonth string) (models.AffiliateDashboard, error)
+	StatementCSV(dpi *DataPassIn, affiliateID int, month string) ([]byte, error)
+	PayoutCSV(dpi *DataPassIn, month string) ([]byte, error)
+	RecordCommission(dpi *DataPassIn, order *models.Order) error
+	ReverseCommission(dpi *DataPassIn, order *models.Order, cents, whole int, reason string) (int, error)
+	ListAffiliates(dpi *DataPassIn) ([]*models.Affiliate, error)
+	CreateAffiliate(dpi *DataPassIn, code, name, email, password string, commission float64, windowDays int) (*models.Affiliate, error)
+	UpdateAffiliate(dpi *DataPassIn, id int, name, email, password string, commission float64, windowDays int) (*models.Affiliate, error)
+	DeactivateAffiliate(dpi *DataPassIn, id int) (*models.Affiliate, error)
+	SetRates(dpi *DataPassIn, id int, rates []*models.AffiliateRate) error
+}
+
+type affiliateService struct {
+	affiliateRepo repositories.AffiliateRepository
+}
+
+func NewAffiliateService(affiliateRepo repositories.AffiliateRepository) AffiliateService {
+	return &affiliateService{affiliateRepo: affiliateRepo}
+}
+
+// Returns a portal token good for AFFILIATE_PORTAL_HOURS; the same error for every failure so emails can't be probed
+func (s *affiliateService) Login(dpi *DataPassIn, email, password string, tools *config.Tools) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	failed := errors.New("invalid email or password")
+
+	if unmaxed, err := config.RateLimit(tools.Redis, dpi.Store, "AFLE", email, config.AFFILIATE_LOGIN_ATTEMPTS, time.Hour); err != nil {
+		return "", err
+	} else if !unmaxed {
+		return "", errors.New("too many login attempts, try again later")
+	}
+	if unmaxed, err := config.RateLimit(tools.Redis, dpi.Store, "AFLI", dpi.IPAddress, config.AFFILIATE_LOGIN_ATTEMPTS, time.Hour); err != nil {
+		return "", err
+	} else if !unmaxed {
+		return "", errors.New("too many login attempts, try again later")
+	}
+
+	aff, err := s.affiliateRepo.GetPortalAffiliate(email)
+	if err != nil {
+		return "", err
+	} else if aff == nil || !aff.Valid || aff.Password == "" || !custhelp.CheckPassword(aff.Password, password) {
+		return "", failed
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	now := time.Now()
+	return token, s.affiliateRepo.SaveLogin(&models.AffiliateLogin{
+		TokenHash:   portalTokenHash(token),
+		AffiliateID: aff.ID,
+		Created:     now,
+		Expires:     now.Add(config.AFFILIATE_PORTAL_HOURS * time.Hour),
+	})
+}
+
+func (s *affiliateService) Logout(dpi *DataPassIn, token string) error {
+	return s.affiliateRepo.DeleteLogin(portalTokenHash(token))
+}
+
+// The affiliate the token is for, zero when it is unknown, expired or the affiliate was deactivated
+func (s *affiliateService) CheckLogin(dpi *DataPassIn, token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	login, err := s.affiliateRepo.GetLogin(portalTokenHash(token), time.Now())
+	if err != nil || login == nil {
+		return 0, err
+	}
+
+	aff, err := s.affiliateRepo.GetAffiliate(login.AffiliateID)
+	if err != nil {
+		return 0, err
+	} else if !aff.Valid {
+		return 0, nil
+	}
+	return aff.ID, nil
+}
+
+// Clicks, orders, revenue and commission by month from fromMonth through toMonth, both 2006-01;
+// empty fromMonth is eleven months before toMonth and empty toMonth is this month
+func (s *affiliateService) GetDashboard(dpi *DataPassIn, affiliateID int, fromMonth, toMonth string) (models.AffiliateDashboard, error) {
+	ret := models.AffiliateDashboard{}
+
+	now := time.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if toMonth != "" {
+		t, err := time.Parse("2006-01", toMonth)
+		if err != nil {
+			return ret, errors.New("month must be YYYY-MM")
+		}
+		to = t
+	}
+	from := to.AddDate(0, -11, 0)
+	if fromMonth != "" {
+		t, err := time.Parse("2006-01", fromMonth)
+		if err != nil {
+			return ret, errors.New("month must be YYYY-MM")
+		}
+		from = t
+	}
+	to = to.AddDate(0, 1, 0)
+	if !from.Before(to) {
+		return ret, errors.New("from month is after to month")
+	}
+	ret.From, ret.To = from, to
+
+	aff, err := s.affiliateRepo.GetAffiliate(affiliateID)
+	if err != nil {
+		return ret, err
+	}
+	rates, err := s.affiliateRepo.GetRates(affiliateID)
+	if err != nil {
+		return ret, err
+	}
+	ret.Code, ret.Name, ret.Rate, ret.Rates = aff.Code, aff.Name, aff.Commission, rates
+
+	clicks, err := s.affiliateRepo.ClickMonths(affiliateID, from, to)
+	if err != nil {
+		return ret, err
+	}
+	sales, err := s.affiliateRepo.SaleMonths(affiliateID, from, to)
+	if err != nil {
+		return ret, err
+	}
+	commissions, err := s.affiliateRepo.CommissionMonths(affiliateID, from, to)
+	if err != nil {
+		return ret, err
+	}
+
+	months := map[string]*models.AffiliateMonth{}
+	month := func(m string) *models.AffiliateMonth {
+		if _, ok := months[m]; !ok {
+			months[m] = &models.AffiliateMonth{Month: m}
+		}
+		return months[m]
+	}
+	for _, c := range clicks {
+		month(c.Month).Clicks += c.Count
+	}
+	for _, c := range sales {
+		month(c.Month).Orders += c.Count
+	}
+	for _, c := range commissions {
+		m := month(c.Month)
+		m.Revenue += c.Net
+		m.Commission += c.Cents
+	}
+
+	for _, m := range months {
+		ret.Months = append(ret.Months, *m)
+		ret.Clicks += m.Clicks
+		ret.Orders += m.Orders
+		ret.Revenue += m.Revenue
+		ret.Commission += m.Commission
+	}
+	sort.Slice(ret.Months, func(i, j int) bool { return ret.Months[i].Month < ret.Months[j].Month })
+
+	return ret, nil
+}
+
+// month is 2006-01
+func (s *affiliateService) StatementCSV(dpi *DataPassIn, affiliateID int, month string) ([]byte, error) {
+	from, err := time.Parse("2006-01", month)
+	if err != nil {
+		return nil, errors.New("month must be YYYY-MM")
+	}
+
+	aff, err := s.affiliateRepo.GetAffiliate(affiliateID)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := s.affiliateRepo.GetCommissions(affiliateID, from, from.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	return orderhelp.AffiliateStatementCSV(aff, month, lines)
+}
+
+// What every affiliate is owed for the month, for paying out
+func (s *affiliateService) PayoutCSV(dpi *DataPassIn, month string) ([]byte, error) {
+	from, err := time.Parse("2006-01", month)
+	if err != nil {
+		return nil, errors.New("month must be YYYY-MM")
+	}
+
+	counts, err := s.affiliateRepo.CommissionMonths(0, from, from.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+	list, err := s.affiliateRepo.ListAffiliates()
+	if err != nil {
+		return nil, err
+	}
+
+	affs := map[int]*models.Affiliate{}
+	for _, a := range list {
+		affs[a.ID] = a
+	}
+	return orderhelp.AffiliatePayoutCSV(month, affs, counts)
+}
+
+// Customer referral codes are paid through referrals, not commission
+func (s *affiliateService) RecordCommission(dpi *DataPassIn, order *models.Order) error {
+	if !order.AffiliateCredited() {
+		return nil
+	}
+
+	aff, err := s.affiliateRepo.GetAffiliate(order.AffiliateID)
+	if err != nil {
+		return err
+	} else if !aff.Valid || aff.CustomerID > 0 {
+		return nil
+	}
+
+	rates, err := s.affiliateRepo.GetRates(aff.ID)
+	if err != nil {
+		return err
+	}
+	rateMap := map[int]float64{}
+	for _, r := range rates {
+		rateMap[r.ProductID] = r.Commission
+	}
+
+	net, cents := orderhelp.AffiliateCommission(order, aff.Commission, rateMap)
+	if net <= 0 {
+		return nil
+	}
+
+	_, err = s.affiliateRepo.EarnCommission(&models.AffiliateCommission{
+		AffiliateID: aff.ID,
+		OrderID:     order.ID.Hex(),
+		Date:        time.Now(),
+		Kind:        "Earn",
+		NetCents:    net,
+		Cents:       cents,
+		Reason:      "Order " + order.ID.Hex(),
+	})
+	return err
+}
+
+// Takes back the share cents is of whole of the order's commission, e.g. a refund out of the order total
+func (s *affiliateService) ReverseCommission(dpi *DataPassIn, order *models.Order, cents, whole int, reason string) (int, error) {
+	if order.AffiliateID <= 0 || cents <= 0 || whole <= 0 {
+		return 0, nil
+	}
+	return s.affiliateRepo.ReverseCommission(order.ID.Hex(), cents, whole, reason)
+}
+
+func (s *affiliateService) ListAffiliates(dpi *DataPassIn) ([]*models.Affiliate, error) {
+	return s.affiliateRepo.ListAffiliates()
+}
+
+// An empty code is generated; an empty password leaves the affiliate without a portal login
+func (s *affiliateService) CreateAffiliate(dpi *DataPassIn, code, name, email, password string, commission float64, windowDays int) (*models.Affiliate, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		var err error
+		if code, err = discount.GenerateBatchCode(config.AFFILIATE_PREFIX); err != nil {
+			return nil, err
+		}
+	} else if discount.IsReferralCode(code) {
+		return nil, errors.New("affiliate code can't look like a referral code")
+	} else if len(code) > 64 {
+		return nil, errors.New("affiliate code too long")
+	}
+
+	aff := &models.Affiliate{Code: code, CreatedAt: time.Now(), Valid: true}
+	if err := setAffiliateDetails(aff, name, email, password, commission, windowDays); err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.affiliateRepo.GetPortalAffiliate(aff.Email); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, errors.New("an affiliate already has this email")
+	}
+
+	return aff, s.affiliateRepo.CreateAffiliate(aff)
+}
+
+// The code can't change as links with it are already out; an empty password keeps the current one
+func (s *affiliateService) UpdateAffiliate(dpi *DataPassIn, id int, name, email, password string, commission float64, windowDays int) (*models.Affiliate, error) {
+	aff, err := s.affiliateRepo.GetAffiliate(id)
+	if err != nil {
+		return nil, err
+	} else if aff.CustomerID > 0 {
+		return nil, errors.New("customer referral codes aren't managed as affiliates")
+	}
+
+	if err := setAffiliateDetails(aff, name, email, password, commission, windowDays); err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.affiliateRepo.GetPortalAffiliate(aff.Email); err != nil {
+		return nil, err
+	} else if existing != nil && existing.ID != aff.ID {
+		return nil, errors.New("an affiliate already has this email")
+	}
+
+	return aff, s.affiliateRepo.SaveAffiliate(aff)
+}
+
+// Stops tracking, commission and portal logins; what was already earned stays in the ledger
+func (s *affiliateService) DeactivateAffiliate(dpi *DataPassIn, id int) (*models.Affiliate, error) {
+	aff, err := s.affiliateRepo.GetAffiliate(id)
+	if err != nil {
+		return nil, err
+	} else if aff.CustomerID > 0 {
+		return nil, errors.New("customer referral codes aren't managed as affiliates")
+	}
+
+	aff.Valid = false
+	return aff, s.affiliateRepo.SaveAffiliate(aff)
+}
+
+func (s *affiliateService) SetRates(dpi *DataPassIn, id int, rates []*models.AffiliateRate) error {
+	aff, err := s.affiliateRepo.GetAffiliate(id)
+	if err != nil {
+		return err
+	} else if aff.CustomerID > 0 {
+		return errors.New("customer referral codes aren't managed as affiliates")
+	}
+
+	seen := map[int]bool{}
+	for _, r := range rates {
+		if r.ProductID <= 0 {
+			return errors.New("product rate needs a product id")
+		} else if seen[r.ProductID] {
+			return errors.New("product has more than one rate")
+		} else if r.Commission < 0 || r.Commission > 1 {
+			return errors.New("commission must be between 0 and 1")
+		}
+		seen[r.ProductID] = true
+		r.AffiliateID = id
+	}
+
+	return s.affiliateRepo.SetRates(id, rates)
+}
+
+func setAffiliateDetails(aff *models.Affiliate, name, email, password string, commission float64, windowDays int) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if name == "" || len(name) > 128 {
+		return errors.New("affiliate name required, up to 128 characters")
+	} else if !strings.Contains(email, "@") || len(email) > 256 {
+		return errors.New("affiliate needs a valid email")
+	} else if commission < 0 || commission > 1 {
+		return errors.New("commission must be between 0 and 1")
+	} else if windowDays < 0 || windowDays > 365 {
+		return errors.New("attribution window must be between 0 and 365 days")
+	}
+
+	if password != "" {
+		if !custhelp.PasswordMeetsRequirements(password, "", false) {
+			return errors.New("password doesn't meet requirements")
+		}
+		hash, err := custhelp.EncryptPassword(password)
+		if err != nil {
+			return err
+		}
+		aff.Password = hash
+	}
+
+	aff.Name, aff.Email, aff.Commission, aff.WindowDays = name, email, commission, windowDays
+	return nil
+}
+
+func portalTokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
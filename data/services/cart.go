@@ -5,6 +5,7 @@ import (
 	"beam/data/models"
 	"beam/data/repositories"
 	"beam/data/services/carthelp"
+	"beam/data/services/draftorderhelp"
 	"beam/data/services/product"
 	"errors"
 	"fmt"
@@ -14,14 +15,14 @@ import (
 )
 
 type CartService interface {
-	AddToCart(dpi *DataPassIn, handle string, vid, quant int, prodServ ProductService) (*models.Cart, error)
+	AddToCart(dpi *DataPassIn, handle string, vid, quant int, prodServ ProductService, storeSettings *config.SettingsMutex) (*models.Cart, error)
 	GetCart(dpi *DataPassIn, prodServ ProductService) (*models.CartRender, error)
-	AdjustQuantity(dpi *DataPassIn, lineID, quant int, prodServ ProductService) (*models.CartRender, error)
+	AdjustQuantity(dpi *DataPassIn, lineID, quant int, prodServ ProductService, storeSettings *config.SettingsMutex) (*models.CartRender, error)
 	ClearCart(dpi *DataPassIn) (*models.CartRender, error)
-	AddGiftCard(dpi *DataPassIn, message string, cents int, discService DiscountService, tools *config.Tools) (*models.Cart, error)
+	AddGiftCard(dpi *DataPassIn, message string, cents int, recipient models.GiftCardRecipient, discService DiscountService, tools *config.Tools) (*models.Cart, error)
 	DeleteGiftCard(dpi *DataPassIn, lineID int, prodServ ProductService) (*models.CartRender, error)
 	UpdateRender(dpi *DataPassIn, name string, cart *models.CartRender, ps ProductService) error
-	SavesListToCart(dpi *DataPassIn, varid int, handle string, ps ProductService, ls ListService) (models.SavesListRender, *models.CartRender, error)
+	SavesListToCart(dpi *DataPassIn, varid int, handle string, ps ProductService, ls ListService, storeSettings *config.SettingsMutex) (models.SavesListRender, *models.CartRender, error)
 
 	CartMiddleware(cartID, custID int, guestID string) (int, error)
 	GetCartMain(dpi *DataPassIn) (*models.Cart, error, bool)
@@ -37,6 +38,8 @@ type CartService interface {
 	GetCartLineWithValidation(dpi *DataPassIn, lineID int) (*models.CartLine, error)
 
 	CopyCartFromShare(dpi *DataPassIn, sharedCartID int) error
+	ApplyPromotions(dpi *DataPassIn, cart *models.CartRender, prodServ ProductService, storeSettings *config.SettingsMutex) error
+	PreviewDiscountCode(dpi *DataPassIn, code string, prodServ ProductService, ds DiscountService, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (*models.CartRender, error)
 }
 
 type cartService struct {
@@ -51,7 +54,7 @@ func (s *cartService) GetCartLineWithValidation(dpi *DataPassIn, lineID int) (*m
 	return s.cartRepo.GetCartLineWithValidation(dpi.CustomerID, dpi.CartID, lineID)
 }
 
-func (s *cartService) AddToCart(dpi *DataPassIn, handle string, vid, quant int, prodServ ProductService) (*models.Cart, error) {
+func (s *cartService) AddToCart(dpi *DataPassIn, handle string, vid, quant int, prodServ ProductService, storeSettings *config.SettingsMutex) (*models.Cart, error) {
 	p, r, err := prodServ.GetFullProduct(dpi, dpi.Store, handle)
 	if err != nil {
 		dpi.AddLog("Cart", "AddToCart", "Error querying product", "", err, models.EventPassInFinal{VariantID: vid, CartID: dpi.CartID})
@@ -96,7 +99,7 @@ func (s *cartService) AddToCart(dpi *DataPassIn, handle string, vid, quant int,
 	}
 
 	line.Quantity += quant
-	line.Price = product.VolumeDiscPrice(p.Variants[index].Price, quant, p.VolumeDisc)
+	line.Price = product.VolumeDiscPrice(p.Variants[index].Price, line.Quantity, product.VolumeTiers(&p, config.TagVolumeTiers(storeSettings, dpi.Store)))
 	line.CartID = cart.ID
 
 	if err := s.cartRepo.SaveCartLineNew(line); err != nil {
@@ -137,7 +140,7 @@ func (s *cartService) GetCart(dpi *DataPassIn, prodServ ProductService) (*models
 	return &ret, nil
 }
 
-func (s *cartService) AdjustQuantity(dpi *DataPassIn, lineID, quant int, prodServ ProductService) (*models.CartRender, error) {
+func (s *cartService) AdjustQuantity(dpi *DataPassIn, lineID, quant int, prodServ ProductService, storeSettings *config.SettingsMutex) (*models.CartRender, error) {
 	ret := models.CartRender{}
 
 	id, cart, lines, err := s.GetCartWithLinesAndVerify(dpi)
@@ -232,12 +235,13 @@ func (s *cartService) AdjustQuantity(dpi *DataPassIn, lineID, quant int, prodSer
 
 	oldQuant := ret.CartLines[index].ActualLine.Quantity
 	ret.CartLines[index].ActualLine.Quantity = newQuant
-	ret.CartLines[index].ActualLine.Price = product.VolumeDiscPrice(prod.Variants[varIndex].Price, newQuant, prod.VolumeDisc)
+	tiers := product.VolumeTiers(&prod, config.TagVolumeTiers(storeSettings, dpi.Store))
+	ret.CartLines[index].ActualLine.Price = product.VolumeDiscPrice(prod.Variants[varIndex].Price, newQuant, tiers)
 	ret.CartLines[index].QuantityMaxed = tooHigh
 
 	if err := s.cartRepo.SaveCartLineNew(&ret.CartLines[index].ActualLine); err != nil {
 		ret.CartLines[index].ActualLine.Quantity = oldQuant
-		ret.CartLines[index].ActualLine.Price = product.VolumeDiscPrice(prod.Variants[varIndex].Price, oldQuant, prod.VolumeDisc)
+		ret.CartLines[index].ActualLine.Price = product.VolumeDiscPrice(prod.Variants[varIndex].Price, oldQuant, tiers)
 
 		dpi.AddLog("Cart", "AdjustQuantity", "Unable to save cart line", "", err, models.EventPassInFinal{CartID: dpi.CartID, CartLineID: lineID, ProductID: lines[index].ProductID, VariantID: lines[index].VariantID})
 
@@ -293,7 +297,7 @@ func (s *cartService) ClearCart(dpi *DataPassIn) (*models.CartRender, error) {
 	return &ret, nil
 }
 
-func (s *cartService) AddGiftCard(dpi *DataPassIn, message string, cents int, discService DiscountService, tools *config.Tools) (*models.Cart, error) {
+func (s *cartService) AddGiftCard(dpi *DataPassIn, message string, cents int, recipient models.GiftCardRecipient, discService DiscountService, tools *config.Tools) (*models.Cart, error) {
 	id, cart, err := s.GetCartAndVerify(dpi)
 	if err != nil {
 		dpi.AddLog("Cart", "AddGiftCard", "Unable to query cart + lines", "", err, models.EventPassInFinal{CartID: dpi.CartID})
@@ -301,7 +305,7 @@ func (s *cartService) AddGiftCard(dpi *DataPassIn, message string, cents int, di
 	}
 	dpi.CartID = id
 
-	idDB, _, gccode, err := discService.CreateGiftCard(dpi, cents, message, dpi.Store, tools)
+	idDB, gccode, _, err := discService.CreateGiftCard(dpi, cents, message, recipient, dpi.Store, tools)
 	if err != nil {
 		dpi.AddLog("Cart", "AddGiftCard", "Unable to create gift card to add to cart", "", err, models.EventPassInFinal{CartID: dpi.CartID})
 		return nil, err
@@ -314,6 +318,7 @@ func (s *cartService) AddGiftCard(dpi *DataPassIn, message string, cents int, di
 		VariantID:       idDB,
 		GiftCardCode:    gccode,
 		GiftCardMessage: message,
+		GiftCardTo:      recipient,
 		Price:           cents,
 		Quantity:        1,
 	}
@@ -383,7 +388,7 @@ func (s *cartService) DeleteGiftCard(dpi *DataPassIn, lineID int, prodServ Produ
 	return &ret, nil
 }
 
-func (s *cartService) SavesListToCart(dpi *DataPassIn, varid int, handle string, ps ProductService, ls ListService) (models.SavesListRender, *models.CartRender, error) {
+func (s *cartService) SavesListToCart(dpi *DataPassIn, varid int, handle string, ps ProductService, ls ListService, storeSettings *config.SettingsMutex) (models.SavesListRender, *models.CartRender, error) {
 
 	sl, err := ls.DeleteSavesListRender(dpi, varid, 1, ps)
 	if err != nil {
@@ -391,7 +396,7 @@ func (s *cartService) SavesListToCart(dpi *DataPassIn, varid int, handle string,
 		return models.SavesListRender{}, nil, err
 	}
 
-	_, err = s.AddToCart(dpi, handle, varid, 1, ps)
+	_, err = s.AddToCart(dpi, handle, varid, 1, ps, storeSettings)
 	if err != nil {
 		dpi.AddLog("Cart", "SavesListToCart", "Unable to add to cart after delete off of saves list", "", err, models.EventPassInFinal{CartID: dpi.CartID, VariantID: varid})
 		return models.SavesListRender{}, nil, err
@@ -731,3 +736,60 @@ func (s *cartService) CopyCartFromShare(dpi *DataPassIn, sharedCartID int) error
 	dpi.AddLog("Cart", "CopyCartFromShare", "", "", nil, models.EventPassInFinal{CartID: newID})
 	return nil
 }
+
+// Cart render with what the code would take off, and why it only takes from part of the cart when it does
+func (s *cartService) PreviewDiscountCode(dpi *DataPassIn, code string, prodServ ProductService, ds DiscountService, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (*models.CartRender, error) {
+	ret, err := s.GetCart(dpi, prodServ)
+	if err != nil {
+		return nil, err
+	} else if ret.Empty {
+		return ret, nil
+	}
+
+	disc, users, err := ds.GetDiscountCodeForDraft(dpi, code, dpi.Store, ret.Subtotal, dpi.CustomerID, dpi.CustomerID < 1 && dpi.GuestID != "", "", storeSettings, tools, cs, ors)
+	if err != nil {
+		dpi.AddLog("Cart", "PreviewDiscountCode", "Discount code not usable", "", err, models.EventPassInFinal{CartID: dpi.CartID})
+		return ret, err
+	}
+
+	pMap, err := s.cartProducts(dpi, ret, prodServ)
+	if err != nil {
+		dpi.AddLog("Cart", "PreviewDiscountCode", "Unable to get products for cart lines", "", err, models.EventPassInFinal{CartID: dpi.CartID})
+		return ret, err
+	}
+
+	carthelp.ApplyPromotions(ret, config.Promotions(storeSettings, dpi.Store, time.Now()), pMap)
+	carthelp.PreviewDiscount(ret, draftorderhelp.OrderDiscountFrom(disc, users), pMap)
+
+	dpi.AddLog("Cart", "PreviewDiscountCode", "", "", nil, models.EventPassInFinal{CartID: dpi.CartID})
+	return ret, nil
+}
+
+// Line level adjustments from the store's automatic promotions
+func (s *cartService) ApplyPromotions(dpi *DataPassIn, cart *models.CartRender, prodServ ProductService, storeSettings *config.SettingsMutex) error {
+	if cart.Empty {
+		return nil
+	}
+
+	pMap, err := s.cartProducts(dpi, cart, prodServ)
+	if err != nil {
+		dpi.AddLog("Cart", "ApplyPromotions", "Unable to get products for cart lines", "", err, models.EventPassInFinal{CartID: dpi.CartID})
+		return err
+	}
+
+	carthelp.ApplyPromotions(cart, config.Promotions(storeSettings, dpi.Store, time.Now()), pMap)
+
+	dpi.AddLog("Cart", "ApplyPromotions", "", "", nil, models.EventPassInFinal{CartID: dpi.CartID})
+	return nil
+}
+
+func (s *cartService) cartProducts(dpi *DataPassIn, cart *models.CartRender, prodServ ProductService) (map[int]*models.ProductRedis, error) {
+	lines := []*models.CartLine{}
+	for i := range cart.CartLines {
+		if !cart.CartLines[i].ActualLine.IsGiftCard {
+			lines = append(lines, &cart.CartLines[i].ActualLine)
+		}
+	}
+
+	return prodServ.GetProductsMapFromCartLine(dpi, dpi.Store, lines)
+}
@@ -2,6 +2,7 @@ package carthelp
 
 import (
 	"beam/data/models"
+	"time"
 )
 
 func UpdateCartSub(cart *models.CartRender) {
@@ -15,3 +16,37 @@ func UpdateCartSub(cart *models.CartRender) {
 	cart.SumQuantity = quant
 	cart.Subtotal = subtotal
 }
+
+// Sets how far the cart is from the nearest free shipping threshold; gift cards don't count toward it
+// Rules limited to countries only apply once the country is known
+func SetFreeShipProgress(cart *models.CartRender, rules []models.FreeShipRule, country string, custTags []string) {
+	cart.FreeShipQualified = false
+	cart.FreeShipAway = 0
+	cart.FreeShipRule = ""
+
+	subtotal := 0
+	for _, l := range cart.CartLines {
+		if !l.ActualLine.IsGiftCard {
+			subtotal += l.ActualLine.Price * l.ActualLine.Quantity
+		}
+	}
+
+	now := time.Now()
+	for _, r := range rules {
+		if !r.Active(now) || !r.MatchesCountry(country) || !r.MatchesCustomer(custTags) {
+			continue
+		}
+
+		away := r.MinSubtotal - subtotal
+		if away <= 0 {
+			cart.FreeShipQualified = true
+			cart.FreeShipAway = 0
+			cart.FreeShipRule = r.Name
+			return
+		}
+		if cart.FreeShipAway == 0 || away < cart.FreeShipAway {
+			cart.FreeShipAway = away
+			cart.FreeShipRule = r.Name
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package carthelp
+
+import (
+	"beam/data/models"
+	"beam/data/services/draftorderhelp"
+)
+
+// Order lines for the cart's product lines so checkout's discount and promotion rules can run on the cart
+// The second return is each order line's index in cart.CartLines
+func orderLinesFromCart(cart *models.CartRender, products map[int]*models.ProductRedis) ([]models.OrderLine, []int) {
+	lines, index := []models.OrderLine{}, []int{}
+	for i, l := range cart.CartLines {
+		if l.ActualLine.IsGiftCard {
+			continue
+		}
+		ol := models.OrderLine{
+			ProductID:     l.ActualLine.ProductID,
+			VariantID:     l.ActualLine.VariantID,
+			Quantity:      l.ActualLine.Quantity,
+			EndPrice:      l.ActualLine.Price,
+			PromoDiscount: l.PromoDiscount,
+		}
+		if p, ok := products[l.ActualLine.ProductID]; ok {
+			ol.ProductTitle = p.Title
+			ol.Tags = p.Tags
+		}
+		lines = append(lines, ol)
+		index = append(index, i)
+	}
+	return lines, index
+}
+
+// Works out what the code would take off the cart the same way checkout does, gift cards excluded
+func PreviewDiscount(cart *models.CartRender, od models.OrderDiscount, products map[int]*models.ProductRedis) {
+	lines, _ := orderLinesFromCart(cart, products)
+
+	eligible, note := draftorderhelp.DiscountEligibility(od.Target, lines)
+	subtotal, eligibleSub := 0, 0
+	for i, l := range lines {
+		left := l.EndPrice*l.Quantity - l.PromoDiscount
+		subtotal += left
+		if eligible[i] {
+			eligibleSub += left
+		}
+	}
+
+	cart.DiscountCode = od.DiscountCode
+	cart.DiscountAmount = draftorderhelp.TargetedDiscountAmount(od, eligibleSub, subtotal)
+	cart.DiscountNote = note
+}
+
+// Sets each line's promotion adjustments and the cart's promotion total
+func ApplyPromotions(cart *models.CartRender, promos []models.Promotion, products map[int]*models.ProductRedis) {
+	for i := range cart.CartLines {
+		cart.CartLines[i].Promotions = nil
+		cart.CartLines[i].PromoDiscount = 0
+	}
+
+	lines, index := orderLinesFromCart(cart, products)
+	cart.Promotions = draftorderhelp.EvaluatePromotions(lines, promos)
+	cart.PromoDiscount = draftorderhelp.PromotionsTotal(cart.Promotions)
+
+	for i, l := range lines {
+		cart.CartLines[index[i]].Promotions = l.Promotions
+		cart.CartLines[index[i]].PromoDiscount = l.PromoDiscount
+	}
+}
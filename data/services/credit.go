@@ -0,0 +1,138 @@
+package services
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"beam/data/repositories"
+	"errors"
+	"slices"
+	"time"
+)
+
+type StoreCreditService interface {
+	IssueStoreCredit(dpi *DataPassIn, customerID, cents int, source, orderID, note string, storeSettings *config.SettingsMutex) (*models.StoreCredit, error)
+	GetWallet(dpi *DataPassIn, customerID int) (models.StoreCreditWallet, error)
+	Balance(dpi *DataPassIn, customerID int) (int, error)
+	UseStoreCredit(dpi *DataPassIn, customerID, cents int, orderID string) error
+	RestoreOrderCredit(dpi *DataPassIn, orderID, reason string, storeSettings *config.SettingsMutex) (int, error)
+	ExpireStoreCredit(dpi *DataPassIn) (int, error)
+}
+
+type storeCreditService struct {
+	storeCreditRepo repositories.StoreCreditRepository
+}
+
+func NewStoreCreditService(storeCreditRepo repositories.StoreCreditRepository) StoreCreditService {
+	return &storeCreditService{storeCreditRepo: storeCreditRepo}
+}
+
+var creditSources = []string{"Refund", "Return", "Goodwill", "Referral"}
+
+// Expiry comes from the store's rule for the source
+func (s *storeCreditService) IssueStoreCredit(dpi *DataPassIn, customerID, cents int, source, orderID, note string, storeSettings *config.SettingsMutex) (*models.StoreCredit, error) {
+	if customerID <= 0 {
+		return nil, errors.New("store credit needs a customer account")
+	} else if cents <= 0 || cents >= 100000000 {
+		return nil, errors.New("store credit amount out of range")
+	} else if !slices.Contains(creditSources, source) {
+		return nil, errors.New("store credit source must be Refund, Return, Goodwill or Referral")
+	} else if source == "Goodwill" && note == "" {
+		return nil, errors.New("goodwill credit needs a note")
+	}
+	if len(note) > 256 {
+		note = note[:255]
+	}
+
+	now := time.Now()
+	credit := &models.StoreCredit{
+		CustomerID:    customerID,
+		Created:       now,
+		Source:        source,
+		OrderID:       orderID,
+		Note:          note,
+		OriginalCents: cents,
+		LeftoverCents: cents,
+		Status:        "Active",
+	}
+	if days := config.StoreCreditDays(storeSettings, dpi.Store, source); days > 0 {
+		credit.Expires = now.AddDate(0, 0, days)
+	}
+
+	reason := source
+	if note != "" {
+		reason += ": " + note
+	}
+	if err := s.storeCreditRepo.IssueCredit(credit, reason); err != nil {
+		return nil, err
+	}
+	return credit, nil
+}
+
+func (s *storeCreditService) GetWallet(dpi *DataPassIn, customerID int) (models.StoreCreditWallet, error) {
+	ret := models.StoreCreditWallet{}
+
+	credits, err := s.storeCreditRepo.GetCredits(customerID)
+	if err != nil {
+		return ret, err
+	}
+	lines, err := s.storeCreditRepo.GetCreditLines(customerID, config.PAGELEN*5)
+	if err != nil {
+		return ret, err
+	}
+	ret.Credits, ret.Lines = credits, lines
+
+	now := time.Now()
+	for _, c := range credits {
+		if c.Status != "Active" || c.LeftoverCents <= 0 || (!c.Expires.IsZero() && !c.Expires.After(now)) {
+			continue
+		}
+		ret.Balance += c.LeftoverCents
+
+		if c.Expires.IsZero() {
+			continue
+		} else if ret.NextExpiry.IsZero() || c.Expires.Before(ret.NextExpiry) {
+			ret.NextExpiry, ret.Expiring = c.Expires, c.LeftoverCents
+		} else if c.Expires.Equal(ret.NextExpiry) {
+			ret.Expiring += c.LeftoverCents
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *storeCreditService) Balance(dpi *DataPassIn, customerID int) (int, error) {
+	if customerID <= 0 {
+		return 0, nil
+	}
+
+	credits, err := s.storeCreditRepo.GetActiveCredits(customerID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	balance := 0
+	for _, c := range credits {
+		balance += c.LeftoverCents
+	}
+	return balance, nil
+}
+
+func (s *storeCreditService) UseStoreCredit(dpi *DataPassIn, customerID, cents int, orderID string) error {
+	return s.storeCreditRepo.UseCredit(customerID, cents, orderID, time.Now())
+}
+
+// Puts credit spent on an order back, e.g. when it is cancelled; credit that expired meanwhile restarts its source's expiry
+func (s *storeCreditService) RestoreOrderCredit(dpi *DataPassIn, orderID, reason string, storeSettings *config.SettingsMutex) (int, error) {
+	now := time.Now()
+	return s.storeCreditRepo.RestoreOrderCredit(orderID, reason, func(source string) time.Time {
+		if days := config.StoreCreditDays(storeSettings, dpi.Store, source); days > 0 {
+			return now.AddDate(0, 0, days)
+		}
+		return time.Time{}
+	})
+}
+
+// Meant to run on a schedule per store; spending already skips expired credit, this writes it off in the ledger
+func (s *storeCreditService) ExpireStoreCredit(dpi *DataPassIn) (int, error) {
+	return s.storeCreditRepo.ExpireCredits(time.Now(), config.PAGELEN*10)
+}
@@ -0,0 +1,298 @@
+package custhelp
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Checks a contact already passed through VerifyContact (country and state codes set)
+// Hard problems go in Errors, anything worth offering the customer goes in Suggested
+func CheckAddress(contact *models.Contact, mutex *config.AllMutexes, store string) models.AddressCheck {
+	ret := models.AddressCheck{}
+	suggested := *contact
+	changed := false
+
+	country := strings.ToUpper(contact.CountryCode)
+
+	postal := normalizePostal(country, contact.ZipCode)
+	if format, ok := postalFormats[country]; ok && !format.MatchString(postal) {
+		ret.Errors = append(ret.Errors, fmt.Sprintf("postal code %s is not valid for %s", contact.ZipCode, contact.Country))
+	} else if postal != contact.ZipCode {
+		suggested.ZipCode = postal
+		changed = true
+	}
+
+	if len(ret.Errors) == 0 {
+		if ok, known := postalMatchesState(country, contact.StateCode, postal); known && !ok {
+			ret.Errors = append(ret.Errors, fmt.Sprintf("postal code %s is not in %s", postal, contact.ProvinceState))
+			if code := stateForPostal(country, postal); code != "" {
+				if name := stateName(mutex, country, code); name != "" {
+					suggested.ProvinceState = name
+					suggested.StateCode = code
+					changed = true
+				}
+			}
+		}
+	}
+
+	if !poBoxAllowed(&mutex.Settings, store, country) {
+		if poBoxPattern.MatchString(contact.StreetAddress1) || (contact.StreetAddress2 != nil && poBoxPattern.MatchString(*contact.StreetAddress2)) {
+			ret.Errors = append(ret.Errors, "we are unable to ship to PO boxes in "+contact.Country)
+		}
+	}
+
+	if country == "US" || country == "CA" || country == "AU" {
+		if street := NormalizeStreet(contact.StreetAddress1); street != contact.StreetAddress1 {
+			suggested.StreetAddress1 = street
+			changed = true
+		}
+		if contact.StreetAddress2 != nil {
+			if street := NormalizeStreet(*contact.StreetAddress2); street != *contact.StreetAddress2 {
+				suggested.StreetAddress2 = &street
+				changed = true
+			}
+		}
+	}
+
+	if city := strings.Join(strings.Fields(contact.City), " "); city != contact.City {
+		suggested.City = city
+		changed = true
+	}
+
+	if _, ok := postalFormats[country]; !ok {
+		ret.Warnings = append(ret.Warnings, "postal code format not checked for "+contact.Country)
+	}
+
+	ret.Valid = len(ret.Errors) == 0
+	if changed {
+		ret.Suggested = &suggested
+	}
+
+	return ret
+}
+
+func normalizePostal(country, postal string) string {
+	p := strings.ToUpper(strings.Join(strings.Fields(postal), " "))
+
+	compact := strings.NewReplacer(" ", "", "-", "").Replace(p)
+
+	switch country {
+	case "US":
+		if len(compact) == 9 && isDigits(compact) {
+			return compact[:5] + "-" + compact[5:]
+		}
+	case "CA", "GB", "IE":
+		if len(compact) >= 5 && len(compact) <= 7 {
+			return compact[:len(compact)-3] + " " + compact[len(compact)-3:]
+		}
+	case "NL":
+		if len(compact) == 6 {
+			return compact[:4] + " " + compact[4:]
+		}
+	case "SE":
+		if len(compact) == 5 && isDigits(compact) {
+			return compact[:3] + " " + compact[3:]
+		}
+	case "JP":
+		if len(compact) == 7 && isDigits(compact) {
+			return compact[:3] + "-" + compact[3:]
+		}
+	case "BR":
+		if len(compact) == 8 && isDigits(compact) {
+			return compact[:5] + "-" + compact[5:]
+		}
+	case "PL":
+		if len(compact) == 5 && isDigits(compact) {
+			return compact[:2] + "-" + compact[2:]
+		}
+	case "PT":
+		if len(compact) == 7 && isDigits(compact) {
+			return compact[:4] + "-" + compact[4:]
+		}
+	}
+
+	return p
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Second return is false when there is no table for the country/state
+func postalMatchesState(country, stateCode, postal string) (bool, bool) {
+	stateCode = strings.ToUpper(stateCode)
+	if alias, ok := stateCodeAliases[stateCode]; ok {
+		stateCode = alias
+	}
+
+	switch country {
+	case "CA":
+		letters, ok := caPostalLetters[stateCode]
+		if !ok || postal == "" {
+			return false, false
+		}
+		return strings.ContainsRune(letters, rune(postal[0])), true
+	case "US", "AU", "MX":
+		ranges, ok := postalRanges(country)[stateCode]
+		if !ok {
+			return false, false
+		}
+		val, ok := postalRangeValue(country, postal)
+		if !ok {
+			return false, false
+		}
+		return inRanges(ranges, val), true
+	}
+
+	return false, false
+}
+
+// Only returns a state when exactly one matches
+func stateForPostal(country, postal string) string {
+	matches := []string{}
+
+	switch country {
+	case "CA":
+		if postal == "" {
+			return ""
+		}
+		for code, letters := range caPostalLetters {
+			if strings.ContainsRune(letters, rune(postal[0])) {
+				matches = append(matches, code)
+			}
+		}
+	case "US", "AU", "MX":
+		val, ok := postalRangeValue(country, postal)
+		if !ok {
+			return ""
+		}
+		for code, ranges := range postalRanges(country) {
+			if inRanges(ranges, val) {
+				matches = append(matches, code)
+			}
+		}
+	}
+
+	if len(matches) != 1 {
+		return ""
+	}
+	return matches[0]
+}
+
+func postalRanges(country string) map[string][]postalRange {
+	switch country {
+	case "US":
+		return usZipPrefixes
+	case "AU":
+		return auPostcodes
+	case "MX":
+		return mxPostalPrefixes
+	}
+	return nil
+}
+
+// US uses the 3 digit prefix, MX the 2 digit prefix, AU the whole postcode
+func postalRangeValue(country, postal string) (int, bool) {
+	digits := postal
+	switch country {
+	case "US":
+		if len(postal) < 3 {
+			return 0, false
+		}
+		digits = postal[:3]
+	case "MX":
+		if len(postal) < 2 {
+			return 0, false
+		}
+		digits = postal[:2]
+	}
+
+	val, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, false
+	}
+	return val, true
+}
+
+func inRanges(ranges []postalRange, val int) bool {
+	for _, r := range ranges {
+		if val >= r.Low && val <= r.High {
+			return true
+		}
+	}
+	return false
+}
+
+func stateName(mutex *config.AllMutexes, country, code string) string {
+	mutex.Iso.Mu.RLock()
+	defer mutex.Iso.Mu.RUnlock()
+
+	var list []models.CodeBlock
+	switch country {
+	case "US":
+		list = mutex.Iso.States.US
+	case "CA":
+		list = mutex.Iso.States.CA
+	case "AU":
+		list = mutex.Iso.States.AU
+	case "MX":
+		list = mutex.Iso.States.MX
+	}
+
+	for _, bl := range list {
+		if strings.EqualFold(bl.Code, code) {
+			return bl.Name
+		}
+	}
+	return ""
+}
+
+func poBoxAllowed(settings *config.SettingsMutex, store, country string) bool {
+	settings.Mu.RLock()
+	defer settings.Mu.RUnlock()
+
+	countries, ok := settings.Settings.POBoxCountries[store]
+	if !ok {
+		return true
+	}
+	return slices.ContainsFunc(countries, func(c string) bool { return strings.EqualFold(c, country) })
+}
+
+// Collapses spacing, drops trailing periods and uses the usual postal abbreviations
+func NormalizeStreet(street string) string {
+	words := strings.Fields(street)
+
+	for i, w := range words {
+		trimmed := strings.TrimRight(w, ".,")
+		trail := w[len(trimmed):]
+		if trail == "." {
+			trail = ""
+		}
+		upper := strings.ToUpper(trimmed)
+
+		if abbr, ok := streetAbbreviations[upper]; ok {
+			words[i] = abbr + trail
+			continue
+		}
+
+		afterNumber := i == 1 && isDigits(strings.TrimRightFunc(words[0], unicode.IsLetter))
+		if abbr, ok := directionAbbreviations[upper]; ok && (afterNumber || (i == len(words)-1 && i > 0)) {
+			words[i] = abbr + trail
+			continue
+		}
+
+		words[i] = trimmed + trail
+	}
+
+	return strings.Join(words, " ")
+}
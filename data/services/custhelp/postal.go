@@ -0,0 +1,201 @@
+package custhelp
+
+import "regexp"
+
+// Country code -> accepted postal code format, after normalizePostal
+var postalFormats = map[string]*regexp.Regexp{
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	"CA": regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[ABCEGHJ-NPRSTV-Z] \d[ABCEGHJ-NPRSTV-Z]\d$`),
+	"MX": regexp.MustCompile(`^\d{5}$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`),
+	"IE": regexp.MustCompile(`^[A-Z]\d[\dW] [A-Z\d]{4}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"NL": regexp.MustCompile(`^\d{4} [A-Z]{2}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"AT": regexp.MustCompile(`^\d{4}$`),
+	"CH": regexp.MustCompile(`^\d{4}$`),
+	"DK": regexp.MustCompile(`^\d{4}$`),
+	"NO": regexp.MustCompile(`^\d{4}$`),
+	"SE": regexp.MustCompile(`^\d{3} \d{2}$`),
+	"FI": regexp.MustCompile(`^\d{5}$`),
+	"PL": regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"NZ": regexp.MustCompile(`^\d{4}$`),
+	"JP": regexp.MustCompile(`^\d{3}-\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-\d{3}$`),
+}
+
+type postalRange struct {
+	Low  int
+	High int
+}
+
+// US state code -> 3 digit ZIP prefix ranges
+var usZipPrefixes = map[string][]postalRange{
+	"AL": {{350, 369}},
+	"AK": {{995, 999}},
+	"AZ": {{850, 865}},
+	"AR": {{716, 729}},
+	"CA": {{900, 961}},
+	"CO": {{800, 816}},
+	"CT": {{60, 69}},
+	"DE": {{197, 199}},
+	"DC": {{200, 200}, {202, 205}, {569, 569}},
+	"FL": {{320, 349}},
+	"GA": {{300, 319}, {398, 399}},
+	"HI": {{967, 968}},
+	"ID": {{832, 838}},
+	"IL": {{600, 629}},
+	"IN": {{460, 479}},
+	"IA": {{500, 528}},
+	"KS": {{660, 679}},
+	"KY": {{400, 427}},
+	"LA": {{700, 714}},
+	"ME": {{39, 49}},
+	"MD": {{206, 219}},
+	"MA": {{10, 27}, {55, 55}},
+	"MI": {{480, 499}},
+	"MN": {{550, 567}},
+	"MS": {{386, 397}},
+	"MO": {{630, 658}},
+	"MT": {{590, 599}},
+	"NE": {{680, 693}},
+	"NV": {{889, 898}},
+	"NH": {{30, 38}},
+	"NJ": {{70, 89}},
+	"NM": {{870, 884}},
+	"NY": {{5, 5}, {100, 149}},
+	"NC": {{270, 289}},
+	"ND": {{580, 588}},
+	"OH": {{430, 459}},
+	"OK": {{730, 749}},
+	"OR": {{970, 979}},
+	"PA": {{150, 196}},
+	"PR": {{6, 7}, {9, 9}},
+	"RI": {{28, 29}},
+	"SC": {{290, 299}},
+	"SD": {{570, 577}},
+	"TN": {{370, 385}},
+	"TX": {{750, 799}, {885, 885}},
+	"UT": {{840, 847}},
+	"VT": {{50, 54}, {56, 59}},
+	"VA": {{201, 201}, {220, 246}},
+	"WA": {{980, 994}},
+	"WV": {{247, 268}},
+	"WI": {{530, 549}},
+	"WY": {{820, 831}},
+}
+
+// AU state code -> 4 digit postcode ranges
+var auPostcodes = map[string][]postalRange{
+	"NSW": {{1000, 2599}, {2619, 2899}, {2921, 2999}},
+	"ACT": {{200, 299}, {2600, 2618}, {2900, 2920}},
+	"VIC": {{3000, 3999}, {8000, 8999}},
+	"QLD": {{4000, 4999}, {9000, 9999}},
+	"SA":  {{5000, 5999}},
+	"WA":  {{6000, 6999}},
+	"TAS": {{7000, 7999}},
+	"NT":  {{800, 999}},
+}
+
+// MX state code -> first 2 digits of the codigo postal
+var mxPostalPrefixes = map[string][]postalRange{
+	"CMX": {{1, 16}},
+	"AGU": {{20, 20}},
+	"BCN": {{21, 22}},
+	"BCS": {{23, 23}},
+	"CAM": {{24, 24}},
+	"COA": {{25, 27}},
+	"COL": {{28, 28}},
+	"CHP": {{29, 30}},
+	"CHH": {{31, 33}},
+	"DUR": {{34, 35}},
+	"GUA": {{36, 38}},
+	"GRO": {{39, 41}},
+	"HID": {{42, 43}},
+	"JAL": {{44, 49}},
+	"MEX": {{50, 57}},
+	"MIC": {{58, 61}},
+	"MOR": {{62, 62}},
+	"NAY": {{63, 63}},
+	"NLE": {{64, 67}},
+	"OAX": {{68, 71}},
+	"PUE": {{72, 75}},
+	"QUE": {{76, 76}},
+	"ROO": {{77, 77}},
+	"SLP": {{78, 79}},
+	"SIN": {{80, 82}},
+	"SON": {{83, 85}},
+	"TAB": {{86, 86}},
+	"TAM": {{87, 89}},
+	"TLA": {{90, 90}},
+	"VER": {{91, 96}},
+	"YUC": {{97, 97}},
+	"ZAC": {{98, 99}},
+}
+
+// Older codes some lists still use
+var stateCodeAliases = map[string]string{
+	"DF": "CMX",
+}
+
+// CA province code -> first letters of the postal code
+var caPostalLetters = map[string]string{
+	"NL": "A",
+	"NS": "B",
+	"PE": "C",
+	"NB": "E",
+	"QC": "GHJ",
+	"ON": "KLMNP",
+	"MB": "R",
+	"SK": "S",
+	"AB": "T",
+	"BC": "V",
+	"NT": "X",
+	"NU": "X",
+	"YT": "Y",
+}
+
+// Applied to whole words of US, CA and AU street lines
+var streetAbbreviations = map[string]string{
+	"STREET":     "St",
+	"AVENUE":     "Ave",
+	"BOULEVARD":  "Blvd",
+	"ROAD":       "Rd",
+	"DRIVE":      "Dr",
+	"LANE":       "Ln",
+	"COURT":      "Ct",
+	"PLACE":      "Pl",
+	"TERRACE":    "Ter",
+	"PARKWAY":    "Pkwy",
+	"HIGHWAY":    "Hwy",
+	"CIRCLE":     "Cir",
+	"SQUARE":     "Sq",
+	"TRAIL":      "Trl",
+	"CRESCENT":   "Cres",
+	"EXPRESSWAY": "Expy",
+	"FREEWAY":    "Fwy",
+	"APARTMENT":  "Apt",
+	"SUITE":      "Ste",
+	"BUILDING":   "Bldg",
+	"FLOOR":      "Fl",
+	"UNIT":       "Unit",
+}
+
+// Directions only abbreviated right after the house number or as the last word
+var directionAbbreviations = map[string]string{
+	"NORTH":     "N",
+	"SOUTH":     "S",
+	"EAST":      "E",
+	"WEST":      "W",
+	"NORTHEAST": "NE",
+	"NORTHWEST": "NW",
+	"SOUTHEAST": "SE",
+	"SOUTHWEST": "SW",
+}
+
+var poBoxPattern = regexp.MustCompile(`(?i)\b(p\.?\s*o\.?\s*box|post\s+office\s+box|pobox|gpo\s+box|locked\s+bag|apartado\s+postal)\b`)
@@ -0,0 +1,105 @@
+package custhelp
+
+import (
+	"beam/config"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// 160 bit secret, base32 as authenticator apps expect it
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// otpauth:// URI for the QR code an authenticator app scans
+func TOTPURI(issuer, email, secret string) string {
+	label := url.PathEscape(issuer + ":" + email)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(config.TOTP_DIGITS))
+	q.Set("period", fmt.Sprint(config.TOTP_PERIOD))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// RFC 6238 code for a time step
+func totpCode(secret string, step int64) (uint, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < config.TOTP_DIGITS; i++ {
+		mod *= 10
+	}
+	return uint(code % mod), nil
+}
+
+// Accepts TOTP_SKEW steps either side for clock drift, but never a step at or before lastStep so a code can't be replayed;
+// returns the step matched, zero when none did
+func CheckTOTP(secret string, code uint, lastStep int64, now time.Time) (int64, error) {
+	current := now.Unix() / config.TOTP_PERIOD
+	for step := current - config.TOTP_SKEW; step <= current+config.TOTP_SKEW; step++ {
+		if step <= lastStep {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeEq(int32(want), int32(code)) == 1 {
+			return step, nil
+		}
+	}
+	return 0, nil
+}
+
+// Plain codes are shown once, only their hashes are stored
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes, hashes := []string{}, []string{}
+	for i := 0; i < config.RECOVERY_CODE_COUNT; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// Case and dashes don't matter when typing a code back in
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
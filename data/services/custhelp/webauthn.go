@@ -0,0 +1,385 @@
+package custhelp
+
+import (
+	"beam/data/models"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// COSE algorithms offered when registering, in order of preference
+var PasskeyAlgorithms = []int{-7, -8, -257}
+
+func NewPasskeyChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	coseKey      map[any]any
+}
+
+// Attestation statements aren't checked, registration asks for none; returns the credential ID, its SPKI public key, algorithm and sign counter
+func VerifyPasskeyRegistration(rpID, challenge string, reg models.PasskeyRegistration) (string, []byte, int, uint32, error) {
+	clientJSON, err := decodeB64(reg.ClientDataJSON)
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+	if err := checkClientData(clientJSON, "webauthn.create", rpID, challenge); err != nil {
+		return "", nil, 0, 0, err
+	}
+
+	attRaw, err := decodeB64(reg.AttestationObject)
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+	att, _, err := cborDecode(attRaw, 0)
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+	attMap, ok := att.(map[any]any)
+	if !ok {
+		return "", nil, 0, 0, errors.New("attestation object isn't a map")
+	}
+	rawAuth, ok := attMap["authData"].([]byte)
+	if !ok {
+		return "", nil, 0, 0, errors.New("attestation object has no authenticator data")
+	}
+
+	ad, err := parseAuthData(rawAuth)
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+	if err := checkAuthData(ad, rpID); err != nil {
+		return "", nil, 0, 0, err
+	}
+	if ad.credentialID == nil || ad.coseKey == nil {
+		return "", nil, 0, 0, errors.New("no attested credential")
+	}
+
+	pub, alg, err := coseToPublicKey(ad.coseKey)
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", nil, 0, 0, err
+	}
+
+	credID := base64.RawURLEncoding.EncodeToString(ad.credentialID)
+	if reg.ID != "" && reg.ID != credID {
+		return "", nil, 0, 0, errors.New("credential id doesn't match attested credential")
+	}
+
+	return credID, spki, alg, ad.signCount, nil
+}
+
+// Checks the signature with the stored key; returns the authenticator's new sign counter
+func VerifyPasskeyAssertion(rpID, challenge string, pk *models.Passkey, as models.PasskeyAssertion) (uint32, error) {
+	clientJSON, err := decodeB64(as.ClientDataJSON)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkClientData(clientJSON, "webauthn.get", rpID, challenge); err != nil {
+		return 0, err
+	}
+
+	rawAuth, err := decodeB64(as.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	ad, err := parseAuthData(rawAuth)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkAuthData(ad, rpID); err != nil {
+		return 0, err
+	}
+
+	sig, err := decodeB64(as.Signature)
+	if err != nil {
+		return 0, err
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(pk.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientHash := sha256.Sum256(clientJSON)
+	signed := append(append([]byte{}, rawAuth...), clientHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	switch pk.Algorithm {
+	case -7:
+		key, ok := pub.(*ecdsa.PublicKey)
+		if !ok || !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return 0, errors.New("invalid passkey signature")
+		}
+	case -257:
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return 0, errors.New("invalid passkey signature")
+		}
+	case -8:
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(key, signed, sig) {
+			return 0, errors.New("invalid passkey signature")
+		}
+	default:
+		return 0, errors.New("unsupported passkey algorithm")
+	}
+
+	// Zero both times means the authenticator doesn't count; otherwise it must go up or the key was cloned
+	if (ad.signCount != 0 || pk.SignCount != 0) && ad.signCount <= pk.SignCount {
+		return 0, errors.New("passkey sign counter didn't increase")
+	}
+
+	return ad.signCount, nil
+}
+
+func checkClientData(raw []byte, wantType, rpID, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return err
+	}
+	if cd.Type != wantType {
+		return errors.New("wrong client data type")
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return errors.New("challenge doesn't match")
+	}
+	if cd.Origin != "https://"+rpID || cd.CrossOrigin {
+		return errors.New("origin doesn't match store domain")
+	}
+	return nil
+}
+
+// Passkeys stand in for both password and two factor, so the user has to be verified and not just present
+func checkAuthData(ad *authData, rpID string) error {
+	rpHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(ad.rpIDHash, rpHash[:]) {
+		return errors.New("relying party doesn't match store domain")
+	}
+	if ad.flags&flagUserPresent == 0 || ad.flags&flagUserVerified == 0 {
+		return errors.New("user wasn't verified")
+	}
+	return nil
+}
+
+func parseAuthData(b []byte) (*authData, error) {
+	if len(b) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+
+	ad := &authData{rpIDHash: b[:32], flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if ad.flags&flagAttested == 0 {
+		return ad, nil
+	}
+
+	// 16 byte AAGUID, then the credential ID length
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data too short")
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, errors.New("credential id too short")
+	}
+	ad.credentialID = rest[:idLen]
+
+	key, _, err := cborDecode(rest[idLen:], 0)
+	if err != nil {
+		return nil, err
+	}
+	coseKey, ok := key.(map[any]any)
+	if !ok {
+		return nil, errors.New("credential public key isn't a map")
+	}
+	ad.coseKey = coseKey
+
+	return ad, nil
+}
+
+func coseToPublicKey(key map[any]any) (crypto.PublicKey, int, error) {
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == -7:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errors.New("P-256 point not on curve")
+		}
+		return pub, -7, nil
+	case kty == 3 && alg == -257:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("invalid RSA key")
+		}
+		exp := 0
+		for _, c := range e {
+			exp = exp<<8 | int(c)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, -257, nil
+	case kty == 1 && alg == -8:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), -8, nil
+	}
+
+	return nil, 0, errors.New("unsupported passkey key type")
+}
+
+// Browsers send base64url, some libraries pad it
+func decodeB64(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
+
+// Just enough CBOR for attestation objects and COSE keys; returns the value and bytes read
+func cborDecode(b []byte, depth int) (any, int, error) {
+	if depth > 8 {
+		return nil, 0, errors.New("cbor nested too deep")
+	}
+	if len(b) == 0 {
+		return nil, 0, errors.New("cbor truncated")
+	}
+
+	major, info := b[0]>>5, b[0]&0x1f
+	n, used := uint64(0), 1
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info == 24 && len(b) >= 2:
+		n, used = uint64(b[1]), 2
+	case info == 25 && len(b) >= 3:
+		n, used = uint64(binary.BigEndian.Uint16(b[1:3])), 3
+	case info == 26 && len(b) >= 5:
+		n, used = uint64(binary.BigEndian.Uint32(b[1:5])), 5
+	case info == 27 && len(b) >= 9:
+		n, used = binary.BigEndian.Uint64(b[1:9]), 9
+	default:
+		return nil, 0, errors.New("unsupported cbor length")
+	}
+
+	switch major {
+	case 0:
+		if n > 1<<62 {
+			return nil, 0, errors.New("cbor int too large")
+		}
+		return int64(n), used, nil
+	case 1:
+		if n > 1<<62 {
+			return nil, 0, errors.New("cbor int too large")
+		}
+		return -1 - int64(n), used, nil
+	case 2, 3:
+		if n > uint64(len(b)-used) {
+			return nil, 0, errors.New("cbor truncated")
+		}
+		val := b[used : used+int(n)]
+		if major == 3 {
+			return string(val), used + int(n), nil
+		}
+		return val, used + int(n), nil
+	case 4:
+		if n > uint64(len(b)) {
+			return nil, 0, errors.New("cbor truncated")
+		}
+		arr := make([]any, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v, l, err := cborDecode(b[used:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, v)
+			used += l
+		}
+		return arr, used, nil
+	case 5:
+		if n > uint64(len(b)) {
+			return nil, 0, errors.New("cbor truncated")
+		}
+		m := make(map[any]any, n)
+		for i := uint64(0); i < n; i++ {
+			k, l, err := cborDecode(b[used:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			used += l
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, 0, errors.New("unsupported cbor map key")
+			}
+			v, l, err := cborDecode(b[used:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			used += l
+			m[k] = v
+		}
+		return m, used, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, used, nil
+		case 21:
+			return true, used, nil
+		case 22, 23:
+			return nil, used, nil
+		}
+		// Floats only show up in attestation statements, which aren't read
+		if info >= 25 && info <= 27 {
+			return nil, used, nil
+		}
+	}
+
+	return nil, 0, errors.New("unsupported cbor type")
+}
@@ -7,11 +7,13 @@ import (
 	"beam/data/repositories"
 	"beam/data/services/custhelp"
 	"beam/data/services/orderhelp"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,7 +34,7 @@ type CustomerService interface {
 	UpdateContactAndRender(dpi *DataPassIn, contactID int, newContact *models.Contact, mutex *config.AllMutexes, isDefault bool) ([]*models.Contact, error, error)
 	DeleteContact(dpi *DataPassIn, contactID int) (int, error)
 	DeleteContactAndRender(dpi *DataPassIn, contactID int) ([]*models.Contact, error, error)
-	CreateCustomer(dpi *DataPassIn, customer *models.CustomerPost, ors OrderService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.ClientCookie, *models.TwoFactorCookie, *models.Customer, *models.ServerCookie, error) // To cart/draft/order IF !2FA
+	CreateCustomer(dpi *DataPassIn, customer *models.CustomerPost, ors OrderService, lys LoyaltyService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.ClientCookie, *models.TwoFactorCookie, *models.Customer, *models.ServerCookie, error) // To cart/draft/order IF !2FA
 	DeleteCustomer(dpi *DataPassIn) (*models.Customer, error)
 	UpdateCustomer(dpi *DataPassIn, customer *models.CustomerPost) (*models.Customer, error)
 
@@ -48,6 +50,7 @@ type CustomerService interface {
 	LogoutCookie(dpi *DataPassIn, cookie *models.ClientCookie) error
 	GetCookieCurrencies(mutex *config.AllMutexes) ([]models.CodeBlock, []models.CodeBlock)
 	SetCookieCurrency(c *models.ClientCookie, mutex *config.AllMutexes, choice string) error
+	SetCookieCountry(c *models.ClientCookie, mutex *config.AllMutexes, choice string) error
 
 	GetContactsWithDefault(dpi *DataPassIn, customerID int) ([]*models.Contact, error)
 	Update(dpi *DataPassIn, cust *models.Customer) error
@@ -59,16 +62,34 @@ type CustomerService interface {
 
 	WatchEmailVerification(dpi *DataPassIn, conn *websocket.Conn)
 	SendVerificationEmail(dpi *DataPassIn, tools *config.Tools) (string, error)
-	ProcessVerificationEmail(dpi *DataPassIn, param string) error // To cart/draft/order ?
+	ProcessVerificationEmail(dpi *DataPassIn, param string, lys LoyaltyService, storeSettings *config.SettingsMutex) error // To cart/draft/order ?
 
 	SendSignInCodeEmail(dpi *DataPassIn, email string, tools *config.Tools) (*models.SignInCodeCookie, bool, error)
-	ProcessSignInCodeEmail(dpi *DataPassIn, siCookie *models.SignInCodeCookie, sixdigits uint, post *models.CustomerPost, ors OrderService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.ClientCookie, error) // To cart/draft/order
+	ProcessSignInCodeEmail(dpi *DataPassIn, siCookie *models.SignInCodeCookie, sixdigits uint, post *models.CustomerPost, ors OrderService, lys LoyaltyService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.ClientCookie, error) // To cart/draft/order
 	ResendSignInCode(dpi *DataPassIn, siCookie models.SignInCodeCookie, tools *config.Tools) (models.SignInCodeCookie, error)
 
 	CreateTwoFACode(dpi *DataPassIn, cust *models.Customer, store, ipStr string, tools *config.Tools) (*models.TwoFactorCookie, error)
 	ProcessTwoFactor(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, sixdigits uint) (bool, error)
+	ProcessRecoveryCode(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, code string) (bool, error)
 	ResendTwoFactor(dpi *DataPassIn, twofactorcookie models.TwoFactorCookie, tools *config.Tools) (models.TwoFactorCookie, error)
 
+	BeginTOTP(dpi *DataPassIn) (string, string, error)
+	ConfirmTOTP(dpi *DataPassIn, sixdigits uint, tools *config.Tools) ([]string, error)
+	RegenerateRecoveryCodes(dpi *DataPassIn, sixdigits uint, tools *config.Tools) ([]string, error)
+	RecoveryCodesLeft(dpi *DataPassIn) (int64, error)
+	SetTwoFAMethod(dpi *DataPassIn, method string) error
+	RemoveTOTP(dpi *DataPassIn, sixdigits uint, tools *config.Tools) error
+
+	BeginPasskeyRegistration(dpi *DataPassIn, password string, sixdigits *uint, mutexes *config.AllMutexes) (*models.PasskeyOptions, error)
+	FinishPasskeyRegistration(dpi *DataPassIn, param, name string, reg models.PasskeyRegistration) (*models.Passkey, error)
+	BeginPasskeyLogin(dpi *DataPassIn, mutexes *config.AllMutexes) (*models.PasskeyOptions, error)
+	FinishPasskeyLogin(dpi *DataPassIn, param string, as models.PasskeyAssertion, tools *config.Tools) (*models.ClientCookie, error) // To cart/draft/order
+	BeginPasskeyTwoFactor(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, mutexes *config.AllMutexes) (*models.PasskeyOptions, error)
+	ProcessTwoFactorPasskey(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, param string, as models.PasskeyAssertion) (bool, error)
+	GetPasskeys(dpi *DataPassIn) ([]*models.Passkey, error)
+	RenamePasskey(dpi *DataPassIn, id int, name string) error
+	DeletePasskey(dpi *DataPassIn, id int, password string, sixdigits *uint) error
+
 	ChangeCustomerEmail(dpi *DataPassIn, newEmail, password string, tools *config.Tools) error
 
 	ActualEmailVerification(dpi *DataPassIn, store, param, ipStr string, customer *models.Customer, tools *config.Tools) error
@@ -82,12 +103,13 @@ type CustomerService interface {
 	ProcessResetEmail(dpi *DataPassIn, param string) (*models.ResetEmailCookie, error)
 	ResetPasswordActual(dpi *DataPassIn, resetCookie *models.ResetEmailCookie, password, passwordConfirm string, logAllOut bool) error
 
-	BirthdayEmails(dpi *DataPassIn, store string, ds DiscountService, tools *config.Tools) error
+	BirthdayEmails(dpi *DataPassIn, store string, ds DiscountService, lys LoyaltyService, storeSettings *config.SettingsMutex, tools *config.Tools) error
 
 	PrefillEmailAuth(dpi *DataPassIn, param string, tools *config.Tools) (string, bool, error)
 	GeneratePrefillAuthParam(dpi *DataPassIn, email string) string
 
 	CheckIfValidForWelcome(dpi *DataPassIn, custID int, email string, ors OrderService, tools *config.Tools) (bool, error)
+	GetDeviceCustomer(dpi *DataPassIn, guestID string) (int, error)
 	WelcomeDiscountEmail(dpi *DataPassIn, email string, cust *models.Customer, isCreate bool, ors OrderService, storeSettings *config.SettingsMutex, tools *config.Tools)
 
 	CreateAuthParams(dpi *DataPassIn, returnRoute, draftID, orderID string, cartID int) (string, error)
@@ -241,7 +263,7 @@ func (s *customerService) DeleteContactAndRender(dpi *DataPassIn, contactID int)
 	return list, updateErr, getErr
 }
 
-func (s *customerService) CreateCustomer(dpi *DataPassIn, customer *models.CustomerPost, ors OrderService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.ClientCookie, *models.TwoFactorCookie, *models.Customer, *models.ServerCookie, error) {
+func (s *customerService) CreateCustomer(dpi *DataPassIn, customer *models.CustomerPost, ors OrderService, lys LoyaltyService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.ClientCookie, *models.TwoFactorCookie, *models.Customer, *models.ServerCookie, error) {
 	validate := validator.New()
 	err := validate.Struct(customer)
 	if err != nil {
@@ -326,6 +348,12 @@ func (s *customerService) CreateCustomer(dpi *DataPassIn, customer *models.Custo
 		return nil, nil, newCust, c, err
 	}
 
+	if customer.IsEmailVerified {
+		if err := lys.AwardSignupPoints(dpi, newCust.ID, s, storeSettings); err != nil {
+			log.Printf("Unable to award signup points for customer: %d, in store: %s; error: %v\n", newCust.ID, dpi.Store, err)
+		}
+	}
+
 	var twofa *models.TwoFactorCookie
 	if customer.Uses2FA {
 		twofa, err = s.CreateTwoFACode(dpi, newCust, dpi.Store, dpi.IPAddress, tools)
@@ -446,13 +474,31 @@ func (s *customerService) LoginCookie(dpi *DataPassIn, email, password string, a
 
 	}
 
-	serverCookie, err := s.customerRepo.GetServerCookie(customer.ID, dpi.Store)
+	ret, twofa, err := s.loginCustomer(dpi, customer, addEmailSub, customer.Uses2FA, tools)
 	if err != nil {
 		return nil, nil, false, err
+	}
+
+	if usesPassword {
+		if err := s.customerRepo.SuccessfulPasswordAttempt(dpi.Store, dpi.GuestID, customer.ID); err != nil {
+			return ret, twofa, false, err
+		}
+	}
+
+	return ret, twofa, false, nil
+}
+
+// Shared by every way of signing in; a passkey already counts as the second factor so it skips the code
+func (s *customerService) loginCustomer(dpi *DataPassIn, customer *models.Customer, addEmailSub, withTwoFA bool, tools *config.Tools) (*models.ClientCookie, *models.TwoFactorCookie, error) {
+	email := customer.Email
+
+	serverCookie, err := s.customerRepo.GetServerCookie(customer.ID, dpi.Store)
+	if err != nil {
+		return nil, nil, err
 	} else if serverCookie == nil {
-		return nil, nil, false, fmt.Errorf("no active server cookie for email: %s; customer id: %d; store: %s", email, customer.ID, dpi.Store)
+		return nil, nil, fmt.Errorf("no active server cookie for email: %s; customer id: %d; store: %s", email, customer.ID, dpi.Store)
 	} else if customer.Status == "Archived" {
-		return nil, nil, false, fmt.Errorf("archived server cookie for email: %s; customer id: %d; store: %s", email, customer.ID, dpi.Store)
+		return nil, nil, fmt.Errorf("archived server cookie for email: %s; customer id: %d; store: %s", email, customer.ID, dpi.Store)
 	}
 
 	if addEmailSub {
@@ -462,14 +508,14 @@ func (s *customerService) LoginCookie(dpi *DataPassIn, email, password string, a
 	}
 
 	if err := s.customerRepo.SetDeviceMapping(customer.ID, dpi.GuestID, dpi.Store); err != nil {
-		return nil, nil, false, err
+		return nil, nil, err
 	}
 
 	var twofa *models.TwoFactorCookie
-	if customer.Uses2FA {
+	if withTwoFA {
 		twofa, err = s.CreateTwoFACode(dpi, customer, dpi.Store, dpi.IPAddress, tools)
 		if err != nil {
-			return nil, nil, false, err
+			return nil, nil, err
 		}
 	}
 
@@ -482,13 +528,7 @@ func (s *customerService) LoginCookie(dpi *DataPassIn, email, password string, a
 		Currency:      customer.OtherCurrency,
 	}
 
-	if usesPassword {
-		if err := s.customerRepo.SuccessfulPasswordAttempt(dpi.Store, dpi.GuestID, customer.ID); err != nil {
-			return ret, twofa, false, err
-		}
-	}
-
-	return ret, twofa, false, nil
+	return ret, twofa, nil
 }
 
 func (s *customerService) ResetPass(dpi *DataPassIn, email string) error {
@@ -684,6 +724,34 @@ func (s *customerService) SetCookieCurrency(c *models.ClientCookie, mutex *confi
 	return nil
 }
 
+// Country for display only; checkout uses the shipping contact for the actual tax
+func (s *customerService) SetCookieCountry(c *models.ClientCookie, mutex *config.AllMutexes, choice string) error {
+
+	if c == nil {
+		return errors.New("nil cookie pointer")
+	}
+
+	choice = strings.ToUpper(strings.TrimSpace(choice))
+
+	in := false
+	mutex.Iso.Mu.RLock()
+	for _, b := range mutex.Iso.Countries.List {
+		if b.Code == choice {
+			in = true
+			break
+		}
+	}
+	mutex.Iso.Mu.RUnlock()
+
+	if !in {
+		return errors.New("not approved country choice: " + choice)
+	}
+
+	c.Country = choice
+
+	return nil
+}
+
 func (s *customerService) GetContactsWithDefault(dpi *DataPassIn, customerID int) ([]*models.Contact, error) {
 	return s.customerRepo.GetContactsWithDefault(customerID)
 }
@@ -816,7 +884,7 @@ func (s *customerService) SendVerificationEmail(dpi *DataPassIn, tools *config.T
 	return id, s.SendVerificationToEmail(dpi, dpi.Store, id, dpi.IPAddress, cust, tools)
 }
 
-func (s *customerService) ProcessVerificationEmail(dpi *DataPassIn, param string) error {
+func (s *customerService) ProcessVerificationEmail(dpi *DataPassIn, param string, lys LoyaltyService, storeSettings *config.SettingsMutex) error {
 	verifParams, err := s.customerRepo.GetVerificationEmail(param, dpi.Store)
 	if err != nil {
 		return err
@@ -852,7 +920,15 @@ func (s *customerService) ProcessVerificationEmail(dpi *DataPassIn, param string
 		return nil
 	}
 
-	return s.customerRepo.SetEmailVerified(cust.ID, true)
+	if err := s.customerRepo.SetEmailVerified(cust.ID, true); err != nil {
+		return err
+	}
+
+	// Accounts made before verifying earn their signup points here
+	if err := lys.AwardSignupPoints(dpi, cust.ID, s, storeSettings); err != nil {
+		log.Printf("Unable to award signup points for customer: %d, in store: %s; error: %v\n", cust.ID, dpi.Store, err)
+	}
+	return nil
 }
 
 // Cookie, whether to render full form, error
@@ -897,7 +973,7 @@ func (s *customerService) SendSignInCodeEmail(dpi *DataPassIn, email string, too
 }
 
 // nil, nil means everything else right, but 6 digit code wrong
-func (s *customerService) ProcessSignInCodeEmail(dpi *DataPassIn, siCookie *models.SignInCodeCookie, sixdigits uint, post *models.CustomerPost, ors OrderService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.ClientCookie, error) {
+func (s *customerService) ProcessSignInCodeEmail(dpi *DataPassIn, siCookie *models.SignInCodeCookie, sixdigits uint, post *models.CustomerPost, ors OrderService, lys LoyaltyService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.ClientCookie, error) {
 	if time.Since(siCookie.Set) > config.SIGNIN_EXPIR_MINS*time.Minute {
 		return nil, errors.New("past expiration")
 	}
@@ -990,20 +1066,28 @@ func (s *customerService) ProcessSignInCodeEmail(dpi *DataPassIn, siCookie *mode
 	}
 
 	post.IsEmailVerified = true
-	client, _, _, _, err := s.CreateCustomer(dpi, post, ors, storeSettings, tools)
+	client, _, _, _, err := s.CreateCustomer(dpi, post, ors, lys, storeSettings, tools)
 	if err != nil {
 		return nil, err
 	}
 	return client, nil
 }
 
+// Customers on TOTP get no email, the code comes from their authenticator app
 func (s *customerService) CreateTwoFACode(dpi *DataPassIn, cust *models.Customer, store, ipStr string, tools *config.Tools) (*models.TwoFactorCookie, error) {
 
 	code := "TF-" + uuid.NewString()
-	sixdigit := uint(100000 + rand.Intn(900000))
 	setTime := time.Now()
-	param := models.TwoFactorEmailParam{Param: code, CustomerID: cust.ID, Set: setTime, SixDigitCode: []uint{sixdigit}, Tries: 0}
-	cookie := models.TwoFactorCookie{TwoFactorCode: code, CustomerID: cust.ID, Set: setTime}
+
+	if cust.TwoFAMethod == "totp" && cust.TOTPSecret != "" {
+		param := models.TwoFactorEmailParam{Param: code, CustomerID: cust.ID, Set: setTime, Tries: 0, Method: "totp"}
+		cookie := models.TwoFactorCookie{TwoFactorCode: code, CustomerID: cust.ID, Set: setTime, Method: "totp"}
+		return &cookie, s.customerRepo.StoreTwoFactor(param, store)
+	}
+
+	sixdigit := uint(100000 + rand.Intn(900000))
+	param := models.TwoFactorEmailParam{Param: code, CustomerID: cust.ID, Set: setTime, SixDigitCode: []uint{sixdigit}, Tries: 0, Method: "email"}
+	cookie := models.TwoFactorCookie{TwoFactorCode: code, CustomerID: cust.ID, Set: setTime, Method: "email"}
 
 	if err := emails.TwoFactorEmail(store, cust.Email, ipStr, sixdigit, tools); err != nil {
 		return &cookie, err
@@ -1014,6 +1098,25 @@ func (s *customerService) CreateTwoFACode(dpi *DataPassIn, cust *models.Customer
 
 // Is correct six digit (recoverable), other error (not recoverable)
 func (s *customerService) ProcessTwoFactor(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, sixdigits uint) (bool, error) {
+	return s.processTwoFactor(dpi, twofactorcookie, true, func(serverTwoFA models.TwoFactorEmailParam) (bool, error) {
+		if serverTwoFA.Method == "totp" {
+			return s.totpMatches(serverTwoFA.CustomerID, sixdigits)
+		}
+		return slices.Contains(serverTwoFA.SixDigitCode, sixdigits), nil
+	})
+}
+
+// A recovery code in place of the authenticator app's code, each works once; wrong ones count as failed tries
+func (s *customerService) ProcessRecoveryCode(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, code string) (bool, error) {
+	return s.processTwoFactor(dpi, twofactorcookie, false, func(serverTwoFA models.TwoFactorEmailParam) (bool, error) {
+		if serverTwoFA.Method != "totp" {
+			return false, errors.New("recovery codes are only for authenticator app two factor")
+		}
+		return s.customerRepo.UseRecoveryCode(serverTwoFA.CustomerID, custhelp.HashRecoveryCode(code))
+	})
+}
+
+func (s *customerService) processTwoFactor(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, emailedCode bool, matches func(serverTwoFA models.TwoFactorEmailParam) (bool, error)) (bool, error) {
 	if time.Since(twofactorcookie.Set) > config.TWOFA_EXPIR_MINS*time.Minute {
 		return false, errors.New("past expiration")
 	}
@@ -1066,7 +1169,12 @@ func (s *customerService) ProcessTwoFactor(dpi *DataPassIn, twofactorcookie *mod
 		return false, errors.New("incorrect two factor code between cookie and server side")
 	}
 
-	if !slices.Contains(serverTwoFA.SixDigitCode, sixdigits) {
+	matched, err := matches(serverTwoFA)
+	if err != nil {
+		return false, err
+	}
+
+	if !matched {
 		serverTwoFA.Tries++
 		if serverTwoFA.Tries >= config.MAX_TWOFA_ATTEMPTS {
 			return false, errors.New("two many failed attempts")
@@ -1078,6 +1186,11 @@ func (s *customerService) ProcessTwoFactor(dpi *DataPassIn, twofactorcookie *mod
 		return false, nil
 	}
 
+	// Only an emailed code shows they have the inbox
+	if !emailedCode || serverTwoFA.Method == "totp" {
+		return true, nil
+	}
+
 	if err := s.ToggleEmailVerified(dpi, true); err != nil {
 		return false, err
 	}
@@ -1427,7 +1540,7 @@ func (s *customerService) ResetPasswordActual(dpi *DataPassIn, resetCookie *mode
 	return nil
 }
 
-func (s *customerService) BirthdayEmails(dpi *DataPassIn, store string, ds DiscountService, tools *config.Tools) error {
+func (s *customerService) BirthdayEmails(dpi *DataPassIn, store string, ds DiscountService, lys LoyaltyService, storeSettings *config.SettingsMutex, tools *config.Tools) error {
 	currentDate := time.Now()
 	day := currentDate.Day()
 	month := int(currentDate.Month())
@@ -1468,6 +1581,7 @@ func (s *customerService) BirthdayEmails(dpi *DataPassIn, store string, ds Disco
 			continue
 		}
 		emails.CustBirthdayEmail(store, cust.Email, discCode, cust, false, tools)
+		s.birthdayPoints(dpi, cust.ID, lys, storeSettings)
 	}
 
 	for _, cust := range secondCusts {
@@ -1475,11 +1589,18 @@ func (s *customerService) BirthdayEmails(dpi *DataPassIn, store string, ds Disco
 			continue
 		}
 		emails.CustBirthdayEmail(store, cust.Email, discCode, cust, true, tools)
+		s.birthdayPoints(dpi, cust.ID, lys, storeSettings)
 	}
 
 	return nil
 }
 
+func (s *customerService) birthdayPoints(dpi *DataPassIn, customerID int, lys LoyaltyService, storeSettings *config.SettingsMutex) {
+	if err := lys.AwardBirthdayPoints(dpi, customerID, time.Now().Year(), s, storeSettings); err != nil {
+		log.Printf("Unable to award birthday points for customer: %d, in store: %s; error: %v\n", customerID, dpi.Store, err)
+	}
+}
+
 func (s *customerService) ResendSignInCode(dpi *DataPassIn, siCookie models.SignInCodeCookie, tools *config.Tools) (models.SignInCodeCookie, error) {
 	if time.Since(siCookie.Set) > config.SIGNIN_EXPIR_MINS*time.Minute {
 		return siCookie, errors.New("past expiration")
@@ -1603,6 +1724,10 @@ func (s *customerService) ResendTwoFactor(dpi *DataPassIn, twofactorcookie model
 	if err != nil {
 		return twofactorcookie, err
 	}
+
+	if serverTwoFA.Method == "totp" {
+		return twofactorcookie, errors.New("authenticator codes can't be resent")
+	}
 	removeTFA = true
 
 	if time.Since(serverTwoFA.Set) > config.TWOFA_EXPIR_MINS*time.Minute {
@@ -1656,6 +1781,509 @@ func (s *customerService) ResendTwoFactor(dpi *DataPassIn, twofactorcookie model
 	return twofactorcookie, nil
 }
 
+// The pending secret only replaces a current one once ConfirmTOTP sees a code from it; returns the secret and its QR uri
+func (s *customerService) BeginTOTP(dpi *DataPassIn) (string, string, error) {
+	cust, err := s.customerRepo.Read(dpi.CustomerID)
+	if err != nil {
+		return "", "", err
+	} else if cust == nil || cust.Status != "Active" {
+		return "", "", errors.New("no active customer")
+	}
+
+	secret, err := custhelp.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	sealed, err := config.SealSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	cust.TOTPPending = sealed
+	if err := s.customerRepo.Update(*cust); err != nil {
+		return "", "", err
+	}
+
+	return secret, custhelp.TOTPURI(dpi.Store, cust.Email, secret), nil
+}
+
+// Switches the customer to the authenticator app and turns two factor on; returns the recovery codes, shown only this once
+func (s *customerService) ConfirmTOTP(dpi *DataPassIn, sixdigits uint, tools *config.Tools) ([]string, error) {
+	if err := s.limitTOTP(dpi); err != nil {
+		return nil, err
+	}
+
+	cust, err := s.customerRepo.Read(dpi.CustomerID)
+	if err != nil {
+		return nil, err
+	} else if cust == nil || cust.TOTPPending == "" {
+		return nil, errors.New("no authenticator setup started")
+	}
+
+	secret, err := config.OpenSecret(cust.TOTPPending)
+	if err != nil {
+		return nil, err
+	}
+
+	step, err := custhelp.CheckTOTP(secret, sixdigits, 0, time.Now())
+	if err != nil {
+		return nil, err
+	} else if step == 0 {
+		return nil, s.wrongTOTP(dpi)
+	}
+
+	codes, hashes, err := custhelp.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	cust.TOTPSecret = cust.TOTPPending
+	cust.TOTPPending = ""
+	cust.TOTPLastStep = step
+	cust.TwoFAMethod = "totp"
+	if err := s.customerRepo.Update(*cust); err != nil {
+		return nil, err
+	}
+
+	if err := s.customerRepo.ReplaceRecoveryCodes(cust.ID, hashes); err != nil {
+		return nil, err
+	}
+
+	if err := s.ToggleTwoFactor(dpi, true); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Old codes stop working, the new ones are shown only this once
+func (s *customerService) RegenerateRecoveryCodes(dpi *DataPassIn, sixdigits uint, tools *config.Tools) ([]string, error) {
+	if err := s.limitTOTP(dpi); err != nil {
+		return nil, err
+	}
+
+	if ok, err := s.totpMatches(dpi.CustomerID, sixdigits); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, s.wrongTOTP(dpi)
+	}
+
+	codes, hashes, err := custhelp.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, s.customerRepo.ReplaceRecoveryCodes(dpi.CustomerID, hashes)
+}
+
+func (s *customerService) RecoveryCodesLeft(dpi *DataPassIn) (int64, error) {
+	return s.customerRepo.CountRecoveryCodes(dpi.CustomerID)
+}
+
+// Either method can be picked while an authenticator is set up, email is always available
+func (s *customerService) SetTwoFAMethod(dpi *DataPassIn, method string) error {
+	if method != "email" && method != "totp" {
+		return errors.New("invalid two factor method")
+	}
+
+	cust, err := s.customerRepo.Read(dpi.CustomerID)
+	if err != nil {
+		return err
+	} else if cust == nil {
+		return errors.New("no customer")
+	}
+
+	if method == "totp" && cust.TOTPSecret == "" {
+		return errors.New("no authenticator set up")
+	}
+
+	if cust.TwoFAMethod == method {
+		return nil
+	}
+
+	cust.TwoFAMethod = method
+	return s.customerRepo.Update(*cust)
+}
+
+// Back to emailed codes; two factor stays on
+func (s *customerService) RemoveTOTP(dpi *DataPassIn, sixdigits uint, tools *config.Tools) error {
+	if err := s.limitTOTP(dpi); err != nil {
+		return err
+	}
+
+	if ok, err := s.totpMatches(dpi.CustomerID, sixdigits); err != nil {
+		return err
+	} else if !ok {
+		return s.wrongTOTP(dpi)
+	}
+
+	cust, err := s.customerRepo.Read(dpi.CustomerID)
+	if err != nil {
+		return err
+	}
+
+	cust.TOTPSecret = ""
+	cust.TOTPPending = ""
+	cust.TOTPLastStep = 0
+	cust.TwoFAMethod = "email"
+	if err := s.customerRepo.Update(*cust); err != nil {
+		return err
+	}
+
+	return s.customerRepo.ReplaceRecoveryCodes(cust.ID, nil)
+}
+
+// Same wrong codes allowed as a two factor sign in gets, per customer; right codes don't count
+func (s *customerService) limitTOTP(dpi *DataPassIn) error {
+	if tooMany, err := s.customerRepo.CheckTOTPFailedAttempts(dpi.Store, dpi.CustomerID); err != nil {
+		return err
+	} else if tooMany {
+		return errors.New("too many authenticator attempts, try again later")
+	}
+	return nil
+}
+
+func (s *customerService) wrongTOTP(dpi *DataPassIn) error {
+	if err := s.customerRepo.SetTOTPFailedAttempts(dpi.Store, dpi.CustomerID); err != nil {
+		return err
+	}
+	return errors.New("incorrect authenticator code")
+}
+
+// Saves the matched step so the same code can't be used twice, even by two requests at once
+func (s *customerService) totpMatches(customerID int, sixdigits uint) (bool, error) {
+	cust, err := s.customerRepo.Read(customerID)
+	if err != nil {
+		return false, err
+	} else if cust == nil || cust.TOTPSecret == "" {
+		return false, errors.New("no authenticator set up")
+	}
+
+	secret, err := config.OpenSecret(cust.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, err := custhelp.CheckTOTP(secret, sixdigits, cust.TOTPLastStep, time.Now())
+	if err != nil || step == 0 {
+		return false, err
+	}
+
+	return s.customerRepo.SetTOTPStep(customerID, step)
+}
+
+// The store's domain is the relying party, so passkeys only work on the store they were made on; a stolen session
+// can't add one since the password or authenticator code is asked for first, and finishing needs this challenge
+func (s *customerService) BeginPasskeyRegistration(dpi *DataPassIn, password string, sixdigits *uint, mutexes *config.AllMutexes) (*models.PasskeyOptions, error) {
+	cust, err := s.customerRepo.Read(dpi.CustomerID)
+	if err != nil {
+		return nil, err
+	} else if cust == nil || cust.Status != "Active" {
+		return nil, errors.New("no active customer")
+	}
+
+	if err := s.reverifyCustomer(dpi, cust, password, sixdigits); err != nil {
+		return nil, err
+	}
+
+	pks, err := s.customerRepo.GetPasskeys(cust.ID)
+	if err != nil {
+		return nil, err
+	} else if len(pks) >= config.MAX_PASSKEYS {
+		return nil, errors.New("too many passkeys")
+	}
+
+	opts, err := s.newPasskeyChallenge(dpi, cust.ID, "Register", mutexes)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.UserID = passkeyUserHandle(cust.ID)
+	opts.UserName = cust.Email
+	opts.Algorithms = custhelp.PasskeyAlgorithms
+	for _, pk := range pks {
+		opts.Credentials = append(opts.Credentials, pk.CredentialID)
+	}
+
+	return opts, nil
+}
+
+func (s *customerService) FinishPasskeyRegistration(dpi *DataPassIn, param, name string, reg models.PasskeyRegistration) (*models.Passkey, error) {
+	challenge, err := s.takePasskeyChallenge(dpi, param, "Register")
+	if err != nil {
+		return nil, err
+	} else if challenge.CustomerID != dpi.CustomerID {
+		return nil, errors.New("incorrect customer between dpi and passkey challenge")
+	}
+
+	credID, publicKey, alg, signCount, err := custhelp.VerifyPasskeyRegistration(challenge.RPID, challenge.Challenge, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.customerRepo.GetPasskeyByCredential(credID); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, errors.New("passkey already registered")
+	}
+
+	if count, err := s.customerRepo.CountPasskeys(dpi.CustomerID); err != nil {
+		return nil, err
+	} else if count >= config.MAX_PASSKEYS {
+		return nil, errors.New("too many passkeys")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	} else if len(name) > 64 {
+		name = name[:64]
+	}
+
+	pk := &models.Passkey{
+		CustomerID:   dpi.CustomerID,
+		CredentialID: credID,
+		PublicKey:    publicKey,
+		Algorithm:    alg,
+		SignCount:    signCount,
+		Name:         name,
+		Created:      time.Now(),
+	}
+	return pk, s.customerRepo.CreatePasskey(pk)
+}
+
+// No allowed credentials, the browser offers whichever passkeys it has for the store
+func (s *customerService) BeginPasskeyLogin(dpi *DataPassIn, mutexes *config.AllMutexes) (*models.PasskeyOptions, error) {
+	return s.newPasskeyChallenge(dpi, 0, "Login", mutexes)
+}
+
+// Signs in the same as LoginCookie; the passkey verified the user so there's no two factor step
+func (s *customerService) FinishPasskeyLogin(dpi *DataPassIn, param string, as models.PasskeyAssertion, tools *config.Tools) (*models.ClientCookie, error) {
+	if unmaxed, err := config.RateLimit(tools.Redis, dpi.Store, "PKLI", dpi.IPAddress, config.MAX_PASSKEY_ATTEMPTS, time.Hour); err != nil {
+		return nil, err
+	} else if !unmaxed {
+		return nil, errors.New("too many passkey attempts, try again later")
+	}
+
+	challenge, err := s.takePasskeyChallenge(dpi, param, "Login")
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := s.checkPasskey(challenge, 0, as)
+	if err != nil {
+		return nil, err
+	}
+
+	customer, err := s.customerRepo.Read(pk.CustomerID)
+	if err != nil {
+		return nil, err
+	} else if customer == nil {
+		return nil, fmt.Errorf("no active customer for passkey: %d", pk.ID)
+	} else if customer.Status == "Archived" {
+		return nil, fmt.Errorf("archived customer for passkey: %d", pk.ID)
+	} else if customer.Status == "EmailOnly" {
+		return nil, fmt.Errorf("email only customer for passkey: %d", pk.ID)
+	}
+
+	client, _, err := s.loginCustomer(dpi, customer, false, false, tools)
+	return client, err
+}
+
+// Only the customer's own passkeys are allowed
+func (s *customerService) BeginPasskeyTwoFactor(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, mutexes *config.AllMutexes) (*models.PasskeyOptions, error) {
+	if twofactorcookie.CustomerID != dpi.CustomerID {
+		return nil, errors.New("incorrect customer between dpi and two factor cookie")
+	}
+
+	pks, err := s.customerRepo.GetPasskeys(dpi.CustomerID)
+	if err != nil {
+		return nil, err
+	} else if len(pks) == 0 {
+		return nil, errors.New("no passkeys registered")
+	}
+
+	opts, err := s.newPasskeyChallenge(dpi, dpi.CustomerID, "TwoFactor", mutexes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pk := range pks {
+		opts.Credentials = append(opts.Credentials, pk.CredentialID)
+	}
+	return opts, nil
+}
+
+// A passkey in place of the emailed or authenticator code, whichever method the customer uses
+func (s *customerService) ProcessTwoFactorPasskey(dpi *DataPassIn, twofactorcookie *models.TwoFactorCookie, param string, as models.PasskeyAssertion) (bool, error) {
+	challenge, err := s.takePasskeyChallenge(dpi, param, "TwoFactor")
+	if err != nil {
+		return false, err
+	}
+
+	return s.processTwoFactor(dpi, twofactorcookie, false, func(serverTwoFA models.TwoFactorEmailParam) (bool, error) {
+		if challenge.CustomerID != serverTwoFA.CustomerID {
+			return false, errors.New("incorrect customer between two factor and passkey challenge")
+		}
+		if _, err := s.checkPasskey(challenge, serverTwoFA.CustomerID, as); err != nil {
+			log.Printf("Passkey failed for two factor; customer: %d, in store: %s; error: %v\n", serverTwoFA.CustomerID, dpi.Store, err)
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+func (s *customerService) GetPasskeys(dpi *DataPassIn) ([]*models.Passkey, error) {
+	return s.customerRepo.GetPasskeys(dpi.CustomerID)
+}
+
+func (s *customerService) RenamePasskey(dpi *DataPassIn, id int, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return errors.New("passkey name must be 1 to 64 characters")
+	}
+	return s.customerRepo.RenamePasskey(dpi.CustomerID, id, name)
+}
+
+func (s *customerService) DeletePasskey(dpi *DataPassIn, id int, password string, sixdigits *uint) error {
+	cust, err := s.customerRepo.Read(dpi.CustomerID)
+	if err != nil {
+		return err
+	} else if cust == nil {
+		return errors.New("no customer")
+	}
+
+	if err := s.reverifyCustomer(dpi, cust, password, sixdigits); err != nil {
+		return err
+	}
+
+	return s.customerRepo.DeletePasskey(dpi.CustomerID, id)
+}
+
+// Password when given, otherwise the authenticator app's code (nil when none was sent, 000000 is a real code); wrong ones
+// count the same as at sign in
+func (s *customerService) reverifyCustomer(dpi *DataPassIn, cust *models.Customer, password string, sixdigits *uint) error {
+	if password != "" && cust.PasswordHash != "" {
+		if tooMany, err := s.customerRepo.CheckPasswordFailedAttempts(dpi.Store, dpi.GuestID, cust.ID); err != nil {
+			return err
+		} else if tooMany {
+			return errors.New("too many password attempts, try again later")
+		}
+
+		if !custhelp.CheckPassword(cust.PasswordHash, password) {
+			if tooMany, err := s.customerRepo.SetPasswordFailedAttempts(dpi.Store, dpi.GuestID, cust.ID); err != nil {
+				return err
+			} else if tooMany {
+				return errors.New("too many password attempts, try again later")
+			}
+			return errors.New("wrong password")
+		}
+		return s.customerRepo.SuccessfulPasswordAttempt(dpi.Store, dpi.GuestID, cust.ID)
+	}
+
+	if sixdigits != nil && cust.TOTPSecret != "" {
+		if err := s.limitTOTP(dpi); err != nil {
+			return err
+		}
+
+		if ok, err := s.totpMatches(cust.ID, *sixdigits); err != nil {
+			return err
+		} else if !ok {
+			return s.wrongTOTP(dpi)
+		}
+		return nil
+	}
+
+	return errors.New("confirm your password or authenticator code first")
+}
+
+func (s *customerService) newPasskeyChallenge(dpi *DataPassIn, customerID int, purpose string, mutexes *config.AllMutexes) (*models.PasskeyOptions, error) {
+	mutexes.Store.Mu.RLock()
+	domain, ok := mutexes.Store.Store.ToDomain[dpi.Store]
+	mutexes.Store.Mu.RUnlock()
+	if !ok || domain == "" {
+		return nil, fmt.Errorf("no domain for store: %s", dpi.Store)
+	}
+
+	challenge, err := custhelp.NewPasskeyChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	param := models.PasskeyChallengeParam{
+		Param:      "PK-" + uuid.NewString(),
+		Challenge:  challenge,
+		CustomerID: customerID,
+		Purpose:    purpose,
+		RPID:       domain,
+		Set:        time.Now(),
+	}
+	if err := s.customerRepo.StorePasskeyChallenge(param, dpi.Store); err != nil {
+		return nil, err
+	}
+
+	return &models.PasskeyOptions{
+		Param:     param.Param,
+		Challenge: challenge,
+		RPID:      domain,
+		RPName:    dpi.Store,
+		TimeoutMS: config.PASSKEY_EXPIR_MINS * 60 * 1000,
+	}, nil
+}
+
+func (s *customerService) takePasskeyChallenge(dpi *DataPassIn, param, purpose string) (models.PasskeyChallengeParam, error) {
+	challenge, err := s.customerRepo.TakePasskeyChallenge(param, dpi.Store)
+	if err != nil {
+		return challenge, err
+	}
+
+	if challenge.Purpose != purpose {
+		return challenge, errors.New("passkey challenge for a different ceremony")
+	}
+
+	if time.Since(challenge.Set) > config.PASSKEY_EXPIR_MINS*time.Minute {
+		return challenge, errors.New("past expiration server side")
+	}
+
+	return challenge, nil
+}
+
+// customerID zero takes whoever the passkey belongs to; the counter is saved so a replay or clone fails next time
+func (s *customerService) checkPasskey(challenge models.PasskeyChallengeParam, customerID int, as models.PasskeyAssertion) (*models.Passkey, error) {
+	pk, err := s.customerRepo.GetPasskeyByCredential(as.ID)
+	if err != nil {
+		return nil, err
+	} else if pk == nil {
+		return nil, errors.New("unknown passkey")
+	}
+
+	if customerID > 0 && pk.CustomerID != customerID {
+		return nil, errors.New("passkey belongs to another customer")
+	}
+
+	if as.UserHandle != "" && as.UserHandle != passkeyUserHandle(pk.CustomerID) {
+		return nil, errors.New("passkey user handle doesn't match")
+	}
+
+	signCount, err := custhelp.VerifyPasskeyAssertion(challenge.RPID, challenge.Challenge, pk, as)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := s.customerRepo.UsePasskey(pk, signCount); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("passkey used at the same time elsewhere")
+	}
+
+	return pk, nil
+}
+
+func passkeyUserHandle(customerID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(customerID)))
+}
+
 // Customer already exists normal, customer already exists email only, error (succeess is false, false, nil)
 func (s *customerService) CreateEmailOnlyCustomer(dpi *DataPassIn, email string, tools *config.Tools) (bool, bool, error) {
 	email = strings.ToLower(email)
@@ -1748,6 +2376,11 @@ func (s *customerService) CheckIfValidForWelcome(dpi *DataPassIn, custID int, em
 	}
 }
 
+// The customer last logged in on the device, zero if none
+func (s *customerService) GetDeviceCustomer(dpi *DataPassIn, guestID string) (int, error) {
+	return s.customerRepo.GetDeviceMapping(guestID, dpi.Store)
+}
+
 func (s *customerService) WelcomeDiscountEmail(dpi *DataPassIn, email string, cust *models.Customer, isCreate bool, ors OrderService, storeSettings *config.SettingsMutex, tools *config.Tools) {
 	id := 0
 	if cust != nil {
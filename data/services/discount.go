@@ -6,11 +6,18 @@ import (
 	"beam/data/models"
 	"beam/data/repositories"
 	"beam/data/services/discount"
+	"beam/data/services/draftorderhelp"
 	"errors"
 	"fmt"
+	"log"
+	"math/rand"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type DiscountService interface {
@@ -19,16 +26,39 @@ type DiscountService interface {
 	UpdateDiscount(dpi *DataPassIn, discount models.Discount) error
 	DeleteDiscount(dpi *DataPassIn, id int) error
 
-	CreateGiftCard(dpi *DataPassIn, cents int, message string, store string, tools *config.Tools) (int, string, string, error)
-	RenderGiftCard(dpi *DataPassIn, code string) (*models.GiftCardRender, error)
-	RetrieveGiftCard(dpi *DataPassIn, code, pin string) (*models.GiftCard, error)
+	ListDiscounts(dpi *DataPassIn, query, status string, page int) (models.DiscountListRender, error)
+	GetDiscountWithUsers(dpi *DataPassIn, id int) (*models.Discount, []*models.DiscountUser, error)
+	SaveDiscount(dpi *DataPassIn, discount models.Discount, customerIDs []int, mutexes *config.AllMutexes) (*models.Discount, error)
+	DeactivateDiscount(dpi *DataPassIn, id int) error
+	DiscountAnalytics(dpi *DataPassIn, id int, interval string, ors OrderService) (*models.DiscountAnalytics, error)
+
+	CreateDiscountBatch(dpi *DataPassIn, name, prefix string, count int, template models.Discount) (*models.DiscountBatch, error)
+	DiscountBatchCSV(dpi *DataPassIn, batchID int) ([]byte, error)
+	DiscountBatchStats(dpi *DataPassIn, batchID int) (*models.DiscountBatchStats, error)
+	DeactivateDiscountBatch(dpi *DataPassIn, batchID int) (int, error)
+
+	CreateGiftCard(dpi *DataPassIn, cents int, message string, recipient models.GiftCardRecipient, store string, tools *config.Tools) (int, string, string, error)
+	ActivatePurchasedGiftCards(dpi *DataPassIn, order *models.Order) error
+	VoidOrderGiftCards(dpi *DataPassIn, order *models.Order, reason string) (int, error)
+	DeliverScheduledGiftCards(dpi *DataPassIn, mutexes *config.AllMutexes, tools *config.Tools) (int, error)
+	UpdateGiftCardRecipient(dpi *DataPassIn, orderID string, cardID int, recipient models.GiftCardRecipient, ors OrderService) (*models.GiftCard, error)
+	ResendGiftCard(dpi *DataPassIn, orderID string, cardID int, ors OrderService, mutexes *config.AllMutexes, tools *config.Tools) error
+	RenderGiftCard(dpi *DataPassIn, code, deviceID string, tools *config.Tools) (*models.GiftCardRender, error)
+	IssueGiftCard(dpi *DataPassIn, cents int, message, reason string, tools *config.Tools) (*models.GiftCard, error)
+	SearchGiftCards(dpi *DataPassIn, query, status string, page int) (models.GiftCardListRender, error)
+	GetGiftCardLedger(dpi *DataPassIn, id int) (*models.GiftCardLedger, error)
+	VoidGiftCard(dpi *DataPassIn, id int, reason string) (*models.GiftCard, error)
+	AdjustGiftCard(dpi *DataPassIn, id, change int, reason string) (*models.GiftCard, error)
+	ExtendGiftCard(dpi *DataPassIn, id int, expires time.Time, reason string) (*models.GiftCard, error)
+	UnlockGiftCard(dpi *DataPassIn, id int, tools *config.Tools) (*models.GiftCard, error)
+	RetrieveGiftCard(dpi *DataPassIn, code, pin, deviceID string, tools *config.Tools) (*models.GiftCard, error)
 	CheckMultipleGiftCards(dpi *DataPassIn, codesAndAmounts map[[2]string]int) error
-	CheckDiscountCode(dpi *DataPassIn, code, store string, subtotal, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) error
-	CheckGiftCardsAndDiscountCodes(dpi *DataPassIn, codesAndAmounts map[[2]string]int, code, store string, subtotal int, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (error, error)
+	CheckDiscountCode(dpi *DataPassIn, codes []string, store string, subtotal, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) error
+	CheckGiftCardsAndDiscountCodes(dpi *DataPassIn, codesAndAmounts map[[2]string]int, codes []string, store string, subtotal int, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (error, error)
 	GetDiscountCodeForDraft(dpi *DataPassIn, code, store string, subtotal, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (*models.Discount, []*models.DiscountUser, error)
 
 	UseMultipleGiftCards(dpi *DataPassIn, codesAndAmounts map[[2]string]int, customderID int, guestID, orderID, sessionID string) error
-	UseDiscountCode(dpi *DataPassIn, code, guestID, orderID, sessionID, store string, subtotal int, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) error
+	UseDiscountCode(dpi *DataPassIn, codes []string, guestID, orderID, sessionID, store string, subtotal int, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) error
 }
 
 type discountService struct {
@@ -55,56 +85,649 @@ func (s *discountService) DeleteDiscount(dpi *DataPassIn, id int) error {
 	return s.discountRepo.Delete(id)
 }
 
-func (s *discountService) CreateGiftCard(dpi *DataPassIn, cents int, message string, store string, tools *config.Tools) (int, string, string, error) {
+func (s *discountService) ListDiscounts(dpi *DataPassIn, query, status string, page int) (models.DiscountListRender, error) {
+	ret := models.DiscountListRender{Page: page}
+	if page < 1 {
+		ret.Page = 1
+	}
+
+	discounts, total, err := s.discountRepo.SearchDiscounts(strings.TrimSpace(query), status, config.PAGELEN, (ret.Page-1)*config.PAGELEN)
+	if err != nil {
+		return ret, err
+	}
+
+	ret.Discounts = discounts
+	ret.Total = int(total)
+	ret.Previous = ret.Page > 1
+	ret.Next = ret.Page*config.PAGELEN < ret.Total
+	return ret, nil
+}
+
+func (s *discountService) GetDiscountWithUsers(dpi *DataPassIn, id int) (*models.Discount, []*models.DiscountUser, error) {
+	disc, err := s.discountRepo.Read(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := []*models.DiscountUser{}
+	if disc.HasUserList {
+		if users, err = s.discountRepo.GetDiscountUsers(id); err != nil {
+			return nil, nil, err
+		}
+	}
+	return disc, users, nil
+}
+
+// Creates the code when ID is 0; uses, creation date and batch are kept from the saved code on edits
+func (s *discountService) SaveDiscount(dpi *DataPassIn, disc models.Discount, customerIDs []int, mutexes *config.AllMutexes) (*models.Discount, error) {
+	if err := discount.ValidateDiscount(&disc, customerIDs, mutexes, dpi.Store); err != nil {
+		return nil, err
+	}
+
+	if disc.ID == 0 {
+		disc.Created = time.Now()
+		disc.Uses = 0
+		disc.BatchID = 0
+	} else {
+		existing, err := s.discountRepo.Read(disc.ID)
+		if err != nil {
+			return nil, err
+		}
+		disc.Created = existing.Created
+		disc.Uses = existing.Uses
+		disc.BatchID = existing.BatchID
+		disc.Deactivated = existing.Deactivated
+	}
+
+	if disc.Status == "Deactivated" && disc.Deactivated.IsZero() {
+		disc.Deactivated = time.Now()
+	} else if disc.Status == "Active" {
+		disc.Deactivated = time.Time{}
+	}
+
+	if !disc.HasUserList {
+		customerIDs = nil
+	}
+	if err := s.discountRepo.SaveDiscountWithUserList(&disc, customerIDs); err != nil {
+		return nil, err
+	}
+	return &disc, nil
+}
+
+func (s *discountService) DeactivateDiscount(dpi *DataPassIn, id int) error {
+	disc, err := s.discountRepo.Read(id)
+	if err != nil {
+		return err
+	} else if disc.Status == "Deactivated" {
+		return nil
+	}
+
+	disc.Status = "Deactivated"
+	disc.Deactivated = time.Now()
+	return s.discountRepo.SaveDiscount(disc)
+}
+
+// Interval is "day", "week" or "month" for the Periods breakdown
+func (s *discountService) DiscountAnalytics(dpi *DataPassIn, id int, interval string, ors OrderService) (*models.DiscountAnalytics, error) {
+	disc, err := s.discountRepo.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	uses, err := s.discountRepo.GetDiscountUseLines(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &models.DiscountAnalytics{DiscountID: disc.ID, DiscountCode: disc.DiscountCode}
+
+	net, first, orderIDs := map[string]int{}, map[string]time.Time{}, []string{}
+	for _, u := range uses {
+		if u.IsReversal {
+			net[u.OrderID]--
+			ret.Reversals++
+			continue
+		}
+		if _, ok := first[u.OrderID]; !ok {
+			first[u.OrderID] = u.Date
+			orderIDs = append(orderIDs, u.OrderID)
+		}
+		net[u.OrderID]++
+	}
+
+	orders, err := ors.GetOrdersByIDs(dpi, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	periods := map[time.Time]*models.DiscountPeriod{}
+	for _, o := range orders {
+		if net[o.ID.Hex()] <= 0 || o.Status == "Cancelled" {
+			continue
+		}
+
+		ret.Uses += net[o.ID.Hex()]
+		ret.Orders++
+		ret.Revenue += o.PostDiscountTotal
+		ret.DiscountGiven += discount.CodeAmount(&o, disc.DiscountCode)
+
+		start := discount.PeriodStart(first[o.ID.Hex()], interval)
+		p, ok := periods[start]
+		if !ok {
+			p = &models.DiscountPeriod{Start: start}
+			periods[start] = p
+		}
+		p.Uses += net[o.ID.Hex()]
+		p.Revenue += o.PostDiscountTotal
+	}
+
+	if ret.Orders > 0 {
+		ret.AverageOrder = ret.Revenue / ret.Orders
+	}
+
+	ret.Periods = []models.DiscountPeriod{}
+	for _, p := range periods {
+		ret.Periods = append(ret.Periods, *p)
+	}
+	sort.Slice(ret.Periods, func(i, j int) bool { return ret.Periods[i].Start.Before(ret.Periods[j].Start) })
+
+	return ret, nil
+}
+
+// Every code is the template with its own code and one use
+func (s *discountService) CreateDiscountBatch(dpi *DataPassIn, name, prefix string, count int, template models.Discount) (*models.DiscountBatch, error) {
+	// Stored as the codes have it so looking the batch up by prefix finds them
+	prefix = strings.ToUpper(strings.TrimSpace(prefix))
+
+	if name == "" {
+		return nil, errors.New("batch needs a name")
+	} else if count <= 0 || count > config.MAX_BATCH_CODES {
+		return nil, fmt.Errorf("batch size must be between 1 and %d", config.MAX_BATCH_CODES)
+	} else if strings.ContainsAny(prefix, " -") {
+		return nil, errors.New("batch prefix can't contain spaces or dashes")
+	} else if template.IsPercentageOff == template.IsDollarsOff && !template.IsShippingOff {
+		return nil, errors.New("batch template must be either percentage or dollars off")
+	} else if template.IsPercentageOff && (template.PercentageOff <= 0 || template.PercentageOff > 1) {
+		return nil, errors.New("batch template percentage off must be above 0 and at most 1")
+	} else if template.IsDollarsOff && template.DollarsOff <= 0 {
+		return nil, errors.New("batch template dollars off has no amount")
+	} else if !template.Expired.After(time.Now()) {
+		return nil, errors.New("batch template must expire in the future")
+	}
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var codes []string
+		codes, err = s.uniqueBatchCodes(prefix, count)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		discounts := make([]*models.Discount, len(codes))
+		for i, code := range codes {
+			d := template
+			d.ID = 0
+			d.DiscountCode = code
+			d.Status = "Active"
+			d.Created = now
+			d.HasMaxUses = true
+			d.MaxUses = 1
+			d.Uses = 0
+			d.HasUserList = false
+			d.AppliesToAllAny = true
+			discounts[i] = &d
+		}
+
+		batch := &models.DiscountBatch{Name: name, Prefix: prefix, Count: count, Created: now}
+		if err = s.discountRepo.CreateDiscountBatch(batch, discounts); err == nil {
+			return batch, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Codes unique within the batch and not already in the store's discounts
+func (s *discountService) uniqueBatchCodes(prefix string, count int) ([]string, error) {
+	seen := map[string]bool{}
+	codes := []string{}
+
+	for tries := 0; len(codes) < count && tries < 5; tries++ {
+		fresh := []string{}
+		for len(codes)+len(fresh) < count {
+			code, err := discount.GenerateBatchCode(prefix)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[code] {
+				seen[code] = true
+				fresh = append(fresh, code)
+			}
+		}
+
+		taken := map[string]bool{}
+		for start := 0; start < len(fresh); start += 1000 {
+			end := min(start+1000, len(fresh))
+			existing, err := s.discountRepo.GetDiscountsByCodes(fresh[start:end])
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range existing {
+				taken[d.DiscountCode] = true
+			}
+		}
+
+		for _, code := range fresh {
+			if !taken[code] {
+				codes = append(codes, code)
+			}
+		}
+	}
+
+	if len(codes) < count {
+		return nil, errors.New("unable to generate enough unique discount codes")
+	}
+	return codes, nil
+}
+
+func (s *discountService) DiscountBatchCSV(dpi *DataPassIn, batchID int) ([]byte, error) {
+	discounts, err := s.discountRepo.GetBatchDiscounts(batchID)
+	if err != nil {
+		return nil, err
+	} else if len(discounts) == 0 {
+		return nil, errors.New("no codes for discount batch")
+	}
+	return discount.BatchCSV(discounts)
+}
+
+func (s *discountService) DiscountBatchStats(dpi *DataPassIn, batchID int) (*models.DiscountBatchStats, error) {
+	if _, err := s.discountRepo.GetDiscountBatch(batchID); err != nil {
+		return nil, err
+	}
+	return s.discountRepo.BatchStats(batchID)
+}
+
+// Returns how many codes were still active
+func (s *discountService) DeactivateDiscountBatch(dpi *DataPassIn, batchID int) (int, error) {
+	if _, err := s.discountRepo.GetDiscountBatch(batchID); err != nil {
+		return 0, err
+	}
+
+	count, err := s.discountRepo.DeactivateBatch(batchID)
+	return int(count), err
+}
+
+func (s *discountService) CreateGiftCard(dpi *DataPassIn, cents int, message string, recipient models.GiftCardRecipient, store string, tools *config.Tools) (int, string, string, error) {
 	if len(message) > 256 {
 		message = message[:255]
 	}
 
+	recipient, err := discount.CleanRecipient(recipient, time.Now())
+	if err != nil {
+		return 0, "", "", err
+	}
+
 	if cents < 250 {
 		return 0, "", "", errors.New("not a large enough amount for gift card")
 	} else if cents >= 100000000 {
 		return 0, "", "", errors.New("too large amount for gift card")
 	}
 
-	var err error
-	idSt, exists, iter := "", false, 0
-	for !exists && iter < 10 {
-		idSt = discount.GenerateCartID()
-		exists, err = s.discountRepo.IDCodeExists(idSt)
+	idSt, err := s.newGiftCardID(store, tools)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	idDB, pin, err := s.discountRepo.CreateGiftCard(idSt, cents, message, recipient)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	return idDB, discount.SpaceDisplayGC(idSt), pin, nil
+}
+
+func (s *discountService) ActivatePurchasedGiftCards(dpi *DataPassIn, order *models.Order) error {
+	ids := []int{}
+	for _, l := range order.GiftCardBuyLines {
+		ids = append(ids, l.CardID)
+	}
+	return s.discountRepo.ActivateGiftCards(ids, order.ID.Hex())
+}
+
+// Voids the cards bought on the order; returns the cents already spent off them, which can't be taken back
+func (s *discountService) VoidOrderGiftCards(dpi *DataPassIn, order *models.Order, reason string) (int, error) {
+	spent := 0
+	for _, l := range order.GiftCardBuyLines {
+		gc, err := s.discountRepo.GetGiftCardByID(l.CardID)
 		if err != nil {
-			return 0, "", "", err
+			return spent, err
+		} else if gc.Status == "Void" {
+			continue
 		}
-		if !exists {
-			emails.AlertGiftCardID(idSt, iter, store, tools)
+
+		if _, err := s.discountRepo.ChangeGiftCard(l.CardID, "Void", reason, func(gc *models.GiftCard) (int, error) {
+			used := max(gc.OriginalCents-gc.LeftoverCents, 0)
+			change := -gc.LeftoverCents
+			gc.LeftoverCents = 0
+			gc.Status = "Void"
+			gc.SendPending = false
+			spent += used
+			return change, nil
+		}); err != nil {
+			return spent, err
 		}
 	}
+	return spent, nil
+}
 
-	if !exists {
-		return 0, "", "", errors.New("severe issue: could not create an id for gift card in 10 attempts")
+// Emails bought cards whose send time has come; meant to run on a schedule per store
+func (s *discountService) DeliverScheduledGiftCards(dpi *DataPassIn, mutexes *config.AllMutexes, tools *config.Tools) (int, error) {
+	giftCards, err := s.discountRepo.GetDueGiftCards(time.Now(), config.GC_DELIVERY_BATCH)
+	if err != nil {
+		return 0, err
 	}
 
-	idDB, pin, err := s.discountRepo.CreateGiftCard(idSt, cents, message)
+	domain := mutexes.Store.Store.ToDomain[dpi.Store]
+	sent, failed := 0, []int{}
+	var lastErr error
+	for _, gc := range giftCards {
+		// Claim first so an overlapping run can't send it twice
+		if claimed, err := s.discountRepo.ClaimGiftCardSend(gc.ID); err != nil || !claimed {
+			continue
+		}
+
+		if err := emails.GiftCardDelivery(dpi.Store, domain, gc, tools); err != nil {
+			failed, lastErr = append(failed, gc.ID), err
+			if err := s.discountRepo.ReleaseGiftCardSend(gc.ID); err != nil {
+				lastErr = err
+			}
+			continue
+		}
+		sent++
+	}
+
+	if len(failed) > 0 {
+		go emails.AlertGiftCardDelivery(dpi.Store, failed, tools, lastErr)
+	}
+
+	return sent, nil
+}
+
+// Only before the card goes out; after that the code has already been seen
+func (s *discountService) UpdateGiftCardRecipient(dpi *DataPassIn, orderID string, cardID int, recipient models.GiftCardRecipient, ors OrderService) (*models.GiftCard, error) {
+	if err := s.checkGiftCardBuyer(dpi, orderID, cardID, ors); err != nil {
+		return nil, err
+	}
+
+	recipient, err := discount.CleanRecipient(recipient, time.Now())
 	if err != nil {
-		return 0, "", "", err
+		return nil, err
 	}
 
-	return idDB, discount.SpaceDisplayGC(idSt), pin, nil
+	return s.discountRepo.UpdateGiftCard(cardID, func(gc *models.GiftCard) error {
+		if !gc.Delivered.IsZero() {
+			return errors.New("gift card has already been sent")
+		} else if gc.Status == "Void" {
+			return errors.New("gift card is void")
+		}
+		gc.Recipient = recipient
+		gc.SendPending = gc.Status == "Active" && recipient.Email != ""
+		return nil
+	})
+}
+
+// Sends a card that already went out to its recipient again, straight away
+func (s *discountService) ResendGiftCard(dpi *DataPassIn, orderID string, cardID int, ors OrderService, mutexes *config.AllMutexes, tools *config.Tools) error {
+	if err := s.checkGiftCardBuyer(dpi, orderID, cardID, ors); err != nil {
+		return err
+	}
+
+	gc, err := s.discountRepo.GetGiftCardByID(cardID)
+	if err != nil {
+		return err
+	} else if gc.Delivered.IsZero() {
+		return errors.New("gift card hasn't been sent yet")
+	} else if gc.Status != "Active" {
+		return fmt.Errorf("gift card is %s", strings.ToLower(gc.Status))
+	}
+
+	return emails.GiftCardDelivery(dpi.Store, mutexes.Store.Store.ToDomain[dpi.Store], gc, tools)
+}
+
+func (s *discountService) checkGiftCardBuyer(dpi *DataPassIn, orderID string, cardID int, ors OrderService) error {
+	orders, err := ors.GetOrdersByIDs(dpi, []string{orderID})
+	if err != nil {
+		return err
+	} else if len(orders) == 0 {
+		return errors.New("order not found")
+	}
+
+	order := orders[0]
+	if (order.Guest && order.GuestID != dpi.GuestID) || (!order.Guest && order.CustomerID != dpi.CustomerID) {
+		return errors.New("order does not belong to customer")
+	}
+
+	for _, l := range order.GiftCardBuyLines {
+		if l.CardID == cardID {
+			return nil
+		}
+	}
+	return errors.New("gift card not on order")
+}
+
+func (s *discountService) UnlockGiftCard(dpi *DataPassIn, id int, tools *config.Tools) (*models.GiftCard, error) {
+	gc, err := s.discountRepo.UpdateGiftCard(id, func(gc *models.GiftCard) error {
+		if !gc.Locked {
+			return errors.New("gift card is not locked")
+		}
+		gc.Locked = false
+		gc.LockedAt = time.Time{}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gc, discount.ClearGiftCardFailures(dpi.Store, gc.IDCode, tools)
+}
+
+func (s *discountService) newGiftCardID(store string, tools *config.Tools) (string, error) {
+	for iter := 0; iter < 10; iter++ {
+		idSt := discount.GenerateCartID()
+		exists, err := s.discountRepo.IDCodeExists(idSt)
+		if err != nil {
+			return "", err
+		} else if !exists {
+			return idSt, nil
+		}
+		emails.AlertGiftCardID(idSt, iter, store, tools)
+	}
+
+	return "", errors.New("severe issue: could not create an id for gift card in 10 attempts")
+}
+
+// Customer service credit; the card is active straight away and the pin comes back on the card
+func (s *discountService) IssueGiftCard(dpi *DataPassIn, cents int, message, reason string, tools *config.Tools) (*models.GiftCard, error) {
+	if reason == "" {
+		return nil, errors.New("issuing a gift card needs a reason")
+	} else if cents <= 0 || cents >= 100000000 {
+		return nil, errors.New("gift card amount out of range")
+	}
+	if len(message) > 256 {
+		message = message[:255]
+	}
+
+	idSt, err := s.newGiftCardID(dpi.Store, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	gc := &models.GiftCard{
+		IDCode:        idSt,
+		Created:       now,
+		Activated:     now,
+		Expired:       now.AddDate(6, 0, 0),
+		Status:        "Active",
+		OriginalCents: cents,
+		LeftoverCents: cents,
+		ShortMessage:  message,
+		Pin:           fmt.Sprintf("%03d", rand.Intn(1000)),
+	}
+
+	if err := s.discountRepo.IssueGiftCard(gc, reason); err != nil {
+		return nil, err
+	}
+	return gc, nil
+}
+
+func (s *discountService) SearchGiftCards(dpi *DataPassIn, query, status string, page int) (models.GiftCardListRender, error) {
+	ret := models.GiftCardListRender{Page: page}
+	if page < 1 {
+		ret.Page = 1
+	}
+
+	query = strings.NewReplacer(" ", "", "-", "").Replace(query)
+	giftCards, total, err := s.discountRepo.SearchGiftCards(query, status, config.PAGELEN, (ret.Page-1)*config.PAGELEN)
+	if err != nil {
+		return ret, err
+	}
+
+	ret.GiftCards = giftCards
+	ret.Total = int(total)
+	ret.Previous = ret.Page > 1
+	ret.Next = ret.Page*config.PAGELEN < ret.Total
+	return ret, nil
+}
+
+func (s *discountService) GetGiftCardLedger(dpi *DataPassIn, id int) (*models.GiftCardLedger, error) {
+	gc, err := s.discountRepo.GetGiftCardByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := s.discountRepo.GetGiftCardLines(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := discount.GiftCardLedger(gc, lines)
+	return &ledger, nil
+}
+
+// Takes whatever is left off the card for good
+func (s *discountService) VoidGiftCard(dpi *DataPassIn, id int, reason string) (*models.GiftCard, error) {
+	if reason == "" {
+		return nil, errors.New("voiding a gift card needs a reason")
+	}
+
+	return s.discountRepo.ChangeGiftCard(id, "Void", reason, func(gc *models.GiftCard) (int, error) {
+		if gc.Status == "Void" {
+			return 0, errors.New("gift card already void")
+		}
+		change := -gc.LeftoverCents
+		gc.LeftoverCents = 0
+		gc.Status = "Void"
+		return change, nil
+	})
+}
+
+// Change is signed cents; a card brought back above zero is active again
+func (s *discountService) AdjustGiftCard(dpi *DataPassIn, id, change int, reason string) (*models.GiftCard, error) {
+	if reason == "" {
+		return nil, errors.New("adjusting a gift card needs a reason")
+	} else if change == 0 {
+		return nil, errors.New("gift card adjustment has no amount")
+	}
+
+	return s.discountRepo.ChangeGiftCard(id, "Adjust", reason, func(gc *models.GiftCard) (int, error) {
+		if gc.Status == "Void" || gc.Status == "Draft" {
+			return 0, fmt.Errorf("can't adjust a %s gift card", strings.ToLower(gc.Status))
+		} else if gc.LeftoverCents+change < 0 {
+			return 0, fmt.Errorf("adjustment of %d would take the balance of %d below zero", change, gc.LeftoverCents)
+		}
+
+		gc.LeftoverCents += change
+		if gc.LeftoverCents == 0 {
+			gc.Status = "Spent"
+			gc.Spent = time.Now()
+		} else if gc.Status == "Spent" {
+			gc.Status = "Active"
+			gc.Spent = time.Time{}
+		}
+		return change, nil
+	})
+}
+
+func (s *discountService) ExtendGiftCard(dpi *DataPassIn, id int, expires time.Time, reason string) (*models.GiftCard, error) {
+	if reason == "" {
+		return nil, errors.New("extending a gift card needs a reason")
+	} else if !expires.After(time.Now()) {
+		return nil, errors.New("new expiry must be in the future")
+	}
+
+	return s.discountRepo.ChangeGiftCard(id, "Extend", reason+" (until "+expires.Format("2006-01-02")+")", func(gc *models.GiftCard) (int, error) {
+		if gc.Status == "Void" {
+			return 0, errors.New("can't extend a void gift card")
+		} else if !expires.After(gc.Expired) {
+			return 0, errors.New("new expiry must be after the current one")
+		}
+		gc.Expired = expires
+		return 0, nil
+	})
+}
+
+// Wrong codes, wrong pins and locked codes all get the same error so a lookup never says which part was wrong
+var errGiftCardLookup = errors.New("gift card code or pin is incorrect")
+var errGiftCardAttempts = errors.New("too many gift card attempts, please try again later")
+
+// Counts the failure and locks the card once its code hits the limit; always returns the error to show
+func (s *discountService) giftCardFailure(dpi *DataPassIn, code, deviceID string, gc *models.GiftCard, tools *config.Tools) error {
+	failurePoint, err := discount.GiftCardFailure(dpi.Store, dpi.GuestID, deviceID, dpi.IPAddress, code, tools)
+	if err != nil {
+		log.Printf("Unable to count failed gift card lookup; store; %s; err: %v\n", dpi.Store, err)
+		return errGiftCardLookup
+	}
+
+	switch failurePoint {
+	case "":
+		return errGiftCardLookup
+	case "code":
+		if gc != nil && !gc.Locked {
+			if err := s.discountRepo.LockGiftCard(gc.ID); err != nil {
+				log.Printf("Unable to lock gift card %d after failed lookups; store; %s; err: %v\n", gc.ID, dpi.Store, err)
+			}
+			go emails.AlertGiftCardLocked(gc.IDCode, gc.ID, dpi.Store, dpi.IPAddress, tools)
+		}
+		return errGiftCardLookup
+	}
+	return errGiftCardAttempts
 }
 
-func (s *discountService) RetrieveGiftCard(dpi *DataPassIn, code, pin string) (*models.GiftCard, error) {
+func (s *discountService) RetrieveGiftCard(dpi *DataPassIn, code, pin, deviceID string, tools *config.Tools) (*models.GiftCard, error) {
+	if banned, err := discount.GiftCardLookupBanned(dpi.Store, dpi.GuestID, deviceID, dpi.IPAddress, tools); err != nil {
+		return nil, err
+	} else if banned {
+		return nil, errGiftCardAttempts
+	}
+
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
 	if !discount.CheckID(code) {
-		return nil, errors.New("invalid gift card code")
+		return nil, s.giftCardFailure(dpi, "", deviceID, nil, tools)
 	} else if matched, err := regexp.MatchString(`^\d{3}$`, pin); !matched || err != nil {
-		return nil, errors.New("invalid pin code")
+		return nil, s.giftCardFailure(dpi, code, deviceID, nil, tools)
 	}
 
 	gc, err := s.discountRepo.GetGiftCard(code)
-	if err != nil {
+	if err == gorm.ErrRecordNotFound {
+		return nil, s.giftCardFailure(dpi, code, deviceID, nil, tools)
+	} else if err != nil {
 		return nil, err
 	}
 
-	if gc.Pin != pin {
-		return nil, errors.New("pins do not match")
+	if gc.Locked || gc.Pin != pin {
+		return nil, s.giftCardFailure(dpi, code, deviceID, gc, tools)
 	}
 
 	if gc.Status == "Draft" {
@@ -122,15 +745,29 @@ func (s *discountService) RetrieveGiftCard(dpi *DataPassIn, code, pin string) (*
 	return gc, nil
 }
 
-func (s *discountService) RenderGiftCard(dpi *DataPassIn, code string) (*models.GiftCardRender, error) {
+// Balance check by code alone; unknown codes count against the looker the same as wrong pins do
+func (s *discountService) RenderGiftCard(dpi *DataPassIn, code, deviceID string, tools *config.Tools) (*models.GiftCardRender, error) {
+	if banned, err := discount.GiftCardLookupBanned(dpi.Store, dpi.GuestID, deviceID, dpi.IPAddress, tools); err != nil {
+		return nil, err
+	} else if banned {
+		return nil, errGiftCardAttempts
+	}
+
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
 	if !discount.CheckID(code) {
-		return nil, errors.New("invalid gift card code")
+		return nil, s.giftCardFailure(dpi, "", deviceID, nil, tools)
 	}
 
 	gc, err := s.discountRepo.GetGiftCard(code)
-	if err != nil {
+	if err == gorm.ErrRecordNotFound {
+		return nil, s.giftCardFailure(dpi, "", deviceID, nil, tools)
+	} else if err != nil {
 		return nil, err
+	} else if gc.Locked {
+		return nil, errGiftCardLookup
 	}
+
+	gc.Pin = ""
 	return &models.GiftCardRender{GiftCard: *gc, Expired: gc.Expired.Before(time.Now())}, nil
 }
 
@@ -210,13 +847,36 @@ func (s *discountService) CheckMultipleGiftCards(dpi *DataPassIn, codesAndAmount
 	return nil
 }
 
-func (s *discountService) CheckDiscountCode(dpi *DataPassIn, code, store string, subtotal, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) error {
+func (s *discountService) CheckDiscountCode(dpi *DataPassIn, codes []string, store string, subtotal, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) error {
 
-	_, _, err := s.GetDiscountCodeForDraft(dpi, code, store, subtotal, cust, noCustomer, email, storeSettings, tools, cs, ors)
+	_, _, err := s.getDiscountCodesForDraft(dpi, codes, store, subtotal, cust, noCustomer, email, storeSettings, tools, cs, ors)
 	return err
 }
 
-func (s *discountService) CheckGiftCardsAndDiscountCodes(dpi *DataPassIn, codesAndAmounts map[[2]string]int, code, store string, subtotal int, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (error, error) {
+// Every code has to be usable on its own and allowed to combine with the rest
+func (s *discountService) getDiscountCodesForDraft(dpi *DataPassIn, codes []string, store string, subtotal, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) ([]*models.Discount, [][]*models.DiscountUser, error) {
+	discs := []*models.Discount{}
+	users := [][]*models.DiscountUser{}
+	applied := []models.OrderDiscount{}
+
+	for _, code := range codes {
+		disc, ul, err := s.GetDiscountCodeForDraft(dpi, code, store, subtotal, cust, noCustomer, email, storeSettings, tools, cs, ors)
+		if err != nil {
+			return nil, nil, fmt.Errorf("discount code %s: %v", code, err)
+		}
+		discs = append(discs, disc)
+		users = append(users, ul)
+		applied = append(applied, draftorderhelp.OrderDiscountFrom(disc, ul))
+	}
+
+	if err := draftorderhelp.CheckDiscountCombination(applied); err != nil {
+		return nil, nil, err
+	}
+
+	return discs, users, nil
+}
+
+func (s *discountService) CheckGiftCardsAndDiscountCodes(dpi *DataPassIn, codesAndAmounts map[[2]string]int, codes []string, store string, subtotal int, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (error, error) {
 	var errGiftCards, errDiscountCodes error
 
 	wg := sync.WaitGroup{}
@@ -229,7 +889,7 @@ func (s *discountService) CheckGiftCardsAndDiscountCodes(dpi *DataPassIn, codesA
 
 	go func() {
 		defer wg.Done()
-		errDiscountCodes = s.CheckDiscountCode(dpi, code, store, subtotal, cust, noCustomer, email, storeSettings, tools, cs, ors)
+		errDiscountCodes = s.CheckDiscountCode(dpi, codes, store, subtotal, cust, noCustomer, email, storeSettings, tools, cs, ors)
 	}()
 
 	wg.Wait()
@@ -259,6 +919,10 @@ func (s *discountService) GetDiscountCodeForDraft(dpi *DataPassIn, code, store s
 		}, nil, nil
 	}
 
+	if discount.IsReferralCode(code) {
+		return s.referralDiscount(dpi, code, store, cust, email, storeSettings, tools, cs, ors)
+	}
+
 	if code == alwaysCode {
 		return &models.Discount{
 			ID:              -2,
@@ -319,6 +983,16 @@ func (s *discountService) GetDiscountCodeForDraft(dpi *DataPassIn, code, store s
 		return nil, nil, fmt.Errorf("subtotal too low for discount code")
 	}
 
+	if disc.IsDollarsOff {
+		if disc.IsPercentageOff {
+			return nil, nil, fmt.Errorf("discount code cannot be both percentage and dollars off")
+		} else if disc.DollarsOff <= 0 {
+			return nil, nil, fmt.Errorf("dollars off discount code has no amount")
+		} else if subtotal <= config.MIN_ORDER_PRICE {
+			return nil, nil, fmt.Errorf("subtotal too low for discount code")
+		}
+	}
+
 	return disc, users, nil
 }
 
@@ -341,66 +1015,63 @@ func (s *discountService) UseMultipleGiftCards(dpi *DataPassIn, codesAndAmounts
 		return errors.New("maximum 3 allowed gift cards to pay for an order")
 	}
 
-	uses, err := s.discountRepo.UseGiftCards(codesAndAmounts, orderID, guestID, sessionID, customderID)
-	if err != nil {
-		return err
-	}
-
-	go func() {
-		s.discountRepo.GiftCardUseLines(uses)
-	}()
-
-	return nil
+	// Use lines are saved with the balances so the ledger can't fall behind
+	_, err := s.discountRepo.UseGiftCards(codesAndAmounts, orderID, guestID, sessionID, customderID)
+	return err
 }
 
-func (s *discountService) UseDiscountCode(dpi *DataPassIn, code, guestID, orderID, sessionID, store string, subtotal int, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) error {
+// Records a use and a use line for every code on the order, all at once after every code checks out
+func (s *discountService) UseDiscountCode(dpi *DataPassIn, codes []string, guestID, orderID, sessionID, store string, subtotal int, cust int, noCustomer bool, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) error {
 
-	disc, users, err := s.GetDiscountCodeForDraft(dpi, code, store, subtotal, cust, noCustomer, email, storeSettings, tools, cs, ors)
+	discs, _, err := s.getDiscountCodesForDraft(dpi, codes, store, subtotal, cust, noCustomer, email, storeSettings, tools, cs, ors)
 	if err != nil {
 		return err
 	}
 
-	disc.Uses += 1
-	var saveUser *models.DiscountUser
-
-	if disc.HasUserList {
-		reachedMax := true
-		for _, u := range users {
-			if u.CustomerID == cust {
-				u.Uses += 1
-				saveUser = u
-			}
-			if u.Uses < disc.MaxUses || !disc.HasMaxUses {
-				reachedMax = false
-			}
-		}
-		if reachedMax {
-			disc.Status = "Deactivated"
-		}
-	} else if disc.HasMaxUses && disc.Uses == disc.MaxUses {
-		disc.Status = "Deactivated"
-	}
-
-	if disc.HasUserList {
-		return s.discountRepo.SaveDiscountWithUser(disc, saveUser)
-	}
-
-	if err := s.discountRepo.SaveDiscount(disc); err != nil {
-		return err
-	}
-
-	go func() {
-		use := &models.DiscountUseLine{
+	uses := []*models.DiscountUseLine{}
+	for _, disc := range discs {
+		uses = append(uses, &models.DiscountUseLine{
 			DiscountID:   disc.ID,
-			DiscountCode: code,
+			DiscountCode: disc.DiscountCode,
 			OrderID:      orderID,
 			CustomerID:   cust,
 			GuestID:      guestID,
 			SessionID:    sessionID,
 			Date:         time.Now(),
-		}
-		s.discountRepo.DiscountUseLine(use)
-	}()
+		})
+	}
 
-	return nil
+	return s.discountRepo.UseDiscounts(discs, cust, uses)
+}
+
+// A friend's first order discount, like the welcome code but never for the referrer themselves
+func (s *discountService) referralDiscount(dpi *DataPassIn, code, store string, cust int, email string, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (*models.Discount, []*models.DiscountUser, error) {
+	program, ok := config.ReferralProgram(storeSettings, store)
+	if !ok {
+		return nil, nil, errors.New("referral codes aren't available")
+	} else if email == "" {
+		return nil, nil, errors.New("must supply email for referral disc")
+	}
+
+	aff, err := s.discountRepo.GetReferrer(code)
+	if err != nil {
+		return nil, nil, err
+	} else if (cust > 0 && aff.CustomerID == cust) || discount.ReferralEmail(aff.Email) == discount.ReferralEmail(email) {
+		return nil, nil, errors.New("cannot use your own referral code")
+	}
+
+	if allowed, err := cs.CheckIfValidForWelcome(&DataPassIn{Store: store}, cust, email, ors, tools); err != nil {
+		return nil, nil, err
+	} else if !allowed {
+		return nil, nil, errors.New("referral disc only applies to a first order")
+	}
+
+	return &models.Discount{
+		ID:              -3,
+		DiscountCode:    code,
+		Status:          "Active",
+		IsPercentageOff: true,
+		PercentageOff:   float64(program.FriendPct) / 100,
+		ShortMessage:    "A friend sent you",
+	}, nil, nil
 }
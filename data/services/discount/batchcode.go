@@ -0,0 +1,88 @@
+package discount
+
+import (
+	"beam/data/models"
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// No 0/O or 1/I so codes read back cleanly off a screen or a card
+const BatchVals = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+const batchCodeLen = 10
+
+// Prefix, a dash, then batchCodeLen random characters and a check character
+func GenerateBatchCode(prefix string) (string, error) {
+	b := make([]byte, batchCodeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	slice := make([]int, batchCodeLen)
+	for i := range b {
+		slice[i] = int(b[i]) % len(BatchVals)
+	}
+
+	var builder strings.Builder
+	if prefix != "" {
+		builder.WriteString(strings.ToUpper(prefix))
+		builder.WriteString("-")
+	}
+	for _, v := range slice {
+		builder.WriteByte(BatchVals[v])
+	}
+	builder.WriteByte(BatchVals[batchCheck(slice)])
+	return builder.String(), nil
+}
+
+// Catches typos before a lookup; the prefix is not part of the check
+func CheckBatchCode(code string) bool {
+	body := strings.ToUpper(code)
+	if i := strings.LastIndex(body, "-"); i >= 0 {
+		body = body[i+1:]
+	}
+	if len(body) != batchCodeLen+1 {
+		return false
+	}
+
+	slice := make([]int, batchCodeLen+1)
+	for i := range body {
+		index := strings.IndexByte(BatchVals, body[i])
+		if index == -1 {
+			return false
+		}
+		slice[i] = index
+	}
+
+	return batchCheck(slice[:batchCodeLen]) == slice[batchCodeLen]
+}
+
+func batchCheck(slice []int) int {
+	sum := 0
+	for i, v := range slice {
+		sum += v * (i%2 + 1)
+	}
+	return sum % len(BatchVals)
+}
+
+func BatchCSV(discounts []*models.Discount) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"code", "status", "uses", "max_uses", "expires"}); err != nil {
+		return nil, err
+	}
+	for _, d := range discounts {
+		row := []string{d.DiscountCode, d.Status, strconv.Itoa(d.Uses), strconv.Itoa(d.MaxUses), d.Expired.Format(time.RFC3339)}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,43 @@
+package discount
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Trims the recipient and checks it; an empty recipient means the buyer hands the card over themselves
+func CleanRecipient(r models.GiftCardRecipient, now time.Time) (models.GiftCardRecipient, error) {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Email = strings.TrimSpace(r.Email)
+
+	if r.Email == "" {
+		if r.Name != "" || !r.SendAt.IsZero() {
+			return r, errors.New("gift card recipient needs an email")
+		}
+		return r, nil
+	}
+
+	addr, err := mail.ParseAddress(r.Email)
+	if err != nil {
+		return r, errors.New("invalid gift card recipient email")
+	}
+	r.Email = addr.Address
+
+	if len(r.Name) > 100 {
+		return r, errors.New("gift card recipient name too long")
+	} else if !r.SendAt.IsZero() && r.SendAt.Before(now.Add(-time.Hour)) {
+		return r, errors.New("gift card send date is in the past")
+	} else if r.SendAt.After(now.AddDate(0, 0, config.GC_MAX_SEND_DAYS)) {
+		return r, errors.New("gift card send date too far in the future")
+	}
+
+	return r, nil
+}
+
+func BalanceCheckURL(domain, idCode string) string {
+	return "https://" + domain + config.GC_HANDLE + "/balance?code=" + idCode
+}
@@ -0,0 +1,38 @@
+package discount
+
+import "beam/data/models"
+
+// Cards issued before the ledger have no Issue line, so one is made up from OriginalCents
+func GiftCardLedger(gc *models.GiftCard, lines []*models.GiftCardUseLine) models.GiftCardLedger {
+	ret := models.GiftCardLedger{GiftCard: *gc, Lines: []models.GiftCardUseLine{}}
+
+	issued := false
+	for _, l := range lines {
+		if l.Kind == "Issue" {
+			issued = true
+			break
+		}
+	}
+	if !issued {
+		ret.Lines = append(ret.Lines, models.GiftCardUseLine{
+			GiftCardID:    gc.ID,
+			GiftCardCode:  gc.IDCode,
+			Date:          gc.Created,
+			AmountApplied: gc.OriginalCents,
+			EndAmount:     gc.OriginalCents,
+			Kind:          "Issue",
+			Change:        gc.OriginalCents,
+		})
+	}
+
+	for _, l := range lines {
+		ret.Lines = append(ret.Lines, *l)
+	}
+
+	for _, l := range ret.Lines {
+		ret.LedgerSum += l.BalanceChange()
+	}
+	ret.Balanced = ret.LedgerSum == gc.LeftoverCents
+
+	return ret
+}
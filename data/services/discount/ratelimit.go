@@ -0,0 +1,83 @@
+package discount
+
+import (
+	"beam/config"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Checks the temp bans on whoever is looking up a card; empty ids are skipped
+func GiftCardLookupBanned(store, guestID, deviceID, ip string, tools *config.Tools) (bool, error) {
+	for _, b := range [][2]string{{"GCBI", ip}, {"GCBD", deviceID}, {"GCBG", guestID}} {
+		if b[1] == "" {
+			continue
+		}
+
+		exists, err := tools.Redis.Exists(context.Background(), fmt.Sprintf("%s::%s::%s", store, b[0], b[1])).Result()
+		if err != nil {
+			return false, err
+		} else if exists > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Counts a failed code/pin lookup against everything it came from; every count goes up before any is checked so one
+// limit being hit doesn't let the others skip the failure
+// Failure type = code, ip, device, guest, or empty when nothing hit its limit; the code comes first since locking it matters most
+func GiftCardFailure(store, guestID, deviceID, ip, code string, tools *config.Tools) (string, error) {
+	counters := []struct {
+		failType, prefix, ban, id string
+		limit                     int
+		window                    time.Duration
+	}{
+		{"code", "GCFC", "", code, config.GC_FAILS_CODE, config.GC_FAIL_WINDOW_HOURS * time.Hour},
+		{"ip", "GCFI", "GCBI", ip, config.GC_FAILS_IP, time.Hour},
+		{"device", "GCFD", "GCBD", deviceID, config.GC_FAILS_DEVICE, time.Hour},
+		{"guest", "GCFG", "GCBG", guestID, config.GC_FAILS_DEVICE, time.Hour},
+	}
+
+	maxed := make([]bool, len(counters))
+	for i, c := range counters {
+		if c.id == "" {
+			continue
+		}
+
+		unmaxed, err := config.RateLimit(tools.Redis, store, c.prefix, c.id, c.limit, c.window)
+		if err != nil {
+			return "", err
+		}
+		maxed[i] = !unmaxed
+	}
+
+	failType := ""
+	for i, c := range counters {
+		if !maxed[i] {
+			continue
+		}
+		if failType == "" {
+			failType = c.failType
+		}
+		if c.ban != "" {
+			if err := setGiftCardBan(store, c.ban, c.id, tools); err != nil {
+				return failType, err
+			}
+		}
+	}
+
+	return failType, nil
+}
+
+// Starts the code's failure count over once an admin unlocks it
+func ClearGiftCardFailures(store, code string, tools *config.Tools) error {
+	return tools.Redis.Del(context.Background(), fmt.Sprintf("%s::WNDW::GCFC::%s", store, code)).Err()
+}
+
+func setGiftCardBan(store, kind, id string, tools *config.Tools) error {
+	key := fmt.Sprintf("%s::%s::%s", store, kind, id)
+	duration := config.GC_LOCKOUT_MINUTES * time.Minute
+
+	return tools.Redis.Set(context.Background(), key, "1", duration).Err()
+}
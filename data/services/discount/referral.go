@@ -0,0 +1,29 @@
+package discount
+
+import (
+	"beam/config"
+	"strings"
+)
+
+// Lowercased with any +tag dropped, and dots dropped for gmail, so one inbox can't pass as several friends
+func ReferralEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return email
+	}
+
+	local, domain := email[:at], email[at+1:]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+		domain = "gmail.com"
+	}
+	return local + "@" + domain
+}
+
+func IsReferralCode(code string) bool {
+	return strings.HasPrefix(code, config.REFERRAL_PREFIX+"-")
+}
@@ -0,0 +1,135 @@
+package discount
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var codePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,40}$`)
+
+// Checks an admin created or edited code before it's saved; customerIDs is the DiscountUser list when HasUserList is set
+func ValidateDiscount(d *models.Discount, customerIDs []int, mutexes *config.AllMutexes, store string) error {
+	d.DiscountCode = strings.ToUpper(strings.TrimSpace(d.DiscountCode))
+	if !codePattern.MatchString(d.DiscountCode) {
+		return errors.New("discount code must be 3 to 40 letters, numbers, dashes or underscores")
+	}
+
+	welcome, _, always, _ := SpecialDiscNames(&mutexes.Settings, store)
+	if d.DiscountCode == welcome || d.DiscountCode == always || IsReferralCode(d.DiscountCode) {
+		return errors.New("discount code is reserved")
+	}
+
+	if d.Status != "Active" && d.Status != "Deactivated" {
+		return errors.New("discount status must be Active or Deactivated")
+	}
+
+	if d.IsPercentageOff && d.IsDollarsOff {
+		return errors.New("discount code cannot be both percentage and dollars off")
+	} else if !d.IsPercentageOff && !d.IsDollarsOff && !d.IsShippingOff {
+		return errors.New("discount code must take something off")
+	} else if d.IsPercentageOff && (d.PercentageOff <= 0 || d.PercentageOff > 1) {
+		return errors.New("percentage off must be above 0 and at most 1")
+	} else if d.IsDollarsOff && d.DollarsOff <= 0 {
+		return errors.New("dollars off discount code has no amount")
+	} else if d.IsShippingOff && d.ShippingOff < 0 {
+		return errors.New("shipping off can't be negative")
+	}
+
+	if d.Combination != "" && d.Combination != "all" && d.Combination != "shipping" && d.Combination != "exclusive" {
+		return errors.New("combination must be all, shipping or exclusive")
+	}
+
+	if d.Expired.IsZero() {
+		return errors.New("discount code needs an expiry")
+	} else if d.ID == 0 && !d.Expired.After(time.Now()) {
+		return errors.New("discount code expiry must be in the future")
+	}
+
+	if d.HasMaxUses && d.MaxUses <= 0 {
+		return errors.New("max uses must be at least 1")
+	} else if !d.HasMaxUses {
+		d.MaxUses = 0
+	}
+	if d.HasMinSubtotal && d.MinSubtotal <= 0 {
+		return errors.New("minimum subtotal must be above 0")
+	}
+
+	if d.HasUserList {
+		if len(customerIDs) == 0 {
+			return errors.New("user list discount needs at least one customer")
+		}
+		d.AppliesToAllAny = false
+		d.SingleCustomerID = 0
+	} else if len(customerIDs) > 0 {
+		return errors.New("customer list given for a discount without a user list")
+	} else if !d.AppliesToAllAny && d.SingleCustomerID <= 0 {
+		return errors.New("discount code must apply to everyone, a customer list or a single customer")
+	}
+
+	return validateTarget(d.Target, mutexes, store)
+}
+
+// Tags and collections have to be ones the store actually uses
+func validateTarget(t models.DiscountTarget, mutexes *config.AllMutexes, store string) error {
+	if t.MinEligibleQuantity < 0 {
+		return errors.New("minimum eligible quantity can't be negative")
+	}
+
+	mutexes.Tags.Mu.RLock()
+	defer mutexes.Tags.Mu.RUnlock()
+	known := mutexes.Tags.Tags.All[store].ToURL
+
+	for _, tag := range append(append([]string{}, t.IncludeTags...), t.ExcludeTags...) {
+		key, val, ok := strings.Cut(tag, "__")
+		if !ok {
+			return fmt.Errorf("tag %s must be Key__Value", tag)
+		}
+		if _, ok := known[key]; !ok {
+			return fmt.Errorf("unknown tag key %s", key)
+		} else if _, ok := known[val]; !ok {
+			return fmt.Errorf("unknown tag value %s", val)
+		}
+	}
+
+	for _, c := range append(append([]string{}, t.IncludeCollections...), t.ExcludeCollections...) {
+		if _, ok := known[c]; !ok {
+			return fmt.Errorf("unknown collection %s", c)
+		}
+	}
+
+	return nil
+}
+
+// What the code took off the order's subtotal and shipping
+// Orders from before per-code amounts were kept have the whole discount on their one code
+func CodeAmount(order *models.Order, code string) int {
+	discs := order.AppliedDiscounts()
+	for _, d := range discs {
+		if d.DiscountCode != code {
+			continue
+		}
+		if d.Amount == 0 && d.ShippingAmount == 0 && len(discs) == 1 {
+			return order.OrderLevelDiscount + order.ShippingDiscount
+		}
+		return d.Amount + d.ShippingAmount
+	}
+	return 0
+}
+
+// Anything but "day" or "month" groups by the week starting Monday
+func PeriodStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case "day":
+		return day
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
@@ -8,27 +8,31 @@ import (
 	"beam/data/services/draftorderhelp"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
 type DraftOrderService interface {
-	CreateDraftOrder(dpi *DataPassIn, crs CartService, pds ProductService, cts CustomerService) (*models.DraftOrder, error)
+	CreateDraftOrder(dpi *DataPassIn, crs CartService, pds ProductService, cts CustomerService, scs StoreCreditService, lys LoyaltyService, mutexes *config.AllMutexes) (*models.DraftOrder, error)
+	SetUseStoreCredit(dpi *DataPassIn, draftID string, use bool, scs StoreCreditService) (*models.DraftOrder, error)
+	SetRedeemPoints(dpi *DataPassIn, draftID string, points int, lys LoyaltyService, storeSettings *config.SettingsMutex) (*models.DraftOrder, error)
 	GetDraftOrder(dpi *DataPassIn, draftID string, cts CustomerService) (*models.DraftOrder, string, error)
 	PostRenderUpdate(dpi *DataPassIn, ip, draftID string, cts CustomerService, mutexes *config.AllMutexes, tools *config.Tools) (*models.DraftOrder, error)
 	SaveAndUpdatePtl(draft *models.DraftOrder) error
 	GetDraftPtl(draftID, guestID string, custID int) (*models.DraftOrder, error)
+	CheckAddress(dpi *DataPassIn, contact *models.Contact, mutexes *config.AllMutexes) (models.AddressCheck, error)
 	AddAddressToDraft(dpi *DataPassIn, draftID, ip string, cts CustomerService, contact *models.Contact, addToCust bool, mutexes *config.AllMutexes, tools *config.Tools) (*models.DraftOrder, error)
 	ChooseAddress(dpi *DataPassIn, draftID, ip string, addrID, index, customerID int, cts CustomerService, mutexes *config.AllMutexes, tools *config.Tools) (*models.DraftOrder, error)
 	ChooseShipRate(dpi *DataPassIn, draftID, rateName string) (*models.DraftOrder, error)
 	ChoosePaymentMethod(dpi *DataPassIn, draftID, paymentMethodID string, cts CustomerService) (*models.DraftOrder, error)
 	RemovePaymentMethod(dpi *DataPassIn, draftID string) (*models.DraftOrder, error)
 	AddDiscountCode(dpi *DataPassIn, draftID, discCode string, ds DiscountService, storeSettings *config.SettingsMutex, tools *config.Tools, cs CustomerService, ors OrderService) (*models.DraftOrder, error)
-	RemoveDiscountCode(dpi *DataPassIn, draftID string) (*models.DraftOrder, error)
+	RemoveDiscountCode(dpi *DataPassIn, draftID, discCode string) (*models.DraftOrder, error)
 	SetTip(dpi *DataPassIn, draftID string, tip int) (*models.DraftOrder, error)
 	RemoveTip(dpi *DataPassIn, draftID string) (*models.DraftOrder, error)
 	AddGiftSubjectAndMessage(dpi *DataPassIn, draftID, subject, message string) (*models.DraftOrder, error)
-	AddGiftCard(dpi *DataPassIn, draftID, gcCode, pin string, ds DiscountService) (*models.DraftOrder, error)
+	AddGiftCard(dpi *DataPassIn, draftID, gcCode, pin, deviceID string, ds DiscountService, tools *config.Tools) (*models.DraftOrder, error)
 	ApplyGiftCard(dpi *DataPassIn, draftID string, gcID, amount int, useMax bool) (*models.DraftOrder, error)
 	DeApplyGiftCard(dpi *DataPassIn, draftID string, gcID int) (*models.DraftOrder, error)
 	RemoveGiftCard(dpi *DataPassIn, draftID string, gcID int) (*models.DraftOrder, error)
@@ -49,7 +53,7 @@ func NewDraftOrderService(draftRepo repositories.DraftOrderRepository) DraftOrde
 	return &draftOrderService{draftOrderRepo: draftRepo}
 }
 
-func (s *draftOrderService) CreateDraftOrder(dpi *DataPassIn, crs CartService, pds ProductService, cts CustomerService) (*models.DraftOrder, error) {
+func (s *draftOrderService) CreateDraftOrder(dpi *DataPassIn, crs CartService, pds ProductService, cts CustomerService, scs StoreCreditService, lys LoyaltyService, mutexes *config.AllMutexes) (*models.DraftOrder, error) {
 	var wg sync.WaitGroup
 
 	cart := &models.Cart{}
@@ -101,11 +105,27 @@ func (s *draftOrderService) CreateDraftOrder(dpi *DataPassIn, crs CartService, p
 		return nil, errors.New("no existing cart")
 	}
 
-	draft, err := draftorderhelp.CreateDraftOrder(cust, dpi.GuestID, cart, cartLines, pMap, contacts)
+	draft, err := draftorderhelp.CreateDraftOrder(cust, dpi.GuestID, cart, cartLines, pMap, contacts, config.FreeShipRules(&mutexes.Settings, dpi.Store), config.Promotions(&mutexes.Settings, dpi.Store, time.Now()), config.TagVolumeTiers(&mutexes.Settings, dpi.Store))
 	if err != nil {
 		return nil, err
 	}
 
+	if dpi.CustomerID > 0 {
+		balance, err := scs.Balance(dpi, dpi.CustomerID)
+		if err != nil {
+			return nil, err
+		}
+		draftorderhelp.OfferStoreCredit(draft, balance)
+
+		if program, ok := config.LoyaltyProgram(&mutexes.Settings, dpi.Store); ok {
+			points, err := lys.Balance(dpi, dpi.CustomerID)
+			if err != nil {
+				return nil, err
+			}
+			draftorderhelp.OfferPoints(draft, points, program)
+		}
+	}
+
 	_, custUpdate, err := draftorderhelp.ConfirmPaymentIntentDraft(draft, cust, dpi.GuestID)
 	if err != nil {
 		return nil, err
@@ -230,7 +250,7 @@ func (s *draftOrderService) PostRenderUpdate(dpi *DataPassIn, ip, draftID string
 
 	go func() {
 		defer wg.Done()
-		taxRateErr = draftorderhelp.ModifyTaxRate(draft, tools, mutexes)
+		taxRateErr = draftorderhelp.ModifyTaxRate(draft, tools, mutexes, dpi.Store)
 	}()
 
 	go func() {
@@ -261,6 +281,19 @@ func (s *draftOrderService) PostRenderUpdate(dpi *DataPassIn, ip, draftID string
 		return draft, paymentIntentErr
 	}
 
+	// Shows any margin shipping raise before checkout; a shortfall the cap can't cover is refused on submit
+	if policy, ok := config.MarginPolicy(&mutexes.Settings, dpi.Store); ok || draft.MarginShipAdjust != 0 {
+		if err := draftorderhelp.UpdateTaxFromRate(draft); err != nil {
+			return draft, err
+		}
+		changed, _ := draftorderhelp.ApplyMarginShipAdjust(draft, policy)
+		if changed {
+			if _, _, err := draftorderhelp.ConfirmPaymentIntentDraft(draft, cust, dpi.GuestID); err != nil {
+				return draft, err
+			}
+		}
+	}
+
 	err := s.SaveAndUpdatePtl(draft)
 
 	return draft, err
@@ -300,6 +333,15 @@ func (s *draftOrderService) GetDraftPtl(draftID, guestID string, custID int) (*m
 	return draft, nil
 }
 
+// For the checkout to offer corrections before the address is added
+func (s *draftOrderService) CheckAddress(dpi *DataPassIn, contact *models.Contact, mutexes *config.AllMutexes) (models.AddressCheck, error) {
+	if err := custhelp.VerifyContact(contact, mutexes); err != nil {
+		return models.AddressCheck{}, err
+	}
+
+	return custhelp.CheckAddress(contact, mutexes, dpi.Store), nil
+}
+
 func (s *draftOrderService) AddAddressToDraft(dpi *DataPassIn, draftID, ip string, cts CustomerService, contact *models.Contact, addToCust bool, mutexes *config.AllMutexes, tools *config.Tools) (*models.DraftOrder, error) {
 	draft, err := s.GetDraftPtl(draftID, dpi.GuestID, dpi.CustomerID)
 	if err != nil {
@@ -311,6 +353,10 @@ func (s *draftOrderService) AddAddressToDraft(dpi *DataPassIn, draftID, ip strin
 		return draft, err
 	}
 
+	if check := custhelp.CheckAddress(contact, mutexes, dpi.Store); !check.Valid {
+		return draft, errors.New(strings.Join(check.Errors, "; "))
+	}
+
 	var custErr error = nil
 	if addToCust && dpi.CustomerID > 0 {
 		custErr = cts.AddContactToCustomer(dpi, contact)
@@ -318,6 +364,7 @@ func (s *draftOrderService) AddAddressToDraft(dpi *DataPassIn, draftID, ip strin
 
 	draft.ShippingContact = contact
 	draft.ListedContacts = append([]*models.Contact{contact}, draft.ListedContacts...)
+	draftorderhelp.SetInclusiveTax(draft, &mutexes.Settings, dpi.Store)
 
 	if err := draftorderhelp.UpdateShippingRates(draft, contact, mutexes, dpi.Store, ip, tools); err != nil {
 		return draft, err
@@ -336,10 +383,11 @@ func (s *draftOrderService) ChooseAddress(dpi *DataPassIn, draftID, ip string, a
 		return draft, err
 	}
 
+	contact := draft.ShippingContact
 	if addrID == 0 {
 		for _, c := range draft.ListedContacts {
 			if c.ID == addrID {
-				draft.ShippingContact = c
+				contact = c
 				break
 			}
 		}
@@ -349,9 +397,19 @@ func (s *draftOrderService) ChooseAddress(dpi *DataPassIn, draftID, ip string, a
 		} else if index >= len(draft.ListedContacts) {
 			return draft, errors.New("choice of address without id must have index < length of list")
 		}
-		draft.ShippingContact = draft.ListedContacts[index]
+		contact = draft.ListedContacts[index]
+	}
+
+	// Saved addresses can be from before validation or a since changed country list
+	if contact == nil {
+		return draft, errors.New("no address chosen")
+	} else if check := custhelp.CheckAddress(contact, mutexes, dpi.Store); !check.Valid {
+		return draft, errors.New(strings.Join(check.Errors, "; "))
 	}
 
+	draft.ShippingContact = contact
+	draftorderhelp.SetInclusiveTax(draft, &mutexes.Settings, dpi.Store)
+
 	if err := draftorderhelp.UpdateShippingRates(draft, draft.ShippingContact, mutexes, dpi.Store, ip, tools); err != nil {
 		return draft, err
 	}
@@ -460,13 +518,19 @@ func (s *draftOrderService) AddDiscountCode(dpi *DataPassIn, draftID, discCode s
 	return draft, err
 }
 
-func (s *draftOrderService) RemoveDiscountCode(dpi *DataPassIn, draftID string) (*models.DraftOrder, error) {
+// An empty code removes every code
+func (s *draftOrderService) RemoveDiscountCode(dpi *DataPassIn, draftID, discCode string) (*models.DraftOrder, error) {
 	draft, err := s.GetDraftPtl(draftID, dpi.GuestID, dpi.CustomerID)
 	if err != nil {
 		return draft, err
 	}
 
-	if err := draftorderhelp.RemoveDiscountFromOrder(draft); err != nil {
+	if discCode == "" {
+		err = draftorderhelp.RemoveDiscountFromOrder(draft)
+	} else {
+		err = draftorderhelp.RemoveDiscountCodeFromOrder(draft, discCode)
+	}
+	if err != nil {
 		return draft, err
 	}
 
@@ -518,7 +582,7 @@ func (s *draftOrderService) AddGiftSubjectAndMessage(dpi *DataPassIn, draftID, s
 	return draft, err
 }
 
-func (s *draftOrderService) AddGiftCard(dpi *DataPassIn, draftID, gcCode, pin string, ds DiscountService) (*models.DraftOrder, error) {
+func (s *draftOrderService) AddGiftCard(dpi *DataPassIn, draftID, gcCode, pin, deviceID string, ds DiscountService, tools *config.Tools) (*models.DraftOrder, error) {
 	draft, err := s.GetDraftPtl(draftID, dpi.GuestID, dpi.CustomerID)
 	if err != nil {
 		return draft, err
@@ -528,7 +592,7 @@ func (s *draftOrderService) AddGiftCard(dpi *DataPassIn, draftID, gcCode, pin st
 		return draft, errors.New("maximum number of gift cards to add reached")
 	}
 
-	gc, err := ds.RetrieveGiftCard(dpi, gcCode, pin)
+	gc, err := ds.RetrieveGiftCard(dpi, gcCode, pin, deviceID, tools)
 	if err != nil {
 		return draft, err
 	}
@@ -594,14 +658,16 @@ func (s *draftOrderService) CheckDiscountsAndGiftCards(dpi *DataPassIn, draftID
 		return err, nil, nil, false
 	}
 
-	if draft.OrderDiscount.DiscountCode != "" && len(draft.GiftCards) != 0 {
+	discCodes := models.DiscountCodes(draft.AppliedDiscounts())
+
+	if len(discCodes) != 0 && len(draft.GiftCards) != 0 {
 
 		gcsAndAmounts := map[[2]string]int{}
 		for _, gc := range draft.GiftCards {
 			gcsAndAmounts[[2]string{gc.Code, gc.Pin}] = gc.Charged
 		}
 
-		gcErr, draftErr := ds.CheckGiftCardsAndDiscountCodes(dpi, gcsAndAmounts, draft.OrderDiscount.DiscountCode, dpi.Store, draft.Subtotal, dpi.CustomerID, draft.Guest, draft.Email, storeSettings, tools, cs, ors)
+		gcErr, draftErr := ds.CheckGiftCardsAndDiscountCodes(dpi, gcsAndAmounts, discCodes, dpi.Store, draft.Subtotal, dpi.CustomerID, draft.Guest, draft.Email, storeSettings, tools, cs, ors)
 		if gcErr == nil && draftErr == nil {
 			return nil, nil, nil, true
 		}
@@ -622,9 +688,9 @@ func (s *draftOrderService) CheckDiscountsAndGiftCards(dpi *DataPassIn, draftID
 
 		return nil, gcErr, nil, false
 
-	} else if draft.OrderDiscount.DiscountCode != "" {
+	} else if len(discCodes) != 0 {
 
-		draftErr := ds.CheckDiscountCode(dpi, draft.OrderDiscount.DiscountCode, dpi.Store, draft.Subtotal, dpi.CustomerID, draft.Guest, draft.Email, storeSettings, tools, cs, ors)
+		draftErr := ds.CheckDiscountCode(dpi, discCodes, dpi.Store, draft.Subtotal, dpi.CustomerID, draft.Guest, draft.Email, storeSettings, tools, cs, ors)
 		if draftErr == nil {
 			return nil, nil, nil, true
 		}
@@ -636,6 +702,61 @@ func (s *draftOrderService) CheckDiscountsAndGiftCards(dpi *DataPassIn, draftID
 	return nil, nil, nil, true
 }
 
+// Turns store credit on or off, refreshing the balance either way
+func (s *draftOrderService) SetUseStoreCredit(dpi *DataPassIn, draftID string, use bool, scs StoreCreditService) (*models.DraftOrder, error) {
+	draft, err := s.GetDraftPtl(draftID, dpi.GuestID, dpi.CustomerID)
+	if err != nil {
+		return draft, err
+	}
+
+	if draft.Guest || dpi.CustomerID <= 0 {
+		return draft, errors.New("store credit needs an account")
+	}
+
+	balance, err := scs.Balance(dpi, dpi.CustomerID)
+	if err != nil {
+		return draft, err
+	}
+
+	if err := draftorderhelp.SetStoreCredit(draft, use, balance); err != nil {
+		return draft, err
+	}
+
+	err = s.draftOrderRepo.Update(draft)
+
+	return draft, err
+}
+
+// Zero points stops redeeming; the balance is refreshed either way
+func (s *draftOrderService) SetRedeemPoints(dpi *DataPassIn, draftID string, points int, lys LoyaltyService, storeSettings *config.SettingsMutex) (*models.DraftOrder, error) {
+	draft, err := s.GetDraftPtl(draftID, dpi.GuestID, dpi.CustomerID)
+	if err != nil {
+		return draft, err
+	}
+
+	if draft.Guest || dpi.CustomerID <= 0 {
+		return draft, errors.New("redeeming points needs an account")
+	}
+
+	program, ok := config.LoyaltyProgram(storeSettings, dpi.Store)
+	if !ok {
+		return draft, errors.New("store has no points program")
+	}
+
+	balance, err := lys.Balance(dpi, dpi.CustomerID)
+	if err != nil {
+		return draft, err
+	}
+
+	if err := draftorderhelp.SetPoints(draft, points, balance, program); err != nil {
+		return draft, err
+	}
+
+	err = s.draftOrderRepo.Update(draft)
+
+	return draft, err
+}
+
 func (s *draftOrderService) AddGuestInfoToDraft(dpi *DataPassIn, draftID string, email, name string, tools *config.Tools) (*models.DraftOrder, error) {
 	if !custhelp.VerifyEmail(email, tools) {
 		return nil, errors.New("invalid email")
@@ -712,6 +833,9 @@ func (s *draftOrderService) MoveDraftToCustomer(dpi *DataPassIn, draftID string,
 
 	draft.GiftCards = [3]*models.OrderGiftCard{}
 	draft.GiftCardSum = 0
+	draft.StoreCreditSum = 0
+	draft.StoreCreditAvailable = 0
+	draft.UseStoreCredit = false
 	draft.PostGiftCardTotal = draft.PreGiftCardTotal
 	draft.Total = draft.PostGiftCardTotal + draft.GiftCardBuyTotal
 
@@ -731,14 +855,14 @@ func (s *draftOrderService) MoveDraftToCustomer(dpi *DataPassIn, draftID string,
 	draft.CATaxRate = 0
 	draft.ListedContacts = []*models.Contact{}
 
-	if draft.OrderDiscount.DiscountCode != "" {
-		disc, users, err := ds.GetDiscountCodeForDraft(dpi, draft.OrderDiscount.DiscountCode, dpi.Store, draft.Subtotal, dpi.CustomerID, false, draft.Email, storeSettings, tools, cms, ors)
+	for _, code := range models.DiscountCodes(draft.AppliedDiscounts()) {
+		disc, users, err := ds.GetDiscountCodeForDraft(dpi, code, dpi.Store, draft.Subtotal, dpi.CustomerID, false, draft.Email, storeSettings, tools, cms, ors)
 		if err != nil {
 			return 0, err
 		}
 
 		if err := draftorderhelp.ApplyDiscountToOrder(disc, users, draft); err != nil {
-			if err := draftorderhelp.RemoveDiscountFromOrder(draft); err != nil {
+			if err := draftorderhelp.RemoveDiscountCodeFromOrder(draft, code); err != nil {
 				return 0, err
 			}
 		}
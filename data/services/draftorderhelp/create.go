@@ -8,7 +8,7 @@ import (
 	"time"
 )
 
-func CreateDraftOrder(customer *models.Customer, guestID string, cart *models.Cart, cartLines []*models.CartLine, products map[int]*models.ProductRedis, contacts []*models.Contact) (*models.DraftOrder, error) {
+func CreateDraftOrder(customer *models.Customer, guestID string, cart *models.Cart, cartLines []*models.CartLine, products map[int]*models.ProductRedis, contacts []*models.Contact, freeShipRules []models.FreeShipRule, promos []models.Promotion, tagTiers map[string][]models.VolumeTier) (*models.DraftOrder, error) {
 
 	orderLines, gcLines := []models.OrderLine{}, []models.GiftCardBuyLine{}
 	subtotal, gcTotal := 0, 0
@@ -22,6 +22,7 @@ func CreateDraftOrder(customer *models.Customer, guestID string, cart *models.Ca
 				CardID:       line.VariantID,
 				CardCode:     line.GiftCardCode,
 				Price:        line.Price,
+				Recipient:    line.GiftCardTo,
 			}
 			gcTotal += line.Price
 			gcLines = append(gcLines, orderLine)
@@ -42,7 +43,7 @@ func CreateDraftOrder(customer *models.Customer, guestID string, cart *models.Ca
 				return nil, errors.New("no matching redis variant by id")
 			}
 
-			vp := product.VolumeDiscPrice(variant.Price, line.Quantity, prod.VolumeDisc)
+			vp := product.VolumeDiscPrice(variant.Price, line.Quantity, product.VolumeTiers(prod, tagTiers))
 
 			orderLine := models.OrderLine{
 				ImageURL:          prod.ImageURL,
@@ -60,8 +61,10 @@ func CreateDraftOrder(customer *models.Customer, guestID string, cart *models.Ca
 				Quantity:          line.Quantity,
 				UndiscountedPrice: variant.Price,
 				Price:             vp,
+				LineLevelDiscount: (variant.Price - vp) * line.Quantity,
 				EndPrice:          vp,
 				LineTotal:         line.Quantity * vp,
+				Tags:              prod.Tags,
 			}
 			subtotal += line.Quantity * vp
 
@@ -70,6 +73,9 @@ func CreateDraftOrder(customer *models.Customer, guestID string, cart *models.Ca
 
 	}
 
+	promoApplied := EvaluatePromotions(orderLines, promos)
+	subtotal -= PromotionsTotal(promoApplied)
+
 	draftOrder := &models.DraftOrder{
 		Status:             "Created",
 		DateCreated:        time.Now(),
@@ -85,6 +91,7 @@ func CreateDraftOrder(customer *models.Customer, guestID string, cart *models.Ca
 		Total:              subtotal + gcTotal,
 		Lines:              orderLines,
 		GiftCardBuyLines:   gcLines,
+		Promotions:         promoApplied,
 		Guest:              false,
 	}
 
@@ -121,6 +128,6 @@ func CreateDraftOrder(customer *models.Customer, guestID string, cart *models.Ca
 		draftOrder.StripePaymentIntentID = pmid
 	}
 
-	EvaluateFreeShip(draftOrder, customer, products)
+	EvaluateFreeShip(draftOrder, customer, products, freeShipRules)
 	return draftOrder, nil
 }
@@ -0,0 +1,40 @@
+package draftorderhelp
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"errors"
+)
+
+// Offers the customer's whole balance; it is used unless they turn it off
+func OfferStoreCredit(draftOrder *models.DraftOrder, balance int) {
+	draftOrder.StoreCreditAvailable = balance
+	draftOrder.UseStoreCredit = balance > 0
+	draftOrder.PostGiftCardTotal = applyStoreCredit(draftOrder, draftOrder.PreGiftCardTotal-draftOrder.GiftCardSum, config.MIN_ORDER_PRICE-draftOrder.GiftCardBuyTotal)
+	draftOrder.Total = draftOrder.PostGiftCardTotal + draftOrder.GiftCardBuyTotal
+}
+
+// balance is the customer's current balance, which may have changed since the draft was made
+func SetStoreCredit(draftOrder *models.DraftOrder, use bool, balance int) error {
+	if use && balance <= 0 {
+		return errors.New("no store credit to use")
+	}
+
+	draftOrder.StoreCreditAvailable = balance
+	draftOrder.UseStoreCredit = use
+	return EnsureGiftCardSum(draftOrder, 0, draftOrder.PreGiftCardTotal, false)
+}
+
+// Credit covers what gift cards left, but never leaves a charge under the minimum; returns what is still due
+func applyStoreCredit(draftOrder *models.DraftOrder, due, minCharge int) int {
+	credit := 0
+	if draftOrder.UseStoreCredit && due > 0 {
+		credit = min(draftOrder.StoreCreditAvailable, due)
+	}
+	if left := due - credit; left > 0 && left < minCharge {
+		credit = max(due-minCharge, 0)
+	}
+
+	draftOrder.StoreCreditSum = credit
+	return due - credit
+}
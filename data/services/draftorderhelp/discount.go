@@ -1,22 +1,33 @@
 package draftorderhelp
 
 import (
+	"beam/config"
 	"beam/data/models"
+	"beam/data/services/product"
+	"errors"
+	"fmt"
 	"math"
+	"slices"
+	"sort"
 )
 
-func ApplyDiscountToOrder(disc *models.Discount, ul []*models.DiscountUser, draftOrder *models.DraftOrder) error {
-	oldDiscPct := draftOrder.OrderDiscount.PercentageOff
-
-	draftOrder.OrderDiscount = models.OrderDiscount{
+func OrderDiscountFrom(disc *models.Discount, ul []*models.DiscountUser) models.OrderDiscount {
+	od := models.OrderDiscount{
 		DiscountCode:     disc.DiscountCode,
 		ShortMessage:     disc.ShortMessage,
 		IsPercentageOff:  disc.IsPercentageOff,
 		PercentageOff:    disc.PercentageOff,
+		IsDollarsOff:     disc.IsDollarsOff,
+		DollarsOff:       disc.DollarsOff,
 		HasMinSubtotal:   disc.HasMinSubtotal,
+		MinSubtotal:      disc.MinSubtotal,
 		AppliesToAllAny:  disc.AppliesToAllAny,
 		SingleCustomerID: disc.SingleCustomerID,
 		HasUserList:      disc.HasUserList,
+		IsShippingOff:    disc.IsShippingOff,
+		ShippingOff:      disc.ShippingOff,
+		Combination:      disc.CombinationRule(),
+		Target:           disc.Target,
 	}
 
 	if disc.HasUserList {
@@ -24,35 +35,317 @@ func ApplyDiscountToOrder(disc *models.Discount, ul []*models.DiscountUser, draf
 		for _, l := range ul {
 			u = append(u, l.CustomerID)
 		}
-		draftOrder.OrderDiscount.CustomerList = u
+		od.CustomerList = u
 	}
 
-	if disc.IsPercentageOff {
-		return applyPercentOffToDraft(draftOrder, disc.PercentageOff, oldDiscPct)
+	return od
+}
+
+// Adds the code to the codes already on the draft, or refreshes it if it is already there
+func ApplyDiscountToOrder(disc *models.Discount, ul []*models.DiscountUser, draftOrder *models.DraftOrder) error {
+	od := OrderDiscountFrom(disc, ul)
+
+	if !od.IsShippingOff {
+		if eligible, note := DiscountEligibility(od.Target, draftOrder.Lines); !slices.Contains(eligible, true) {
+			return fmt.Errorf("discount code %s doesn't apply to anything in this order: %s", od.DiscountCode, note)
+		}
 	}
 
-	return nil
+	discs := slices.Clone(draftOrder.AppliedDiscounts())
+	if i := slices.IndexFunc(discs, func(d models.OrderDiscount) bool { return d.DiscountCode == od.DiscountCode }); i >= 0 {
+		discs[i] = od
+	} else {
+		discs = append(discs, od)
+	}
+
+	if err := CheckDiscountCombination(discs); err != nil {
+		return err
+	}
+
+	draftOrder.Discounts = discs
+	return applyDiscounts(draftOrder)
 }
 
 func RemoveDiscountFromOrder(draftOrder *models.DraftOrder) error {
-	oldDiscPct := draftOrder.OrderDiscount.PercentageOff
+	draftOrder.Discounts = nil
+	draftOrder.OrderDiscount = models.OrderDiscount{}
+
+	return applyDiscounts(draftOrder)
+}
 
+func RemoveDiscountCodeFromOrder(draftOrder *models.DraftOrder, code string) error {
+	discs := slices.DeleteFunc(slices.Clone(draftOrder.AppliedDiscounts()), func(d models.OrderDiscount) bool {
+		return d.DiscountCode == code
+	})
+	if len(discs) == len(draftOrder.AppliedDiscounts()) {
+		return errors.New("discount code not on order")
+	}
+
+	draftOrder.Discounts = discs
 	draftOrder.OrderDiscount = models.OrderDiscount{}
+	return applyDiscounts(draftOrder)
+}
+
+func CheckDiscountCombination(discs []models.OrderDiscount) error {
+	if len(discs) > config.MAX_DISCOUNT_CODES {
+		return fmt.Errorf("maximum %d discount codes allowed on an order", config.MAX_DISCOUNT_CODES)
+	}
+
+	for i := range discs {
+		for j := i + 1; j < len(discs); j++ {
+			if discs[i].DiscountCode == discs[j].DiscountCode {
+				return fmt.Errorf("discount code %s is already applied", discs[i].DiscountCode)
+			} else if !models.DiscountsCombine(discs[i], discs[j]) {
+				return fmt.Errorf("discount code %s can't be combined with %s", discs[j].DiscountCode, discs[i].DiscountCode)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Percentage codes go first, then dollars off, then shipping only codes; larger amounts first, then by code
+func SortDiscounts(discs []models.OrderDiscount) {
+	rank := func(d models.OrderDiscount) (int, float64) {
+		if d.IsPercentageOff {
+			return 0, d.PercentageOff
+		} else if d.IsDollarsOff {
+			return 1, float64(d.DollarsOff)
+		}
+		return 2, float64(d.ShippingOff)
+	}
+
+	sort.SliceStable(discs, func(i, j int) bool {
+		ri, vi := rank(discs[i])
+		rj, vj := rank(discs[j])
+		if ri != rj {
+			return ri < rj
+		} else if vi != vj {
+			return vi > vj
+		}
+		return discs[i].DiscountCode < discs[j].DiscountCode
+	})
+}
+
+// Percentage off is taken from what earlier codes left; dollars off never takes the discounted subtotal under MIN_ORDER_PRICE
+func OrderDiscountAmount(disc models.OrderDiscount, subtotal int) int {
+	if disc.IsPercentageOff && disc.PercentageOff > 0 {
+		return int(math.Round(disc.PercentageOff * float64(subtotal)))
+	} else if disc.IsDollarsOff && disc.DollarsOff > 0 {
+		off := disc.DollarsOff
+		if most := subtotal - config.MIN_ORDER_PRICE; off > most {
+			off = most
+		}
+		if off < 0 {
+			off = 0
+		}
+		return off
+	}
+	return 0
+}
+
+// Percentage off comes from the eligible lines only; dollars off can't be more than the eligible lines have left
+func TargetedDiscountAmount(disc models.OrderDiscount, eligible, subtotal int) int {
+	if disc.IsPercentageOff {
+		return OrderDiscountAmount(disc, eligible)
+	}
+	off := OrderDiscountAmount(disc, subtotal)
+	if off > eligible {
+		off = eligible
+	}
+	return off
+}
+
+// Which lines a code can take from, with a note for the customer when that isn't every line
+func DiscountEligibility(target models.DiscountTarget, lines []models.OrderLine) ([]bool, string) {
+	eligible := make([]bool, len(lines))
+	if target.IsEmpty() {
+		for i := range eligible {
+			eligible[i] = true
+		}
+		return eligible, ""
+	}
+
+	quant, total, titles := 0, 0, []string{}
+	for i, l := range lines {
+		total += l.Quantity
+		if target.Matches(l) {
+			eligible[i] = true
+			quant += l.Quantity
+			if !slices.Contains(titles, l.ProductTitle) {
+				titles = append(titles, l.ProductTitle)
+			}
+		}
+	}
 
-	return applyPercentOffToDraft(draftOrder, 0, oldDiscPct)
+	if quant == 0 {
+		return eligible, "no eligible items"
+	} else if quant < target.MinEligibleQuantity {
+		for i := range eligible {
+			eligible[i] = false
+		}
+		return eligible, fmt.Sprintf("needs %d eligible items, order has %d", target.MinEligibleQuantity, quant)
+	} else if quant < total {
+		return eligible, fmt.Sprintf("applies to %d of %d items: %s", quant, total, product.JoinEnglish(titles))
+	}
+	return eligible, ""
+}
+
+// Sets each code's ShippingAmount in order against the shipping rate, returning the total taken off
+func ShippingDiscountAmount(discs []models.OrderDiscount, shipping int) int {
+	total := 0
+	for i := range discs {
+		discs[i].ShippingAmount = 0
+		if !discs[i].IsShippingOff || shipping-total <= 0 {
+			continue
+		}
+
+		off := shipping - total
+		if discs[i].ShippingOff > 0 && discs[i].ShippingOff < off {
+			off = discs[i].ShippingOff
+		}
+		discs[i].ShippingAmount = off
+		total += off
+	}
+	return total
+}
+
+// Splits the order level discount over the lines by line total, into each line's OrderDiscShare
+func ProrateOrderDiscount(lines []models.OrderLine, discOff int) {
+	weights := make([]int, len(lines))
+	for i, l := range lines {
+		weights[i] = l.EndPrice*l.Quantity - l.PromoDiscount
+	}
+
+	for i, share := range prorate(weights, discOff) {
+		lines[i].OrderDiscShare = share
+	}
+}
+
+// Largest remainders get the leftover cents so the shares always add up to the amount
+func prorate(weights []int, amount int) []int {
+	shares := make([]int, len(weights))
+
+	whole := 0
+	for _, w := range weights {
+		whole += w
+	}
+	if amount <= 0 || whole <= 0 {
+		return shares
+	}
+
+	remainders := make([]float64, len(weights))
+	given := 0
+	for i, w := range weights {
+		exact := float64(amount) * float64(w) / float64(whole)
+		shares[i] = int(math.Floor(exact))
+		remainders[i] = exact - math.Floor(exact)
+		given += shares[i]
+	}
+
+	for given < amount {
+		best := 0
+		for i := range remainders {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		shares[best]++
+		remainders[best] = -1
+		given++
+	}
+
+	return shares
+}
+
+// Applies every code in a fixed order, recording each code's part of every line
+func applyDiscounts(draftOrder *models.DraftOrder) error {
+	discs := slices.Clone(draftOrder.AppliedDiscounts())
+	SortDiscounts(discs)
+
+	lineLeft := make([]int, len(draftOrder.Lines))
+	for i, l := range draftOrder.Lines {
+		lineLeft[i] = l.EndPrice*l.Quantity - l.PromoDiscount
+		draftOrder.Lines[i].OrderDiscShare = 0
+		draftOrder.Lines[i].Discounts = nil
+	}
+
+	subtotalLeft, discOff := draftOrder.Subtotal, 0
+	for k := range discs {
+		eligible, note := DiscountEligibility(discs[k].Target, draftOrder.Lines)
+
+		weights, eligibleLeft, quant := make([]int, len(lineLeft)), 0, 0
+		for i := range lineLeft {
+			if eligible[i] {
+				weights[i] = lineLeft[i]
+				eligibleLeft += lineLeft[i]
+				quant += draftOrder.Lines[i].Quantity
+			}
+		}
+
+		amount := TargetedDiscountAmount(discs[k], eligibleLeft, subtotalLeft)
+
+		for i, share := range prorate(weights, amount) {
+			if share == 0 {
+				continue
+			}
+			lineLeft[i] -= share
+			draftOrder.Lines[i].OrderDiscShare += share
+			draftOrder.Lines[i].Discounts = append(draftOrder.Lines[i].Discounts, models.LineDiscount{DiscountCode: discs[k].DiscountCode, Amount: share})
+		}
+
+		discs[k].Amount = amount
+		discs[k].EligibleQuantity = quant
+		discs[k].Note = note
+		subtotalLeft -= amount
+		discOff += amount
+	}
+
+	if points := redeemPoints(&draftOrder.Points, subtotalLeft); points > 0 {
+		for i, share := range prorate(lineLeft, points) {
+			if share == 0 {
+				continue
+			}
+			lineLeft[i] -= share
+			draftOrder.Lines[i].OrderDiscShare += share
+			draftOrder.Lines[i].Discounts = append(draftOrder.Lines[i].Discounts, models.LineDiscount{DiscountCode: config.POINTS_CODE, Amount: share})
+		}
+		discOff += points
+	}
+
+	draftOrder.Discounts = discs
+	setShippingDiscount(draftOrder, draftOrder.Shipping+draftOrder.ShippingDiscount-draftOrder.MarginShipAdjust)
+
+	return setDiscountTotals(draftOrder, discOff)
+}
+
+// rate is the chosen shipping rate before any shipping discount or margin raise
+func setShippingDiscount(draftOrder *models.DraftOrder, rate int) {
+	if len(draftOrder.Discounts) == 0 && draftOrder.OrderDiscount.DiscountCode != "" {
+		draftOrder.Discounts = []models.OrderDiscount{draftOrder.OrderDiscount}
+	}
+
+	draftOrder.ShippingDiscount = ShippingDiscountAmount(draftOrder.Discounts, rate)
+	draftOrder.Shipping = rate - draftOrder.ShippingDiscount + draftOrder.MarginShipAdjust
+
+	draftOrder.OrderDiscount = models.OrderDiscount{}
+	if len(draftOrder.Discounts) > 0 {
+		draftOrder.OrderDiscount = draftOrder.Discounts[0]
+	}
 }
 
-func applyPercentOffToDraft(draftOrder *models.DraftOrder, percentageOff, oldDiscPct float64) error {
-	discOff := int(math.Round(percentageOff * float64(draftOrder.Subtotal)))
+func setDiscountTotals(draftOrder *models.DraftOrder, discOff int) error {
 	newPostDiscountTotal := draftOrder.Subtotal - discOff
 
-	newTax := int(math.Round((1 - (percentageOff - oldDiscPct)) * float64(draftOrder.Tax)))
+	newTax := EstimateTax(draftOrder, discOff)
 	if draftOrder.CATax {
 		newTax = int(math.Round(float64(newPostDiscountTotal) * draftOrder.CATaxRate))
+	} else if draftOrder.TaxInclusive {
+		newTax = InclusiveTax(draftOrder, discOff)
 	}
 
 	newPostTaxTotal := newPostDiscountTotal + newTax + draftOrder.Shipping
-	newPreGiftCardTotal := draftOrder.PostTaxTotal + draftOrder.Tip
+	newPreGiftCardTotal := newPostTaxTotal + draftOrder.Tip
 
 	draftOrder.OrderLevelDiscount = discOff
 	draftOrder.PostDiscountTotal = newPostDiscountTotal
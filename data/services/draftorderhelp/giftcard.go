@@ -126,7 +126,8 @@ func EnsureGiftCardSum(draftOrder *models.DraftOrder, newGiftCardSum, newPreGift
 		}
 		draftOrder.PreGiftCardTotal = newPreGiftCardTotal
 		draftOrder.GiftCardSum = 0
-		draftOrder.Total = newPreGiftCardTotal
+		draftOrder.PostGiftCardTotal = applyStoreCredit(draftOrder, newPreGiftCardTotal, config.MIN_ORDER_PRICE-draftOrder.GiftCardBuyTotal)
+		draftOrder.Total = draftOrder.PostGiftCardTotal + draftOrder.GiftCardBuyTotal
 		return nil
 	}
 
@@ -210,11 +211,11 @@ func EnsureGiftCardSum(draftOrder *models.DraftOrder, newGiftCardSum, newPreGift
 
 	draftOrder.PreGiftCardTotal = usedPreGiftCardTotal
 	draftOrder.GiftCardSum = usedGiftCardSum
-	draftOrder.PostGiftCardTotal = newTotal
-	draftOrder.Total = newTotal + draftOrder.GiftCardBuyTotal
+	draftOrder.PostGiftCardTotal = applyStoreCredit(draftOrder, newTotal, minPreGCAllowed)
+	draftOrder.Total = draftOrder.PostGiftCardTotal + draftOrder.GiftCardBuyTotal
 
 	if draftOrder.Total != oldTotal {
-		return updateStripePaymentIntent(draftOrder.StripePaymentIntentID, draftOrder.Total)
+		return UpdateStripePaymentIntent(draftOrder.StripePaymentIntentID, draftOrder.Total)
 	}
 	return nil
 }
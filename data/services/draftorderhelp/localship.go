@@ -0,0 +1,175 @@
+package draftorderhelp
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+func getShipTable(settings *config.SettingsMutex, name string) (models.ShipTable, bool) {
+	settings.Mu.RLock()
+	table, ok := settings.Settings.ShipTables[name]
+	settings.Mu.RUnlock()
+
+	return table, ok
+}
+
+func contactCountry(contact *models.Contact) string {
+	if contact.CountryCode != "" {
+		return contact.CountryCode
+	}
+	return contact.Country
+}
+
+func findShipZone(table models.ShipTable, contact *models.Contact) (models.ShipZone, bool) {
+	country := strings.ToUpper(strings.TrimSpace(contactCountry(contact)))
+	state := strings.ToUpper(strings.TrimSpace(contact.ProvinceState))
+	stateCode := strings.ToUpper(strings.TrimSpace(contact.StateCode))
+
+	for _, z := range table.Zones {
+		if !slices.ContainsFunc(z.Countries, func(c string) bool { return strings.EqualFold(c, country) }) {
+			continue
+		}
+		if len(z.States) == 0 {
+			return z, true
+		}
+		for _, s := range z.States {
+			s = strings.ToUpper(s)
+			if s == state || (stateCode != "" && s == stateCode) {
+				return z, true
+			}
+		}
+	}
+
+	return models.ShipZone{}, false
+}
+
+// Returns the local rates for the contact, or an error if no zone covers it
+func GetLocalShipRates(draft *models.DraftOrder, contact *models.Contact, table models.ShipTable) ([]models.ShippingRate, error) {
+	zone, ok := findShipZone(table, contact)
+	if !ok {
+		return nil, errors.New("no local shipping zone for address")
+	} else if len(zone.Services) == 0 {
+		return nil, errors.New("local shipping zone has no services: " + zone.Name)
+	}
+
+	items, weight, surcharge := 0, 0.0, 0
+	for _, line := range draft.Lines {
+		items += line.Quantity
+
+		w, ok := table.Weights[line.ProductID]
+		if !ok || w <= 0 {
+			w = 1
+		}
+		weight += w * float64(line.Quantity)
+
+		surcharge += table.Surcharges[line.ProductID] * line.Quantity
+	}
+
+	if items == 0 {
+		return nil, errors.New("no items to ship")
+	}
+
+	now := time.Now()
+	rates := make([]models.ShippingRate, 0, len(zone.Services))
+	for _, svc := range zone.Services {
+		units := weight
+		if svc.Basis == "items" {
+			units = float64(items)
+		}
+
+		cents := serviceCents(svc, units) + surcharge
+
+		method := strings.ToUpper(svc.PrintfulMethod)
+		if method == "" {
+			method = "STANDARD"
+		}
+
+		rate := models.ShippingRate{
+			ID:              svc.ID,
+			Name:            svc.Name,
+			PrintfulMethod:  method,
+			Rate:            fmt.Sprintf("%.2f", float64(cents)/100),
+			CentsRate:       cents,
+			Currency:        "USD",
+			MinDeliveryDays: svc.MinDeliveryDays,
+			MaxDeliveryDays: svc.MaxDeliveryDays,
+			Timestamp:       now,
+		}
+		if svc.MinDeliveryDays > 0 {
+			rate.MinDeliveryDate = now.AddDate(0, 0, svc.MinDeliveryDays).Format("2006-01-02")
+		}
+		if svc.MaxDeliveryDays > 0 {
+			rate.MaxDeliveryDate = now.AddDate(0, 0, svc.MaxDeliveryDays).Format("2006-01-02")
+		}
+
+		rates = append(rates, rate)
+	}
+
+	slices.SortStableFunc(rates, func(a, b models.ShippingRate) int { return a.CentsRate - b.CentsRate })
+
+	return rates, nil
+}
+
+// Past the last tier, PerUnitCents is charged for each unit over it
+func serviceCents(svc models.ShipService, units float64) int {
+	whole := int(math.Ceil(units))
+
+	if len(svc.Tiers) > 0 {
+		for _, t := range svc.Tiers {
+			if units <= t.UpTo {
+				return t.Cents
+			}
+		}
+		last := svc.Tiers[len(svc.Tiers)-1]
+		return last.Cents + svc.PerUnitCents*int(math.Ceil(units-last.UpTo))
+	}
+
+	if whole <= 1 {
+		return svc.BaseCents
+	}
+	return svc.BaseCents + svc.PerUnitCents*(whole-1)
+}
+
+// Lowers each Printful rate to the local rate with the same ID, if there is one
+func capShipRates(rates, local []models.ShippingRate) {
+	for i := range rates {
+		for _, l := range local {
+			if strings.EqualFold(rates[i].ID, l.ID) && l.CentsRate < rates[i].CentsRate {
+				rates[i].CentsRate = l.CentsRate
+				rates[i].Rate = l.Rate
+				break
+			}
+		}
+	}
+}
+
+func applyFreeShip(rates []models.ShippingRate, services []string) {
+	if len(rates) == 0 {
+		return
+	}
+
+	if len(services) > 0 {
+		for i := range rates {
+			if slices.ContainsFunc(services, func(s string) bool { return strings.EqualFold(s, rates[i].ID) }) {
+				rates[i].CentsRate = 0
+			}
+		}
+		return
+	}
+
+	cheapest := rates[0].CentsRate
+	for _, r := range rates {
+		if r.CentsRate < cheapest {
+			cheapest = r.CentsRate
+		}
+	}
+	for i := range rates {
+		rates[i].CentsRate = rates[i].CentsRate - cheapest
+	}
+}
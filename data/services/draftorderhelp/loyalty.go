@@ -0,0 +1,44 @@
+package draftorderhelp
+
+import (
+	"beam/data/models"
+	"errors"
+)
+
+// Shows the customer what they could redeem; nothing is redeemed until they ask
+func OfferPoints(draftOrder *models.DraftOrder, balance int, program models.LoyaltyProgram) {
+	draftOrder.Points = models.PointsRedemption{
+		Available:   balance,
+		RedeemStep:  program.RedeemStep,
+		RedeemCents: program.RedeemCents,
+	}
+}
+
+// requested is rounded down to whole steps and zero stops redeeming; balance may have changed since the draft was made
+func SetPoints(draftOrder *models.DraftOrder, requested, balance int, program models.LoyaltyProgram) error {
+	if requested < 0 {
+		return errors.New("points to redeem can't be negative")
+	} else if requested > balance {
+		return errors.New("not enough points")
+	}
+
+	draftOrder.Points.Available = balance
+	draftOrder.Points.RedeemStep = program.RedeemStep
+	draftOrder.Points.RedeemCents = program.RedeemCents
+	draftOrder.Points.Requested = requested - requested%program.RedeemStep
+
+	return applyDiscounts(draftOrder)
+}
+
+// Fits the requested points into what discount codes left of the subtotal, returning the cents they take off
+func redeemPoints(points *models.PointsRedemption, subtotalLeft int) int {
+	points.Redeemed, points.Cents = 0, 0
+	if points.Requested <= 0 || points.RedeemStep <= 0 || points.RedeemCents <= 0 || subtotalLeft <= 0 {
+		return 0
+	}
+
+	steps := min(points.Requested/points.RedeemStep, subtotalLeft/points.RedeemCents)
+	points.Redeemed = steps * points.RedeemStep
+	points.Cents = steps * points.RedeemCents
+	return points.Cents
+}
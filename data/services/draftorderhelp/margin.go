@@ -0,0 +1,91 @@
+package draftorderhelp
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"errors"
+	"math"
+)
+
+func StripeFee(charged int) int {
+	if charged <= 0 {
+		return 0
+	}
+	return int(math.Round(float64(charged)*config.STRIPE_FEE_PCT)) + config.STRIPE_FEE_FIXED
+}
+
+// Gift cards were paid for when bought, so revenue is before gift cards; Stripe only sees the charged total
+func CheckMargin(draft *models.DraftOrder, policy models.MarginPolicy) (models.MarginCheck, error) {
+	var check models.MarginCheck
+
+	if draft.OrderEstimate.Total <= 0 {
+		return check, errors.New("no pending order estimate")
+	}
+
+	check.Revenue = draft.PreGiftCardTotal
+	if draft.CATax || draft.TaxInclusive {
+		check.Revenue -= draft.Tax
+	}
+
+	check.Cost = int(math.Round(draft.OrderEstimate.Total*100)) + StripeFee(draft.Total)
+	check.Profit = check.Revenue - check.Cost
+
+	check.Required = policy.MinCents
+	if pct := int(math.Ceil(float64(check.Revenue) * policy.MinPct)); pct > check.Required {
+		check.Required = pct
+	}
+
+	if check.Profit < check.Required {
+		check.Shortfall = check.Required - check.Profit
+	}
+
+	return check, nil
+}
+
+// Resets the margin shipping raise and, for "adjust_ship" policies, sets it again from the current totals
+// Returns whether the total changed; errors when the policy's cap can't cover the shortfall
+func ApplyMarginShipAdjust(draft *models.DraftOrder, policy models.MarginPolicy) (bool, error) {
+	before := draft.Total
+
+	draft.Shipping -= draft.MarginShipAdjust
+	draft.MarginShipAdjust = 0
+	if err := SetTotalsAndEnsure(draft); err != nil {
+		return false, err
+	}
+
+	if policy.Action != "adjust_ship" {
+		return draft.Total != before, nil
+	}
+
+	// Second pass picks up the fixed Stripe fee when the first raise moves the charge off zero
+	for i := 0; i < 2; i++ {
+		check, err := CheckMargin(draft, policy)
+		if err != nil {
+			return draft.Total != before, err
+		} else if check.Shortfall == 0 {
+			break
+		}
+
+		raise := int(math.Ceil(float64(check.Shortfall) / (1 - config.STRIPE_FEE_PCT)))
+		if policy.MaxShipAdjust > 0 && draft.MarginShipAdjust+raise > policy.MaxShipAdjust {
+			raise = policy.MaxShipAdjust - draft.MarginShipAdjust
+		}
+		if raise <= 0 {
+			break
+		}
+
+		draft.MarginShipAdjust += raise
+		draft.Shipping += raise
+		if err := SetTotalsAndEnsure(draft); err != nil {
+			return draft.Total != before, err
+		}
+	}
+
+	if check, err := CheckMargin(draft, policy); err != nil {
+		return draft.Total != before, err
+	} else if check.Shortfall > 0 {
+		return draft.Total != before, errors.New("order is below the store's minimum margin")
+	}
+
+	return draft.Total != before, nil
+}
@@ -153,6 +153,15 @@ func createEstItemsArray(orderLines []models.OrderLine) ([]apidata.Items, error)
 }
 
 func DraftOrderEstimateUpdate(draftOrder *models.DraftOrder, newContact *models.Contact, mutexes *config.AllMutexes, name, ip string, tools *config.Tools) error {
+	if err := RefreshOrderEstimate(draftOrder, newContact, mutexes, name, ip, tools); err != nil {
+		return err
+	}
+
+	return CompareCostsOfDraftOrder(draftOrder, name, tools)
+}
+
+// Sets the order estimate without comparing it against the price
+func RefreshOrderEstimate(draftOrder *models.DraftOrder, newContact *models.Contact, mutexes *config.AllMutexes, name, ip string, tools *config.Tools) error {
 	shipRate := draftOrder.OrderEstimate.ShipRate
 
 	if shipRate == "" {
@@ -164,7 +173,7 @@ func DraftOrderEstimateUpdate(draftOrder *models.DraftOrder, newContact *models.
 	if est, ok := draftOrder.AllOrderEstimates[address]; ok && time.Since(est.Timestamp) <= 1*time.Hour {
 		draftOrder.OrderEstimate = est
 	} else {
-		if newEst, err := getEstApiShipRates(draftOrder, newContact, mutexes, name, ip, shipRate, tools); err != nil {
+		if newEst, err := getCachedEstApiShipRates(draftOrder, newContact, mutexes, name, ip, shipRate, tools); err != nil {
 			return err
 		} else {
 			newEst.AddressFormat = address
@@ -173,7 +182,7 @@ func DraftOrderEstimateUpdate(draftOrder *models.DraftOrder, newContact *models.
 		}
 	}
 
-	return CompareCostsOfDraftOrder(draftOrder, name, tools)
+	return nil
 }
 
 func CompareCostsOfDraftOrder(draftOrder *models.DraftOrder, name string, tools *config.Tools) error {
@@ -188,7 +197,7 @@ func CompareCostsOfDraftOrder(draftOrder *models.DraftOrder, name string, tools
 	cost := int(math.Round(draftOrder.OrderEstimate.Total * 100))
 	price := draftOrder.PreGiftCardTotal
 
-	if draftOrder.CATax {
+	if draftOrder.CATax || draftOrder.TaxInclusive {
 		price -= draftOrder.Tax
 	}
 
@@ -0,0 +1,176 @@
+package draftorderhelp
+
+import (
+	"beam/data/models"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Runs the promotions (already sorted by priority) over the lines, setting each line's adjustments, PromoDiscount and LineTotal
+// Promotions that took nothing off only come back when they have a note for the customer
+func EvaluatePromotions(lines []models.OrderLine, promos []models.Promotion) []models.AppliedPromotion {
+	lineLeft := make([]int, len(lines))
+	for i, l := range lines {
+		lines[i].Promotions = nil
+		lines[i].PromoDiscount = 0
+		lineLeft[i] = l.EndPrice * l.Quantity
+	}
+
+	applied := []models.AppliedPromotion{}
+	anyApplied := false
+	for _, p := range promos {
+		if p.Exclusive && anyApplied {
+			continue
+		}
+
+		shares, note := promotionShares(p, lines, lineLeft)
+
+		amount := 0
+		for i, share := range shares {
+			if share > lineLeft[i] {
+				share = lineLeft[i]
+			}
+			if share <= 0 {
+				continue
+			}
+			lineLeft[i] -= share
+			lines[i].PromoDiscount += share
+			lines[i].Promotions = append(lines[i].Promotions, models.LineAdjustment{PromotionID: p.ID, Name: p.Name, Amount: share})
+			amount += share
+		}
+
+		if amount > 0 || note != "" {
+			applied = append(applied, models.AppliedPromotion{PromotionID: p.ID, Name: p.Name, Type: p.Type, Amount: amount, Note: note})
+		}
+		if amount > 0 {
+			anyApplied = true
+			if p.Exclusive {
+				break
+			}
+		}
+	}
+
+	for i := range lines {
+		lines[i].LineTotal = lineLeft[i]
+	}
+
+	return applied
+}
+
+func PromotionsTotal(applied []models.AppliedPromotion) int {
+	total := 0
+	for _, a := range applied {
+		total += a.Amount
+	}
+	return total
+}
+
+// What the promotion takes off each line, given what earlier promotions left
+func promotionShares(p models.Promotion, lines []models.OrderLine, lineLeft []int) ([]int, string) {
+	shares := make([]int, len(lines))
+
+	weights, spend := make([]int, len(lines)), 0
+	for i, l := range lines {
+		if p.Type == "gift" && l.VariantID == p.GiftVariantID {
+			continue
+		}
+		if p.Target.Matches(l) {
+			weights[i] = lineLeft[i]
+			spend += lineLeft[i]
+		}
+	}
+
+	switch p.Type {
+	case "bxgy":
+		return buyXGetYShares(p, lines, lineLeft), ""
+
+	case "spend":
+		if spend < p.MinSpend {
+			return shares, spendNote(p.MinSpend-spend, fmt.Sprintf("%d%% off", pct(p.PctOff)))
+		}
+		return prorate(weights, int(math.Round(p.PctOff*float64(spend)))), ""
+
+	case "tiered":
+		best, next := -1, -1
+		for i, t := range p.Tiers {
+			if spend >= t.MinSpend && (best < 0 || t.MinSpend > p.Tiers[best].MinSpend) {
+				best = i
+			} else if spend < t.MinSpend && (next < 0 || t.MinSpend < p.Tiers[next].MinSpend) {
+				next = i
+			}
+		}
+		note := ""
+		if next >= 0 {
+			note = spendNote(p.Tiers[next].MinSpend-spend, fmt.Sprintf("%d%% off", pct(p.Tiers[next].PctOff)))
+		}
+		if best < 0 {
+			return shares, note
+		}
+		return prorate(weights, int(math.Round(p.Tiers[best].PctOff*float64(spend)))), note
+
+	case "gift":
+		gift := -1
+		for i, l := range lines {
+			if l.VariantID == p.GiftVariantID && l.Quantity > 0 {
+				gift = i
+				break
+			}
+		}
+		if spend < p.MinSpend {
+			return shares, spendNote(p.MinSpend-spend, "a free gift")
+		} else if gift < 0 {
+			return shares, "add the free gift to your cart to claim it"
+		}
+		shares[gift] = lines[gift].EndPrice
+		return shares, ""
+	}
+
+	return shares, ""
+}
+
+// The cheapest eligible items are the ones discounted
+func buyXGetYShares(p models.Promotion, lines []models.OrderLine, lineLeft []int) []int {
+	shares := make([]int, len(lines))
+	if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+		return shares
+	}
+
+	type unit struct {
+		line  int
+		price int
+	}
+	units := []unit{}
+	for i, l := range lines {
+		if !p.Target.Matches(l) || l.Quantity <= 0 {
+			continue
+		}
+		for q := 0; q < l.Quantity; q++ {
+			units = append(units, unit{line: i, price: lineLeft[i] / l.Quantity})
+		}
+	}
+
+	free := len(units) / (p.BuyQuantity + p.GetQuantity) * p.GetQuantity
+	if free == 0 {
+		return shares
+	}
+
+	sort.SliceStable(units, func(i, j int) bool { return units[i].price < units[j].price })
+
+	off := p.GetPctOff
+	if off <= 0 || off > 1 {
+		off = 1
+	}
+	for _, u := range units[:free] {
+		shares[u.line] += int(math.Round(off * float64(u.price)))
+	}
+	return shares
+}
+
+func spendNote(away int, reward string) string {
+	return fmt.Sprintf("spend $%.2f more for %s", float64(away)/100, reward)
+}
+
+func pct(f float64) int {
+	return int(math.Round(f * 100))
+}
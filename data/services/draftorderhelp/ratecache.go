@@ -0,0 +1,170 @@
+package draftorderhelp
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func normalizeCachePart(s string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(s), " "))
+}
+
+// Destination and Printful items, independent of line order and of which draft or customer asked
+func rateCacheHash(contact *models.Contact, lines []models.OrderLine) string {
+	parts := []string{
+		normalizeCachePart(contact.StreetAddress1),
+		normalizeCachePart(contact.City),
+		normalizeCachePart(contact.ProvinceState),
+		normalizeCachePart(contact.ZipCode),
+		normalizeCachePart(contact.Country),
+	}
+
+	items := []string{}
+	for _, item := range createItemsArray(lines) {
+		items = append(items, fmt.Sprintf("%v|%v|%v", item["variant_id"], item["external_variant_id"], item["quantity"]))
+	}
+	sort.Strings(items)
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|") + "::" + strings.Join(items, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+func shipRateCacheKey(name string, contact *models.Contact, lines []models.OrderLine) string {
+	return "SRC::" + name + "::" + rateCacheHash(contact, lines)
+}
+
+func estimateCacheKey(name, rateName string, contact *models.Contact, lines []models.OrderLine) string {
+	return "ESC::" + name + "::" + rateName + "::" + rateCacheHash(contact, lines)
+}
+
+// Only one caller refreshes a stale entry, the rest keep serving it
+func claimCacheRefresh(tools *config.Tools, key string) bool {
+	ok, err := tools.Redis.SetNX(context.Background(), key+"::REF", "1", config.SHIP_CACHE_REFRESH).Result()
+	if err != nil {
+		log.Printf("Error claiming rate cache refresh for %s: %v\n", key, err)
+		return false
+	}
+	return ok
+}
+
+func saveCacheEntry(tools *config.Tools, key string, data any) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshaling rate cache entry for %s: %v\n", key, err)
+		return
+	}
+
+	pipe := tools.Redis.TxPipeline()
+	pipe.Set(context.Background(), key, raw, config.SHIP_CACHE_STALE)
+	pipe.Del(context.Background(), key+"::REF")
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		log.Printf("Error saving rate cache entry for %s: %v\n", key, err)
+	}
+}
+
+func readCacheEntry(tools *config.Tools, key string, into any) bool {
+	raw, err := tools.Redis.Get(context.Background(), key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Error reading rate cache entry for %s: %v\n", key, err)
+		}
+		return false
+	}
+
+	if err := json.Unmarshal([]byte(raw), into); err != nil {
+		log.Printf("Error unmarshaling rate cache entry for %s: %v\n", key, err)
+		return false
+	}
+	return true
+}
+
+func storeShipRates(tools *config.Tools, key string, rates []models.ShippingRate) {
+	now := time.Now()
+	saveCacheEntry(tools, key, models.ShipRateStorage{Set: now, Expires: now.Add(config.SHIP_CACHE_FRESH), Rates: rates})
+}
+
+// Printful ship rates through the shared cache; stale entries keep the time they were quoted and are returned while one
+// caller refreshes them, unless fresh is set, which fetches them again right away
+func getCachedApiShipRates(draft *models.DraftOrder, newContact *models.Contact, mutexes *config.AllMutexes, name, ip string, fresh bool, tools *config.Tools) ([]models.ShippingRate, error) {
+	key := shipRateCacheKey(name, newContact, draft.Lines)
+
+	var stored models.ShipRateStorage
+	if readCacheEntry(tools, key, &stored) && len(stored.Rates) > 0 && (!fresh || time.Now().Before(stored.Expires)) {
+		if time.Now().After(stored.Expires) && claimCacheRefresh(tools, key) {
+			refreshDraft := &models.DraftOrder{Lines: draft.Lines}
+			refreshContact := *newContact
+			go func() {
+				rates, err := getApiShipRates(refreshDraft, &refreshContact, mutexes, name, ip, tools)
+				if err != nil {
+					log.Printf("Error refreshing cached ship rates for %s: %v\n", key, err)
+					return
+				}
+				storeShipRates(tools, key, rates)
+			}()
+		}
+
+		return stored.Rates, nil
+	}
+
+	rates, err := getApiShipRates(draft, newContact, mutexes, name, ip, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	go storeShipRates(tools, key, append([]models.ShippingRate{}, rates...))
+
+	return rates, nil
+}
+
+func storeEstimate(tools *config.Tools, key string, est models.OrderEstimateCost) {
+	now := time.Now()
+	saveCacheEntry(tools, key, models.OrderEstimateStorage{Set: now, Expires: now.Add(config.SHIP_CACHE_FRESH), Estimate: est})
+}
+
+// Printful cost estimates through the shared cache, same refresh rules as ship rates
+func getCachedEstApiShipRates(draft *models.DraftOrder, newContact *models.Contact, mutexes *config.AllMutexes, name, ip, rateName string, tools *config.Tools) (*models.OrderEstimateCost, error) {
+	if rateName == "" {
+		rateName = "STANDARD"
+	}
+
+	key := estimateCacheKey(name, rateName, newContact, draft.Lines)
+
+	var stored models.OrderEstimateStorage
+	if readCacheEntry(tools, key, &stored) && stored.Estimate.Total > 0 {
+		if time.Now().After(stored.Expires) && claimCacheRefresh(tools, key) {
+			refreshDraft := &models.DraftOrder{Lines: draft.Lines}
+			refreshContact := *newContact
+			go func() {
+				est, err := getEstApiShipRates(refreshDraft, &refreshContact, mutexes, name, ip, rateName, tools)
+				if err != nil {
+					log.Printf("Error refreshing cached order estimate for %s: %v\n", key, err)
+					return
+				}
+				storeEstimate(tools, key, *est)
+			}()
+		}
+
+		stored.Estimate.Timestamp = time.Now()
+		return &stored.Estimate, nil
+	}
+
+	est, err := getEstApiShipRates(draft, newContact, mutexes, name, ip, rateName, tools)
+	if err != nil {
+		return nil, err
+	}
+
+	go storeEstimate(tools, key, *est)
+
+	return est, nil
+}
@@ -7,10 +7,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -34,7 +36,7 @@ func applyRateLimitsShip(storeName, ip string, tools *config.Tools) error {
 func UpdateShippingRates(draft *models.DraftOrder, newContact *models.Contact, mutexes *config.AllMutexes, name, ip string, tools *config.Tools) error {
 	address := newContact.StreetAddress1 + ", " + newContact.City + ", " + newContact.ProvinceState + ", " + newContact.ZipCode + ", " + newContact.Country
 
-	freeship := slices.Contains(draft.Tags, "FREESHIP_O")
+	freeship := ApplyFreeShipRule(draft, newContact)
 
 	currentRateID := draft.ActualRate.ID
 
@@ -45,10 +47,26 @@ func UpdateShippingRates(draft *models.DraftOrder, newContact *models.Contact, m
 		}
 	}
 
-	newRates, err := getApiShipRates(draft, newContact, mutexes, name, ip, freeship, tools)
+	newRates, err := getShipRates(draft, newContact, mutexes, name, ip, false, tools)
 	if err != nil {
 		return err
 	}
+
+	// Stale cached rates can be shown while the cache refreshes, but are never charged
+	if ratesStale(newRates) {
+		if freeship != nil {
+			applyFreeShip(newRates, freeship.Services)
+		}
+		draft.CurrentShipping = newRates
+		if newRates, err = getShipRates(draft, newContact, mutexes, name, ip, true, tools); err != nil {
+			return err
+		}
+	}
+
+	if freeship != nil {
+		applyFreeShip(newRates, freeship.Services)
+	}
+
 	draft.AllShippingRates[address] = newRates
 	draft.CurrentShipping = newRates
 
@@ -74,18 +92,14 @@ func UpdateActualShippingRate(order *models.DraftOrder, shipID string) error {
 		selectedRate = &order.CurrentShipping[0]
 	}
 
-	if time.Since(selectedRate.Timestamp) > time.Hour {
+	if time.Since(selectedRate.Timestamp) > config.SHIP_CACHE_FRESH {
 		return errors.New("shipping rate has expired")
 	}
 
 	order.ActualRate = *selectedRate
 
-	rateInt, err := convertRateToCents(selectedRate.Rate)
-	if err != nil {
-		return err
-	}
-
-	order.Shipping = rateInt
+	// CentsRate already has free shipping rules and local caps taken off, Rate is what Printful quoted
+	setShippingDiscount(order, selectedRate.CentsRate)
 
 	checkDays := selectedRate.MinDeliveryDays
 	if checkDays <= 0 {
@@ -99,16 +113,57 @@ func UpdateActualShippingRate(order *models.DraftOrder, shipID string) error {
 	return nil
 }
 
+func ratesStale(rates []models.ShippingRate) bool {
+	return slices.ContainsFunc(rates, func(r models.ShippingRate) bool { return time.Since(r.Timestamp) > config.SHIP_CACHE_FRESH })
+}
+
+// Printful sends dollars as strings, e.g. "12.50"
 func convertRateToCents(rate string) (int, error) {
-	var rateInt int
-	_, err := fmt.Sscanf(rate, "%f", &rateInt)
+	dollars, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
 	if err != nil {
 		return 0, fmt.Errorf("invalid rate format: %v", err)
 	}
-	return rateInt, nil
+	return int(math.Round(dollars * 100)), nil
 }
 
-func getApiShipRates(draft *models.DraftOrder, newContact *models.Contact, mutexes *config.AllMutexes, name, ip string, freeship bool, tools *config.Tools) ([]models.ShippingRate, error) {
+// Chooses between Printful and the local table depending on the store's ship table mode; fresh skips stale cached rates
+func getShipRates(draft *models.DraftOrder, newContact *models.Contact, mutexes *config.AllMutexes, name, ip string, fresh bool, tools *config.Tools) ([]models.ShippingRate, error) {
+	table, hasTable := getShipTable(&mutexes.Settings, name)
+	if !hasTable {
+		return getCachedApiShipRates(draft, newContact, mutexes, name, ip, fresh, tools)
+	}
+
+	switch table.Mode {
+	case "primary":
+		if local, err := GetLocalShipRates(draft, newContact, table); err == nil {
+			return local, nil
+		}
+		return getCachedApiShipRates(draft, newContact, mutexes, name, ip, fresh, tools)
+	case "fallback":
+		rates, err := getCachedApiShipRates(draft, newContact, mutexes, name, ip, fresh, tools)
+		if err == nil {
+			return rates, nil
+		}
+		local, localErr := GetLocalShipRates(draft, newContact, table)
+		if localErr != nil {
+			return nil, fmt.Errorf("printful rates failed: %v; local rates failed: %v", err, localErr)
+		}
+		return local, nil
+	case "cap":
+		rates, err := getCachedApiShipRates(draft, newContact, mutexes, name, ip, fresh, tools)
+		if err != nil {
+			return nil, err
+		}
+		if local, err := GetLocalShipRates(draft, newContact, table); err == nil {
+			capShipRates(rates, local)
+		}
+		return rates, nil
+	}
+
+	return getCachedApiShipRates(draft, newContact, mutexes, name, ip, fresh, tools)
+}
+
+func getApiShipRates(draft *models.DraftOrder, newContact *models.Contact, mutexes *config.AllMutexes, name, ip string, tools *config.Tools) ([]models.ShippingRate, error) {
 
 	mutexes.Api.Mu.RLock()
 	apiKey := mutexes.Api.KeyMap[name]
@@ -177,18 +232,6 @@ func getApiShipRates(draft *models.DraftOrder, newContact *models.Contact, mutex
 		newRates[i].CentsRate = cents
 	}
 
-	if freeship {
-		cheapest := newRates[0].CentsRate
-		for _, r := range newRates {
-			if r.CentsRate < cheapest {
-				cheapest = r.CentsRate
-			}
-		}
-		for i := range newRates {
-			newRates[i].CentsRate = newRates[i].CentsRate - cheapest
-		}
-	}
-
 	return newRates, nil
 }
 
@@ -219,28 +262,50 @@ func createItemsArray(orderLines []models.OrderLine) []map[string]any {
 	return items
 }
 
-func EvaluateFreeShip(draftOrder *models.DraftOrder, customer *models.Customer, products map[int]*models.ProductRedis) bool {
-	freeShipSubtotal, err := strconv.Atoi(os.Getenv("FREESHIP_SUBTOTAL"))
-	if err != nil {
-		return false
-	}
-	if (customer != nil && slices.Contains(customer.Tags, "FREESHIP")) || draftOrder.Subtotal >= freeShipSubtotal {
-		allAllowed := true
-		for _, l := range draftOrder.Lines {
-			p, ok := products[l.ProductID]
-			if !ok {
-				allAllowed = false
-				break
-			}
-			if slices.Contains(p.Tags, "NOFREESHIP") {
-				allAllowed = false
-				break
-			}
+// Keeps the rules this draft could qualify for; the country is checked once the address is known
+func EvaluateFreeShip(draftOrder *models.DraftOrder, customer *models.Customer, products map[int]*models.ProductRedis, rules []models.FreeShipRule) bool {
+	draftOrder.FreeShipRules = nil
+
+	for _, l := range draftOrder.Lines {
+		p, ok := products[l.ProductID]
+		if !ok || slices.Contains(p.Tags, "NOFREESHIP") {
+			return false
+		}
+	}
+
+	custTags := []string{}
+	if customer != nil {
+		custTags = customer.Tags
+	}
+
+	now := time.Now()
+	for _, r := range rules {
+		if r.Active(now) && r.MatchesCustomer(custTags) && draftOrder.Subtotal >= r.MinSubtotal {
+			draftOrder.FreeShipRules = append(draftOrder.FreeShipRules, r)
 		}
-		if allAllowed {
-			draftOrder.Tags = append(draftOrder.Tags, "FREESHIP_O")
+	}
+
+	return ApplyFreeShipRule(draftOrder, draftOrder.ShippingContact) != nil
+}
+
+// Tags the draft with the first kept rule matching the contact's country, clearing any earlier choice
+func ApplyFreeShipRule(draftOrder *models.DraftOrder, contact *models.Contact) *models.FreeShipRule {
+	draftOrder.Tags = slices.DeleteFunc(draftOrder.Tags, func(t string) bool {
+		return t == "FREESHIP_O" || strings.HasPrefix(t, "FREESHIP_R:")
+	})
+
+	country := ""
+	if contact != nil {
+		country = contactCountry(contact)
+	}
+
+	now := time.Now()
+	for i, r := range draftOrder.FreeShipRules {
+		if r.Active(now) && r.MatchesCountry(country) {
+			draftOrder.Tags = append(draftOrder.Tags, "FREESHIP_O", "FREESHIP_R:"+r.Name)
+			return &draftOrder.FreeShipRules[i]
 		}
-		return allAllowed
 	}
-	return false
+
+	return nil
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/stripe/stripe-go/v81/customer"
 	"github.com/stripe/stripe-go/v81/paymentintent"
 	"github.com/stripe/stripe-go/v81/paymentmethod"
+	"github.com/stripe/stripe-go/v81/refund"
 )
 
 func CreatePaymentIntent(customerID string, amount int64, currency string) (string, error) {
@@ -40,7 +41,7 @@ func CheckPaymentIntent(paymentIntentID, customerID string, amt int64) error {
 	}
 
 	if pi.Amount != amt {
-		return updateStripePaymentIntent(paymentIntentID, int(amt))
+		return UpdateStripePaymentIntent(paymentIntentID, int(amt))
 	}
 
 	return nil
@@ -184,7 +185,7 @@ func DraftPaymentMethodUpdate(draft *models.DraftOrder, stripeID string) error {
 	return nil
 }
 
-func updateStripePaymentIntent(paymentIntentID string, total int) error {
+func UpdateStripePaymentIntent(paymentIntentID string, total int) error {
 	params := &stripe.PaymentIntentParams{
 		Amount: stripe.Int64(int64(total)),
 	}
@@ -277,3 +278,19 @@ func CreateAndChargePaymentIntent(methodID, customerID string, amount int) (*str
 
 	return intent, nil
 }
+
+// Amount of 0 refunds whatever is left on the payment intent
+func RefundPaymentIntent(paymentIntentID string, amount int64) (string, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+	}
+	if amount > 0 {
+		params.Amount = stripe.Int64(amount)
+	}
+
+	r, err := refund.New(params)
+	if err != nil {
+		return "", err
+	}
+	return r.ID, nil
+}
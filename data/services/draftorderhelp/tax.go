@@ -77,11 +77,13 @@ func GetRateWithFallback(client *http.Client, contact *models.Contact, taxData *
 	return rate, nil
 }
 
-func ModifyTaxRate(draft *models.DraftOrder, tools *config.Tools, mutex *config.AllMutexes) error {
+func ModifyTaxRate(draft *models.DraftOrder, tools *config.Tools, mutex *config.AllMutexes, name string) error {
 	if draft.ShippingContact.StreetAddress1 == "" || draft.ShippingContact.City == "" || draft.ShippingContact.ZipCode == "" {
 		return errors.New("contact is required")
 	}
 
+	SetInclusiveTax(draft, &mutex.Settings, name)
+
 	isCalifornia := strings.EqualFold(draft.ShippingContact.ProvinceState, "ca") || strings.EqualFold(draft.ShippingContact.ProvinceState, "california")
 	isUS := strings.EqualFold(draft.ShippingContact.Country, "US")
 
@@ -112,12 +114,50 @@ func UpdateTaxFromRate(draft *models.DraftOrder) error {
 
 	if isCalifornia && isUS {
 		draft.Tax = int(math.Round(float64(draft.Subtotal) * draft.CATaxRate))
+	} else if draft.TaxInclusive {
+		draft.Tax = InclusiveTax(draft, draft.OrderLevelDiscount)
 	} else {
-		draft.Tax = int(draft.OrderEstimate.Tax * 100)
-		if draft.OrderDiscount.PercentageOff > 0 {
-			draft.Tax = int(math.Round(float64(draft.Tax) * (1 - draft.OrderDiscount.PercentageOff)))
-		}
+		draft.Tax = EstimateTax(draft, draft.OrderLevelDiscount)
 	}
 
 	return nil
 }
+
+// Printful's estimated tax scaled down by the share of the subtotal taken off by the order level discount
+func EstimateTax(draft *models.DraftOrder, discOff int) int {
+	tax := int(draft.OrderEstimate.Tax * 100)
+	if discOff > 0 && draft.Subtotal > 0 {
+		tax = int(math.Round(float64(tax) * float64(draft.Subtotal-discOff) / float64(draft.Subtotal)))
+	}
+	return tax
+}
+
+// Sets whether the shipping country shows prices with VAT/GST included, based on the store settings
+func SetInclusiveTax(draft *models.DraftOrder, settings *config.SettingsMutex, name string) {
+	rate, ok := config.InclusiveTaxRate(settings, name, contactCountry(draft.ShippingContact))
+	draft.TaxInclusive = ok
+	draft.InclusiveTaxRate = rate
+}
+
+// Tax included in the displayed prices after the order level discount
+// Taken as the displayed gross minus the net, so the total always matches what the customer saw
+func InclusiveTax(draft *models.DraftOrder, orderLevelDiscount int) int {
+	if !draft.TaxInclusive || draft.Subtotal <= 0 {
+		return 0
+	}
+
+	gross := 0
+	for _, line := range draft.Lines {
+		gross += config.InclusivePrice(line.EndPrice, draft.InclusiveTaxRate)*line.Quantity - config.InclusivePrice(line.PromoDiscount, draft.InclusiveTaxRate)
+	}
+
+	net := draft.Subtotal - orderLevelDiscount
+	if orderLevelDiscount > 0 {
+		gross = int(math.Round(float64(gross) * float64(net) / float64(draft.Subtotal)))
+	}
+
+	if gross < net {
+		return 0
+	}
+	return gross - net
+}
@@ -11,20 +11,22 @@ import (
 )
 
 type DataPassIn struct {
-	Store         string
-	CustomerID    int
-	IsLoggedIn    bool
-	GuestID       string
-	CartID        int
-	SessionID     string
-	SessionLineID string
-	AffiliateID   int
-	AffiliateCode string
-	IPAddress     string
-	TimeStarted   time.Time
-	Logger        EventService
-	Logs          []models.EventFinal
-	LogsMutex     sync.Mutex
+	Store            string
+	CustomerID       int
+	IsLoggedIn       bool
+	GuestID          string
+	CartID           int
+	SessionID        string
+	SessionLineID    string
+	AffiliateID      int
+	AffiliateCode    string
+	AffiliateTouch   time.Time
+	AffiliateExpires time.Time
+	IPAddress        string
+	TimeStarted      time.Time
+	Logger           EventService
+	Logs             []models.EventFinal
+	LogsMutex        sync.Mutex
 }
 
 func (d *DataPassIn) AddLog(modelName, funcName, errorDesc, extraNote string, err error, ids models.EventPassInFinal) {
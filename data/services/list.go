@@ -41,7 +41,7 @@ type ListService interface {
 	GetLastOrdersListByPage(dpi *DataPassIn, page int, ps ProductService) (models.LastOrderListRender, error)
 	GetCustomListByPage(dpi *DataPassIn, page, listID int, ps ProductService) (models.CustomListRender, error)
 
-	CartToSavesList(dpi *DataPassIn, lineID int, ps ProductService, cs CartService) (models.SavesListRender, *models.CartRender, error)
+	CartToSavesList(dpi *DataPassIn, lineID int, ps ProductService, cs CartService, storeSettings *config.SettingsMutex) (models.SavesListRender, *models.CartRender, error)
 
 	AddToCustomList(dpi *DataPassIn, variantID int, listID int, ps ProductService) error
 	DeleteFromCustomList(dpi *DataPassIn, variantID int, listID int, ps ProductService) (string, *models.LimitedVariantRedis, error)
@@ -497,14 +497,14 @@ func (s *listService) GetCustomListByPage(dpi *DataPassIn, page, listID int, ps
 	return ret, nil
 }
 
-func (s *listService) CartToSavesList(dpi *DataPassIn, lineID int, ps ProductService, cs CartService) (models.SavesListRender, *models.CartRender, error) {
+func (s *listService) CartToSavesList(dpi *DataPassIn, lineID int, ps ProductService, cs CartService, storeSettings *config.SettingsMutex) (models.SavesListRender, *models.CartRender, error) {
 
 	line, err := cs.GetCartLineWithValidation(dpi, lineID)
 	if err != nil {
 		return models.SavesListRender{}, nil, err
 	}
 
-	cr, err := cs.AdjustQuantity(dpi, lineID, 0, ps)
+	cr, err := cs.AdjustQuantity(dpi, lineID, 0, ps, storeSettings)
 	if err != nil {
 		return models.SavesListRender{}, nil, err
 	}
@@ -0,0 +1,297 @@
+package services
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"beam/data/repositories"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type LoyaltyService interface {
+	AwardOrderPoints(dpi *DataPassIn, order *models.Order, cs CustomerService, storeSettings *config.SettingsMutex) (int, error)
+	AwardReviewPoints(dpi *DataPassIn, customerID, productID int, cs CustomerService, storeSettings *config.SettingsMutex) error
+	AwardBirthdayPoints(dpi *DataPassIn, customerID, year int, cs CustomerService, storeSettings *config.SettingsMutex) error
+	AwardSignupPoints(dpi *DataPassIn, customerID int, cs CustomerService, storeSettings *config.SettingsMutex) error
+	AdjustPoints(dpi *DataPassIn, customerID, points int, note string, cs CustomerService, storeSettings *config.SettingsMutex) error
+	GetPointsWallet(dpi *DataPassIn, customerID int, storeSettings *config.SettingsMutex) (models.PointsWallet, error)
+	Balance(dpi *DataPassIn, customerID int) (int, error)
+	RedeemOrderPoints(dpi *DataPassIn, customerID, points int, orderID string) error
+	RestoreOrderPoints(dpi *DataPassIn, orderID, reason string) (int, error)
+	ReverseOrderPoints(dpi *DataPassIn, order *models.Order, cents, whole int, reason string, cs CustomerService, storeSettings *config.SettingsMutex) (int, error)
+	ExpirePoints(dpi *DataPassIn) (int, error)
+}
+
+type loyaltyService struct {
+	loyaltyRepo repositories.LoyaltyRepository
+}
+
+func NewLoyaltyService(loyaltyRepo repositories.LoyaltyRepository) LoyaltyService {
+	return &loyaltyService{loyaltyRepo: loyaltyRepo}
+}
+
+// Earned on the order after discounts, before shipping and tax, at the customer's tier before the order
+func (s *loyaltyService) AwardOrderPoints(dpi *DataPassIn, order *models.Order, cs CustomerService, storeSettings *config.SettingsMutex) (int, error) {
+	program, ok := config.LoyaltyProgram(storeSettings, dpi.Store)
+	if !ok || order.Guest || order.CustomerID <= 0 || program.PointsPerDollar <= 0 {
+		return 0, nil
+	}
+
+	account, err := s.loyaltyRepo.GetAccount(order.CustomerID)
+	if err != nil {
+		return 0, err
+	}
+
+	multiplier := tierFor(program, account.Lifetime).Multiplier
+	if multiplier <= 0 {
+		multiplier = 1
+	}
+
+	points := int(float64(order.PostDiscountTotal*program.PointsPerDollar/100) * multiplier)
+	if points <= 0 {
+		return 0, nil
+	}
+
+	earned, err := s.earn(dpi, program, order.CustomerID, points, "Order", order.ID.Hex(), order.ID.Hex(), "Order "+order.ID.Hex(), cs)
+	if err != nil || !earned {
+		return 0, err
+	}
+	return points, nil
+}
+
+// Once per product, even if the review is later deleted and written again
+func (s *loyaltyService) AwardReviewPoints(dpi *DataPassIn, customerID, productID int, cs CustomerService, storeSettings *config.SettingsMutex) error {
+	program, ok := config.LoyaltyProgram(storeSettings, dpi.Store)
+	if !ok || customerID <= 0 || program.ReviewPoints <= 0 {
+		return nil
+	}
+
+	ref := strconv.Itoa(productID)
+	_, err := s.earn(dpi, program, customerID, program.ReviewPoints, "Review", ref, "", "Review of product "+ref, cs)
+	return err
+}
+
+func (s *loyaltyService) AwardBirthdayPoints(dpi *DataPassIn, customerID, year int, cs CustomerService, storeSettings *config.SettingsMutex) error {
+	program, ok := config.LoyaltyProgram(storeSettings, dpi.Store)
+	if !ok || customerID <= 0 || program.BirthdayPoints <= 0 {
+		return nil
+	}
+
+	ref := strconv.Itoa(year)
+	_, err := s.earn(dpi, program, customerID, program.BirthdayPoints, "Birthday", ref, "", "Birthday "+ref, cs)
+	return err
+}
+
+func (s *loyaltyService) AwardSignupPoints(dpi *DataPassIn, customerID int, cs CustomerService, storeSettings *config.SettingsMutex) error {
+	program, ok := config.LoyaltyProgram(storeSettings, dpi.Store)
+	if !ok || customerID <= 0 || program.SignupPoints <= 0 {
+		return nil
+	}
+
+	_, err := s.earn(dpi, program, customerID, program.SignupPoints, "Signup", "", "", "Account created", cs)
+	return err
+}
+
+// Admin grant; each one is its own lot and counts towards tiers like any other earning
+func (s *loyaltyService) AdjustPoints(dpi *DataPassIn, customerID, points int, note string, cs CustomerService, storeSettings *config.SettingsMutex) error {
+	program, ok := config.LoyaltyProgram(storeSettings, dpi.Store)
+	if !ok {
+		return errors.New("store has no points program")
+	} else if customerID <= 0 {
+		return errors.New("points need a customer account")
+	} else if points <= 0 || points >= 100000000 {
+		return errors.New("points out of range")
+	} else if note == "" {
+		return errors.New("points adjustment needs a note")
+	}
+	if len(note) > 256 {
+		note = note[:255]
+	}
+
+	ref := strconv.FormatInt(time.Now().UnixNano(), 36)
+	_, err := s.earn(dpi, program, customerID, points, "Adjust", ref, "", "Adjust: "+note, cs)
+	return err
+}
+
+func (s *loyaltyService) GetPointsWallet(dpi *DataPassIn, customerID int, storeSettings *config.SettingsMutex) (models.PointsWallet, error) {
+	ret := models.PointsWallet{}
+
+	account, err := s.loyaltyRepo.GetAccount(customerID)
+	if err != nil {
+		return ret, err
+	}
+	lots, err := s.loyaltyRepo.GetLots(customerID)
+	if err != nil {
+		return ret, err
+	}
+	lines, err := s.loyaltyRepo.GetPointsLines(customerID, config.PAGELEN*5)
+	if err != nil {
+		return ret, err
+	}
+	ret.Lifetime, ret.Tier, ret.Lots, ret.Lines = account.Lifetime, account.Tier, lots, lines
+
+	if program, ok := config.LoyaltyProgram(storeSettings, dpi.Store); ok {
+		ret.RedeemStep, ret.RedeemCents = program.RedeemStep, program.RedeemCents
+		for _, t := range program.Tiers {
+			if t.MinLifetime > account.Lifetime {
+				ret.NextTier, ret.ToNextTier = t.Name, t.MinLifetime-account.Lifetime
+				break
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, l := range lots {
+		if l.Status != "Active" || l.LeftoverPoints <= 0 || (!l.Expires.IsZero() && !l.Expires.After(now)) {
+			continue
+		}
+		ret.Balance += l.LeftoverPoints
+
+		if l.Expires.IsZero() {
+			continue
+		} else if ret.NextExpiry.IsZero() || l.Expires.Before(ret.NextExpiry) {
+			ret.NextExpiry, ret.Expiring = l.Expires, l.LeftoverPoints
+		} else if l.Expires.Equal(ret.NextExpiry) {
+			ret.Expiring += l.LeftoverPoints
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *loyaltyService) Balance(dpi *DataPassIn, customerID int) (int, error) {
+	if customerID <= 0 {
+		return 0, nil
+	}
+
+	lots, err := s.loyaltyRepo.GetActiveLots(customerID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	balance := 0
+	for _, l := range lots {
+		balance += l.LeftoverPoints
+	}
+	return balance, nil
+}
+
+func (s *loyaltyService) RedeemOrderPoints(dpi *DataPassIn, customerID, points int, orderID string) error {
+	return s.loyaltyRepo.RedeemPoints(customerID, points, orderID, time.Now())
+}
+
+// Puts points redeemed on an order back, e.g. when it is cancelled
+func (s *loyaltyService) RestoreOrderPoints(dpi *DataPassIn, orderID, reason string) (int, error) {
+	return s.loyaltyRepo.RestoreOrderPoints(orderID, reason)
+}
+
+// Takes back the share cents is of whole of what the order earned, e.g. a refund out of the order total; can drop the customer's tier
+func (s *loyaltyService) ReverseOrderPoints(dpi *DataPassIn, order *models.Order, cents, whole int, reason string, cs CustomerService, storeSettings *config.SettingsMutex) (int, error) {
+	if order.PointsEarned <= 0 || cents <= 0 || order.CustomerID <= 0 {
+		return 0, nil
+	}
+
+	points := order.PointsEarned
+	if cents < whole {
+		points = order.PointsEarned * cents / whole
+	}
+
+	reversed, err := s.loyaltyRepo.ReverseOrderPoints(order.CustomerID, order.ID.Hex(), points, reason)
+	if err != nil || reversed == 0 {
+		return reversed, err
+	}
+
+	if program, ok := config.LoyaltyProgram(storeSettings, dpi.Store); ok {
+		return reversed, s.updateTier(dpi, program, order.CustomerID, cs)
+	}
+	return reversed, nil
+}
+
+// Meant to run on a schedule per store; redeeming already skips expired points, this writes them off in the ledger
+func (s *loyaltyService) ExpirePoints(dpi *DataPassIn) (int, error) {
+	return s.loyaltyRepo.ExpirePoints(time.Now(), config.PAGELEN*10)
+}
+
+// Records a lot unless the customer already has one for the source and ref, then moves their tier if it changed
+func (s *loyaltyService) earn(dpi *DataPassIn, program models.LoyaltyProgram, customerID, points int, source, ref, orderID, reason string, cs CustomerService) (bool, error) {
+	now := time.Now()
+	lot := &models.PointsLot{
+		CustomerID:     customerID,
+		Created:        now,
+		Source:         source,
+		SourceRef:      ref,
+		OriginalPoints: points,
+		LeftoverPoints: points,
+		Status:         "Active",
+	}
+	if program.ExpiryDays > 0 {
+		lot.Expires = now.AddDate(0, 0, program.ExpiryDays)
+	} else if program.ExpiryDays == 0 {
+		lot.Expires = now.AddDate(0, 0, config.POINTS_EXPIRY_DAYS)
+	}
+
+	earned, err := s.loyaltyRepo.EarnPoints(lot, orderID, reason)
+	if err != nil || !earned {
+		return false, err
+	}
+
+	return true, s.updateTier(dpi, program, customerID, cs)
+}
+
+// Keeps the account's tier in step with its lifetime points; a FreeShip tier adds the FREESHIP customer tag,
+// which is only taken away again if the tier added it
+func (s *loyaltyService) updateTier(dpi *DataPassIn, program models.LoyaltyProgram, customerID int, cs CustomerService) error {
+	account, err := s.loyaltyRepo.GetAccount(customerID)
+	if err != nil {
+		return err
+	}
+
+	tier := tierFor(program, account.Lifetime)
+	if tier.Name == account.Tier && tier.FreeShip == account.FreeShipTag {
+		return nil
+	}
+
+	if tier.Name != account.Tier {
+		account.Tier = tier.Name
+		account.TierSince = time.Now()
+	}
+
+	if tier.FreeShip != account.FreeShipTag {
+		cust, err := cs.GetCustomerByID(dpi, customerID)
+		if err != nil {
+			return err
+		} else if cust == nil {
+			return errors.New("no customer for points account")
+		}
+
+		hasTag := slices.Contains(cust.Tags, "FREESHIP")
+		if tier.FreeShip && !hasTag {
+			cust.Tags = append(cust.Tags, "FREESHIP")
+			if err := cs.Update(dpi, cust); err != nil {
+				return err
+			}
+			account.FreeShipTag = true
+		} else if !tier.FreeShip && account.FreeShipTag {
+			cust.Tags = slices.DeleteFunc(cust.Tags, func(t string) bool { return t == "FREESHIP" })
+			if err := cs.Update(dpi, cust); err != nil {
+				return err
+			}
+			account.FreeShipTag = false
+		}
+	}
+
+	return s.loyaltyRepo.SaveAccount(account)
+}
+
+// Highest tier the lifetime points reach, the zero tier below the first
+func tierFor(program models.LoyaltyProgram, lifetime int) models.LoyaltyTier {
+	tier := models.LoyaltyTier{}
+	for _, t := range program.Tiers {
+		if lifetime >= t.MinLifetime {
+			tier = t
+		}
+	}
+	return tier
+}
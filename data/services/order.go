@@ -6,6 +6,7 @@ import (
 	"beam/config"
 	"beam/data/models"
 	"beam/data/repositories"
+	"beam/data/services/custhelp"
 	"beam/data/services/draftorderhelp"
 	"beam/data/services/orderhelp"
 	"errors"
@@ -25,10 +26,17 @@ import (
 )
 
 type OrderService interface {
-	SubmitOrder(dpi *DataPassIn, draftID, newPaymentMethod string, saveMethod bool, useExisting bool, ds DraftOrderService, dts DiscountService, cs CustomerService, ps ProductService, ors OrderService, tools *config.Tools, storeSettings *config.SettingsMutex) (error, error)
-	SubmitPayment(dpi *DataPassIn, draftID, newPayment string, saveMethod bool, useExisting bool, ds DraftOrderService, dts DiscountService, cs CustomerService, ps ProductService, ors OrderService, tools *config.Tools, storeSettings *config.SettingsMutex) (error, error)
-	CompleteOrder(dpi *DataPassIn, orderID string, cs CustomerService, ds DraftOrderService, dts DiscountService, ls ListService, ps ProductService, ors OrderService, ss SessionService, mutexes *config.AllMutexes, tools *config.Tools)
+	SubmitOrder(dpi *DataPassIn, draftID, newPaymentMethod string, saveMethod bool, useExisting bool, ds DraftOrderService, dts DiscountService, cs CustomerService, ps ProductService, ors OrderService, scs StoreCreditService, lys LoyaltyService, tools *config.Tools, storeSettings *config.SettingsMutex) (error, error)
+	SubmitPayment(dpi *DataPassIn, draftID, newPayment string, saveMethod bool, useExisting bool, ds DraftOrderService, dts DiscountService, cs CustomerService, ps ProductService, ors OrderService, scs StoreCreditService, lys LoyaltyService, tools *config.Tools, storeSettings *config.SettingsMutex) (error, error)
+	CompleteOrder(dpi *DataPassIn, orderID string, cs CustomerService, ds DraftOrderService, dts DiscountService, ls ListService, ps ProductService, ors OrderService, ss SessionService, mutexes *config.AllMutexes, tools *config.Tools, prs ProfitService, scs StoreCreditService, lys LoyaltyService, rfs ReferralService, afs AffiliateService)
 	FailOrder(dpi *DataPassIn, store, orderID string)
+	ReleaseHeldOrders(dpi *DataPassIn, ds DraftOrderService, prs ProfitService, dts DiscountService, mutexes *config.AllMutexes, tools *config.Tools) (int, error)
+	EditHeldOrderContact(dpi *DataPassIn, orderID string, contact *models.Contact, lys LoyaltyService, afs AffiliateService, cs CustomerService, mutexes *config.AllMutexes, tools *config.Tools) (*models.Order, error)
+	RemoveHeldOrderLine(dpi *DataPassIn, orderID string, lineIndex int, ps ProductService, lys LoyaltyService, afs AffiliateService, cs CustomerService, mutexes *config.AllMutexes, tools *config.Tools) (*models.Order, error)
+	CancelHeldOrder(dpi *DataPassIn, orderID, reason string, ps ProductService, dts DiscountService, scs StoreCreditService, lys LoyaltyService, afs AffiliateService, cs CustomerService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.Order, error)
+	ApproveHeldOrder(dpi *DataPassIn, orderID string, ds DraftOrderService, prs ProfitService, dts DiscountService, mutexes *config.AllMutexes, tools *config.Tools) (*models.Order, error)
+	RejectHeldOrder(dpi *DataPassIn, orderID, reason string, ps ProductService, dts DiscountService, scs StoreCreditService, lys LoyaltyService, afs AffiliateService, cs CustomerService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.Order, error)
+	RefundToStoreCredit(dpi *DataPassIn, orderID string, cents int, source, reason string, scs StoreCreditService, lys LoyaltyService, afs AffiliateService, cs CustomerService, storeSettings *config.SettingsMutex) (*models.Order, *models.StoreCredit, error)
 	OrderPaymentFailure(dpi *DataPassIn, store, orderID string, mutexes *config.AllMutexes, tools *config.Tools)
 	OrderPaymentFix(dpi *DataPassIn, orderID string, newPaymentMethod, oldPaymentMethod string, saveMethod bool, useExisting bool) error
 
@@ -42,11 +50,12 @@ type OrderService interface {
 	ShipOrder(dpi *DataPassIn, store string, payload apidata.PackageShippedPF) error
 
 	GetCheckDateOrders(dpi *DataPassIn) ([]models.Order, error)
-	AdjustCheckOrders(dpi *DataPassIn, store string, sendEmail, delayCheck []string, tools *config.Tools) (error, error)
+	AdjustCheckOrders(dpi *DataPassIn, store string, sendEmail, delayCheck []string, rfs ReferralService, scs StoreCreditService, dts DiscountService, storeSettings *config.SettingsMutex, tools *config.Tools) (error, error)
 
 	MoveOrderToAccount(dpi *DataPassIn, orderID string) error
 
 	GetOrdersByEmail(dpi *DataPassIn, email string) (bool, error)
+	GetOrdersByIDs(dpi *DataPassIn, ids []string) ([]models.Order, error)
 	GetOrdersByEmailAndCustomer(dpi *DataPassIn, email string, custID int) (bool, error)
 
 	WatchOrderStatus(dpi *DataPassIn, orderID string, conn *websocket.Conn)
@@ -61,7 +70,7 @@ func NewOrderService(orderRepo repositories.OrderRepository) OrderService {
 }
 
 // Charging error, internal error
-func (s *orderService) SubmitPayment(dpi *DataPassIn, draftID, newPaymentMethod string, saveMethod bool, useExisting bool, ds DraftOrderService, dts DiscountService, cs CustomerService, ps ProductService, ors OrderService, tools *config.Tools, storeSettings *config.SettingsMutex) (error, error) {
+func (s *orderService) SubmitPayment(dpi *DataPassIn, draftID, newPaymentMethod string, saveMethod bool, useExisting bool, ds DraftOrderService, dts DiscountService, cs CustomerService, ps ProductService, ors OrderService, scs StoreCreditService, lys LoyaltyService, tools *config.Tools, storeSettings *config.SettingsMutex) (error, error) {
 	start := time.Now()
 
 	var draft *models.DraftOrder
@@ -113,6 +122,18 @@ func (s *orderService) SubmitPayment(dpi *DataPassIn, draftID, newPaymentMethod
 		return nil, err
 	}
 
+	if err := s.checkStoreCredit(dpi, draft, scs); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPoints(dpi, draft, lys); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkMarginPolicy(dpi, draft, storeSettings, tools); err != nil {
+		return nil, err
+	}
+
 	orderID, err := s.orderRepo.CreateBlankOrder()
 	if err != nil {
 		return nil, err
@@ -171,7 +192,7 @@ func (s *orderService) SubmitPayment(dpi *DataPassIn, draftID, newPaymentMethod
 
 	go func() {
 		defer wg.Done()
-		order := orderhelp.CreateOrderFromDraft(draft, dpi.SessionID, dpi.AffiliateCode, dpi.AffiliateID)
+		order := orderhelp.CreateOrderFromDraft(draft, dpi.SessionID, dpi.AffiliateCode, dpi.AffiliateID, dpi.AffiliateTouch, dpi.AffiliateExpires)
 		hexID, err := primitive.ObjectIDFromHex(orderID)
 		if err != nil {
 			orderErr = err
@@ -216,7 +237,7 @@ func (s *orderService) SubmitPayment(dpi *DataPassIn, draftID, newPaymentMethod
 }
 
 // Charging error, internal error
-func (s *orderService) SubmitOrder(dpi *DataPassIn, draftID, newPaymentMethod string, saveMethod bool, useExisting bool, ds DraftOrderService, dts DiscountService, cs CustomerService, ps ProductService, ors OrderService, tools *config.Tools, storeSettings *config.SettingsMutex) (error, error) {
+func (s *orderService) SubmitOrder(dpi *DataPassIn, draftID, newPaymentMethod string, saveMethod bool, useExisting bool, ds DraftOrderService, dts DiscountService, cs CustomerService, ps ProductService, ors OrderService, scs StoreCreditService, lys LoyaltyService, tools *config.Tools, storeSettings *config.SettingsMutex) (error, error) {
 
 	start := time.Now()
 
@@ -253,7 +274,19 @@ func (s *orderService) SubmitOrder(dpi *DataPassIn, draftID, newPaymentMethod st
 		return nil, err
 	}
 
-	order := orderhelp.CreateOrderFromDraft(draft, dpi.SessionID, dpi.AffiliateCode, dpi.AffiliateID)
+	if err := s.checkStoreCredit(dpi, draft, scs); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkPoints(dpi, draft, lys); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkMarginPolicy(dpi, draft, storeSettings, tools); err != nil {
+		return nil, err
+	}
+
+	order := orderhelp.CreateOrderFromDraft(draft, dpi.SessionID, dpi.AffiliateCode, dpi.AffiliateID, dpi.AffiliateTouch, dpi.AffiliateExpires)
 
 	if err := s.orderRepo.CreateOrder(order); err != nil {
 		return nil, err
@@ -300,7 +333,7 @@ func (s *orderService) SubmitOrder(dpi *DataPassIn, draftID, newPaymentMethod st
 
 }
 
-func (s *orderService) CompleteOrder(dpi *DataPassIn, orderID string, cs CustomerService, ds DraftOrderService, dts DiscountService, ls ListService, ps ProductService, ors OrderService, ss SessionService, mutexes *config.AllMutexes, tools *config.Tools) {
+func (s *orderService) CompleteOrder(dpi *DataPassIn, orderID string, cs CustomerService, ds DraftOrderService, dts DiscountService, ls ListService, ps ProductService, ors OrderService, ss SessionService, mutexes *config.AllMutexes, tools *config.Tools, prs ProfitService, scs StoreCreditService, lys LoyaltyService, rfs ReferralService, afs AffiliateService) {
 
 	store := dpi.Store
 
@@ -315,6 +348,8 @@ func (s *orderService) CompleteOrder(dpi *DataPassIn, orderID string, cs Custome
 	dpi.SessionID = order.SessionID
 	dpi.AffiliateID = order.AffiliateID
 	dpi.AffiliateCode = order.AffiliateCode
+	dpi.AffiliateTouch = order.AffiliateTouch
+	dpi.AffiliateExpires = order.AffiliateExpires
 
 	if timeOut, err := s.orderRepo.MarkOrderStatusUpdate(order, "Paid"); err != nil {
 		log.Printf("Unable to mark order paid from ID for order confirmation; store; %s; orderID: %s; err: %v\n", store, orderID, err)
@@ -362,6 +397,73 @@ func (s *orderService) CompleteOrder(dpi *DataPassIn, orderID string, cs Custome
 		}
 	}
 
+	if order.StoreCreditSum > 0 {
+		if err := scs.UseStoreCredit(dpi, order.CustomerID, order.StoreCreditSum, order.ID.Hex()); err != nil {
+			go emails.AlertRecoverableOrderSubmitError(dpi.Store, order.DraftOrderID, order.ID.Hex(), "Unable to take store credit after charging", tools, order, draft, nil, false, err)
+		}
+	}
+
+	if order.Points.Redeemed > 0 {
+		if err := lys.RedeemOrderPoints(dpi, order.CustomerID, order.Points.Redeemed, order.ID.Hex()); err != nil {
+			go emails.AlertRecoverableOrderSubmitError(dpi.Store, order.DraftOrderID, order.ID.Hex(), "Unable to take redeemed points after charging", tools, order, draft, nil, false, err)
+		}
+	}
+
+	if earned, err := lys.AwardOrderPoints(dpi, order, cs, &mutexes.Settings); err != nil {
+		log.Printf("Unable to award points for order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	} else {
+		order.PointsEarned = earned
+	}
+
+	if policy, ok := config.MarginPolicy(&mutexes.Settings, dpi.Store); ok && policy.Action == "approve" {
+		check, err := draftorderhelp.CheckMargin(draft, policy)
+		if err != nil || check.Shortfall > 0 {
+			order.AwaitingApproval = true
+			go emails.AlertMarginPolicy(dpi.Store, order.ID.Hex(), policy.Action, tools, check, err)
+		}
+	}
+
+	if window := config.OrderEditWindow(&mutexes.Settings, dpi.Store); window > 0 || order.AwaitingApproval {
+		now := time.Now()
+		order.Status = "Paid"
+		order.Held = true
+		order.HeldUntil = now.Add(window)
+		draft.Status = "Succeeded"
+		draft.DateSucceeded = now
+
+		if err := s.orderRepo.Update(order); err != nil {
+			go emails.AlertRecoverableOrderSubmitError(dpi.Store, order.DraftOrderID, order.ID.Hex(), "Unable to save held order after charging", tools, order, draft, nil, true, err)
+		}
+		if err := ds.Update(draft); err != nil {
+			go emails.AlertRecoverableOrderSubmitError(dpi.Store, order.DraftOrderID, order.ID.Hex(), "Unable to save draft order of held order after charging", tools, order, draft, nil, false, err)
+		}
+	} else {
+		s.sendOrderToPrintful(dpi, order, draft, ds, prs, dts, mutexes, tools)
+	}
+
+	if err := ls.UpdateLastOrdersList(dpi, order.DateCreated, order.ID.Hex(), vids, ps); err != nil {
+		log.Printf("Unable to update last orders list for order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+
+	ss.AddAffiliateSale(dpi, order)
+
+	if err := afs.RecordCommission(dpi, order); err != nil {
+		log.Printf("Unable to record affiliate commission for order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+
+	if err := rfs.RecordReferral(dpi, order, cs, &mutexes.Settings); err != nil {
+		log.Printf("Unable to record referral for order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+}
+
+// Bought gift cards only go live here, so a held order cancelled before release never has live cards
+func (s *orderService) sendOrderToPrintful(dpi *DataPassIn, order *models.Order, draft *models.DraftOrder, ds DraftOrderService, prs ProfitService, dts DiscountService, mutexes *config.AllMutexes, tools *config.Tools) {
+	order.Held = false
+
+	if err := dts.ActivatePurchasedGiftCards(dpi, order); err != nil {
+		log.Printf("Unable to activate bought gift cards for order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+
 	resp, err := orderhelp.PostOrderToPrintful(order, dpi.Store, mutexes, tools)
 	if err != nil {
 		go emails.AlertRecoverableOrderSubmitError(dpi.Store, order.DraftOrderID, order.ID.Hex(), "Unable to post order to printful after charging", tools, order, draft, resp, true, err)
@@ -379,11 +481,451 @@ func (s *orderService) CompleteOrder(dpi *DataPassIn, orderID string, cs Custome
 		go emails.AlertRecoverableOrderSubmitError(dpi.Store, order.DraftOrderID, order.ID.Hex(), "Unable to send email of success to creat the order", tools, order, draft, nil, false, err)
 	}
 
-	if err := ls.UpdateLastOrdersList(dpi, order.DateCreated, order.ID.Hex(), vids, ps); err != nil {
-		log.Printf("Unable to update last orders list for order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	if err := prs.RecordOrderProfit(dpi, order, resp, &draft.OrderEstimate, tools); err != nil {
+		log.Printf("Unable to record profit for order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+}
+
+// Posts held orders whose edit window has passed; meant to run on a schedule per store
+func (s *orderService) ReleaseHeldOrders(dpi *DataPassIn, ds DraftOrderService, prs ProfitService, dts DiscountService, mutexes *config.AllMutexes, tools *config.Tools) (int, error) {
+	orders, err := s.orderRepo.GetReleasableOrders()
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i := range orders {
+		order := &orders[i]
+
+		draft, err := ds.GetDraftPtl(order.DraftOrderID, order.GuestID, order.CustomerID)
+		if err != nil {
+			log.Printf("Unable to retrieve draft order for held order release; store; %s; orderID: %s; err: %v\n", dpi.Store, order.ID.Hex(), err)
+			continue
+		}
+
+		// Clearing the hold is the claim, an overlapping run, edit or cancel that got there first leaves it for them
+		if claimed, err := s.orderRepo.ClaimHeldOrder(order.ID.Hex()); err != nil {
+			log.Printf("Unable to clear hold for held order release; store; %s; orderID: %s; err: %v\n", dpi.Store, order.ID.Hex(), err)
+			continue
+		} else if !claimed {
+			continue
+		}
+
+		order, err = s.orderRepo.Read(order.ID.Hex())
+		if err != nil {
+			log.Printf("Unable to reread claimed held order for release; store; %s; orderID: %s; err: %v\n", dpi.Store, orders[i].ID.Hex(), err)
+			continue
+		}
+
+		s.sendOrderToPrintful(dpi, order, draft, ds, prs, dts, mutexes, tools)
+		released++
 	}
 
-	ss.AddAffiliateSale(dpi, order.ID.Hex())
+	return released, nil
+}
+
+// Shipping, tax and the cost estimate are redone for the changed order; totals can only go down
+func (s *orderService) repriceHeldOrder(dpi *DataPassIn, order *models.Order, draft *models.DraftOrder, mutexes *config.AllMutexes, tools *config.Tools) (int, error) {
+	promoIDs := []string{}
+	for _, p := range order.Promotions {
+		promoIDs = append(promoIDs, p.PromotionID)
+	}
+	orderhelp.RedoHeldDiscounts(draft, config.PromotionsByID(&mutexes.Settings, dpi.Store, promoIDs))
+	orderhelp.SetHeldTotals(draft)
+
+	draft.FreeShipRules = slices.DeleteFunc(draft.FreeShipRules, func(r models.FreeShipRule) bool {
+		return draft.Subtotal < r.MinSubtotal
+	})
+	draftorderhelp.SetInclusiveTax(draft, &mutexes.Settings, dpi.Store)
+
+	if err := draftorderhelp.UpdateShippingRates(draft, draft.ShippingContact, mutexes, dpi.Store, dpi.IPAddress, tools); err != nil {
+		return 0, err
+	}
+
+	if err := draftorderhelp.ModifyTaxRate(draft, tools, mutexes, dpi.Store); err != nil {
+		return 0, err
+	}
+
+	if err := draftorderhelp.RefreshOrderEstimate(draft, draft.ShippingContact, mutexes, dpi.Store, dpi.IPAddress, tools); err != nil {
+		return 0, err
+	}
+
+	if err := draftorderhelp.UpdateTaxFromRate(draft); err != nil {
+		return 0, err
+	}
+
+	orderhelp.SetHeldTotals(draft)
+
+	if err := draftorderhelp.CompareCostsOfDraftOrder(draft, dpi.Store, tools); err != nil {
+		return 0, err
+	}
+
+	if draft.Total > order.Total {
+		return 0, errors.New("this change would raise the order total, please contact us to make it")
+	}
+
+	return order.Total - draft.Total, nil
+}
+
+// Points earned and affiliate commission are taken back for however much the order's value dropped
+func (s *orderService) saveHeldOrderChange(dpi *DataPassIn, order *models.Order, draft *models.DraftOrder, kind, note string, refund int, lys LoyaltyService, afs AffiliateService, cs CustomerService, storeSettings *config.SettingsMutex, tools *config.Tools) error {
+	refundID := ""
+	if refund > 0 {
+		id, err := draftorderhelp.RefundPaymentIntent(order.StripePaymentIntentID, int64(refund))
+		if err != nil {
+			return err
+		}
+		refundID = id
+		order.PartialRefundIDs = append(order.PartialRefundIDs, id)
+		order.RefundedCents += refund
+	}
+
+	whole := order.PreGiftCardTotal
+	orderhelp.ApplyHeldDraft(order, draft)
+	order.Edits = append(order.Edits, models.OrderEdit{
+		Timestamp:   time.Now(),
+		Kind:        kind,
+		Note:        note,
+		RefundCents: refund,
+		RefundID:    refundID,
+	})
+
+	err := s.orderRepo.Update(order)
+	if err != nil || (refund > 0 && order.GiftCardSum > 0) {
+		go emails.AlertHeldOrderChange(dpi.Store, order.ID.Hex(), kind, tools, refund, order.GiftCardSum, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := lys.ReverseOrderPoints(dpi, order, whole-order.PreGiftCardTotal, whole, kind, cs, storeSettings); err != nil {
+		log.Printf("Unable to reverse points for held order change; order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+	if _, err := afs.ReverseCommission(dpi, order, whole-order.PreGiftCardTotal, whole, kind); err != nil {
+		log.Printf("Unable to reverse affiliate commission for held order change; order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+	return nil
+}
+
+// Locks the held order against release and other changes, then reads it fresh; call the returned func when done
+func (s *orderService) lockHeldOrder(dpi *DataPassIn, orderID string) (*models.Order, func(), error) {
+	if locked, err := s.orderRepo.LockHeldOrder(orderID); err != nil {
+		return nil, nil, err
+	} else if !locked {
+		return nil, nil, errors.New("order is being changed or can no longer be changed")
+	}
+
+	unlock := func() {
+		if err := s.orderRepo.UnlockHeldOrder(orderID); err != nil {
+			log.Printf("Unable to unlock held order; order: %s, in store: %s; error: %v\n", orderID, dpi.Store, err)
+		}
+	}
+
+	order, err := s.orderRepo.Read(orderID)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+
+	return order, unlock, nil
+}
+
+func (s *orderService) EditHeldOrderContact(dpi *DataPassIn, orderID string, contact *models.Contact, lys LoyaltyService, afs AffiliateService, cs CustomerService, mutexes *config.AllMutexes, tools *config.Tools) (*models.Order, error) {
+	order, unlock, err := s.lockHeldOrder(dpi, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := orderhelp.CheckHeldOrder(order, dpi.CustomerID, dpi.GuestID); err != nil {
+		return order, err
+	}
+
+	contact.CustomerID = order.CustomerID
+	if err := custhelp.VerifyContact(contact, mutexes); err != nil {
+		return order, err
+	}
+	if check := custhelp.CheckAddress(contact, mutexes, dpi.Store); !check.Valid {
+		return order, errors.New(strings.Join(check.Errors, "; "))
+	}
+
+	draft := orderhelp.DraftFromOrder(order)
+	draft.ShippingContact = contact
+
+	refund, err := s.repriceHeldOrder(dpi, order, draft, mutexes, tools)
+	if err != nil {
+		return order, err
+	}
+
+	return order, s.saveHeldOrderChange(dpi, order, draft, "Contact", contact.StreetAddress1+", "+contact.City, refund, lys, afs, cs, &mutexes.Settings, tools)
+}
+
+// Removing the last line has to go through CancelHeldOrder
+func (s *orderService) RemoveHeldOrderLine(dpi *DataPassIn, orderID string, lineIndex int, ps ProductService, lys LoyaltyService, afs AffiliateService, cs CustomerService, mutexes *config.AllMutexes, tools *config.Tools) (*models.Order, error) {
+	order, unlock, err := s.lockHeldOrder(dpi, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := orderhelp.CheckHeldOrder(order, dpi.CustomerID, dpi.GuestID); err != nil {
+		return order, err
+	}
+
+	if lineIndex < 0 || lineIndex >= len(order.Lines) {
+		return order, errors.New("no order line at index")
+	} else if len(order.Lines) == 1 {
+		return order, errors.New("cannot remove the only item, cancel the order instead")
+	}
+
+	removed := order.Lines[lineIndex]
+
+	draft := orderhelp.DraftFromOrder(order)
+	draft.Lines = slices.Delete(draft.Lines, lineIndex, lineIndex+1)
+
+	refund, err := s.repriceHeldOrder(dpi, order, draft, mutexes, tools)
+	if err != nil {
+		return order, err
+	}
+
+	if err := s.saveHeldOrderChange(dpi, order, draft, "Remove Line", fmt.Sprintf("%s x%d (variant %d)", removed.ProductTitle, removed.Quantity, removed.VariantID), refund, lys, afs, cs, &mutexes.Settings, tools); err != nil {
+		return order, err
+	}
+
+	if err := ps.SetInventoryFromOrder(dpi, map[int]int{removed.VariantID: -removed.Quantity}, []string{removed.Handle}, order.ID.Hex(), tools); err != nil {
+		log.Printf("Unable to restore inventory for removed held order line; order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+
+	return order, nil
+}
+
+func (s *orderService) CancelHeldOrder(dpi *DataPassIn, orderID, reason string, ps ProductService, dts DiscountService, scs StoreCreditService, lys LoyaltyService, afs AffiliateService, cs CustomerService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.Order, error) {
+	order, unlock, err := s.lockHeldOrder(dpi, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := orderhelp.CheckHeldOrder(order, dpi.CustomerID, dpi.GuestID); err != nil {
+		return order, err
+	}
+
+	return s.cancelHeldOrder(dpi, order, reason, "Cancel", ps, dts, scs, lys, afs, cs, storeSettings, tools)
+}
+
+// Admin decision on an order held by an "approve" margin policy
+func (s *orderService) ApproveHeldOrder(dpi *DataPassIn, orderID string, ds DraftOrderService, prs ProfitService, dts DiscountService, mutexes *config.AllMutexes, tools *config.Tools) (*models.Order, error) {
+	order, unlock, err := s.lockHeldOrder(dpi, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !order.AwaitingApproval {
+		unlock()
+		return order, errors.New("order is not awaiting approval")
+	}
+
+	order.AwaitingApproval = false
+	order.Edits = append(order.Edits, models.OrderEdit{
+		Timestamp: time.Now(),
+		Kind:      "Approve",
+	})
+
+	err = s.orderRepo.Update(order)
+	unlock()
+	if err != nil {
+		return order, err
+	}
+
+	// Still inside the customer edit window, so the release job posts it
+	if time.Now().Before(order.HeldUntil) {
+		return order, nil
+	}
+
+	draft, err := ds.GetDraftPtl(order.DraftOrderID, order.GuestID, order.CustomerID)
+	if err != nil {
+		return order, err
+	}
+
+	if claimed, err := s.orderRepo.ClaimHeldOrder(orderID); err != nil {
+		return order, err
+	} else if !claimed {
+		// The release job got to it first, which is what approving asked for
+		current, err := s.orderRepo.Read(orderID)
+		if err != nil {
+			return order, err
+		} else if !current.Held && current.Status != "Cancelled" {
+			return current, nil
+		}
+		return current, errors.New("order was changed or cancelled while approving")
+	}
+
+	order.Held = false
+	s.sendOrderToPrintful(dpi, order, draft, ds, prs, dts, mutexes, tools)
+	return order, nil
+}
+
+func (s *orderService) RejectHeldOrder(dpi *DataPassIn, orderID, reason string, ps ProductService, dts DiscountService, scs StoreCreditService, lys LoyaltyService, afs AffiliateService, cs CustomerService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.Order, error) {
+	order, unlock, err := s.lockHeldOrder(dpi, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if !order.AwaitingApproval {
+		return order, errors.New("order is not awaiting approval")
+	}
+
+	order.AwaitingApproval = false
+	return s.cancelHeldOrder(dpi, order, reason, "Reject", ps, dts, scs, lys, afs, cs, storeSettings, tools)
+}
+
+// Voids the gift cards bought on it, refunds the charged total less anything already spent off those cards or paid back
+// as store credit, gives back any store credit and points used, takes back points earned and restores inventory; kind is
+// recorded on the order edit
+func (s *orderService) cancelHeldOrder(dpi *DataPassIn, order *models.Order, reason, kind string, ps ProductService, dts DiscountService, scs StoreCreditService, lys LoyaltyService, afs AffiliateService, cs CustomerService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.Order, error) {
+	spent, err := dts.VoidOrderGiftCards(dpi, order, kind+": "+reason)
+	if err != nil {
+		return order, err
+	}
+
+	// Edits already lowered Total by what they refunded, store credit refunds didn't
+	credited := 0
+	for _, e := range order.Edits {
+		if e.Kind == "Store Credit" {
+			credited += e.RefundCents
+		}
+	}
+
+	refund := max(order.Total-spent-credited, 0)
+	refundID := ""
+	if refund > 0 {
+		// Zero refunds whatever is left on the payment
+		amount := int64(0)
+		if spent > 0 || credited > 0 {
+			amount = int64(refund)
+		}
+		id, err := draftorderhelp.RefundPaymentIntent(order.StripePaymentIntentID, amount)
+		if err != nil {
+			return order, err
+		}
+		refundID = id
+		order.StripeRefundID = &id
+		order.RefundedCents += refund
+	}
+
+	now := time.Now()
+	order.Status = "Cancelled"
+	order.Held = false
+	order.DateCancelled = now
+	order.CancellationMessage = reason
+	order.Edits = append(order.Edits, models.OrderEdit{
+		Timestamp:   now,
+		Kind:        kind,
+		Note:        reason,
+		RefundCents: refund,
+		RefundID:    refundID,
+	})
+
+	err = s.orderRepo.Update(order)
+	if err != nil || order.GiftCardSum > 0 {
+		go emails.AlertHeldOrderChange(dpi.Store, order.ID.Hex(), kind, tools, refund, order.GiftCardSum, err)
+	}
+	if err != nil {
+		return order, err
+	}
+
+	if order.StoreCreditSum > 0 {
+		if _, err := scs.RestoreOrderCredit(dpi, order.ID.Hex(), kind+": "+reason, storeSettings); err != nil {
+			log.Printf("Unable to restore store credit for cancelled held order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+		}
+	}
+
+	if order.Points.Redeemed > 0 {
+		if _, err := lys.RestoreOrderPoints(dpi, order.ID.Hex(), kind+": "+reason); err != nil {
+			log.Printf("Unable to restore points for cancelled held order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+		}
+	}
+	if _, err := lys.ReverseOrderPoints(dpi, order, order.PreGiftCardTotal, order.PreGiftCardTotal, kind+": "+reason, cs, storeSettings); err != nil {
+		log.Printf("Unable to reverse points for cancelled held order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+	if _, err := afs.ReverseCommission(dpi, order, order.PreGiftCardTotal, order.PreGiftCardTotal, kind+": "+reason); err != nil {
+		log.Printf("Unable to reverse affiliate commission for cancelled held order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+
+	dec := map[int]int{}
+	handles := []string{}
+	for _, l := range order.Lines {
+		dec[l.VariantID] -= l.Quantity
+		if !slices.Contains(handles, l.Handle) {
+			handles = append(handles, l.Handle)
+		}
+	}
+	if err := ps.SetInventoryFromOrder(dpi, dec, handles, order.ID.Hex(), tools); err != nil {
+		log.Printf("Unable to restore inventory for cancelled held order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+
+	return order, nil
+}
+
+// Statuses an order has once it's been charged, except Cancelled which has already been paid back
+var creditableStatuses = []string{"Paid", "Processed", "Partially Shipped", "Shipped", "Delivered"}
+
+// Refunds part or all of what the order was worth as store credit instead of to the card; source is Refund or Return
+// Held orders are refunded by editing or cancelling them instead
+func (s *orderService) RefundToStoreCredit(dpi *DataPassIn, orderID string, cents int, source, reason string, scs StoreCreditService, lys LoyaltyService, afs AffiliateService, cs CustomerService, storeSettings *config.SettingsMutex) (*models.Order, *models.StoreCredit, error) {
+	if cents <= 0 {
+		return nil, nil, errors.New("store credit refund must be more than zero")
+	}
+
+	if locked, err := s.orderRepo.LockReleasedOrder(orderID); err != nil {
+		return nil, nil, err
+	} else if !locked {
+		return nil, nil, errors.New("order is held, cancelled or being changed")
+	}
+	defer func() {
+		if err := s.orderRepo.UnlockHeldOrder(orderID); err != nil {
+			log.Printf("Unable to unlock order after store credit refund; order: %s, in store: %s; error: %v\n", orderID, dpi.Store, err)
+		}
+	}()
+
+	order, err := s.orderRepo.Read(orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if order.Guest || order.CustomerID <= 0 {
+		return order, nil, errors.New("store credit needs an order placed with an account")
+	} else if !slices.Contains(creditableStatuses, order.Status) {
+		return order, nil, errors.New("only paid orders that aren't cancelled can be refunded to store credit")
+	} else if source != "Refund" && source != "Return" {
+		return order, nil, errors.New("order store credit source must be Refund or Return")
+	} else if left := order.PreGiftCardTotal - order.RefundedCents; cents > left {
+		return order, nil, fmt.Errorf("only %d cents of the order are left to refund", left)
+	}
+
+	credit, err := scs.IssueStoreCredit(dpi, order.CustomerID, cents, source, order.ID.Hex(), reason, storeSettings)
+	if err != nil {
+		return order, nil, err
+	}
+
+	order.RefundedCents += cents
+	order.Edits = append(order.Edits, models.OrderEdit{
+		Timestamp:   time.Now(),
+		Kind:        "Store Credit",
+		Note:        source + ": " + reason,
+		RefundCents: cents,
+		RefundID:    "credit-" + strconv.Itoa(credit.ID),
+	})
+
+	if _, err := lys.ReverseOrderPoints(dpi, order, cents, order.PreGiftCardTotal, source+": "+reason, cs, storeSettings); err != nil {
+		log.Printf("Unable to reverse points for store credit refund; order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+	if _, err := afs.ReverseCommission(dpi, order, cents, order.PreGiftCardTotal, source+": "+reason); err != nil {
+		log.Printf("Unable to reverse affiliate commission for store credit refund; order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+	}
+
+	return order, credit, s.orderRepo.Update(order)
 }
 
 func (s *orderService) FailOrder(dpi *DataPassIn, store, orderID string) {
@@ -547,9 +1089,9 @@ func (s *orderService) UseDiscountsAndGiftCards(dpi *DataPassIn, order *models.O
 
 	}
 
-	if order.OrderDiscount.DiscountCode != "" {
+	if discCodes := models.DiscountCodes(order.AppliedDiscounts()); len(discCodes) != 0 {
 
-		discErr = ds.UseDiscountCode(dpi, order.OrderDiscount.DiscountCode, dpi.GuestID, order.ID.Hex(), dpi.SessionID, dpi.Store, order.Subtotal, dpi.CustomerID, order.Guest, order.Email, storeSettings, tools, cs, ors)
+		discErr = ds.UseDiscountCode(dpi, discCodes, dpi.GuestID, order.ID.Hex(), dpi.SessionID, dpi.Store, order.Subtotal, dpi.CustomerID, order.Guest, order.Email, storeSettings, tools, cs, ors)
 
 		if discErr != nil {
 			return nil, discErr, false
@@ -647,12 +1189,74 @@ func (s *orderService) GetOrdersList(dpi *DataPassIn, fromURL url.Values) (model
 	return ret, nil
 }
 
+// "block" and "adjust_ship" policies are enforced before charging; "approve" is checked once the order is paid
+func (s *orderService) checkMarginPolicy(dpi *DataPassIn, draft *models.DraftOrder, storeSettings *config.SettingsMutex, tools *config.Tools) error {
+	policy, ok := config.MarginPolicy(storeSettings, dpi.Store)
+	if !ok && draft.MarginShipAdjust == 0 {
+		return nil
+	}
+
+	// The customer confirmed the total they saw; PostRenderUpdate sets the new shipping and payment amount for them to confirm again
+	changed, adjErr := draftorderhelp.ApplyMarginShipAdjust(draft, policy)
+	if changed {
+		return errors.New("shipping for this order has changed, please review the new total")
+	}
+
+	if policy.Action != "block" && policy.Action != "adjust_ship" {
+		return adjErr
+	}
+
+	check, err := draftorderhelp.CheckMargin(draft, policy)
+	if err != nil {
+		return errors.New("unable to confirm the order cost, please refresh and try again")
+	} else if check.Shortfall > 0 {
+		go emails.AlertMarginPolicy(dpi.Store, draft.ID.Hex(), policy.Action, tools, check, nil)
+		return errors.New("this order can't be placed with the current discounts")
+	}
+
+	return adjErr
+}
+
+// The balance can change between building the draft and paying, e.g. another order or expiry
+func (s *orderService) checkStoreCredit(dpi *DataPassIn, draft *models.DraftOrder, scs StoreCreditService) error {
+	if draft.StoreCreditSum <= 0 {
+		return nil
+	} else if draft.Guest || dpi.CustomerID <= 0 {
+		return errors.New("store credit needs an account")
+	}
+
+	balance, err := scs.Balance(dpi, dpi.CustomerID)
+	if err != nil {
+		return err
+	} else if balance < draft.StoreCreditSum {
+		return errors.New("store credit balance has changed, please review your order")
+	}
+	return nil
+}
+
+// Same as store credit, the points balance can drop before paying
+func (s *orderService) checkPoints(dpi *DataPassIn, draft *models.DraftOrder, lys LoyaltyService) error {
+	if draft.Points.Redeemed <= 0 {
+		return nil
+	} else if draft.Guest || dpi.CustomerID <= 0 {
+		return errors.New("redeeming points needs an account")
+	}
+
+	balance, err := lys.Balance(dpi, dpi.CustomerID)
+	if err != nil {
+		return err
+	} else if balance < draft.Points.Redeemed {
+		return errors.New("points balance has changed, please review your order")
+	}
+	return nil
+}
+
 func (s *orderService) CheckInvDiscAndGiftCards(order *models.Order, draft *models.DraftOrder, dpi *DataPassIn, ps ProductService, ds DiscountService, cs CustomerService, storeSettings *config.SettingsMutex, tools *config.Tools, ors OrderService) error {
 	dvids := []int{}
 	vinv := map[int]int{}
 
 	var giftCards [3]*models.OrderGiftCard
-	var discCode string
+	var discCodes []string
 	var orderGuest bool
 	var subtotal int
 
@@ -664,7 +1268,7 @@ func (s *orderService) CheckInvDiscAndGiftCards(order *models.Order, draft *mode
 		}
 
 		giftCards = order.GiftCards
-		discCode = order.OrderDiscount.DiscountCode
+		discCodes = models.DiscountCodes(order.AppliedDiscounts())
 		orderGuest = order.Guest
 		subtotal = order.Subtotal
 
@@ -676,7 +1280,7 @@ func (s *orderService) CheckInvDiscAndGiftCards(order *models.Order, draft *mode
 		}
 
 		giftCards = draft.GiftCards
-		discCode = draft.OrderDiscount.DiscountCode
+		discCodes = models.DiscountCodes(draft.AppliedDiscounts())
 		orderGuest = draft.Guest
 		subtotal = draft.Subtotal
 
@@ -699,18 +1303,18 @@ func (s *orderService) CheckInvDiscAndGiftCards(order *models.Order, draft *mode
 		return fmt.Errorf("nonexistent or low inventory vars for draft order: %s, store: %s, list: %s", draft.ID.Hex(), dpi.Store, falseVarIDs)
 	}
 
-	if discCode == "" && len(giftCards) == 0 {
+	if len(discCodes) == 0 && len(giftCards) == 0 {
 		return nil
 	}
 
-	if discCode != "" && len(giftCards) != 0 {
+	if len(discCodes) != 0 && len(giftCards) != 0 {
 
 		gcsAndAmounts := map[[2]string]int{}
 		for _, gc := range giftCards {
 			gcsAndAmounts[[2]string{gc.Code, gc.Pin}] = gc.Charged
 		}
 
-		gcErr, draftErr := ds.CheckGiftCardsAndDiscountCodes(dpi, gcsAndAmounts, discCode, dpi.Store, subtotal, dpi.CustomerID, orderGuest, draft.Email, storeSettings, tools, cs, ors)
+		gcErr, draftErr := ds.CheckGiftCardsAndDiscountCodes(dpi, gcsAndAmounts, discCodes, dpi.Store, subtotal, dpi.CustomerID, orderGuest, draft.Email, storeSettings, tools, cs, ors)
 		if gcErr == nil && draftErr == nil {
 			return nil
 		} else if gcErr == nil {
@@ -735,9 +1339,9 @@ func (s *orderService) CheckInvDiscAndGiftCards(order *models.Order, draft *mode
 
 		return gcErr
 
-	} else if discCode != "" {
+	} else if len(discCodes) != 0 {
 
-		draftErr := ds.CheckDiscountCode(dpi, discCode, dpi.Store, subtotal, dpi.CustomerID, orderGuest, draft.Email, storeSettings, tools, cs, ors)
+		draftErr := ds.CheckDiscountCode(dpi, discCodes, dpi.Store, subtotal, dpi.CustomerID, orderGuest, draft.Email, storeSettings, tools, cs, ors)
 		if draftErr == nil {
 			return nil
 		}
@@ -862,7 +1466,7 @@ func (s *orderService) GetCheckDateOrders(dpi *DataPassIn) ([]models.Order, erro
 	return s.orderRepo.GetCheckOrders()
 }
 
-func (s *orderService) AdjustCheckOrders(dpi *DataPassIn, store string, sendEmail, delayCheck []string, tools *config.Tools) (error, error) {
+func (s *orderService) AdjustCheckOrders(dpi *DataPassIn, store string, sendEmail, delayCheck []string, rfs ReferralService, scs StoreCreditService, dts DiscountService, storeSettings *config.SettingsMutex, tools *config.Tools) (error, error) {
 	sendError, delayError := error(nil), error(nil)
 	if len(sendEmail) > 0 {
 		orders, err := s.orderRepo.GetOrdersByIDs(sendEmail)
@@ -875,6 +1479,15 @@ func (s *orderService) AdjustCheckOrders(dpi *DataPassIn, store string, sendEmai
 			sendError = s.orderRepo.UpdateCheckEmailSent(sendEmail)
 		}
 
+		// Marking them sent also marks them delivered, which is when referrers are rewarded
+		if sendError == nil {
+			for _, id := range sendEmail {
+				if err := rfs.RewardReferral(dpi, id, scs, dts, storeSettings); err != nil {
+					log.Printf("Unable to reward referral for order: %s, in store: %s; error: %v\n", id, store, err)
+				}
+			}
+		}
+
 	}
 
 	if len(delayCheck) > 0 {
@@ -922,6 +1535,12 @@ func (s *orderService) MoveOrderToAccount(dpi *DataPassIn, orderID string) error
 func (s *orderService) GetOrdersByEmail(dpi *DataPassIn, email string) (bool, error) {
 	return s.orderRepo.GetOrdersByEmail(email)
 }
+func (s *orderService) GetOrdersByIDs(dpi *DataPassIn, ids []string) ([]models.Order, error) {
+	if len(ids) == 0 {
+		return []models.Order{}, nil
+	}
+	return s.orderRepo.GetOrdersByIDs(ids)
+}
 func (s *orderService) GetOrdersByEmailAndCustomer(dpi *DataPassIn, email string, custID int) (bool, error) {
 	return s.orderRepo.GetOrdersByEmailAndCustomer(email, custID)
 }
@@ -0,0 +1,89 @@
+package orderhelp
+
+import (
+	"beam/data/models"
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Net is the lines after every discount, before shipping and tax; each line earns its product's rate if it has one
+func AffiliateCommission(order *models.Order, rate float64, rates map[int]float64) (int, int) {
+	net, cents := 0, 0
+	for _, l := range order.Lines {
+		lineNet := l.EndPrice*l.Quantity - l.PromoDiscount - l.OrderDiscShare
+		if lineNet <= 0 {
+			continue
+		}
+
+		lineRate := rate
+		if r, ok := rates[l.ProductID]; ok {
+			lineRate = r
+		}
+
+		net += lineNet
+		cents += int(math.Round(float64(lineNet) * lineRate))
+	}
+	return net, cents
+}
+
+// Every ledger line in the month, then the month's totals
+func AffiliateStatementCSV(aff *models.Affiliate, month string, lines []*models.AffiliateCommission) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"affiliate", "month", "date", "order_id", "kind", "net", "commission", "reason"}); err != nil {
+		return nil, err
+	}
+
+	net, cents := 0, 0
+	for _, l := range lines {
+		row := []string{aff.Code, month, l.Date.Format(time.RFC3339), l.OrderID, l.Kind, centsString(l.NetCents), centsString(l.Cents), l.Reason}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+		net += l.NetCents
+		cents += l.Cents
+	}
+
+	if err := w.Write([]string{aff.Code, month, "", "", "Total", centsString(net), centsString(cents), ""}); err != nil {
+		return nil, err
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// One row per affiliate with commission in the month; reversals of earlier months' orders can leave it negative
+func AffiliatePayoutCSV(month string, affs map[int]*models.Affiliate, counts []models.AffiliateMonthCount) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write([]string{"affiliate_id", "code", "name", "email", "month", "net", "commission"}); err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		aff, ok := affs[c.AffiliateID]
+		if !ok {
+			aff = &models.Affiliate{}
+		}
+
+		row := []string{strconv.Itoa(c.AffiliateID), aff.Code, aff.Name, aff.Email, month, centsString(c.Net), centsString(c.Cents)}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func centsString(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return sign + strconv.Itoa(cents/100) + "." + strconv.Itoa(cents%100/10) + strconv.Itoa(cents%10)
+}
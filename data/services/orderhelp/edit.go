@@ -0,0 +1,195 @@
+package orderhelp
+
+import (
+	"beam/data/models"
+	"beam/data/services/draftorderhelp"
+	"errors"
+	"math"
+	"slices"
+	"time"
+)
+
+// Whether the customer on the request can still change the order
+func CheckHeldOrder(order *models.Order, customerID int, guestID string) error {
+	if order == nil {
+		return errors.New("nil order")
+	}
+
+	if order.Guest || order.CustomerID == 0 {
+		if guestID == "" || order.GuestID != guestID {
+			return errors.New("order does not belong to guest")
+		}
+	} else if order.CustomerID != customerID {
+		return errors.New("order does not belong to customer")
+	}
+
+	if !order.Held || order.Status == "Cancelled" {
+		return errors.New("order can no longer be changed")
+	} else if time.Now().After(order.HeldUntil) {
+		return errors.New("the window to change this order has passed")
+	}
+
+	return nil
+}
+
+// Scratch draft so the draft order shipping, tax and estimate helpers can be reused on a paid order
+func DraftFromOrder(order *models.Order) *models.DraftOrder {
+	return &models.DraftOrder{
+		ID:                 order.ID,
+		Subtotal:           order.Subtotal,
+		OrderLevelDiscount: order.OrderLevelDiscount,
+		PostDiscountTotal:  order.PostDiscountTotal,
+		Shipping:           order.Shipping,
+		Tax:                order.Tax,
+		PostTaxTotal:       order.PostTaxTotal,
+		Tip:                order.Tip,
+		PreGiftCardTotal:   order.PreGiftCardTotal,
+		GiftCardSum:        order.GiftCardSum,
+		StoreCreditSum:     order.StoreCreditSum,
+		PostGiftCardTotal:  order.PostGiftCardTotal,
+		GiftCardBuyTotal:   order.GiftCardBuyTotal,
+		Total:              order.Total,
+		OrderDiscount:      order.OrderDiscount,
+		Discounts:          append([]models.OrderDiscount{}, order.AppliedDiscounts()...),
+		ShippingDiscount:   order.ShippingDiscount,
+		ShippingContact:    CopyContact(order.ShippingContact),
+		Lines:              append([]models.OrderLine{}, order.Lines...),
+		Tags:               append([]string{}, order.Tags...),
+		ActualRate:         order.ActualRate,
+		AllShippingRates:   map[string][]models.ShippingRate{},
+		AllOrderEstimates:  map[string]models.OrderEstimateCost{},
+		OrderEstimate:      models.OrderEstimateCost{ShipRate: order.ActualRate.FulfilmentMethod()},
+		CATax:              order.CATax,
+		CATaxRate:          order.CATaxRate,
+		TaxInclusive:       order.TaxInclusive,
+		InclusiveTaxRate:   order.InclusiveTaxRate,
+		FreeShipRules:      slices.Clone(order.FreeShipRules), // Repricing filters these in place
+		MarginShipAdjust:   order.MarginShipAdjust,
+		Promotions:         order.Promotions,
+	}
+}
+
+// Promotions and code shares are worked out again on the remaining lines, so a removed line can't leave its discount behind
+// Percentage codes take their rate of what's left of each line, fixed amounts and points keep at most what the line had
+func RedoHeldDiscounts(draft *models.DraftOrder, promos []models.Promotion) {
+	draft.Promotions = draftorderhelp.EvaluatePromotions(draft.Lines, promos)
+
+	subtotal := 0
+	for _, l := range draft.Lines {
+		subtotal += l.EndPrice*l.Quantity - l.PromoDiscount
+	}
+
+	codes, dropped := map[string]models.OrderDiscount{}, []string{}
+	for _, d := range draft.Discounts {
+		if d.HasMinSubtotal && subtotal < d.MinSubtotal {
+			dropped = append(dropped, d.DiscountCode)
+			continue
+		}
+		codes[d.DiscountCode] = d
+	}
+
+	for i, l := range draft.Lines {
+		lineLeft := l.EndPrice*l.Quantity - l.PromoDiscount
+		discounts := []models.LineDiscount{}
+		share := 0
+		for _, ld := range l.Discounts {
+			amount := ld.Amount
+			if slices.Contains(dropped, ld.DiscountCode) {
+				continue
+			} else if code, ok := codes[ld.DiscountCode]; ok {
+				if code.IsPercentageOff {
+					amount = int(math.Round(code.PercentageOff * float64(lineLeft)))
+				}
+			}
+			amount = min(amount, lineLeft)
+			if amount <= 0 {
+				continue
+			}
+
+			lineLeft -= amount
+			share += amount
+			discounts = append(discounts, models.LineDiscount{DiscountCode: ld.DiscountCode, Amount: amount})
+		}
+		draft.Lines[i].Discounts = discounts
+		draft.Lines[i].OrderDiscShare = share
+	}
+
+	if len(dropped) > 0 {
+		draft.Discounts = slices.DeleteFunc(draft.Discounts, func(d models.OrderDiscount) bool { return slices.Contains(dropped, d.DiscountCode) })
+		if slices.Contains(dropped, draft.OrderDiscount.DiscountCode) {
+			draft.OrderDiscount = models.OrderDiscount{}
+			if len(draft.Discounts) > 0 {
+				draft.OrderDiscount = draft.Discounts[0]
+			}
+		}
+	}
+}
+
+// Recomputes the draft totals without touching stripe; gift card and store credit amounts already charged stay charged
+func SetHeldTotals(draft *models.DraftOrder) {
+	subtotal := 0
+	for i, l := range draft.Lines {
+		draft.Lines[i].LineTotal = l.EndPrice*l.Quantity - l.PromoDiscount
+		subtotal += draft.Lines[i].LineTotal
+	}
+	draft.Subtotal = subtotal
+
+	// Lines keep their share of each discount, so a removed line takes only its own share with it
+	draft.OrderLevelDiscount = 0
+	codeAmounts := map[string]int{}
+	for _, l := range draft.Lines {
+		draft.OrderLevelDiscount += l.OrderDiscShare
+		for _, ld := range l.Discounts {
+			codeAmounts[ld.DiscountCode] += ld.Amount
+		}
+	}
+	if draft.OrderLevelDiscount == 0 {
+		draft.OrderLevelDiscount = draftorderhelp.OrderDiscountAmount(draft.OrderDiscount, subtotal)
+		draftorderhelp.ProrateOrderDiscount(draft.Lines, draft.OrderLevelDiscount)
+	} else if len(codeAmounts) > 0 {
+		for i := range draft.Discounts {
+			draft.Discounts[i].Amount = codeAmounts[draft.Discounts[i].DiscountCode]
+		}
+		if len(draft.Discounts) > 0 {
+			draft.OrderDiscount = draft.Discounts[0]
+		}
+	}
+
+	draft.PostDiscountTotal = draft.Subtotal - draft.OrderLevelDiscount
+	draft.PostTaxTotal = draft.PostDiscountTotal + draft.Shipping + draft.Tax
+	draft.PreGiftCardTotal = draft.PostTaxTotal + draft.Tip
+
+	draft.PostGiftCardTotal = draft.PreGiftCardTotal - draft.GiftCardSum - draft.StoreCreditSum
+	if draft.PostGiftCardTotal < 0 {
+		draft.PostGiftCardTotal = 0
+	}
+	draft.Total = draft.PostGiftCardTotal + draft.GiftCardBuyTotal
+}
+
+// Copies the recalculated draft back onto the order
+func ApplyHeldDraft(order *models.Order, draft *models.DraftOrder) {
+	order.Subtotal = draft.Subtotal
+	order.OrderLevelDiscount = draft.OrderLevelDiscount
+	order.PostDiscountTotal = draft.PostDiscountTotal
+	order.Shipping = draft.Shipping
+	order.Tax = draft.Tax
+	order.PostTaxTotal = draft.PostTaxTotal
+	order.PreGiftCardTotal = draft.PreGiftCardTotal
+	order.PostGiftCardTotal = draft.PostGiftCardTotal
+	order.Total = draft.Total
+	order.ShippingContact = draft.ShippingContact
+	order.Lines = draft.Lines
+	order.Tags = draft.Tags
+	order.ActualRate = draft.ActualRate
+	order.OrderDiscount = draft.OrderDiscount
+	order.Discounts = draft.Discounts
+	order.ShippingDiscount = draft.ShippingDiscount
+	order.Promotions = draft.Promotions
+	order.FreeShipRules = draft.FreeShipRules
+	order.CATax = draft.CATax
+	order.CATaxRate = draft.CATaxRate
+	order.TaxInclusive = draft.TaxInclusive
+	order.InclusiveTaxRate = draft.InclusiveTaxRate
+	order.MarginShipAdjust = draft.MarginShipAdjust
+	order.CheckDeliveryDate = draft.CheckDeliveryDate
+}
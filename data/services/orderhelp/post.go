@@ -8,9 +8,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,7 +32,7 @@ func CopyContact(c *models.Contact) *models.Contact {
 	return &copy
 }
 
-func CreateOrderFromDraft(draft *models.DraftOrder, sessionID, affiliateCode string, affiliateID int) *models.Order {
+func CreateOrderFromDraft(draft *models.DraftOrder, sessionID, affiliateCode string, affiliateID int, affiliateTouch, affiliateExpires time.Time) *models.Order {
 	ret := &models.Order{
 		PrintfulID:         draft.PrintfulID,
 		CustomerID:         draft.CustomerID,
@@ -48,11 +50,18 @@ func CreateOrderFromDraft(draft *models.DraftOrder, sessionID, affiliateCode str
 		Tip:                draft.Tip,
 		PreGiftCardTotal:   draft.PreGiftCardTotal,
 		GiftCardSum:        draft.GiftCardSum,
+		StoreCreditSum:     draft.StoreCreditSum,
+		Points:             draft.Points,
+		PostGiftCardTotal:  draft.PostGiftCardTotal,
+		GiftCardBuyTotal:   draft.GiftCardBuyTotal,
 		Total:              draft.Total,
 		OrderDiscount:      draft.OrderDiscount,
+		Discounts:          draft.Discounts,
+		ShippingDiscount:   draft.ShippingDiscount,
 		ShippingContact:    CopyContact(draft.ShippingContact),
 		Lines:              draft.Lines,
 		GiftCards:          draft.GiftCards,
+		GiftCardBuyLines:   draft.GiftCardBuyLines,
 		Tags:               draft.Tags,
 		Guest:              draft.Guest,
 		GuestID:            draft.GuestID,
@@ -60,12 +69,19 @@ func CreateOrderFromDraft(draft *models.DraftOrder, sessionID, affiliateCode str
 		CustStripeID:       draft.CustStripeID,
 		AffiliateCode:      affiliateCode,
 		AffiliateID:        affiliateID,
+		AffiliateTouch:     affiliateTouch,
+		AffiliateExpires:   affiliateExpires,
 		SessionID:          sessionID,
 		ActualRate:         draft.ActualRate,
 		GiftSubject:        draft.GiftSubject,
 		GiftMessage:        draft.GiftMessage,
 		CATax:              draft.CATax,
 		CATaxRate:          draft.CATaxRate,
+		TaxInclusive:       draft.TaxInclusive,
+		InclusiveTaxRate:   draft.InclusiveTaxRate,
+		FreeShipRules:      draft.FreeShipRules,
+		MarginShipAdjust:   draft.MarginShipAdjust,
+		Promotions:         draft.Promotions,
 		CheckDeliveryDate:  draft.CheckDeliveryDate,
 	}
 
@@ -79,7 +95,7 @@ func CreateOrderFromDraft(draft *models.DraftOrder, sessionID, affiliateCode str
 func CreatePrintfulOrder(order *models.Order, mutex *config.AllMutexes) (*apidata.Order, error) {
 	ret := &apidata.Order{
 		ExternalID: order.ID.Hex(),
-		Shipping:   order.ActualRate.ID,
+		Shipping:   order.ActualRate.FulfilmentMethod(),
 		Recipient: apidata.OrderRecipient{
 			Name:        order.ShippingContact.FirstName,
 			Address1:    order.ShippingContact.StreetAddress1,
@@ -244,7 +260,7 @@ func OrderEmailWithProfit(resp *apidata.OrderResponse, order *models.Order, tool
 
 	price := order.PreGiftCardTotal
 
-	if order.CATax {
+	if order.CATax || order.TaxInclusive {
 		price -= order.Tax
 	}
 
@@ -253,11 +269,11 @@ func OrderEmailWithProfit(resp *apidata.OrderResponse, order *models.Order, tool
 	return nil
 }
 
+// Printful sends dollars as strings, e.g. "12.50"
 func convertRateToCents(rate string) (int, error) {
-	var rateInt int
-	_, err := fmt.Sscanf(rate, "%f", &rateInt)
+	dollars, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
 	if err != nil {
 		return 0, fmt.Errorf("invalid rate format: %v", err)
 	}
-	return rateInt, nil
+	return int(math.Round(dollars * 100)), nil
 }
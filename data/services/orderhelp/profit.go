@@ -0,0 +1,169 @@
+package orderhelp
+
+import (
+	"beam/background/apidata"
+	"beam/data/models"
+	"beam/data/services/draftorderhelp"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Missing printful cost fields count as zero
+func optionalCents(rate string) int {
+	if rate == "" {
+		return 0
+	}
+	cents, err := convertRateToCents(rate)
+	if err != nil {
+		return 0
+	}
+	return cents
+}
+
+func estimateCents(dollars float64) int {
+	return int(math.Round(dollars * 100))
+}
+
+// Uses the printful response when it has costs, otherwise the draft's order estimate
+func BuildOrderProfit(resp *apidata.OrderResponse, order *models.Order, est *models.OrderEstimateCost) (*models.OrderProfit, []*models.OrderProfitLine, error) {
+	if order == nil {
+		return nil, nil, errors.New("nil order")
+	}
+
+	profit := &models.OrderProfit{
+		OrderID:         order.ID.Hex(),
+		PrintfulID:      order.PrintfulID,
+		Created:         time.Now(),
+		DiscountCode:    strings.Join(models.DiscountCodes(order.AppliedDiscounts()), "+"),
+		Subtotal:        order.Subtotal,
+		Discount:        order.OrderLevelDiscount,
+		ShippingCharged: order.Shipping,
+		TaxCollected:    order.Tax,
+		Tip:             order.Tip,
+		Revenue:         order.PreGiftCardTotal,
+		StripeFee:       draftorderhelp.StripeFee(order.Total),
+	}
+
+	if order.CATax || order.TaxInclusive {
+		profit.Revenue -= order.Tax
+	}
+
+	itemPrices := map[string]int{}
+
+	if resp != nil && resp.Result.Costs.Total != "" {
+		costs := resp.Result.Costs
+		profit.PFItems = optionalCents(costs.Subtotal)
+		profit.PFDiscount = optionalCents(costs.Discount)
+		profit.PFShipping = optionalCents(costs.Shipping)
+		profit.PFDigitization = optionalCents(costs.Digitization)
+		profit.PFAdditionalFee = optionalCents(costs.AdditionalFee)
+		profit.PFFulfillmentFee = optionalCents(costs.FulfillmentFee)
+		profit.PFRetailDeliveryFee = optionalCents(costs.RetailDeliveryFee)
+		profit.PFTax = optionalCents(costs.Tax)
+		profit.PFVat = optionalCents(costs.Vat)
+		profit.PFTotal = optionalCents(costs.Total)
+
+		for _, item := range resp.Result.Items {
+			itemPrices[strconv.Itoa(item.SyncVariantID)] = optionalCents(item.Price)
+		}
+	} else if est != nil && est.Total > 0 {
+		profit.IsEstimate = true
+		profit.PFItems = estimateCents(est.Subtotal)
+		profit.PFDiscount = estimateCents(est.Discount)
+		profit.PFShipping = estimateCents(est.Shipping)
+		profit.PFDigitization = estimateCents(est.Digitization)
+		profit.PFAdditionalFee = estimateCents(est.AdditionalFee)
+		profit.PFFulfillmentFee = estimateCents(est.FulfillmentFee)
+		profit.PFTax = estimateCents(est.Tax)
+		profit.PFVat = estimateCents(est.Vat)
+		profit.PFTotal = estimateCents(est.Total)
+	} else {
+		return nil, nil, errors.New("no printful costs or order estimate to build profit from")
+	}
+
+	profit.TotalCost = profit.PFTotal + profit.StripeFee
+	profit.Margin = profit.Revenue - profit.TotalCost
+
+	return profit, buildProfitLines(profit, order, itemPrices), nil
+}
+
+// Line revenue is the discounted line total plus its share of shipping, tip and kept tax
+// Item cost comes from printful item prices when known, otherwise PFItems split by revenue
+func buildProfitLines(profit *models.OrderProfit, order *models.Order, itemPrices map[string]int) []*models.OrderProfitLine {
+	lines := []*models.OrderProfitLine{}
+	if len(order.Lines) == 0 {
+		return lines
+	}
+
+	prorated := 0
+	for _, l := range order.Lines {
+		prorated += l.OrderDiscShare
+	}
+
+	discounted := make([]int, len(order.Lines))
+	discountedSum := 0
+	for i, l := range order.Lines {
+		lineTotal := l.EndPrice*l.Quantity - l.PromoDiscount
+		if prorated == order.OrderLevelDiscount {
+			discounted[i] = lineTotal - l.OrderDiscShare
+		} else if order.Subtotal > 0 {
+			discounted[i] = int(math.Round(float64(lineTotal) * float64(order.Subtotal-order.OrderLevelDiscount) / float64(order.Subtotal)))
+		}
+		discountedSum += discounted[i]
+	}
+
+	itemCosts := make([]int, len(order.Lines))
+	knownItems := len(itemPrices) > 0
+	itemCostSum := 0
+	for i, l := range order.Lines {
+		if knownItems {
+			for _, pf := range l.PrintfulID {
+				itemCosts[i] += itemPrices[pf.VariantID] * pf.Quantity * l.Quantity
+			}
+		} else {
+			itemCosts[i] = share(profit.PFItems, discounted[i], discountedSum)
+		}
+		itemCostSum += itemCosts[i]
+	}
+
+	extraRevenue := profit.Revenue - discountedSum
+	sharedCost := profit.TotalCost - itemCostSum
+
+	revenueLeft, sharedLeft := extraRevenue, sharedCost
+	for i, l := range order.Lines {
+		lineExtra := share(extraRevenue, discounted[i], discountedSum)
+		lineShared := share(sharedCost, discounted[i], discountedSum)
+		if i == len(order.Lines)-1 {
+			lineExtra, lineShared = revenueLeft, sharedLeft
+		}
+		revenueLeft -= lineExtra
+		sharedLeft -= lineShared
+
+		pl := &models.OrderProfitLine{
+			OrderID:      profit.OrderID,
+			Created:      profit.Created,
+			ProductID:    l.ProductID,
+			VariantID:    l.VariantID,
+			Handle:       l.Handle,
+			DiscountCode: profit.DiscountCode,
+			Quantity:     l.Quantity,
+			Revenue:      discounted[i] + lineExtra,
+			ItemCost:     itemCosts[i],
+			SharedCost:   lineShared,
+		}
+		pl.Margin = pl.Revenue - pl.ItemCost - pl.SharedCost
+		lines = append(lines, pl)
+	}
+
+	return lines
+}
+
+func share(amount, part, whole int) int {
+	if whole <= 0 {
+		return 0
+	}
+	return int(math.Round(float64(amount) * float64(part) / float64(whole)))
+}
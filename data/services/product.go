@@ -26,9 +26,9 @@ type ProductService interface {
 	GetFullProduct(dpi *DataPassIn, store, handle string) (models.ProductRedis, string, error)
 
 	GetAllProductInfo(dpi *DataPassIn, fromURL url.Values, Mutex *config.AllMutexes, name string) (models.CollectionRender, error)
-	GetProductAndProductRender(dpi *DataPassIn, name, handle string, varid int) (models.ProductRedis, models.ProductRender, string, error)
+	GetProductAndProductRender(dpi *DataPassIn, name, handle string, varid int, storeSettings *config.SettingsMutex) (models.ProductRedis, models.ProductRender, string, error)
 
-	GetProductRender(dpi *DataPassIn, name, handle string, varid int) (models.ProductRender, string, error)
+	GetProductRender(dpi *DataPassIn, name, handle string, varid int, storeSettings *config.SettingsMutex) (models.ProductRender, string, error)
 	GetLimitedVariants(dpi *DataPassIn, name string, vids []int) ([]*models.LimitedVariantRedis, error)
 	GetProductByVariantID(dpi *DataPassIn, name string, vid int) (models.ProductRedis, string, error)
 	GetProductsByVariantIDs(dpi *DataPassIn, name string, vids []int) (map[int]*models.ProductRedis, error)
@@ -136,7 +136,7 @@ func (s *productService) GetAllProductInfo(dpi *DataPassIn, fromURL url.Values,
 
 }
 
-func (s *productService) GetProductAndProductRender(dpi *DataPassIn, name, handle string, varid int) (models.ProductRedis, models.ProductRender, string, error) {
+func (s *productService) GetProductAndProductRender(dpi *DataPassIn, name, handle string, varid int, storeSettings *config.SettingsMutex) (models.ProductRedis, models.ProductRender, string, error) {
 
 	rprod, redir, err := s.productRepo.GetFullProduct(name, handle)
 	if err != nil {
@@ -152,14 +152,17 @@ func (s *productService) GetProductAndProductRender(dpi *DataPassIn, name, handl
 		}
 	}
 
+	tiers := product.VolumeTiers(&rprod, config.TagVolumeTiers(storeSettings, name))
+
 	if len(rprod.Variants) == 1 && rprod.Var1Key == "&" {
 		return rprod, models.ProductRender{
-			FullName:  rprod.Title,
-			VariantID: rprod.Variants[0].PK,
-			Inventory: rprod.Variants[0].Quantity,
-			Price:     rprod.Variants[0].Price,
-			CompareAt: rprod.Variants[0].CompareAtPrice,
-			VarImage:  rprod.Variants[0].VariantImageURL,
+			FullName:    rprod.Title,
+			VariantID:   rprod.Variants[0].PK,
+			Inventory:   rprod.Variants[0].Quantity,
+			Price:       rprod.Variants[0].Price,
+			CompareAt:   rprod.Variants[0].CompareAtPrice,
+			VarImage:    rprod.Variants[0].VariantImageURL,
+			VolumeTiers: product.VolumeTierRenders(rprod.Variants[0].Price, tiers),
 		}, "", nil
 	}
 
@@ -184,13 +187,14 @@ func (s *productService) GetProductAndProductRender(dpi *DataPassIn, name, handl
 		VarImage:    rprod.Variants[0].VariantImageURL,
 		HasVariants: true,
 		Blocks:      product.VariantSelectorRenders(rprod, actualID),
+		VolumeTiers: product.VolumeTierRenders(rprod.Variants[0].Price, tiers),
 	}
 
 	return rprod, ret, "", nil
 }
 
-func (s *productService) GetProductRender(dpi *DataPassIn, name, handle string, varid int) (models.ProductRender, string, error) {
-	_, rend, redir, err := s.GetProductAndProductRender(dpi, name, handle, varid)
+func (s *productService) GetProductRender(dpi *DataPassIn, name, handle string, varid int, storeSettings *config.SettingsMutex) (models.ProductRender, string, error) {
+	_, rend, redir, err := s.GetProductAndProductRender(dpi, name, handle, varid, storeSettings)
 	return rend, redir, err
 }
 
@@ -1,17 +1,66 @@
 package product
 
-import "math"
+import (
+	"beam/data/models"
+	"math"
+	"slices"
+	"sort"
+)
 
-func VolumeDiscPrice(price, quantity int, allowed bool) int {
-	if !allowed || quantity < 5 {
+// Used for products with VolumeDisc on and no tiers of their own or from their tags
+var DefaultVolumeTiers = []models.VolumeTier{
+	{MinQuantity: 5, PctOff: 0.05},
+	{MinQuantity: 10, PctOff: 0.1},
+	{MinQuantity: 20, PctOff: 0.15},
+	{MinQuantity: 50, PctOff: 0.2},
+}
+
+// The product's own tiers, then the first of its tags with tiers, then the default tiers if VolumeDisc is on
+// Returned sorted by MinQuantity
+func VolumeTiers(prod *models.ProductRedis, tagTiers map[string][]models.VolumeTier) []models.VolumeTier {
+	tiers := prod.VolumeTiers
+	if len(tiers) == 0 {
+		for _, tag := range prod.Tags {
+			if t, ok := tagTiers[tag]; ok && len(t) > 0 {
+				tiers = t
+				break
+			}
+		}
+	}
+	if len(tiers) == 0 && prod.VolumeDisc {
+		tiers = DefaultVolumeTiers
+	}
+
+	tiers = slices.Clone(tiers)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinQuantity < tiers[j].MinQuantity })
+	return tiers
+}
+
+// Per unit price at the highest tier the quantity reaches; tiers must be sorted by MinQuantity
+func VolumeDiscPrice(price, quantity int, tiers []models.VolumeTier) int {
+	pct := 0.0
+	for _, t := range tiers {
+		if quantity >= t.MinQuantity && t.PctOff > 0 && t.PctOff < 1 {
+			pct = t.PctOff
+		}
+	}
+	if pct == 0 {
 		return price
-	} else if quantity < 10 {
-		return int(math.Round(float64(price) * 0.95))
-	} else if quantity < 20 {
-		return int(math.Round(float64(price) * 0.9))
-	} else if quantity < 50 {
-		return int(math.Round(float64(price) * 0.85))
-	} else {
-		return int(math.Round(float64(price) * 0.8))
 	}
+	return int(math.Round(float64(price) * (1 - pct)))
+}
+
+func VolumeTierRenders(price int, tiers []models.VolumeTier) []models.VolumeTierRender {
+	ret := []models.VolumeTierRender{}
+	for _, t := range tiers {
+		if t.MinQuantity <= 1 || t.PctOff <= 0 || t.PctOff >= 1 {
+			continue
+		}
+		ret = append(ret, models.VolumeTierRender{
+			MinQuantity: t.MinQuantity,
+			PctOff:      t.PctOff,
+			Price:       VolumeDiscPrice(price, t.MinQuantity, tiers),
+		})
+	}
+	return ret
 }
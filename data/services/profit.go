@@ -0,0 +1,95 @@
+package services
+
+import (
+	"beam/background/apidata"
+	"beam/background/emails"
+	"beam/config"
+	"beam/data/models"
+	"beam/data/repositories"
+	"beam/data/services/orderhelp"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+type ProfitService interface {
+	RecordOrderProfit(dpi *DataPassIn, order *models.Order, resp *apidata.OrderResponse, est *models.OrderEstimateCost, tools *config.Tools) error
+	GetOrderProfit(dpi *DataPassIn, orderID string) (*models.OrderProfit, []*models.OrderProfitLine, error)
+	MarginReport(dpi *DataPassIn, groupBy string, start, end time.Time) ([]models.MarginReport, error)
+}
+
+type profitService struct {
+	profitRepo repositories.ProfitRepository
+}
+
+func NewProfitService(profitRepo repositories.ProfitRepository) ProfitService {
+	return &profitService{profitRepo: profitRepo}
+}
+
+func (s *profitService) RecordOrderProfit(dpi *DataPassIn, order *models.Order, resp *apidata.OrderResponse, est *models.OrderEstimateCost, tools *config.Tools) error {
+	profit, lines, err := orderhelp.BuildOrderProfit(resp, order, est)
+	if err != nil {
+		return err
+	}
+
+	if err := s.profitRepo.SaveOrderProfit(profit, lines); err != nil {
+		return err
+	}
+
+	vids := []int{}
+	for _, l := range lines {
+		vids = append(vids, l.VariantID)
+	}
+	if len(vids) == 0 {
+		return nil
+	}
+
+	margins, err := s.profitRepo.VariantMargins(vids, time.Now().Add(-config.MARGIN_WINDOW))
+	if err != nil {
+		log.Printf("Unable to check rolling variant margins for order: %s, in store: %s; error: %v\n", order.ID.Hex(), dpi.Store, err)
+		return nil
+	}
+
+	for vid, m := range margins {
+		if m.Margin < 0 && claimMarginAlert(dpi.Store, vid, tools) {
+			go emails.AlertNegativeVariantMargin(dpi.Store, vid, tools, m)
+		}
+	}
+
+	return nil
+}
+
+// One alert per variant per day
+func claimMarginAlert(store string, variantID int, tools *config.Tools) bool {
+	ok, err := tools.Redis.SetNX(context.Background(), "MRG::"+store+"::"+strconv.Itoa(variantID), "1", 24*time.Hour).Result()
+	if err != nil {
+		log.Printf("Unable to claim margin alert for variant: %d, in store: %s; error: %v\n", variantID, store, err)
+		return false
+	}
+	return ok
+}
+
+func (s *profitService) GetOrderProfit(dpi *DataPassIn, orderID string) (*models.OrderProfit, []*models.OrderProfitLine, error) {
+	return s.profitRepo.GetOrderProfit(orderID)
+}
+
+func (s *profitService) MarginReport(dpi *DataPassIn, groupBy string, start, end time.Time) ([]models.MarginReport, error) {
+	if !end.After(start) {
+		return nil, errors.New("report end must be after start")
+	}
+
+	rows, err := s.profitRepo.MarginReport(groupBy, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if groupBy == "store" {
+		for i := range rows {
+			rows[i].Key = dpi.Store
+		}
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"beam/config"
+	"beam/data/models"
+	"beam/data/repositories"
+	"beam/data/services/discount"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type ReferralService interface {
+	GetReferralCode(dpi *DataPassIn, cs CustomerService, mutexes *config.AllMutexes) (models.ReferralRender, error)
+	RecordReferral(dpi *DataPassIn, order *models.Order, cs CustomerService, storeSettings *config.SettingsMutex) error
+	RewardReferral(dpi *DataPassIn, orderID string, scs StoreCreditService, ds DiscountService, storeSettings *config.SettingsMutex) error
+	GetReferrals(dpi *DataPassIn, referrerID int) ([]*models.Referral, error)
+}
+
+type referralService struct {
+	referralRepo repositories.ReferralRepository
+}
+
+func NewReferralService(referralRepo repositories.ReferralRepository) ReferralService {
+	return &referralService{referralRepo: referralRepo}
+}
+
+// The logged in customer's code and link, made the first time they ask; the link goes through the affiliate tracking
+func (s *referralService) GetReferralCode(dpi *DataPassIn, cs CustomerService, mutexes *config.AllMutexes) (models.ReferralRender, error) {
+	ret := models.ReferralRender{}
+
+	program, ok := config.ReferralProgram(&mutexes.Settings, dpi.Store)
+	if !ok {
+		return ret, errors.New("store has no referral program")
+	} else if dpi.CustomerID <= 0 {
+		return ret, errors.New("referrals need an account")
+	}
+
+	aff, err := s.referralRepo.GetReferralAffiliate(dpi.CustomerID)
+	if err != nil {
+		return ret, err
+	}
+
+	if aff == nil {
+		cust, err := cs.GetCustomerByID(dpi, dpi.CustomerID)
+		if err != nil {
+			return ret, err
+		} else if cust == nil || cust.Status != "Active" {
+			return ret, errors.New("referrals need an active account")
+		}
+
+		aff = &models.Affiliate{
+			CustomerID: cust.ID,
+			Name:       cust.FirstName + " " + cust.LastName,
+			Email:      cust.Email,
+			CreatedAt:  time.Now(),
+			Valid:      true,
+		}
+		for i := 0; i < 5; i++ {
+			if aff.Code, err = discount.GenerateBatchCode(config.REFERRAL_PREFIX); err != nil {
+				return ret, err
+			}
+			if err = s.referralRepo.CreateReferralAffiliate(aff); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	refs, err := s.referralRepo.GetReferrals(dpi.CustomerID)
+	if err != nil {
+		return ret, err
+	}
+
+	mutexes.Store.Mu.RLock()
+	domain := mutexes.Store.Store.ToDomain[dpi.Store]
+	mutexes.Store.Mu.RUnlock()
+
+	ret.Code = aff.Code
+	ret.Link = "https://" + domain + "/?affiliate=" + aff.Code
+	ret.FriendPct = program.FriendPct
+	ret.Referrals = refs
+	for _, r := range refs {
+		if r.Status == "Pending" || r.Status == "Rewarding" {
+			ret.Pending++
+		} else if r.Status == "Rewarded" {
+			ret.Rewarded++
+			ret.Earned += r.RewardCents
+		}
+	}
+	return ret, nil
+}
+
+// Records the order if it used a referral code; ones that look like self referral are kept as Rejected for review
+func (s *referralService) RecordReferral(dpi *DataPassIn, order *models.Order, cs CustomerService, storeSettings *config.SettingsMutex) error {
+	if _, ok := config.ReferralProgram(storeSettings, dpi.Store); !ok {
+		return nil
+	}
+
+	code := ""
+	for _, d := range order.AppliedDiscounts() {
+		if discount.IsReferralCode(d.DiscountCode) {
+			code = d.DiscountCode
+			break
+		}
+	}
+	if code == "" {
+		return nil
+	}
+
+	aff, err := s.referralRepo.GetAffiliateByCode(code)
+	if err != nil {
+		return err
+	}
+
+	ip, err := s.referralRepo.GetSessionIP(order.SessionID)
+	if err != nil {
+		return err
+	}
+
+	ref := &models.Referral{
+		AffiliateID: aff.ID,
+		ReferrerID:  aff.CustomerID,
+		Code:        code,
+		OrderID:     order.ID.Hex(),
+		FriendID:    order.CustomerID,
+		FriendEmail: discount.ReferralEmail(order.Email),
+		GuestID:     order.GuestID,
+		IPAddress:   ip,
+		Created:     time.Now(),
+		Status:      "Pending",
+	}
+
+	if ref.FriendID > 0 && ref.FriendID == ref.ReferrerID {
+		ref.RejectReason = "self"
+	} else if ref.FriendEmail == discount.ReferralEmail(aff.Email) {
+		ref.RejectReason = "email"
+	} else if reason, err := s.referralRepo.FraudCheck(ref); err != nil {
+		return err
+	} else {
+		ref.RejectReason = reason
+	}
+
+	// The device may have been used logged in as the referrer without a session saying so
+	if ref.RejectReason == "" && ref.GuestID != "" {
+		if owner, err := cs.GetDeviceCustomer(dpi, ref.GuestID); err == nil && owner == ref.ReferrerID {
+			ref.RejectReason = "device"
+		}
+	}
+
+	if ref.RejectReason != "" {
+		ref.Status = "Rejected"
+	}
+
+	_, err = s.referralRepo.CreateReferral(ref)
+	return err
+}
+
+// Meant to run when an order is marked delivered; pays the referrer once, within the program's monthly cap
+func (s *referralService) RewardReferral(dpi *DataPassIn, orderID string, scs StoreCreditService, ds DiscountService, storeSettings *config.SettingsMutex) error {
+	program, ok := config.ReferralProgram(storeSettings, dpi.Store)
+	if !ok {
+		return nil
+	}
+
+	ref, err := s.referralRepo.GetReferralByOrder(orderID)
+	if err != nil || ref == nil || ref.Status != "Pending" {
+		return err
+	}
+
+	if program.MaxPerMonth > 0 {
+		count, err := s.referralRepo.CountRewarded(ref.ReferrerID, time.Now().AddDate(0, -1, 0))
+		if err != nil {
+			return err
+		} else if count >= int64(program.MaxPerMonth) {
+			ref.Status, ref.RejectReason = "Rejected", "limit"
+			return s.referralRepo.SaveReferral(ref)
+		}
+	}
+
+	if claimed, err := s.referralRepo.ClaimReferral(ref.ID); err != nil || !claimed {
+		return err
+	}
+
+	ref.RewardKind = program.RewardKind
+	ref.RewardCents = program.RewardCents
+	note := "Referral of order " + orderID
+
+	if program.RewardKind == "credit" {
+		credit, err := scs.IssueStoreCredit(dpi, ref.ReferrerID, program.RewardCents, "Referral", orderID, note, storeSettings)
+		if err != nil {
+			ref.Status = "Pending"
+			s.referralRepo.SaveReferral(ref)
+			return err
+		}
+		ref.RewardCreditID = credit.ID
+	} else {
+		code, err := discount.GenerateBatchCode(config.REFERRAL_REWARD_PREFIX)
+		if err == nil {
+			err = ds.AddDiscount(dpi, models.Discount{
+				DiscountCode:     code,
+				Status:           "Active",
+				Created:          time.Now(),
+				Expired:          time.Now().AddDate(0, 0, program.CodeDays),
+				IsDollarsOff:     true,
+				DollarsOff:       program.RewardCents,
+				HasMaxUses:       true,
+				MaxUses:          1,
+				SingleCustomerID: ref.ReferrerID,
+				ShortMessage:     "Thanks for the referral",
+			})
+		}
+		if err != nil {
+			ref.Status = "Pending"
+			s.referralRepo.SaveReferral(ref)
+			return fmt.Errorf("unable to make referral reward code: %w", err)
+		}
+		ref.RewardCode = code
+	}
+
+	ref.Status = "Rewarded"
+	ref.Rewarded = time.Now()
+	return s.referralRepo.SaveReferral(ref)
+}
+
+func (s *referralService) GetReferrals(dpi *DataPassIn, referrerID int) ([]*models.Referral, error) {
+	return s.referralRepo.GetReferrals(referrerID)
+}
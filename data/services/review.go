@@ -15,7 +15,7 @@ import (
 )
 
 type ReviewService interface {
-	AddReview(dpi *DataPassIn, productID int, store string, stars int, justStar, useDefaultName, public bool, displayName, subject, body string, imgs []models.IntermImage, ps ProductService, cs CustomerService, tools *config.Tools) (*models.Review, error)
+	AddReview(dpi *DataPassIn, productID int, store string, stars int, justStar, useDefaultName, public bool, displayName, subject, body string, imgs []models.IntermImage, ps ProductService, cs CustomerService, lys LoyaltyService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.Review, error)
 	UpdateReview(dpi *DataPassIn, productID int, store string, stars int, justStar, useDefaultName, public bool, displayName, subject, body string, ps ProductService, cs CustomerService, tools *config.Tools) (*models.Review, error)
 	DeleteReview(dpi *DataPassIn, productID int, store string, ps ProductService, tools *config.Tools) (*models.Review, error)
 
@@ -45,7 +45,7 @@ func NewReviewService(reviewRepo repositories.ReviewRepository) ReviewService {
 	return &reviewService{reviewRepo: reviewRepo}
 }
 
-func (s *reviewService) AddReview(dpi *DataPassIn, productID int, store string, stars int, justStar, useDefaultName, public bool, displayName, subject, body string, imgs []models.IntermImage, ps ProductService, cs CustomerService, tools *config.Tools) (*models.Review, error) {
+func (s *reviewService) AddReview(dpi *DataPassIn, productID int, store string, stars int, justStar, useDefaultName, public bool, displayName, subject, body string, imgs []models.IntermImage, ps ProductService, cs CustomerService, lys LoyaltyService, storeSettings *config.SettingsMutex, tools *config.Tools) (*models.Review, error) {
 	if len(subject) > 280 {
 		subject = subject[:277] + "..."
 	}
@@ -122,6 +122,10 @@ func (s *reviewService) AddReview(dpi *DataPassIn, productID int, store string,
 
 	go ps.UpdateRatings(dpi, productID, stars, 0, 1, tools)
 
+	if err := lys.AwardReviewPoints(dpi, dpi.CustomerID, productID, cs, storeSettings); err != nil {
+		log.Printf("Unable to award review points for customer: %d, product: %d, in store: %s; error: %v\n", dpi.CustomerID, productID, dpi.Store, err)
+	}
+
 	dpi.AddLog("Review", "AddReview", "", "", nil, models.EventPassInFinal{ProductID: productID, ReviewID: review.PK})
 	return review, nil
 }
@@ -21,9 +21,9 @@ type SessionService interface {
 	AddSessionLine(dpi *DataPassIn, c *gin.Context)
 
 	SessionMiddleware(cookie *models.SessionCookie, customerID int, guestID, store string, c *gin.Context, tools *config.Tools)
-	AffiliateMiddleware(cookie *models.AffiliateSession, sessionID, store string, c *gin.Context)
+	AffiliateMiddleware(cookie *models.AffiliateSession, sessionID, store string, storeSettings *config.SettingsMutex, c *gin.Context)
 
-	AddAffiliateSale(dpi *DataPassIn, orderID string)
+	AddAffiliateSale(dpi *DataPassIn, order *models.Order)
 }
 
 type sessionService struct {
@@ -101,9 +101,11 @@ func (s *sessionService) SessionMiddleware(cookie *models.SessionCookie, custome
 	}
 }
 
-func (s *sessionService) AffiliateMiddleware(cookie *models.AffiliateSession, sessionID, store string, c *gin.Context) {
+// Every new code clicked is recorded; with first touch a cookie still in its window keeps its affiliate,
+// with last touch the latest click takes over and a repeat click of the same code restarts the window
+func (s *sessionService) AffiliateMiddleware(cookie *models.AffiliateSession, sessionID, store string, storeSettings *config.SettingsMutex, c *gin.Context) {
 	if cookie == nil {
-		cookie = &models.AffiliateSession{}
+		return
 	}
 
 	affiliateCode := c.Query("affiliate")
@@ -111,38 +113,58 @@ func (s *sessionService) AffiliateMiddleware(cookie *models.AffiliateSession, se
 		return
 	}
 
-	if cookie.ID == 0 || cookie.ActualCode != affiliateCode {
-		newCookie, err := s.sessionRepo.GetAffiliate(affiliateCode)
-		if err != nil {
-			log.Printf("Unable to query affiliate with ID: %s; Store: %s\n", affiliateCode, store)
-			return
-		}
+	now := time.Now()
+	attribution := config.AffiliateAttribution(storeSettings, store)
+	active := cookie.ID != 0 && now.Before(cookie.Expires)
+	sameCode := active && cookie.ActualCode == affiliateCode
+	if sameCode && attribution.Touch == "first" {
+		return
+	}
 
-		cookie.ID = newCookie.ID
-		cookie.ActualCode = newCookie.ActualCode
+	aff, err := s.sessionRepo.GetAffiliate(affiliateCode)
+	if err != nil {
+		log.Printf("Unable to query affiliate with ID: %s; Store: %s\n", affiliateCode, store)
+		return
+	}
 
+	if !sameCode {
 		line := &models.AffiliateLine{
-			AffiliateID: cookie.ID,
-			Code:        cookie.ActualCode,
+			AffiliateID: aff.ID,
+			Code:        aff.Code,
 			SessionID:   sessionID,
-			Timestamp:   time.Now(),
+			Timestamp:   now,
 		}
 		go func() {
 			s.sessionRepo.AddAffiliateLine(line, store)
 		}()
 	}
+
+	if active && attribution.Touch == "first" {
+		return
+	}
+
+	days := attribution.WindowDays
+	if aff.WindowDays > 0 {
+		days = aff.WindowDays
+	}
+
+	cookie.ID = aff.ID
+	cookie.ActualCode = aff.Code
+	cookie.Touched = now
+	cookie.Expires = now.AddDate(0, 0, days)
 }
 
-func (s *sessionService) AddAffiliateSale(dpi *DataPassIn, orderID string) {
-	if dpi.AffiliateID == 0 {
+// Orders placed after the attribution window aren't credited
+func (s *sessionService) AddAffiliateSale(dpi *DataPassIn, order *models.Order) {
+	if !order.AffiliateCredited() {
 		return
 	}
 
 	use := models.AffiliateSale{
-		AffiliateID: dpi.AffiliateID,
-		Code:        dpi.AffiliateCode,
-		SessionID:   dpi.SessionID,
-		OrderID:     orderID,
+		AffiliateID: order.AffiliateID,
+		Code:        order.AffiliateCode,
+		SessionID:   order.SessionID,
+		OrderID:     order.ID.Hex(),
 		Timestamp:   time.Now(),
 	}
 
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"beam/data"
+	"beam/data/services"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Admin requests carry ADMIN_API_KEY in X-Admin-Key; the store comes from the domain like any other request
+func AdminMiddleware(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := os.Getenv("ADMIN_API_KEY")
+		if key == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Key")), []byte(key)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		domain := strings.Split(c.Request.Host, ":")[0]
+
+		fullService.Mutex.Store.Mu.RLock()
+		store, ok := fullService.Mutex.Store.Store.FromDomain[domain]
+		fullService.Mutex.Store.Mu.RUnlock()
+		if _, exists := fullService.Map[store]; !ok || !exists {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		c.Set("adminStore", store)
+		c.Next()
+	}
+}
+
+func FormatDataAdmin(c *gin.Context, fullService *data.AllServices) (*services.DataPassIn, *data.MainService) {
+	store := c.GetString("adminStore")
+	dpi := FormatDataWebhooks(c, fullService, store)
+	dpi.Store = store
+	return dpi, fullService.Map[store]
+}
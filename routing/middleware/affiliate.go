@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"beam/data"
+	"beam/data/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Affiliate portal requests carry the token from logging in as X-Affiliate-Token; the store comes from the domain
+func AffiliatePortalMiddleware(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store, ok := portalStore(c, fullService)
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		dpi := FormatDataWebhooks(c, fullService, store)
+		dpi.Store = store
+		id, err := fullService.Map[store].Affiliate.CheckLogin(dpi, c.GetHeader("X-Affiliate-Token"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		} else if id == 0 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Set("affiliateStore", store)
+		c.Set("affiliateID", id)
+		c.Next()
+	}
+}
+
+// For logging in, before there is a token
+func FormatDataAffiliateLogin(c *gin.Context, fullService *data.AllServices) (*services.DataPassIn, *data.MainService, bool) {
+	store, ok := portalStore(c, fullService)
+	if !ok {
+		return nil, nil, false
+	}
+
+	dpi := FormatDataWebhooks(c, fullService, store)
+	dpi.Store = store
+	return dpi, fullService.Map[store], true
+}
+
+func FormatDataAffiliate(c *gin.Context, fullService *data.AllServices) (*services.DataPassIn, *data.MainService, int) {
+	store := c.GetString("affiliateStore")
+	dpi := FormatDataWebhooks(c, fullService, store)
+	dpi.Store = store
+	return dpi, fullService.Map[store], c.GetInt("affiliateID")
+}
+
+func portalStore(c *gin.Context, fullService *data.AllServices) (string, bool) {
+	domain := strings.Split(c.Request.Host, ":")[0]
+
+	fullService.Mutex.Store.Mu.RLock()
+	store, ok := fullService.Mutex.Store.Store.FromDomain[domain]
+	fullService.Mutex.Store.Mu.RUnlock()
+	_, exists := fullService.Map[store]
+	return store, ok && exists
+}
@@ -21,7 +21,7 @@ func FormatDataForFunctions(c *gin.Context, fullService *data.AllServices) *serv
 	if sessionCookie == nil {
 		sessionCookie = &models.SessionCookie{}
 	}
-	if affiliateCookie == nil {
+	if affiliateCookie == nil || !time.Now().Before(affiliateCookie.Expires) {
 		affiliateCookie = &models.AffiliateSession{}
 	}
 
@@ -32,19 +32,21 @@ func FormatDataForFunctions(c *gin.Context, fullService *data.AllServices) *serv
 	}
 
 	ret := services.DataPassIn{
-		Store:         clientCookie.Store,
-		CustomerID:    clientCookie.CustomerID,
-		GuestID:       clientCookie.GuestID,
-		IsLoggedIn:    clientCookie.CustomerID > 0,
-		CartID:        clientCookie.GetCart(),
-		SessionID:     sessionCookie.SessionID,
-		SessionLineID: "SL-" + uuid.NewString(),
-		AffiliateID:   affiliateCookie.ID,
-		AffiliateCode: affiliateCookie.ActualCode,
-		IPAddress:     ipStr,
-		TimeStarted:   time.Now(),
-		Logs:          []models.EventFinal{},
-		LogsMutex:     sync.Mutex{},
+		Store:            clientCookie.Store,
+		CustomerID:       clientCookie.CustomerID,
+		GuestID:          clientCookie.GuestID,
+		IsLoggedIn:       clientCookie.CustomerID > 0,
+		CartID:           clientCookie.GetCart(),
+		SessionID:        sessionCookie.SessionID,
+		SessionLineID:    "SL-" + uuid.NewString(),
+		AffiliateID:      affiliateCookie.ID,
+		AffiliateCode:    affiliateCookie.ActualCode,
+		AffiliateTouch:   affiliateCookie.Touched,
+		AffiliateExpires: affiliateCookie.Expires,
+		IPAddress:        ipStr,
+		TimeStarted:      time.Now(),
+		Logs:             []models.EventFinal{},
+		LogsMutex:        sync.Mutex{},
 	}
 
 	if serv, ok := fullService.Map[clientCookie.Store]; ok {
@@ -46,10 +46,10 @@ func CookieMiddleware(fullService *data.AllServices, tools *config.Tools) gin.Ha
 			sessionCookie = &models.SessionCookie{}
 		}
 
-		service.Session.AffiliateMiddleware(affiliateCookie, sessionCookie.SessionID, store, c)
 		if affiliateCookie == nil {
 			affiliateCookie = &models.AffiliateSession{}
 		}
+		service.Session.AffiliateMiddleware(affiliateCookie, sessionCookie.SessionID, store, &fullService.Mutex.Settings, c)
 
 		cartID, err := service.Cart.CartMiddleware(clientCookie.GetCart(), clientCookie.CustomerID, clientCookie.GuestID)
 		if err != nil {
@@ -129,7 +129,21 @@ func GetSessionCookie(c *gin.Context) *models.SessionCookie {
 	return &session
 }
 
+// Lasts as long as the attribution window, and is cleared once it's over
 func SetAffiliateCookie(c *gin.Context, affiliate models.AffiliateSession) {
+	if affiliate.ID == 0 || !time.Now().Before(affiliate.Expires) {
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     "affiliate",
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+			MaxAge:   -1,
+		})
+		return
+	}
+
 	data, _ := json.Marshal(affiliate)
 	http.SetCookie(c.Writer, &http.Cookie{
 		Name:     "affiliate",
@@ -138,7 +152,7 @@ func SetAffiliateCookie(c *gin.Context, affiliate models.AffiliateSession) {
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().AddDate(100, 0, 0),
+		Expires:  affiliate.Expires,
 	})
 }
 
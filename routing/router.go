@@ -4,6 +4,8 @@ import (
 	"beam/config"
 	"beam/data"
 	"beam/routing/middleware"
+	"beam/routing/routes/admin"
+	"beam/routing/routes/affiliate"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,5 +13,41 @@ import (
 func New(fullService *data.AllServices, tools *config.Tools) *gin.Engine {
 	router := gin.Default()
 	router.Use(middleware.CookieMiddleware(fullService, tools))
+
+	adm := router.Group("/admin", middleware.AdminMiddleware(fullService))
+	adm.GET("/discounts", admin.ListDiscounts(fullService))
+	adm.POST("/discounts", admin.SaveDiscount(fullService))
+	adm.GET("/discounts/:id", admin.GetDiscount(fullService))
+	adm.PUT("/discounts/:id", admin.SaveDiscount(fullService))
+	adm.POST("/discounts/:id/deactivate", admin.DeactivateDiscount(fullService))
+	adm.GET("/discounts/:id/analytics", admin.DiscountAnalytics(fullService))
+	adm.GET("/giftcards", admin.ListGiftCards(fullService))
+	adm.POST("/giftcards", admin.IssueGiftCard(fullService, tools))
+	adm.GET("/giftcards/:id", admin.GetGiftCard(fullService))
+	adm.POST("/giftcards/:id/void", admin.VoidGiftCard(fullService))
+	adm.POST("/giftcards/:id/adjust", admin.AdjustGiftCard(fullService))
+	adm.POST("/giftcards/:id/extend", admin.ExtendGiftCard(fullService))
+	adm.POST("/giftcards/:id/unlock", admin.UnlockGiftCard(fullService, tools))
+	adm.GET("/customers/:id/credit", admin.GetStoreCredit(fullService))
+	adm.POST("/customers/:id/credit", admin.IssueStoreCredit(fullService))
+	adm.POST("/orders/:id/credit", admin.RefundOrderToStoreCredit(fullService))
+	adm.GET("/customers/:id/points", admin.GetPoints(fullService))
+	adm.POST("/customers/:id/points", admin.AdjustPoints(fullService))
+	adm.GET("/customers/:id/referrals", admin.GetReferrals(fullService))
+	adm.GET("/affiliates", admin.ListAffiliates(fullService))
+	adm.POST("/affiliates", admin.SaveAffiliate(fullService))
+	adm.PUT("/affiliates/:id", admin.SaveAffiliate(fullService))
+	adm.POST("/affiliates/:id/deactivate", admin.DeactivateAffiliate(fullService))
+	adm.PUT("/affiliates/:id/rates", admin.SetAffiliateRates(fullService))
+	adm.GET("/affiliates/:id/dashboard", admin.AffiliateDashboard(fullService))
+	adm.GET("/affiliates/:id/statements/:month", admin.AffiliateStatement(fullService))
+	adm.GET("/affiliate-payouts/:month", admin.AffiliatePayouts(fullService))
+
+	router.POST("/affiliate/login", affiliate.Login(fullService, tools))
+	aff := router.Group("/affiliate", middleware.AffiliatePortalMiddleware(fullService))
+	aff.POST("/logout", affiliate.Logout(fullService))
+	aff.GET("/dashboard", affiliate.Dashboard(fullService))
+	aff.GET("/statements/:month", affiliate.Statement(fullService))
+
 	return router
 }
@@ -0,0 +1,173 @@
+package admin
+
+import (
+	"beam/data"
+	"beam/data/models"
+	"beam/routing/middleware"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type affiliateRequest struct {
+	Code       string  `json:"code"` // Only on create, empty generates one
+	Name       string  `json:"name"`
+	Email      string  `json:"email"`
+	Password   string  `json:"password"` // Empty keeps the current one
+	Commission float64 `json:"commission"`
+	WindowDays int     `json:"window_days"` // Zero uses the store's attribution window
+}
+
+type affiliateRateRequest struct {
+	ProductID  int     `json:"product_id"`
+	Commission float64 `json:"commission"`
+}
+
+func ListAffiliates(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		affs, err := service.Affiliate.ListAffiliates(dpi)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, affs)
+	}
+}
+
+// Creates on POST, edits the affiliate in the path on PUT
+func SaveAffiliate(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		var req affiliateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var aff *models.Affiliate
+		var err error
+		if c.Param("id") == "" {
+			aff, err = service.Affiliate.CreateAffiliate(dpi, req.Code, req.Name, req.Email, req.Password, req.Commission, req.WindowDays)
+		} else {
+			id, convErr := strconv.Atoi(c.Param("id"))
+			if convErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid affiliate id"})
+				return
+			}
+			aff, err = service.Affiliate.UpdateAffiliate(dpi, id, req.Name, req.Email, req.Password, req.Commission, req.WindowDays)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, aff)
+	}
+}
+
+func DeactivateAffiliate(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid affiliate id"})
+			return
+		}
+
+		aff, err := service.Affiliate.DeactivateAffiliate(dpi, id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, aff)
+	}
+}
+
+// Replaces the affiliate's product rates; an empty list leaves only their base commission
+func SetAffiliateRates(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid affiliate id"})
+			return
+		}
+
+		var req []affiliateRateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		rates := make([]*models.AffiliateRate, len(req))
+		for i, r := range req {
+			rates[i] = &models.AffiliateRate{ProductID: r.ProductID, Commission: r.Commission}
+		}
+
+		if err := service.Affiliate.SetRates(dpi, id, rates); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, rates)
+	}
+}
+
+// The same dashboard the affiliate sees in the portal
+func AffiliateDashboard(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid affiliate id"})
+			return
+		}
+
+		dash, err := service.Affiliate.GetDashboard(dpi, id, c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dash)
+	}
+}
+
+func AffiliateStatement(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid affiliate id"})
+			return
+		}
+
+		body, err := service.Affiliate.StatementCSV(dpi, id, c.Param("month"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=affiliate-"+c.Param("id")+"-"+c.Param("month")+".csv")
+		c.Data(http.StatusOK, "text/csv", body)
+	}
+}
+
+// Every affiliate's commission for the month, to pay out from
+func AffiliatePayouts(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		body, err := service.Affiliate.PayoutCSV(dpi, c.Param("month"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=affiliate-payouts-"+c.Param("month")+".csv")
+		c.Data(http.StatusOK, "text/csv", body)
+	}
+}
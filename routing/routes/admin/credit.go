@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"beam/data"
+	"beam/routing/middleware"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type storeCreditRequest struct {
+	Cents   int    `json:"cents"`
+	Source  string `json:"source"`
+	OrderID string `json:"order_id"`
+	Note    string `json:"note"`
+}
+
+func GetStoreCredit(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+			return
+		}
+
+		wallet, err := service.StoreCredit.GetWallet(dpi, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, wallet)
+	}
+}
+
+// Goodwill credit, or a refund or return handled outside an order
+func IssueStoreCredit(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+			return
+		}
+
+		var req storeCreditRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Credit tied to an order counts against what's left of it to refund, same as refunding from the order
+		if req.OrderID != "" {
+			orders, err := service.Order.GetOrdersByIDs(dpi, []string{req.OrderID})
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			} else if len(orders) == 0 || orders[0].CustomerID != id {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "order isn't this customer's"})
+				return
+			}
+
+			_, credit, err := service.Order.RefundToStoreCredit(dpi, req.OrderID, req.Cents, req.Source, req.Note, service.StoreCredit, service.Loyalty, service.Affiliate, service.Customer, &fullService.Mutex.Settings)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, credit)
+			return
+		}
+
+		credit, err := service.StoreCredit.IssueStoreCredit(dpi, id, req.Cents, req.Source, "", req.Note, &fullService.Mutex.Settings)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, credit)
+	}
+}
+
+// Refund or completed return on an order paid back as store credit
+func RefundOrderToStoreCredit(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		var req storeCreditRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		order, credit, err := service.Order.RefundToStoreCredit(dpi, c.Param("id"), req.Cents, req.Source, req.Note, service.StoreCredit, service.Loyalty, service.Affiliate, service.Customer, &fullService.Mutex.Settings)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order": order, "credit": credit})
+	}
+}
+
+// Referrals the customer made, including ones rejected by the fraud checks
+func GetReferrals(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+			return
+		}
+
+		refs, err := service.Referral.GetReferrals(dpi, id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, refs)
+	}
+}
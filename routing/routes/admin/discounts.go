@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"beam/data"
+	"beam/data/models"
+	"beam/routing/middleware"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type discountRequest struct {
+	Discount    models.Discount `json:"discount"`
+	CustomerIDs []int           `json:"customer_ids"`
+}
+
+func ListDiscounts(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		ret, err := service.Discount.ListDiscounts(dpi, c.Query("q"), c.Query("status"), page)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ret)
+	}
+}
+
+func GetDiscount(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid discount id"})
+			return
+		}
+
+		disc, users, err := service.Discount.GetDiscountWithUsers(dpi, id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"discount": disc, "users": users})
+	}
+}
+
+// Creates on POST, edits the code in the path on PUT
+func SaveDiscount(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		var req discountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		req.Discount.ID = 0
+		if c.Param("id") != "" {
+			id, err := strconv.Atoi(c.Param("id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid discount id"})
+				return
+			}
+			req.Discount.ID = id
+		}
+
+		disc, err := service.Discount.SaveDiscount(dpi, req.Discount, req.CustomerIDs, fullService.Mutex)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, disc)
+	}
+}
+
+func DeactivateDiscount(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid discount id"})
+			return
+		}
+
+		if err := service.Discount.DeactivateDiscount(dpi, id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func DiscountAnalytics(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid discount id"})
+			return
+		}
+
+		ret, err := service.Discount.DiscountAnalytics(dpi, id, c.DefaultQuery("interval", "week"), service.Order)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ret)
+	}
+}
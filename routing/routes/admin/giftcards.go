@@ -0,0 +1,173 @@
+package admin
+
+import (
+	"beam/config"
+	"beam/data"
+	"beam/data/services/discount"
+	"beam/routing/middleware"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type issueGiftCardRequest struct {
+	Cents   int    `json:"cents"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+type changeGiftCardRequest struct {
+	Change  int       `json:"change"`
+	Expires time.Time `json:"expires"`
+	Reason  string    `json:"reason"`
+}
+
+func ListGiftCards(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		ret, err := service.Discount.SearchGiftCards(dpi, c.Query("q"), c.Query("status"), page)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ret)
+	}
+}
+
+func IssueGiftCard(fullService *data.AllServices, tools *config.Tools) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		var req issueGiftCardRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		gc, err := service.Discount.IssueGiftCard(dpi, req.Cents, req.Message, req.Reason, tools)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"gift_card": gc, "code": discount.SpaceDisplayGC(gc.IDCode)})
+	}
+}
+
+func GetGiftCard(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid gift card id"})
+			return
+		}
+
+		ledger, err := service.Discount.GetGiftCardLedger(dpi, id)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ledger)
+	}
+}
+
+func VoidGiftCard(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid gift card id"})
+			return
+		}
+
+		var req changeGiftCardRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		gc, err := service.Discount.VoidGiftCard(dpi, id, req.Reason)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gc)
+	}
+}
+
+// Change is signed cents
+func AdjustGiftCard(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid gift card id"})
+			return
+		}
+
+		var req changeGiftCardRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		gc, err := service.Discount.AdjustGiftCard(dpi, id, req.Change, req.Reason)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gc)
+	}
+}
+
+func ExtendGiftCard(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid gift card id"})
+			return
+		}
+
+		var req changeGiftCardRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		gc, err := service.Discount.ExtendGiftCard(dpi, id, req.Expires, req.Reason)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gc)
+	}
+}
+
+// Clears a lock from failed pin attempts and starts its failure count over
+func UnlockGiftCard(fullService *data.AllServices, tools *config.Tools) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid gift card id"})
+			return
+		}
+
+		gc, err := service.Discount.UnlockGiftCard(dpi, id, tools)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gc)
+	}
+}
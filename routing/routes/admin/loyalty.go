@@ -0,0 +1,65 @@
+package admin
+
+import (
+	"beam/data"
+	"beam/routing/middleware"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type pointsRequest struct {
+	Points int    `json:"points"`
+	Note   string `json:"note"`
+}
+
+func GetPoints(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+			return
+		}
+
+		wallet, err := service.Loyalty.GetPointsWallet(dpi, id, &fullService.Mutex.Settings)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, wallet)
+	}
+}
+
+// Goodwill points, e.g. for earnings missed by a failed order
+func AdjustPoints(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service := middleware.FormatDataAdmin(c, fullService)
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid customer id"})
+			return
+		}
+
+		var req pointsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := service.Loyalty.AdjustPoints(dpi, id, req.Points, req.Note, service.Customer, &fullService.Mutex.Settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		wallet, err := service.Loyalty.GetPointsWallet(dpi, id, &fullService.Mutex.Settings)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, wallet)
+	}
+}
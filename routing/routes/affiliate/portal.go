@@ -0,0 +1,79 @@
+package affiliate
+
+import (
+	"beam/config"
+	"beam/data"
+	"beam/routing/middleware"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Returns the token to send as X-Affiliate-Token
+func Login(fullService *data.AllServices, tools *config.Tools) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service, ok := middleware.FormatDataAffiliateLogin(c, fullService)
+		if !ok {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		var req loginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, err := service.Affiliate.Login(dpi, req.Email, req.Password, tools)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token})
+	}
+}
+
+func Logout(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service, _ := middleware.FormatDataAffiliate(c, fullService)
+
+		if err := service.Affiliate.Logout(dpi, c.GetHeader("X-Affiliate-Token")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func Dashboard(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service, id := middleware.FormatDataAffiliate(c, fullService)
+
+		dash, err := service.Affiliate.GetDashboard(dpi, id, c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dash)
+	}
+}
+
+// Monthly payout statement as CSV
+func Statement(fullService *data.AllServices) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dpi, service, id := middleware.FormatDataAffiliate(c, fullService)
+
+		body, err := service.Affiliate.StatementCSV(dpi, id, c.Param("month"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=statement-"+c.Param("month")+".csv")
+		c.Data(http.StatusOK, "text/csv", body)
+	}
+}
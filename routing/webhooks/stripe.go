@@ -61,7 +61,7 @@ func PaymentSuccess(c *gin.Context, fullService *data.AllServices, tools *config
 	}
 
 	go func() {
-		service.Order.CompleteOrder(dpi, orderInfo.OrderID, service.Customer, service.DraftOrder, service.Discount, service.List, service.Product, service.Order, service.Session, fullService.Mutex, tools)
+		service.Order.CompleteOrder(dpi, orderInfo.OrderID, service.Customer, service.DraftOrder, service.Discount, service.List, service.Product, service.Order, service.Session, fullService.Mutex, tools, service.Profit, service.StoreCredit, service.Loyalty, service.Referral, service.Affiliate)
 	}()
 
 	c.Status(http.StatusOK)